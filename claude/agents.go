@@ -0,0 +1,255 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// サブエージェントを起動するツール名
+// CLIのバージョンにより"Task"または"Agent"が使われる
+var subagentToolNames = map[string]bool{
+	"Task":  true,
+	"Agent": true,
+}
+
+// AgentModel はサブエージェントが使用するモデルを表す
+type AgentModel string
+
+const (
+	AgentModelSonnet  AgentModel = "sonnet"
+	AgentModelOpus    AgentModel = "opus"
+	AgentModelHaiku   AgentModel = "haiku"
+	AgentModelInherit AgentModel = "inherit" // 親エージェントと同じモデル
+)
+
+// AgentDefinition はプログラマティックに定義するサブエージェント
+// Options.Agentsのキーがサブエージェント名になる
+type AgentDefinition struct {
+	Description string     // いつこのサブエージェントを使うかの説明（必須）
+	Prompt      string     // サブエージェントのシステムプロンプト（必須）
+	Tools       []string   // 許可するツール（空の場合は親から継承）
+	Model       AgentModel // 使用するモデル（空の場合はinherit）
+}
+
+// validateAgents はサブエージェント定義を検証する
+func validateAgents(agents map[string]AgentDefinition) error {
+	for name, agent := range agents {
+		if name == "" {
+			return fmt.Errorf("agent name must not be empty")
+		}
+		if agent.Description == "" {
+			return fmt.Errorf("agent %q: description is required", name)
+		}
+		if agent.Prompt == "" {
+			return fmt.Errorf("agent %q: prompt is required", name)
+		}
+	}
+	return nil
+}
+
+// buildAgentsConfig はサブエージェント定義をプロトコル層の型に変換する
+func buildAgentsConfig(agents map[string]AgentDefinition) map[string]protocol.AgentDefinition {
+	if len(agents) == 0 {
+		return nil
+	}
+
+	config := make(map[string]protocol.AgentDefinition, len(agents))
+	for name, agent := range agents {
+		config[name] = protocol.AgentDefinition{
+			Description: agent.Description,
+			Prompt:      agent.Prompt,
+			Tools:       agent.Tools,
+			Model:       string(agent.Model),
+		}
+	}
+	return config
+}
+
+// agentsArg はCLI引数用にサブエージェント定義をJSON文字列に変換する
+func agentsArg(agents map[string]AgentDefinition) string {
+	config := buildAgentsConfig(agents)
+	if config == nil {
+		return ""
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// ParentToolUseID はメッセージを生成したサブエージェントの起動元tool_use IDを返す
+// メインエージェントのメッセージの場合は空文字列を返す
+func ParentToolUseID(msg protocol.Message) string {
+	var id *string
+	switch m := msg.(type) {
+	case *protocol.AssistantMessage:
+		id = m.ParentToolUseID
+	case *protocol.UserMessage:
+		id = m.ParentToolUseID
+	case *protocol.StreamEvent:
+		id = m.ParentToolUseID
+	}
+	if id == nil {
+		return ""
+	}
+	return *id
+}
+
+// SubagentActivity はサブエージェント1回分の実行状況を表す
+type SubagentActivity struct {
+	ToolUseID string         // サブエージェントを起動したtool_use ID
+	Agent     string         // サブエージェント名（subagent_type）
+	Messages  int            // サブエージェントが生成したアシスタントメッセージ数
+	ToolCalls map[string]int // ツール名ごとの呼び出し回数
+	Usage     protocol.Usage // サブエージェントのトークン使用量
+	Models    []string       // サブエージェントが使用したモデル
+	Completed bool           // 起動元にtool_resultが返されたか
+}
+
+// SubagentTracker はParentToolUseIDを使ってメッセージをサブエージェントごとに集計する
+type SubagentTracker struct {
+	mu         sync.Mutex
+	activities map[string]*SubagentActivity
+	order      []string
+}
+
+// NewSubagentTracker は新しいSubagentTrackerを作成する
+func NewSubagentTracker() *SubagentTracker {
+	return &SubagentTracker{
+		activities: make(map[string]*SubagentActivity),
+	}
+}
+
+// Track はメッセージを集計に反映する
+func (t *SubagentTracker) Track(msg protocol.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parentID := ParentToolUseID(msg)
+
+	switch m := msg.(type) {
+	case *protocol.AssistantMessage:
+		// サブエージェントの起動を検出（ネストしたサブエージェントも含む）
+		for _, block := range m.ToolUses() {
+			if subagentToolNames[block.Name] {
+				agent, _ := block.Input["subagent_type"].(string)
+				t.activity(block.ID).Agent = agent
+			}
+		}
+
+		if parentID == "" {
+			return
+		}
+		activity := t.activity(parentID)
+		activity.Messages++
+		for _, block := range m.ToolUses() {
+			activity.ToolCalls[block.Name]++
+		}
+		if m.Message.Usage != nil {
+			activity.Usage.InputTokens += m.Message.Usage.InputTokens
+			activity.Usage.OutputTokens += m.Message.Usage.OutputTokens
+			activity.Usage.CacheCreationTokens += m.Message.Usage.CacheCreationTokens
+			activity.Usage.CacheReadTokens += m.Message.Usage.CacheReadTokens
+		}
+		if m.Message.Model != "" && !slices.Contains(activity.Models, m.Message.Model) {
+			activity.Models = append(activity.Models, m.Message.Model)
+		}
+
+	case *protocol.UserMessage:
+		// 起動元へのtool_resultでサブエージェントの完了を検出
+		for _, block := range m.ToolResults() {
+			if activity, ok := t.activities[block.ToolUseID]; ok {
+				activity.Completed = true
+			}
+		}
+	}
+}
+
+// activity はtool_use IDに対応する集計を取得または作成する（t.muを保持して呼ぶ）
+func (t *SubagentTracker) activity(toolUseID string) *SubagentActivity {
+	activity, ok := t.activities[toolUseID]
+	if !ok {
+		activity = &SubagentActivity{
+			ToolUseID: toolUseID,
+			ToolCalls: make(map[string]int),
+		}
+		t.activities[toolUseID] = activity
+		t.order = append(t.order, toolUseID)
+	}
+	return activity
+}
+
+// Activities はサブエージェントの実行状況を起動順に返す
+func (t *SubagentTracker) Activities() []SubagentActivity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]SubagentActivity, 0, len(t.order))
+	for _, id := range t.order {
+		result = append(result, copyActivity(t.activities[id]))
+	}
+	return result
+}
+
+// Activity はtool_use IDに対応するサブエージェントの実行状況を返す
+func (t *SubagentTracker) Activity(toolUseID string) (SubagentActivity, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	activity, ok := t.activities[toolUseID]
+	if !ok {
+		return SubagentActivity{}, false
+	}
+	return copyActivity(activity), true
+}
+
+// ByAgent はサブエージェント名ごとに実行状況を合算して返す
+func (t *SubagentTracker) ByAgent() map[string]SubagentActivity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make(map[string]SubagentActivity)
+	for _, id := range t.order {
+		a := t.activities[id]
+		total, ok := result[a.Agent]
+		if !ok {
+			total = SubagentActivity{Agent: a.Agent, ToolCalls: make(map[string]int), Completed: true}
+		}
+		total.ToolUseID = ""
+		total.Messages += a.Messages
+		for name, n := range a.ToolCalls {
+			total.ToolCalls[name] += n
+		}
+		total.Usage.InputTokens += a.Usage.InputTokens
+		total.Usage.OutputTokens += a.Usage.OutputTokens
+		total.Usage.CacheCreationTokens += a.Usage.CacheCreationTokens
+		total.Usage.CacheReadTokens += a.Usage.CacheReadTokens
+		for _, model := range a.Models {
+			if !slices.Contains(total.Models, model) {
+				total.Models = append(total.Models, model)
+			}
+		}
+		total.Completed = total.Completed && a.Completed
+		result[a.Agent] = total
+	}
+	for _, a := range result {
+		sort.Strings(a.Models)
+	}
+	return result
+}
+
+func copyActivity(a *SubagentActivity) SubagentActivity {
+	c := *a
+	c.ToolCalls = make(map[string]int, len(a.ToolCalls))
+	for name, n := range a.ToolCalls {
+		c.ToolCalls[name] = n
+	}
+	c.Models = append([]string(nil), a.Models...)
+	return c
+}
@@ -0,0 +1,204 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+func testAgents() map[string]AgentDefinition {
+	return map[string]AgentDefinition{
+		"code-reviewer": {
+			Description: "Reviews code for quality",
+			Prompt:      "You are a code reviewer.",
+			Tools:       []string{"Read", "Grep"},
+			Model:       AgentModelSonnet,
+		},
+	}
+}
+
+func TestBuildQueryArgs_Agents(t *testing.T) {
+	args := buildQueryArgs("test", &Options{Agents: testAgents()})
+
+	var agentsJSON string
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "--agents" {
+			agentsJSON = args[i+1]
+		}
+	}
+	if agentsJSON == "" {
+		t.Fatal("missing arg: --agents")
+	}
+
+	var agents map[string]protocol.AgentDefinition
+	if err := json.Unmarshal([]byte(agentsJSON), &agents); err != nil {
+		t.Fatalf("--agents is not valid JSON: %v", err)
+	}
+	reviewer, ok := agents["code-reviewer"]
+	if !ok {
+		t.Fatalf("agents = %v", agents)
+	}
+	if reviewer.Prompt != "You are a code reviewer." {
+		t.Errorf("Prompt = %q", reviewer.Prompt)
+	}
+	if reviewer.Model != "sonnet" {
+		t.Errorf("Model = %q, want %q", reviewer.Model, "sonnet")
+	}
+	if len(reviewer.Tools) != 2 {
+		t.Errorf("Tools = %v", reviewer.Tools)
+	}
+}
+
+func TestClient_InitializeSendsAgents(t *testing.T) {
+	client, mt := newTestClient(&Options{Agents: testAgents()})
+
+	var got map[string]protocol.AgentDefinition
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		var initReq protocol.InitializeRequest
+		if err := remarshal(req, &initReq); err == nil {
+			got = initReq.Agents
+		}
+		return map[string]any{}, ""
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := client.initialize(ctx); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+	if got["code-reviewer"].Description != "Reviews code for quality" {
+		t.Errorf("agents = %v", got)
+	}
+}
+
+func TestValidateAgents(t *testing.T) {
+	tests := []struct {
+		name    string
+		agents  map[string]AgentDefinition
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"valid", testAgents(), false},
+		{"missing description", map[string]AgentDefinition{"a": {Prompt: "p"}}, true},
+		{"missing prompt", map[string]AgentDefinition{"a": {Description: "d"}}, true},
+		{"empty name", map[string]AgentDefinition{"": {Description: "d", Prompt: "p"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAgents(tt.agents)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAgents() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuery_InvalidAgents(t *testing.T) {
+	_, err := Query(context.Background(), "test", &Options{
+		Agents: map[string]AgentDefinition{"broken": {}},
+	})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestSubagentTracker(t *testing.T) {
+	parentID := "toolu_task_1"
+	messages := []protocol.Message{
+		// メインエージェントがサブエージェントを起動
+		&protocol.AssistantMessage{
+			Type: "assistant",
+			Message: protocol.AssistantBody{
+				Model: "claude-opus",
+				Content: protocol.Blocks{&protocol.ToolUseBlock{
+					ID:    parentID,
+					Name:  "Task",
+					Input: map[string]any{"subagent_type": "code-reviewer", "prompt": "review"},
+				}},
+				Usage: &protocol.Usage{InputTokens: 1000, OutputTokens: 100},
+			},
+		},
+		// サブエージェントのツール呼び出し
+		&protocol.AssistantMessage{
+			Type: "assistant",
+			Message: protocol.AssistantBody{
+				Model: "claude-sonnet",
+				Content: protocol.Blocks{
+					&protocol.ToolUseBlock{ID: "toolu_2", Name: "Read"},
+					&protocol.ToolUseBlock{ID: "toolu_3", Name: "Grep"},
+				},
+				Usage: &protocol.Usage{InputTokens: 10, OutputTokens: 5, CacheReadTokens: 3},
+			},
+			ParentToolUseID: &parentID,
+		},
+		&protocol.AssistantMessage{
+			Type: "assistant",
+			Message: protocol.AssistantBody{
+				Model:   "claude-sonnet",
+				Content: protocol.Blocks{&protocol.ToolUseBlock{ID: "toolu_4", Name: "Read"}},
+				Usage:   &protocol.Usage{InputTokens: 20, OutputTokens: 7},
+			},
+			ParentToolUseID: &parentID,
+		},
+		// 起動元へのtool_result
+		&protocol.UserMessage{
+			Type: "user",
+			Message: protocol.UserContent{
+				Role:    "user",
+				Content: protocol.NewBlocksContent(protocol.NewToolResultBlock(parentID, protocol.NewTextContent("LGTM"), false)),
+			},
+		},
+	}
+
+	tracker := NewSubagentTracker()
+	for _, msg := range messages {
+		tracker.Track(msg)
+	}
+
+	activity, ok := tracker.Activity(parentID)
+	if !ok {
+		t.Fatal("activity not found")
+	}
+	if activity.Agent != "code-reviewer" {
+		t.Errorf("Agent = %q, want %q", activity.Agent, "code-reviewer")
+	}
+	if activity.Messages != 2 {
+		t.Errorf("Messages = %d, want %d", activity.Messages, 2)
+	}
+	if activity.ToolCalls["Read"] != 2 || activity.ToolCalls["Grep"] != 1 {
+		t.Errorf("ToolCalls = %v", activity.ToolCalls)
+	}
+	if activity.Usage.InputTokens != 30 || activity.Usage.OutputTokens != 12 || activity.Usage.CacheReadTokens != 3 {
+		t.Errorf("Usage = %+v", activity.Usage)
+	}
+	if len(activity.Models) != 1 || activity.Models[0] != "claude-sonnet" {
+		t.Errorf("Models = %v", activity.Models)
+	}
+	if !activity.Completed {
+		t.Error("Completed should be true")
+	}
+
+	byAgent := tracker.ByAgent()
+	if byAgent["code-reviewer"].ToolCalls["Read"] != 2 {
+		t.Errorf("ByAgent = %+v", byAgent)
+	}
+}
+
+func TestParentToolUseID(t *testing.T) {
+	parentID := "toolu_1"
+	if got := ParentToolUseID(&protocol.AssistantMessage{ParentToolUseID: &parentID}); got != parentID {
+		t.Errorf("ParentToolUseID = %q, want %q", got, parentID)
+	}
+	if got := ParentToolUseID(&protocol.AssistantMessage{}); got != "" {
+		t.Errorf("ParentToolUseID = %q, want empty", got)
+	}
+	if got := ParentToolUseID(&protocol.ResultMessage{}); got != "" {
+		t.Errorf("ParentToolUseID = %q, want empty", got)
+	}
+}
@@ -0,0 +1,449 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// BatchItem はバッチの1件の入力
+type BatchItem struct {
+	ID      string   // チェックポイントで完了済みかを判定するID（空の場合は入力の位置）
+	Prompt  string   // プロンプト
+	Options *Options // この入力のオプション（nilの場合はBatchConfig.Options）
+}
+
+// BatchConfig はバッチ実行の設定
+type BatchConfig struct {
+	Options     *Options     // 各Queryのオプション
+	Concurrency int          // 同時に実行するQuery数（デフォルト: 4）
+	RateLimiter *RateLimiter // Queryの開始速度の上限（複数のバッチで共有可能、nilの場合は制限しない）
+	Retry       *RetryConfig // リトライ設定（nilの場合はリトライしない）
+
+	// Checkpoint は結果を1件ずつ追記するJSONLファイルのパス（空の場合は記録しない）
+	// 既存のファイルに結果が記録されている入力は実行せず、中断したバッチを途中から再開する
+	Checkpoint string
+	// RetryFailed は再開時に、チェックポイントに失敗として記録された入力も再実行する
+	RetryFailed bool
+
+	// OnResult は1件完了するごとに呼ばれる（複数のワーカーから並行して呼ばれる）
+	OnResult func(BatchResult)
+}
+
+// DefaultBatchConcurrency はデフォルトの同時実行数
+const DefaultBatchConcurrency = 4
+
+// BatchResult は1件の結果（チェックポイントの1行）
+type BatchResult struct {
+	ID          string         `json:"id"`
+	SessionID   string         `json:"session_id,omitempty"`
+	Result      string         `json:"result,omitempty"`
+	CostUSD     float64        `json:"cost_usd"`
+	Usage       protocol.Usage `json:"usage"`
+	Duration    time.Duration  `json:"duration_ns"`
+	Error       string         `json:"error,omitempty"`
+	ErrorClass  string         `json:"error_class,omitempty"`
+	CompletedAt time.Time      `json:"completed_at"`
+
+	// Query はこの実行で得たクエリ結果（チェックポイントから読み込んだ結果ではnil）
+	Query *QueryResult `json:"-"`
+}
+
+// Failed は失敗した結果かを返す
+func (r *BatchResult) Failed() bool {
+	return r.Error != ""
+}
+
+// BatchSummary はバッチ全体の集計
+// 再開した場合はチェックポイントに記録済みの結果も含めて集計する
+type BatchSummary struct {
+	Total     int // 入力件数
+	Succeeded int // 成功した件数
+	Failed    int // 失敗した件数
+	Resumed   int // チェックポイントに記録済みのため実行しなかった件数
+	NotRun    int // キャンセルにより実行しなかった件数
+
+	TotalCostUSD    float64        // 合計コスト
+	FailuresByClass map[string]int // エラーの分類ごとの失敗件数
+
+	// 1件あたりの所要時間（リトライを含む）のパーセンタイル
+	DurationP50 time.Duration
+	DurationP90 time.Duration
+	DurationP99 time.Duration
+	DurationMax time.Duration
+
+	Elapsed time.Duration // この実行の所要時間
+
+	// Results は完了した入力の結果（入力順）
+	Results []BatchResult
+}
+
+// RunBatch は入力ごとにQuery（Retry設定時はQueryWithRetry）を並行して実行する
+// ctxがキャンセルされた場合は実行中のQueryを中断し、それまでの集計とctx.Err()を返す
+// キャンセルで中断した入力はチェックポイントに記録しないため、再開時に再実行される
+func RunBatch(ctx context.Context, items []BatchItem, config *BatchConfig) (*BatchSummary, error) {
+	start := time.Now()
+	if config == nil {
+		config = &BatchConfig{}
+	}
+
+	ids := make([]string, len(items))
+	index := make(map[string]int, len(items))
+	for i, item := range items {
+		id := item.ID
+		if id == "" {
+			id = strconv.Itoa(i)
+		}
+		if _, ok := index[id]; ok {
+			return nil, &SDKError{Op: "batch", Err: ErrInvalidConfig, Details: fmt.Sprintf("duplicate item ID %q", id)}
+		}
+		ids[i] = id
+		index[id] = i
+	}
+
+	results := make([]*BatchResult, len(items))
+	var checkpoint *batchCheckpoint
+	if config.Checkpoint != "" {
+		var err error
+		checkpoint, err = openBatchCheckpoint(config.Checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		defer checkpoint.close()
+
+		for id, r := range checkpoint.previous {
+			if i, ok := index[id]; ok && (!r.Failed() || !config.RetryFailed) {
+				results[i] = r
+			}
+		}
+	}
+
+	summary := &BatchSummary{Total: len(items), FailuresByClass: make(map[string]int)}
+	var pending []int
+	for i := range items {
+		if results[i] != nil {
+			summary.Resumed++
+		} else {
+			pending = append(pending, i)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	queue := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		writeErr error
+	)
+	for w := 0; w < min(concurrency, len(pending)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				r, ok := runBatchItem(ctx, ids[i], items[i], config)
+				if !ok {
+					continue
+				}
+				if checkpoint != nil {
+					if err := checkpoint.write(r); err != nil {
+						errMu.Lock()
+						if writeErr == nil {
+							writeErr = err
+						}
+						errMu.Unlock()
+						// 記録できない結果は再開時に失われるため、バッチを中断する
+						cancel()
+						continue
+					}
+				}
+				results[i] = r
+				if config.OnResult != nil {
+					config.OnResult(*r)
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, i := range pending {
+		select {
+		case queue <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	summary.collect(results)
+	summary.Elapsed = time.Since(start)
+
+	if writeErr != nil {
+		return summary, writeErr
+	}
+	return summary, ctx.Err()
+}
+
+// runBatchItem は1件のQueryを実行する
+// キャンセルにより中断した場合は結果を記録しないためfalseを返す
+func runBatchItem(ctx context.Context, id string, item BatchItem, config *BatchConfig) (*BatchResult, bool) {
+	if config.RateLimiter != nil {
+		if err := config.RateLimiter.Wait(ctx); err != nil {
+			return nil, false
+		}
+	}
+
+	opts := item.Options
+	if opts == nil {
+		opts = config.Options
+	}
+
+	start := time.Now()
+	var (
+		result *QueryResult
+		err    error
+	)
+	if config.Retry != nil {
+		result, err = QueryWithRetry(ctx, item.Prompt, opts, config.Retry)
+	} else {
+		result, err = Query(ctx, item.Prompt, opts)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, false
+	}
+
+	r := &BatchResult{
+		ID:          id,
+		Duration:    time.Since(start),
+		CompletedAt: time.Now(),
+		Query:       result,
+	}
+	if result != nil {
+		r.SessionID = result.SessionID
+		r.CostUSD = result.TotalCost
+		r.Usage = result.Usage
+		if result.Result != nil {
+			r.Result = result.Result.Result
+		}
+	}
+	if err != nil {
+		r.Error = err.Error()
+		r.ErrorClass = errorClass(err)
+	}
+	return r, true
+}
+
+// collect は結果を集計する
+func (s *BatchSummary) collect(results []*BatchResult) {
+	var durations []time.Duration
+	for _, r := range results {
+		if r == nil {
+			s.NotRun++
+			continue
+		}
+		s.Results = append(s.Results, *r)
+		s.TotalCostUSD += r.CostUSD
+		durations = append(durations, r.Duration)
+		if r.Failed() {
+			s.Failed++
+			s.FailuresByClass[r.ErrorClass]++
+		} else {
+			s.Succeeded++
+		}
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	s.DurationP50 = percentile(durations, 50)
+	s.DurationP90 = percentile(durations, 90)
+	s.DurationP99 = percentile(durations, 99)
+	s.DurationMax = percentile(durations, 100)
+}
+
+// percentile はソート済みの値からpパーセンタイル（nearest-rank法）を返す
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// errorClass はエラーを集計用の分類に変換する
+func errorClass(err error) string {
+	var structuredErr *StructuredOutputError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrInterrupted), errors.Is(err, ErrCanceled):
+		return "canceled"
+	case errors.Is(err, ErrRateLimit):
+		return "rate_limit"
+	case IsLimitError(err):
+		return "limit"
+	case IsAuthError(err):
+		return "auth"
+	case IsPermissionError(err):
+		return "permission"
+	case errors.Is(err, ErrStructuredOutput), errors.As(err, &structuredErr):
+		return "structured_output"
+	case errors.Is(err, ErrInvalidConfig), errors.Is(err, ErrInvalidContent), errors.Is(err, ErrModelNotFound):
+		return "config"
+	case errors.Is(err, ErrCLINotFound), errors.Is(err, ErrCLIConnection), errors.Is(err, ErrProcessExited):
+		return "process"
+	case errors.Is(err, ErrMessageParse), errors.Is(err, ErrJSONDecode), errors.Is(err, ErrBufferOverflow),
+		errors.Is(err, ErrControlTimeout), errors.Is(err, ErrControlRejected):
+		return "protocol"
+	default:
+		return "other"
+	}
+}
+
+// batchCheckpoint は結果をJSONLファイルに追記する
+type batchCheckpoint struct {
+	mu   sync.Mutex
+	file *os.File
+	// previous は既存のファイルに記録されていたIDごとの最新の結果
+	previous map[string]*BatchResult
+}
+
+// openBatchCheckpoint はチェックポイントファイルを開き、記録済みの結果を読み込む
+func openBatchCheckpoint(path string) (*batchCheckpoint, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, &SDKError{Op: "batch", Err: ErrInvalidConfig, Details: fmt.Sprintf("open checkpoint: %v", err)}
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, &SDKError{Op: "batch", Err: ErrInvalidConfig, Details: fmt.Sprintf("read checkpoint: %v", err)}
+	}
+
+	c := &batchCheckpoint{file: file, previous: make(map[string]*BatchResult)}
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var r BatchResult
+		if err := json.Unmarshal(line, &r); err != nil {
+			// 書き込み中に中断した最終行（改行なし）は未記録として切り詰める
+			if i == len(lines)-1 {
+				if err := file.Truncate(int64(len(data) - len(line))); err != nil {
+					file.Close()
+					return nil, &SDKError{Op: "batch", Err: ErrInvalidConfig, Details: fmt.Sprintf("truncate checkpoint: %v", err)}
+				}
+				return c, nil
+			}
+			file.Close()
+			return nil, &SDKError{Op: "batch", Err: ErrJSONDecode, Details: fmt.Sprintf("checkpoint line %d: %v", i+1, err)}
+		}
+		c.previous[r.ID] = &r
+	}
+
+	// 改行で終わっていない最終行の後ろに追記しないよう改行する
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if _, err := file.Write([]byte("\n")); err != nil {
+			file.Close()
+			return nil, &SDKError{Op: "batch", Err: ErrInvalidConfig, Details: fmt.Sprintf("write checkpoint: %v", err)}
+		}
+	}
+	return c, nil
+}
+
+// write は結果を1行追記する
+func (c *batchCheckpoint) write(r *BatchResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return &SDKError{Op: "batch", Err: err, Details: "encode checkpoint"}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return &SDKError{Op: "batch", Err: err, Details: "write checkpoint"}
+	}
+	return nil
+}
+
+func (c *batchCheckpoint) close() error {
+	return c.file.Close()
+}
+
+// RateLimiter は開始の速度を制限するトークンバケット
+// 複数のワーカーやバッチで共有すると、合計の開始速度を制限できる
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 1秒あたりに補充するトークン数
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter は1秒あたりperSecond回、最大burst回まで連続して許可するRateLimiterを作成する
+// burstが1未満の場合は1とする
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   perSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait は実行が許可されるまで待つ
+// ctxがキャンセルされた場合は確保した枠を戻してctx.Err()を返す
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l.rate <= 0 {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
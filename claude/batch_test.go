@@ -0,0 +1,244 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+)
+
+// batchItems はIDがprefix0, prefix1, ...の入力を作成する
+func batchItems(prefix string, n int) []BatchItem {
+	items := make([]BatchItem, n)
+	for i := range items {
+		items[i] = BatchItem{ID: fmt.Sprintf("%s%d", prefix, i), Prompt: "summarize"}
+	}
+	return items
+}
+
+func TestRunBatch_CheckpointResume(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionResult, Result: "ok", CostUSD: 0.01},
+		},
+	})
+	checkpoint := filepath.Join(t.TempDir(), "batch.jsonl")
+
+	// 1件は前回の実行で完了し、2件目の書き込み中に中断した状態
+	previous := `{"id":"item0","result":"ok","cost_usd":0.5,"duration_ns":1000000}` + "\n" + `{"id":"item1","res`
+	if err := os.WriteFile(checkpoint, []byte(previous), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var ran atomic.Int32
+	config := &BatchConfig{
+		Options:     &Options{CLIPath: cliPath},
+		Concurrency: 2,
+		Checkpoint:  checkpoint,
+		OnResult:    func(BatchResult) { ran.Add(1) },
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	summary, err := RunBatch(ctx, batchItems("item", 4), config)
+	if err != nil {
+		t.Fatalf("RunBatch failed: %v", err)
+	}
+	if ran.Load() != 3 || summary.Resumed != 1 || summary.Succeeded != 4 || summary.Failed != 0 {
+		t.Errorf("ran = %d, summary = %+v", ran.Load(), summary)
+	}
+	if diff := summary.TotalCostUSD - 0.53; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("TotalCostUSD = %v, want 0.53", summary.TotalCostUSD)
+	}
+	if len(summary.Results) != 4 || summary.Results[0].ID != "item0" || summary.Results[3].ID != "item3" {
+		t.Errorf("results are not in input order: %+v", summary.Results)
+	}
+	if summary.Results[1].Query == nil || summary.Results[1].SessionID == "" {
+		t.Errorf("result of this run should include the query result: %+v", summary.Results[1])
+	}
+
+	// 再開するとすべて完了済みとして実行しない
+	ran.Store(0)
+	summary, err = RunBatch(ctx, batchItems("item", 4), config)
+	if err != nil {
+		t.Fatalf("RunBatch (resume) failed: %v", err)
+	}
+	if ran.Load() != 0 || summary.Resumed != 4 || summary.Succeeded != 4 {
+		t.Errorf("ran = %d, summary = %+v", ran.Load(), summary)
+	}
+
+	data, err := os.ReadFile(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 途中で終わっていた行は切り詰められる
+	if lines := strings.Count(string(data), "\n"); lines != 4 || strings.Contains(string(data), `"res"`) {
+		t.Errorf("checkpoint has %d lines, want 4:\n%s", lines, data)
+	}
+}
+
+func TestRunBatch_FailuresByClass(t *testing.T) {
+	okCLI := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionResult, Result: "ok", CostUSD: 0.01},
+		},
+	})
+	rateLimitedCLI := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionResult, IsError: true, Subtype: "error_during_execution", ErrorCode: "rate_limit", CostUSD: 0.02},
+		},
+	})
+	checkpoint := filepath.Join(t.TempDir(), "batch.jsonl")
+
+	items := []BatchItem{
+		{ID: "ok", Prompt: "a"},
+		{ID: "limited", Prompt: "b", Options: &Options{CLIPath: rateLimitedCLI}},
+		{ID: "missing", Prompt: "c", Options: &Options{CLIPath: filepath.Join(t.TempDir(), "no-such-cli")}},
+	}
+	config := &BatchConfig{Options: &Options{CLIPath: okCLI}, Checkpoint: checkpoint}
+
+	summary, err := RunBatch(context.Background(), items, config)
+	if err != nil {
+		t.Fatalf("RunBatch failed: %v", err)
+	}
+	if summary.Succeeded != 1 || summary.Failed != 2 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if summary.FailuresByClass["rate_limit"] != 1 || summary.FailuresByClass["process"] != 1 {
+		t.Errorf("FailuresByClass = %v", summary.FailuresByClass)
+	}
+	// 失敗したQueryのコストも集計する
+	if diff := summary.TotalCostUSD - 0.03; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("TotalCostUSD = %v, want 0.03", summary.TotalCostUSD)
+	}
+	if summary.DurationMax <= 0 || summary.DurationP50 > summary.DurationMax {
+		t.Errorf("durations = p50 %v, max %v", summary.DurationP50, summary.DurationMax)
+	}
+
+	// RetryFailedを指定すると失敗した入力だけを再実行する
+	var rerun []string
+	config.RetryFailed = true
+	config.OnResult = func(r BatchResult) { rerun = append(rerun, r.ID) }
+	config.Concurrency = 1
+	if _, err := RunBatch(context.Background(), items, config); err != nil {
+		t.Fatalf("RunBatch (retry failed) failed: %v", err)
+	}
+	if strings.Join(rerun, ",") != "limited,missing" {
+		t.Errorf("rerun = %v, want [limited missing]", rerun)
+	}
+}
+
+func TestRunBatch_Canceled(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionSleep, DurationMs: 10000},
+			{Action: fakecli.ActionResult, Result: "ok"},
+		},
+	})
+	checkpoint := filepath.Join(t.TempDir(), "batch.jsonl")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	summary, err := RunBatch(ctx, batchItems("item", 3), &BatchConfig{
+		Options:    &Options{CLIPath: cliPath},
+		Checkpoint: checkpoint,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RunBatch error = %v, want DeadlineExceeded", err)
+	}
+	if summary == nil || summary.NotRun != 3 {
+		t.Fatalf("summary = %+v, want 3 items not run", summary)
+	}
+
+	// 中断した入力は記録しない
+	if data, _ := os.ReadFile(checkpoint); len(data) != 0 {
+		t.Errorf("checkpoint = %q, want empty", data)
+	}
+}
+
+func TestRunBatch_DuplicateID(t *testing.T) {
+	items := []BatchItem{{ID: "a"}, {ID: "a"}}
+	if _, err := RunBatch(context.Background(), items, nil); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("RunBatch error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(20, 1)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 1回目はすぐに許可され、以降は50msごと
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 waits took %v, want >= 100ms", elapsed)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := limiter.Wait(canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait error = %v, want Canceled", err)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var durations []time.Duration
+	for i := 1; i <= 100; i++ {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+
+	tests := []struct {
+		p    int
+		want time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(durations, tt.p); got != tt.want {
+			t.Errorf("percentile(%d) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of empty = %v", got)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&SDKError{Op: "query", Err: ErrRateLimit}, "rate_limit"},
+		{&SDKError{Op: "retry", Err: &SDKError{Op: "query", Err: ErrBudgetExceeded}}, "limit"},
+		{&SDKError{Op: "query", Err: ErrAuthentication}, "auth"},
+		{&SDKError{Op: "connect", Err: ErrCLIConnection}, "process"},
+		{&SDKError{Op: "parse", Err: ErrMessageParse}, "protocol"},
+		{&StructuredOutputError{}, "structured_output"},
+		{context.DeadlineExceeded, "timeout"},
+		{errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("errorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
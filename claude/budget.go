@@ -0,0 +1,382 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// デフォルトのソフトリミット（上限に対する割合）
+const defaultBudgetSoftLimit = 0.8
+
+// 予算の上限の種類（BudgetWarning.Limit）
+const (
+	BudgetLimitCost     = "cost"
+	BudgetLimitTokens   = "tokens"
+	BudgetLimitTurns    = "turns"
+	BudgetLimitDuration = "duration"
+	// ツール呼び出しの上限は "tool:<ツール名>" で表す
+	budgetLimitToolPrefix = "tool:"
+)
+
+// BudgetConfig はSDK側で強制する予算の設定
+// ゼロ値の上限は無制限として扱う
+type BudgetConfig struct {
+	MaxCostUSD   float64        // コストの上限（ドル）
+	MaxTokens    int64          // トークン数の上限（キャッシュ作成・読み込みを含む）
+	MaxTurns     int            // エージェントのターン数（メインエージェントのAPI応答数）の上限。CLIのMaxTurnsと異なり複数のクエリにまたがって数える
+	MaxDuration  time.Duration  // 最初のClientが接続してからの経過時間の上限
+	MaxToolCalls map[string]int // ツール名ごとの呼び出し回数の上限
+
+	// SoftLimit は警告を出す上限に対する割合（デフォルト: 0.8）
+	SoftLimit float64
+	// OnWarning はソフトリミットに達したときに上限ごとに1回呼ばれる（ブロックしてはならない）
+	OnWarning func(BudgetWarning)
+}
+
+// BudgetWarning はソフトリミットへの到達を表す
+type BudgetWarning struct {
+	Limit string  // 上限の種類（"cost", "tokens", "turns", "duration", "tool:<ツール名>"）
+	Used  float64 // 現在の使用量（durationは秒）
+	Max   float64 // 上限
+}
+
+func (w BudgetWarning) String() string {
+	return fmt.Sprintf("%s at %.0f%% of limit (%g / %g)", w.Limit, w.Used/w.Max*100, w.Used, w.Max)
+}
+
+// Budget は複数のターン・複数のClientで共有する予算
+// Options.Budgetに設定したClientの使用量を合算し、上限に達すると接続中のすべてのClientを中断する
+// 上限超過はClient.Errors()にSDKError（ErrBudgetExceeded / ErrTurnsExceeded）として通知され、
+// 以降のSendとConnectも同じエラーを返す
+type Budget struct {
+	config BudgetConfig
+	usage  *UsageTracker
+
+	mu           sync.Mutex
+	turns        int
+	seenMessages map[string]bool
+	toolCalls    map[string]int
+	seenToolUses map[string]bool
+	// turnIDs はターン内で記録したseenMessages・seenToolUsesのキー（resultでターンが閉じたら削除する）
+	turnIDs  map[usageKey]*budgetTurn
+	warned   map[string]bool
+	started  time.Time
+	timer    *time.Timer
+	clients  map[*Client]struct{}
+	err      error
+	exceeded chan struct{}
+}
+
+// NewBudget は新しいBudgetを作成する
+func NewBudget(config *BudgetConfig) *Budget {
+	b := &Budget{
+		usage:        NewUsageTracker(),
+		seenMessages: make(map[string]bool),
+		toolCalls:    make(map[string]int),
+		seenToolUses: make(map[string]bool),
+		turnIDs:      make(map[usageKey]*budgetTurn),
+		warned:       make(map[string]bool),
+		clients:      make(map[*Client]struct{}),
+		exceeded:     make(chan struct{}),
+	}
+	if config != nil {
+		b.config = *config
+	}
+	if b.config.SoftLimit <= 0 || b.config.SoftLimit > 1 {
+		b.config.SoftLimit = defaultBudgetSoftLimit
+	}
+	return b
+}
+
+// Err は上限を超過している場合にそのエラーを返す
+func (b *Budget) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Exceeded は上限を超過したときにクローズされるチャネルを返す
+func (b *Budget) Exceeded() <-chan struct{} {
+	return b.exceeded
+}
+
+// exceededChan はnilの場合に受信できないチャネルを返す（select用）
+func (b *Budget) exceededChan() <-chan struct{} {
+	if b == nil {
+		return nil
+	}
+	return b.exceeded
+}
+
+// Usage は予算に計上された使用量を返す
+func (b *Budget) Usage() UsageSnapshot {
+	return b.usage.Snapshot()
+}
+
+// ToolCalls はツール名ごとの呼び出し回数を返す
+func (b *Budget) ToolCalls() map[string]int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make(map[string]int, len(b.toolCalls))
+	for name, n := range b.toolCalls {
+		result[name] = n
+	}
+	return result
+}
+
+// Elapsed は予算の計測開始からの経過時間を返す
+func (b *Budget) Elapsed() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.started.IsZero() {
+		return 0
+	}
+	return time.Since(b.started)
+}
+
+// start は最初の利用時に経過時間の計測を開始する（b.muを保持して呼ぶ）
+func (b *Budget) start() {
+	if !b.started.IsZero() {
+		return
+	}
+	b.started = time.Now()
+	if b.config.MaxDuration > 0 {
+		b.timer = time.AfterFunc(b.config.MaxDuration, b.check)
+	}
+}
+
+// attach はClientを予算に登録する（超過済みの場合はエラー）
+func (b *Budget) attach(c *Client) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.start()
+	b.clients[c] = struct{}{}
+	return nil
+}
+
+// detach はClientの登録を解除する
+func (b *Budget) detach(c *Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.clients, c)
+}
+
+// beforeTurn は新しいターン（SendまたはQuery）を開始できるかを確認して計測を開始する
+func (b *Budget) beforeTurn() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	if b.config.MaxTurns > 0 && b.turns >= b.config.MaxTurns {
+		return &SDKError{
+			Op:      "budget",
+			Err:     ErrTurnsExceeded,
+			Details: fmt.Sprintf("%d turns reached the limit of %d", b.turns, b.config.MaxTurns),
+		}
+	}
+	b.start()
+	return nil
+}
+
+// budgetTurn はターン内で記録したメッセージIDとtool_use ID
+type budgetTurn struct {
+	messageIDs []string
+	toolUseIDs []string
+}
+
+// observe は受信メッセージを予算に計上して上限を確認する
+func (b *Budget) observe(source uint64, msg protocol.Message) {
+	b.usage.track(source, msg)
+
+	switch m := msg.(type) {
+	case *protocol.AssistantMessage:
+		b.mu.Lock()
+		turn := b.turn(usageKey{source: source, sessionID: m.SessionID})
+		// CLIは1回のAPI応答をコンテンツブロックごとに同じメッセージIDで複数回出力する
+		if ParentToolUseID(m) == "" && (m.Message.ID == "" || !b.seenMessages[m.Message.ID]) {
+			if id := m.Message.ID; id != "" {
+				b.seenMessages[id] = true
+				turn.messageIDs = append(turn.messageIDs, id)
+			}
+			b.turns++
+		}
+		for _, block := range m.ToolUses() {
+			if !b.seenToolUses[block.ID] {
+				b.seenToolUses[block.ID] = true
+				turn.toolUseIDs = append(turn.toolUseIDs, block.ID)
+				b.toolCalls[block.Name]++
+			}
+		}
+		b.mu.Unlock()
+	case *protocol.ResultMessage:
+		// ターンが閉じたのでメッセージ単位の重複排除の状態を破棄する
+		b.mu.Lock()
+		b.endTurn(usageKey{source: source, sessionID: m.SessionID})
+		b.endTurn(usageKey{source: source})
+		b.mu.Unlock()
+	}
+
+	switch msg.(type) {
+	case *protocol.AssistantMessage, *protocol.ResultMessage:
+		b.check()
+	}
+}
+
+// turn は集計元とセッションのターンを取得または作成する（b.muを保持して呼ぶ）
+func (b *Budget) turn(key usageKey) *budgetTurn {
+	turn, ok := b.turnIDs[key]
+	if !ok {
+		turn = &budgetTurn{}
+		b.turnIDs[key] = turn
+	}
+	return turn
+}
+
+// endTurn はターン内で記録したメッセージIDとtool_use IDを破棄する（b.muを保持して呼ぶ）
+func (b *Budget) endTurn(key usageKey) {
+	turn, ok := b.turnIDs[key]
+	if !ok {
+		return
+	}
+	for _, id := range turn.messageIDs {
+		delete(b.seenMessages, id)
+	}
+	for _, id := range turn.toolUseIDs {
+		delete(b.seenToolUses, id)
+	}
+	delete(b.turnIDs, key)
+}
+
+// release はCLIプロセスの終了時に集計元の状態を破棄する
+func (b *Budget) release(source uint64) {
+	b.usage.release(source)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key := range b.turnIDs {
+		if key.source == source {
+			b.endTurn(key)
+		}
+	}
+}
+
+// budgetUsage は上限1つ分の使用状況
+type budgetUsage struct {
+	limit string
+	used  float64
+	max   float64
+	// exceeded は上限に達して中断すべきか
+	exceeded bool
+	err      error // 超過時のエラー
+}
+
+// usages は設定された上限ごとの使用状況を返す（b.muを保持して呼ぶ）
+func (b *Budget) usages() []budgetUsage {
+	total := b.usage.Snapshot().Total
+	var result []budgetUsage
+
+	if b.config.MaxCostUSD > 0 {
+		result = append(result, budgetUsage{BudgetLimitCost, total.CostUSD, b.config.MaxCostUSD, total.CostUSD >= b.config.MaxCostUSD, ErrBudgetExceeded})
+	}
+	if b.config.MaxTokens > 0 {
+		used := float64(total.TotalTokens())
+		result = append(result, budgetUsage{BudgetLimitTokens, used, float64(b.config.MaxTokens), total.TotalTokens() >= b.config.MaxTokens, ErrBudgetExceeded})
+	}
+	if b.config.MaxDuration > 0 && !b.started.IsZero() {
+		elapsed := time.Since(b.started)
+		result = append(result, budgetUsage{BudgetLimitDuration, elapsed.Seconds(), b.config.MaxDuration.Seconds(), elapsed >= b.config.MaxDuration, ErrBudgetExceeded})
+	}
+
+	names := make([]string, 0, len(b.config.MaxToolCalls))
+	for name := range b.config.MaxToolCalls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		max := b.config.MaxToolCalls[name]
+		if max <= 0 {
+			continue
+		}
+		used := b.toolCalls[name]
+		// 上限の回数までは呼び出せる
+		result = append(result, budgetUsage{budgetLimitToolPrefix + name, float64(used), float64(max), used > max, ErrBudgetExceeded})
+	}
+
+	// ターン数も上限の回数までは実行できる
+	if b.config.MaxTurns > 0 {
+		result = append(result, budgetUsage{BudgetLimitTurns, float64(b.turns), float64(b.config.MaxTurns), b.turns > b.config.MaxTurns, ErrTurnsExceeded})
+	}
+	return result
+}
+
+// check は上限を確認し、ソフトリミットの警告と上限超過時の中断を行う
+func (b *Budget) check() {
+	b.mu.Lock()
+	if b.err != nil {
+		b.mu.Unlock()
+		return
+	}
+
+	var warnings []BudgetWarning
+	var exceeded *budgetUsage
+	for _, u := range b.usages() {
+		if u.exceeded {
+			exceeded = &u
+			break
+		}
+		if u.used >= u.max*b.config.SoftLimit && !b.warned[u.limit] {
+			b.warned[u.limit] = true
+			warnings = append(warnings, BudgetWarning{Limit: u.limit, Used: u.used, Max: u.max})
+		}
+	}
+
+	var clients []*Client
+	if exceeded != nil {
+		b.err = &SDKError{
+			Op:      "budget",
+			Err:     exceeded.err,
+			Details: fmt.Sprintf("%s %g reached the limit of %g", exceeded.limit, exceeded.used, exceeded.max),
+		}
+		close(b.exceeded)
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		for c := range b.clients {
+			clients = append(clients, c)
+		}
+	}
+	err := b.err
+	b.mu.Unlock()
+
+	if b.config.OnWarning != nil {
+		for _, w := range warnings {
+			b.config.OnWarning(w)
+		}
+	}
+	for _, c := range clients {
+		// 受信ループ内から呼ばれるため、中断の制御リクエストは別のgoroutineで送る
+		go c.interruptForBudget(err)
+	}
+}
+
+// interruptForBudget は予算超過を通知して実行中のターンを中断する
+func (c *Client) interruptForBudget(err error) {
+	c.logger.Warn("budget exceeded, interrupting turn", "session_id", c.getSessionIDString(), "error", err)
+	c.sendError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.GetTimeout("control"))
+	defer cancel()
+	if ierr := c.Interrupt(ctx); ierr != nil {
+		c.sendError(ierr)
+	}
+}
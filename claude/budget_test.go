@@ -0,0 +1,255 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+	"github.com/y-oga-819/my-go-claude-agent/internal/transport"
+)
+
+func TestBudget_CostWarningAndLimit(t *testing.T) {
+	var warnings []BudgetWarning
+	budget := NewBudget(&BudgetConfig{
+		MaxCostUSD: 1.0,
+		OnWarning:  func(w BudgetWarning) { warnings = append(warnings, w) },
+	})
+
+	budget.observe(1, &protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: 0.5})
+	if len(warnings) != 0 || budget.Err() != nil {
+		t.Fatalf("unexpected warning/error at 50%%: %v %v", warnings, budget.Err())
+	}
+
+	budget.observe(1, &protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: 0.85})
+	// 別のClient（CLIプロセス）の使用量も合算される
+	budget.observe(2, &protocol.ResultMessage{Type: "result", SessionID: "s2", TotalCostUSD: 0.1})
+	if len(warnings) != 1 || warnings[0].Limit != BudgetLimitCost {
+		t.Fatalf("warnings = %v, want one cost warning", warnings)
+	}
+	if budget.Err() != nil {
+		t.Fatalf("unexpected error: %v", budget.Err())
+	}
+
+	budget.observe(2, &protocol.ResultMessage{Type: "result", SessionID: "s2", TotalCostUSD: 0.2})
+	err := budget.Err()
+	var sdkErr *SDKError
+	if !errors.As(err, &sdkErr) || !errors.Is(err, ErrBudgetExceeded) || sdkErr.Op != "budget" {
+		t.Fatalf("expected budget SDKError, got %v", err)
+	}
+	select {
+	case <-budget.Exceeded():
+	default:
+		t.Error("Exceeded() should be closed")
+	}
+	if err := budget.beforeTurn(); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("beforeTurn = %v", err)
+	}
+}
+
+func TestBudget_TurnsAndTokens(t *testing.T) {
+	budget := NewBudget(&BudgetConfig{MaxTurns: 2, MaxTokens: 1000})
+
+	assistant := func(id string, tokens int) *protocol.AssistantMessage {
+		return assistantWithUsage(id, "m", "s1", nil, protocol.Usage{InputTokens: tokens})
+	}
+	budget.observe(1, assistant("msg_1", 100))
+	budget.observe(1, assistant("msg_1", 100)) // 同じAPI応答
+	budget.observe(1, assistant("msg_2", 100))
+	if err := budget.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := budget.beforeTurn(); !errors.Is(err, ErrTurnsExceeded) {
+		t.Errorf("beforeTurn = %v, want ErrTurnsExceeded", err)
+	}
+
+	budget.observe(1, assistant("msg_3", 100))
+	if err := budget.Err(); !errors.Is(err, ErrTurnsExceeded) {
+		t.Errorf("Err = %v, want ErrTurnsExceeded", err)
+	}
+
+	tokens := NewBudget(&BudgetConfig{MaxTokens: 150})
+	tokens.observe(1, assistant("msg_1", 100))
+	tokens.observe(1, assistant("msg_2", 100))
+	if err := tokens.Err(); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Err = %v, want ErrBudgetExceeded", err)
+	}
+}
+
+// budgetTestClient は予算を共有するClientをモックTransportで接続する
+func budgetTestClient(t *testing.T, budget *Budget, interrupts chan<- string) (*Client, *mockTransport) {
+	t.Helper()
+	mt := newMockTransport()
+	client := NewClient(&Options{
+		Budget:    budget,
+		Transport: func(transport.Config) transport.Transport { return mt },
+	})
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		if req["subtype"] == "interrupt" {
+			interrupts <- "interrupt"
+		}
+		return map[string]any{}, ""
+	})
+
+	// Connectのctxは受信ループの寿命になるため、テスト終了までキャンセルしない
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		client.Close()
+		cancel()
+	})
+	if _, err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	return client, mt
+}
+
+func TestBudget_ToolCallLimitInterruptsSharedClients(t *testing.T) {
+	var mu sync.Mutex
+	var warnings []BudgetWarning
+	budget := NewBudget(&BudgetConfig{
+		MaxToolCalls: map[string]int{"Bash": 1},
+		OnWarning: func(w BudgetWarning) {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings = append(warnings, w)
+		},
+	})
+	interrupts := make(chan string, 4)
+	clientA, mtA := budgetTestClient(t, budget, interrupts)
+	clientB, mtB := budgetTestClient(t, budget, interrupts)
+
+	toolUse := func(id string) transport.RawMessage {
+		return rawMessage(map[string]any{
+			"type": "assistant",
+			"message": map[string]any{
+				"role":    "assistant",
+				"model":   "m",
+				"content": []any{map[string]any{"type": "tool_use", "id": id, "name": "Bash", "input": map[string]any{}}},
+			},
+		})
+	}
+	mtA.msgChan <- toolUse("toolu_1")
+	<-clientA.Messages()
+	mtB.msgChan <- toolUse("toolu_2")
+
+	for _, c := range []*Client{clientA, clientB} {
+		select {
+		case err := <-c.Errors():
+			if !errors.Is(err, ErrBudgetExceeded) {
+				t.Errorf("error = %v, want ErrBudgetExceeded", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for budget error")
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-interrupts:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected interrupt for both clients, got %d", i)
+		}
+	}
+
+	mu.Lock()
+	if len(warnings) != 1 || warnings[0].Limit != "tool:Bash" {
+		t.Errorf("warnings = %v", warnings)
+	}
+	mu.Unlock()
+
+	if err := clientA.Send(context.Background(), "more"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Send = %v, want ErrBudgetExceeded", err)
+	}
+	if calls := budget.ToolCalls(); calls["Bash"] != 2 {
+		t.Errorf("ToolCalls = %v", calls)
+	}
+}
+
+func TestBudget_DurationLimit(t *testing.T) {
+	budget := NewBudget(&BudgetConfig{MaxDuration: 50 * time.Millisecond})
+	interrupts := make(chan string, 1)
+	client, _ := budgetTestClient(t, budget, interrupts)
+
+	select {
+	case err := <-client.Errors():
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for duration limit")
+	}
+	select {
+	case <-interrupts:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected interrupt")
+	}
+}
+
+func TestBudget_PrunesTurnState(t *testing.T) {
+	budget := NewBudget(&BudgetConfig{MaxTurns: 10})
+	bash := &protocol.ToolUseBlock{ID: "toolu_1", Name: "Bash"}
+	budget.observe(1, assistantWithUsage("msg_1", "m", "s1", nil, protocol.Usage{InputTokens: 1}, bash))
+	budget.observe(1, assistantWithUsage("", "m", "s1", nil, protocol.Usage{InputTokens: 1}))
+
+	budget.mu.Lock()
+	if len(budget.seenMessages) != 1 || len(budget.seenToolUses) != 1 {
+		t.Errorf("seenMessages = %v, seenToolUses = %v", budget.seenMessages, budget.seenToolUses)
+	}
+	budget.mu.Unlock()
+
+	// resultでターンが閉じたらメッセージ単位の状態を破棄する
+	budget.observe(1, &protocol.ResultMessage{Type: "result", SessionID: "s1"})
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	if len(budget.seenMessages) != 0 || len(budget.seenToolUses) != 0 || len(budget.turnIDs) != 0 {
+		t.Errorf("after result: seenMessages = %v, seenToolUses = %v, turnIDs = %d",
+			budget.seenMessages, budget.seenToolUses, len(budget.turnIDs))
+	}
+	if budget.turns != 2 || budget.toolCalls["Bash"] != 1 {
+		t.Errorf("turns = %d, toolCalls = %v", budget.turns, budget.toolCalls)
+	}
+}
+
+func TestBudget_DetachOnConnectError(t *testing.T) {
+	budget := NewBudget(&BudgetConfig{MaxTurns: 1})
+	client := NewClient(&Options{CLIPath: filepath.Join(t.TempDir(), "missing-cli"), Budget: budget})
+	defer client.Close()
+
+	if _, err := client.Connect(context.Background()); err == nil {
+		t.Fatal("Connect should fail for a missing CLI")
+	}
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	if _, ok := budget.clients[client]; ok {
+		t.Error("client should be detached from the budget when Connect fails")
+	}
+}
+
+func TestQuery_BudgetSharedAcrossQueries(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionResult, Result: "ok", CostUSD: 0.02},
+		},
+	})
+	budget := NewBudget(&BudgetConfig{MaxCostUSD: 0.03})
+	opts := &Options{CLIPath: cliPath, Budget: budget}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := Query(ctx, "one", opts); err != nil {
+		t.Fatalf("first Query failed: %v", err)
+	}
+	if _, err := Query(ctx, "two", opts); err != nil {
+		t.Fatalf("second Query failed: %v", err)
+	}
+	if _, err := Query(ctx, "three", opts); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("third Query = %v, want ErrBudgetExceeded", err)
+	}
+	if cost := budget.Usage().Total.CostUSD; !approxEqual(cost, 0.04) {
+		t.Errorf("cost = %v, want 0.04", cost)
+	}
+}
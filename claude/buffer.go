@@ -0,0 +1,124 @@
+package claude
+
+import (
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// OverflowPolicy はStream.Messages()のバッファが満杯の場合の動作を表す
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest は最も古いメッセージを破棄する（デフォルト）
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowBlock はコンシューマが読み出すまでCLIからの受信を止める
+	// 受信ループ全体が止まるため、Messages()を読まない間は制御リクエストも応答しない
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowSpill は溢れたメッセージをディスクに退避し、順番を保って配信する
+	OverflowSpill OverflowPolicy = "spill"
+	// OverflowError は新しいメッセージを破棄し、Errors()にErrMessageBufferFullを送る
+	OverflowError OverflowPolicy = "error"
+)
+
+// デフォルトのバッファサイズ
+const (
+	DefaultMessageBufferSize = protocol.DefaultMessageBufferSize
+	DefaultErrorBufferSize   = 10
+)
+
+// BufferConfig はメッセージパイプラインのバッファ設定
+type BufferConfig struct {
+	MessageBufferSize   int                    // Messages()の容量（デフォルト: 100）
+	ErrorBufferSize     int                    // Errors()の容量（デフォルト: 10）
+	TransportBufferSize int                    // CLI出力の受信チャネルの容量（デフォルト: 100）
+	OverflowPolicy      OverflowPolicy         // 満杯時の動作（デフォルト: OverflowDropOldest）
+	SpillDir            string                 // OverflowSpillの退避先（デフォルト: os.TempDir()）
+	OnDrop              func(protocol.Message) // メッセージを破棄した際のコールバック（任意）
+}
+
+// DeliveryStats はメッセージ配信の統計
+type DeliveryStats struct {
+	Delivered     uint64            // Messages()に送信したメッセージ数
+	Dropped       uint64            // 破棄したメッセージ数
+	Spilled       uint64            // ディスクに退避したメッセージ数
+	Pending       int               // 退避中で未配信のメッセージ数
+	DroppedByType map[string]uint64 // メッセージ型ごとの破棄数
+	DroppedErrors uint64            // Errors()が満杯で破棄したエラー数
+}
+
+// errorBufferSize はErrors()の容量を返す
+func (o *Options) errorBufferSize() int {
+	if o.Buffer != nil && o.Buffer.ErrorBufferSize > 0 {
+		return o.Buffer.ErrorBufferSize
+	}
+	return DefaultErrorBufferSize
+}
+
+// transportBufferSize はCLI出力の受信チャネルの容量を返す（0はデフォルト）
+func (o *Options) transportBufferSize() int {
+	if o.Buffer == nil {
+		return 0
+	}
+	return o.Buffer.TransportBufferSize
+}
+
+// deliveryConfig はプロトコル層のメッセージ配信設定に変換する
+func (o *Options) deliveryConfig() protocol.DeliveryConfig {
+	var config protocol.DeliveryConfig
+	if o.Buffer != nil {
+		config = protocol.DeliveryConfig{
+			BufferSize: o.Buffer.MessageBufferSize,
+			Policy:     protocol.OverflowPolicy(o.Buffer.OverflowPolicy),
+			SpillDir:   o.Buffer.SpillDir,
+			OnDrop:     o.Buffer.OnDrop,
+		}
+	}
+
+	// 破棄したメッセージをメトリクスにも記録する
+	if o.Metrics != nil {
+		onDrop := config.OnDrop
+		config.OnDrop = func(msg protocol.Message) {
+			o.Metrics.observeDrop(msg)
+			if onDrop != nil {
+				onDrop(msg)
+			}
+		}
+	}
+	return config
+}
+
+// sendError はErrors()にエラーを送信する
+// 満杯の場合は受信ループを止めないよう破棄し、件数を記録する
+func (c *Client) sendError(err error) {
+	select {
+	case c.errChan <- err:
+	case <-c.closeChan:
+	default:
+		c.droppedErrors.Add(1)
+	}
+}
+
+// DeliveryStats はメッセージ配信の統計を返す
+func (c *Client) DeliveryStats() DeliveryStats {
+	stats := DeliveryStats{
+		DroppedErrors: c.droppedErrors.Load(),
+	}
+
+	c.mu.RLock()
+	h := c.protocol
+	c.mu.RUnlock()
+
+	if h != nil {
+		ps := h.DeliveryStats()
+		stats.Delivered = ps.Delivered
+		stats.Dropped = ps.Dropped
+		stats.Spilled = ps.Spilled
+		stats.Pending = ps.Pending
+		stats.DroppedByType = ps.DroppedByType
+	}
+	return stats
+}
+
+// DeliveryStats はメッセージ配信の統計を返す
+func (s *Stream) DeliveryStats() DeliveryStats {
+	return s.client.DeliveryStats()
+}
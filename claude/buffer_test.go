@@ -0,0 +1,88 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+func TestOptions_DeliveryConfig(t *testing.T) {
+	opts := &Options{}
+	if cfg := opts.deliveryConfig(); cfg.BufferSize != 0 || cfg.Policy != "" {
+		t.Errorf("default deliveryConfig = %+v", cfg)
+	}
+	if opts.errorBufferSize() != DefaultErrorBufferSize {
+		t.Errorf("errorBufferSize = %d, want %d", opts.errorBufferSize(), DefaultErrorBufferSize)
+	}
+
+	opts.Buffer = &BufferConfig{
+		MessageBufferSize: 500,
+		ErrorBufferSize:   50,
+		OverflowPolicy:    OverflowSpill,
+		SpillDir:          "/tmp/spill",
+	}
+	cfg := opts.deliveryConfig()
+	if cfg.BufferSize != 500 || cfg.Policy != protocol.OverflowSpill || cfg.SpillDir != "/tmp/spill" {
+		t.Errorf("deliveryConfig = %+v", cfg)
+	}
+	if opts.errorBufferSize() != 50 {
+		t.Errorf("errorBufferSize = %d, want %d", opts.errorBufferSize(), 50)
+	}
+}
+
+func TestClient_SendErrorDoesNotBlock(t *testing.T) {
+	client := NewClient(&Options{Buffer: &BufferConfig{ErrorBufferSize: 1}})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			client.sendError(errors.New("boom"))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sendError blocked on a full error channel")
+	}
+
+	if got := client.DeliveryStats().DroppedErrors; got != 2 {
+		t.Errorf("DroppedErrors = %d, want %d", got, 2)
+	}
+}
+
+func TestClient_OverflowErrorSurfacesOnErrors(t *testing.T) {
+	client, mt := newTestClient(&Options{
+		Buffer: &BufferConfig{MessageBufferSize: 1, OverflowPolicy: OverflowError},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.receiveLoop(ctx, mt)
+
+	for i := 0; i < 2; i++ {
+		mt.msgChan <- rawMessage(map[string]any{
+			"type":    "system",
+			"subtype": "test",
+			"data":    map[string]any{},
+		})
+	}
+
+	select {
+	case err := <-client.Errors():
+		if !errors.Is(err, ErrMessageBufferFull) {
+			t.Errorf("expected ErrMessageBufferFull, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for overflow error")
+	}
+
+	stats := client.DeliveryStats()
+	if stats.Dropped != 1 || stats.DroppedByType["system"] != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
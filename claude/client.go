@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 
 	"github.com/y-oga-819/my-go-claude-agent/internal/hooks"
+	"github.com/y-oga-819/my-go-claude-agent/internal/logging"
+	"github.com/y-oga-819/my-go-claude-agent/internal/mcp"
 	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
 	"github.com/y-oga-819/my-go-claude-agent/internal/transport"
 )
@@ -24,19 +27,77 @@ type Client struct {
 	transport   transport.Transport
 	protocol    *protocol.ProtocolHandler
 	hookManager *hooks.Manager
+	mcpManager  *mcp.Manager
+
+	// hookMatchers はinitialize時にCLIへ登録するフックマッチャー
+	hookMatchers map[string][]protocol.HookMatcher
 
 	// sessionID はatomic.Pointerで管理（ロックフリー）
 	// Connect()時のデッドロックを回避するため、c.muとは独立して管理
 	sessionID atomic.Pointer[string]
 
+	// lineage は接続時に特定した親セッション（Resume/Continue未使用時はnil）
+	lineage *pendingLineage
+
+	// resumeSessionID は自動復旧時に再開するセッションID（initializeのResumeを上書きする）
+	resumeSessionID atomic.Pointer[string]
+
+	// overrides は実行中に変更した設定（再接続時のinitializeに反映する）
+	// Connect中はc.muを保持したまま参照するため、c.muとは別のロックで保護する
+	overridesMu sync.Mutex
+	overrides   runtimeOverrides
+
+	// capabilities はinitializeレスポンスから取得したCLIの機能スナップショット
+	capabilities atomic.Pointer[protocol.InitializeResponse]
+
+	// ready はConnectが完了し、プロセス終了時に自動復旧の対象となるか
+	ready atomic.Bool
+	// recoveryMu は自動復旧処理を直列化する
+	recoveryMu sync.Mutex
+	// recoveryAttempts はターン完了までに行った再接続の試行回数
+	recoveryAttempts atomic.Int32
+
+	// usage は使用量・コストの集計（Options.Usage、未設定の場合はClient専用）
+	usage *UsageTracker
+	// usageSource はCLIプロセスごとの集計元ID（プロセス内の累計コストを区別する）
+	usageSource atomic.Uint64
+
+	// traces はターンとツール呼び出しのスパン
+	traces *turnTracer
+
+	// lifetime はCLIプロセスと受信ループの寿命（nilの場合はConnectのctx、Poolが設定する）
+	lifetime context.Context
+
+	// logger はOptions.Loggerから作成したロガー（未設定時は出力しない）
+	logger *slog.Logger
+
+	// recorder はCLIとのやり取りの記録先（Recording未設定時はnil）
+	recorder *transport.Recorder
+
+	// droppedErrors はErrors()が満杯で破棄したエラー数
+	droppedErrors atomic.Uint64
+
 	msgChan   chan protocol.Message
 	errChan   chan error
 	closeChan chan struct{}
 
+	// exited はCLIプロセスが終了し、自動復旧しない・復旧を断念した場合にクローズされる（Queryで使用）
+	exited   chan struct{}
+	exitOnce sync.Once
+	// exitErr はプロセス終了の理由（終了コード・stderr）。exitedのクローズ前に設定する
+	exitErr *SDKError
+
 	mu     sync.RWMutex
 	closed bool
 }
 
+// runtimeOverrides はSetModel等で変更した設定（nilの項目はOptionsの値を使用する）
+type runtimeOverrides struct {
+	model             *string
+	permissionMode    *PermissionMode
+	maxThinkingTokens *int
+}
+
 // Stream は双方向ストリーミングの状態を表す
 type Stream struct {
 	client *Client
@@ -47,57 +108,135 @@ func NewClient(opts *Options) *Client {
 	if opts == nil {
 		opts = &Options{}
 	}
+	// 同じOptionsから作成した他のClientに影響しないようClient専用のコピーを使用する
+	copied := *opts
+	opts = &copied
 
 	c := &Client{
 		opts:        opts,
+		usage:       opts.Usage,
+		traces:      newTurnTracer(opts.tracer()),
+		logger:      opts.logger(),
 		hookManager: hooks.NewManager(),
+		mcpManager:  mcp.NewManager(),
 		msgChan:     make(chan protocol.Message, 100),
-		errChan:     make(chan error, 10),
+		errChan:     make(chan error, opts.errorBufferSize()),
 		closeChan:   make(chan struct{}),
+		exited:      make(chan struct{}),
 	}
 
+	if c.usage == nil {
+		c.usage = NewUsageTracker()
+	}
+
+	c.hookManager.SetLogger(c.logger)
+	c.mcpManager.SetLogger(c.logger)
+
 	// フックを登録
 	c.registerHooks()
+	if opts.Tracer != nil || opts.Metrics != nil {
+		c.hookManager.SetExecuteObserver(c.observeHookCommand)
+	}
+
+	// MCPサーバーを登録
+	c.registerMCPServers()
+	if opts.Metrics != nil {
+		c.mcpManager.SetRequestObserver(opts.Metrics.observeMCPRequest)
+	}
 
 	return c
 }
 
 // Connect はCLIに接続し、双方向ストリーミングを開始する
 // 注意: SessionID()は最初のメッセージを受信するまでErrSessionIDNotReadyを返す
-func (c *Client) Connect(ctx context.Context) (*Stream, error) {
+func (c *Client) Connect(ctx context.Context) (_ *Stream, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	spanCtx, span := c.traces.tracer.Start(ctx, SpanConnect, Attr(AttrModel, c.opts.Model))
+	defer func() {
+		span.SetAttributes(Attr(AttrSessionID, c.getSessionIDString()))
+		endSpan(span, err)
+	}()
+
 	if c.closed {
 		return nil, fmt.Errorf("client is closed")
 	}
 
-	// Transport設定
-	config := transport.Config{
-		CLIPath:       c.opts.CLIPath,
-		CWD:           c.opts.CWD,
-		StreamingMode: true, // 双方向ストリーミングモード
+	if err := validateAgents(c.opts.Agents); err != nil {
+		return nil, &SDKError{Op: "connect", Err: ErrInvalidConfig, Details: err.Error()}
 	}
 
-	// CanUseToolコールバックが設定されている場合、CLIに権限確認を委譲するよう設定
-	// これにより、CLIはツール使用時にSDKへcontrol_request（can_use_tool）を送信する
-	if c.opts.CanUseTool != nil {
-		config.PermissionPromptToolName = "stdio"
+	if c.opts.Budget != nil {
+		if attachErr := c.opts.Budget.attach(c); attachErr != nil {
+			return nil, attachErr
+		}
+		// 接続に失敗した場合は予算の登録を解除する
+		defer func() {
+			if err != nil {
+				c.opts.Budget.detach(c)
+			}
+		}()
 	}
 
-	c.transport = transport.NewSubprocessTransport(config)
+	if c.recorder == nil {
+		recorder, recordErr := c.opts.Recording.newRecorder()
+		if recordErr != nil {
+			return nil, &SDKError{Op: "connect", Err: ErrInvalidConfig, Details: recordErr.Error()}
+		}
+		c.recorder = recorder
+		// 接続に失敗した場合は記録先を閉じる（再度のConnectで新しく作成する）
+		defer func() {
+			if err != nil && recorder != nil {
+				recorder.Close()
+				c.recorder = nil
+			}
+		}()
+	}
 
-	// 接続
-	if err := c.transport.Connect(ctx); err != nil {
-		return nil, &SDKError{Op: "connect", Err: ErrCLIConnection, Details: err.Error()}
+	// CLIが新しいトランスクリプトを書き始める前に親セッションを特定する
+	c.lineage = newPendingLineage(c.opts)
+
+	// ウォームプールに同じオプションで初期化済みのプロセスがあれば使用する
+	var warm *warmProcess
+	if c.warmable() {
+		warm = c.opts.WarmPool.take(c.warmSpec().fingerprint)
+	}
+
+	lifetime := ctx
+	if c.lifetime != nil {
+		lifetime = c.lifetime
+	}
+
+	if warm != nil {
+		c.transport = warm.transport
+		c.logger.Debug("using warm CLI process")
+	} else {
+		c.transport = c.opts.newTransport(c.transportConfig(), c.recorder)
+
+		// 接続
+		if err := c.transport.Connect(lifetime); err != nil {
+			c.logger.Error("failed to connect to CLI", "error", err)
+			return nil, &SDKError{Op: "connect", Err: ErrCLIConnection, Details: err.Error()}
+		}
 	}
 
 	// プロトコルハンドラを作成
-	c.protocol = protocol.NewProtocolHandler(c.transport)
+	c.protocol = protocol.NewProtocolHandlerWithConfig(c.transport, c.opts.deliveryConfig())
+	c.protocol.SetLogger(c.logger)
+	if c.opts.Metrics != nil {
+		c.protocol.SetControlTimeoutCallback(c.opts.Metrics.observeControlTimeout)
+	}
 
 	// canUseToolコールバックを設定
 	if c.opts.CanUseTool != nil {
-		c.protocol.SetCanUseToolCallback(func(ctx context.Context, req *protocol.CanUseToolRequest) (*protocol.CanUseToolResponse, error) {
+		c.protocol.SetCanUseToolCallback(func(ctx context.Context, req *protocol.CanUseToolRequest) (_ *protocol.CanUseToolResponse, err error) {
+			ctx, span := c.traces.startCallback(ctx, SpanCanUseTool, "", req.ToolName,
+				Attr(AttrToolName, req.ToolName),
+				Attr(AttrSessionID, req.SessionID),
+			)
+			defer func() { endSpan(span, err) }()
+
 			permCtx := &ToolPermissionContext{
 				SessionID:             req.SessionID,
 				PermissionSuggestions: convertPermissionSuggestions(req.PermissionSuggestions),
@@ -106,8 +245,15 @@ func (c *Client) Connect(ctx context.Context) (*Stream, error) {
 
 			result, err := c.opts.CanUseTool(ctx, req.ToolName, req.Input, permCtx)
 			if err != nil {
+				c.opts.Metrics.observePermission(req.ToolName, permissionError)
 				return nil, err
 			}
+			span.SetAttributes(Attr(AttrPermissionAllow, result.Allow))
+			if result.Allow {
+				c.opts.Metrics.observePermission(req.ToolName, permissionAllow)
+			} else {
+				c.opts.Metrics.observePermission(req.ToolName, permissionDeny)
+			}
 
 			return &protocol.CanUseToolResponse{
 				Allow:              result.Allow,
@@ -119,19 +265,100 @@ func (c *Client) Connect(ctx context.Context) (*Stream, error) {
 		})
 	}
 
-	// メッセージ受信ループを開始
-	go c.receiveLoop(ctx)
+	// 受信メッセージを使用量の集計に反映
+	c.usageSource.Store(nextUsageSource())
+	c.protocol.SetMessageObserver(c.observeMessage)
 
-	// 初期化リクエストを送信
-	if err := c.initialize(ctx); err != nil {
-		c.transport.Close()
-		return nil, err
+	// SDK MCPサーバー宛てのmcp_messageをインプロセスで処理
+	c.protocol.SetMCPMessageCallback(c.handleMCPMessage)
+
+	// hook_callbackをhooks.Managerにルーティング
+	c.hookMatchers = c.registerHookCallbacks()
+
+	// メッセージ受信ループを開始
+	go c.receiveLoop(lifetime, c.transport)
+
+	if warm != nil {
+		// 初期化済みのプロセスはinitializeの応答を引き継ぐ
+		c.extractSessionIDFromResponse(warm.initResp)
+		c.storeCapabilities(warm.initResp)
+	} else {
+		// 初期化リクエストを送信
+		if err := c.initialize(spanCtx); err != nil {
+			c.logger.Error("failed to initialize CLI session", "error", err)
+			c.transport.Close()
+			return nil, err
+		}
 	}
 
+	// 以降のプロセス終了は自動復旧の対象
+	c.ready.Store(true)
+	c.opts.Metrics.attach(c)
+	c.logger.Info("client connected", "session_id", c.getSessionIDString(), "model", c.opts.Model)
+
 	return &Stream{client: c}, nil
 }
 
-func (c *Client) initialize(ctx context.Context) error {
+// transportConfig はOptionsからTransport設定を構築する
+func (c *Client) transportConfig() transport.Config {
+	config := transport.Config{
+		CLIPath:       c.opts.CLIPath,
+		CWD:           c.opts.CWD,
+		StreamingMode: true, // 双方向ストリーミングモード
+		Args:          buildClientArgs(c.opts),
+
+		MessageBufferSize: c.opts.transportBufferSize(),
+		Logger:            c.logger,
+	}
+
+	// CanUseToolコールバックが設定されている場合、CLIに権限確認を委譲するよう設定
+	// これにより、CLIはツール使用時にSDKへcontrol_request（can_use_tool）を送信する
+	if c.opts.CanUseTool != nil {
+		config.PermissionPromptToolName = "stdio"
+	}
+
+	return config
+}
+
+// buildClientArgs はストリーミングモードでCLIに渡す追加引数を構築する
+// 多くの設定はinitializeリクエストで渡すため、CLI引数でのみ指定できるものに限る
+func buildClientArgs(opts *Options) []string {
+	args := []string{}
+
+	if opts.IncludePartialMessages {
+		args = append(args, "--include-partial-messages")
+	}
+
+	return args
+}
+
+func (c *Client) initialize(ctx context.Context) (err error) {
+	ctx, span := c.traces.tracer.Start(ctx, SpanInitialize, Attr(AttrModel, c.opts.Model))
+	defer func() {
+		span.SetAttributes(Attr(AttrSessionID, c.getSessionIDString()))
+		endSpan(span, err)
+	}()
+
+	resp, err := c.protocol.SendControlRequest(ctx, c.initializeRequest(c.hookMatchers))
+	if err != nil {
+		return &SDKError{Op: "initialize", Err: err}
+	}
+
+	if resp.Response.Subtype == "error" {
+		return &SDKError{Op: "initialize", Err: fmt.Errorf("initialization failed"), Details: resp.Response.Error}
+	}
+
+	// セッションIDを取得（複数のレスポンス形式に対応）
+	c.extractSessionIDFromResponse(resp)
+
+	// CLIの機能スナップショットを保存
+	c.storeCapabilities(resp)
+
+	return nil
+}
+
+// initializeRequest はOptionsからinitializeリクエストを構築する
+func (c *Client) initializeRequest(hookMatchers map[string][]protocol.HookMatcher) protocol.InitializeRequest {
 	initReq := protocol.InitializeRequest{
 		Subtype:            "initialize",
 		SystemPrompt:       c.opts.SystemPrompt,
@@ -139,8 +366,13 @@ func (c *Client) initialize(ctx context.Context) error {
 		Model:              c.opts.Model,
 		MaxTurns:           c.opts.MaxTurns,
 		MaxBudgetUSD:       c.opts.MaxBudgetUSD,
+		MaxThinkingTokens:  c.opts.MaxThinkingTokens,
 		AllowedTools:       c.opts.AllowedTools,
 		DisallowedTools:    c.opts.DisallowedTools,
+		MCPServers:         c.mcpManager.BuildCLIConfig(),
+		Hooks:              hookMatchers,
+		OutputFormat:       c.opts.OutputFormat.toProtocol(),
+		Agents:             buildAgentsConfig(c.opts.Agents),
 
 		// セッション設定
 		Resume:                  c.opts.Resume,
@@ -153,19 +385,26 @@ func (c *Client) initialize(ctx context.Context) error {
 		initReq.PermissionMode = string(c.opts.PermissionMode)
 	}
 
-	resp, err := c.protocol.SendControlRequest(ctx, initReq)
-	if err != nil {
-		return &SDKError{Op: "initialize", Err: err}
+	c.overridesMu.Lock()
+	if c.overrides.model != nil {
+		initReq.Model = *c.overrides.model
 	}
-
-	if resp.Response.Subtype == "error" {
-		return &SDKError{Op: "initialize", Err: fmt.Errorf("initialization failed"), Details: resp.Response.Error}
+	if c.overrides.permissionMode != nil {
+		initReq.PermissionMode = string(*c.overrides.permissionMode)
 	}
+	if c.overrides.maxThinkingTokens != nil {
+		initReq.MaxThinkingTokens = *c.overrides.maxThinkingTokens
+	}
+	c.overridesMu.Unlock()
 
-	// セッションIDを取得（複数のレスポンス形式に対応）
-	c.extractSessionIDFromResponse(resp)
+	// 自動復旧時は同じセッションを再開する（分岐・継続ではなく）
+	if resume := c.resumeSessionID.Load(); resume != nil {
+		initReq.Resume = *resume
+		initReq.ForkSession = false
+		initReq.Continue = false
+	}
 
-	return nil
+	return initReq
 }
 
 // extractSessionIDFromResponse はControlResponseからsessionIDを抽出する
@@ -178,13 +417,12 @@ func (c *Client) extractSessionIDFromResponse(resp *protocol.ControlResponse) {
 	// Response.Responseがmap[string]anyの場合
 	if respData, ok := resp.Response.Response.(map[string]any); ok {
 		if sid, ok := respData["session_id"].(string); ok && sid != "" {
-			// CompareAndSwapで初回のみ設定（競合安全）
-			c.sessionID.CompareAndSwap(nil, &sid)
+			c.setSessionID(sid)
 		}
 	}
 }
 
-func (c *Client) receiveLoop(ctx context.Context) {
+func (c *Client) receiveLoop(ctx context.Context, t transport.Transport) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -192,21 +430,28 @@ func (c *Client) receiveLoop(ctx context.Context) {
 		case <-c.closeChan:
 			return
 
-		case err := <-c.transport.Errors():
+		case err := <-t.Errors():
 			if err != nil {
-				c.errChan <- err
+				c.sendError(err)
 			}
 
-		case rawMsg, ok := <-c.transport.Messages():
+		case rawMsg, ok := <-t.Messages():
 			if !ok {
+				// CLIプロセスが終了した
+				c.handleTransportExit(ctx, t)
 				return
 			}
 
 			// ResultMessageからsessionIDを抽出
 			c.extractSessionIDFromRawMessage(rawMsg)
 
+			// ターンが完了したら再接続の試行回数をリセット
+			if rawMsg.Type == "result" {
+				c.recoveryAttempts.Store(0)
+			}
+
 			if err := c.protocol.HandleIncoming(ctx, rawMsg); err != nil {
-				c.errChan <- err
+				c.sendError(err)
 			}
 		}
 	}
@@ -223,8 +468,7 @@ func (c *Client) extractSessionIDFromRawMessage(rawMsg transport.RawMessage) {
 	// resultメッセージからsession_idを取得
 	if rawMsg.Type == "result" {
 		if sid, ok := rawMsg.Data["session_id"].(string); ok && sid != "" {
-			// CompareAndSwapで初回のみ設定（競合安全）
-			c.sessionID.CompareAndSwap(nil, &sid)
+			c.setSessionID(sid)
 			return
 		}
 	}
@@ -233,15 +477,39 @@ func (c *Client) extractSessionIDFromRawMessage(rawMsg transport.RawMessage) {
 	if rawMsg.Type == "system" {
 		if data, ok := rawMsg.Data["data"].(map[string]any); ok {
 			if sid, ok := data["session_id"].(string); ok && sid != "" {
-				// CompareAndSwapで初回のみ設定（競合安全）
-				c.sessionID.CompareAndSwap(nil, &sid)
+				c.setSessionID(sid)
 			}
 		}
 	}
 }
 
+// setSessionID は初回のみsessionIDを設定し、Lineageが設定されていれば親セッションとの関係を記録する
+func (c *Client) setSessionID(sid string) {
+	// CompareAndSwapで初回のみ設定（競合安全）
+	if !c.sessionID.CompareAndSwap(nil, &sid) {
+		return
+	}
+	if err := c.lineage.record(sid); err != nil {
+		c.sendError(err)
+	}
+}
+
 // Send はユーザーメッセージを送信する
 func (c *Client) Send(ctx context.Context, content string) error {
+	return c.sendUserMessage(ctx, protocol.NewTextContent(content))
+}
+
+// SendContent は画像やドキュメントを含むユーザーメッセージを送信する
+func (c *Client) SendContent(ctx context.Context, content *Content) error {
+	if content == nil || len(content.Blocks) == 0 {
+		return &SDKError{Op: "send", Err: ErrInvalidContent, Details: "content is empty"}
+	}
+	return c.sendUserMessage(ctx, content.messageContent())
+}
+
+// sendUserMessage はUserPromptSubmitフックを実行してからユーザーメッセージを送信する
+// contentは文字列またはコンテンツブロックの配列
+func (c *Client) sendUserMessage(ctx context.Context, content protocol.BlockContent) (err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -249,9 +517,23 @@ func (c *Client) Send(ctx context.Context, content string) error {
 		return fmt.Errorf("client is not connected")
 	}
 
+	if c.opts.Budget != nil {
+		if err := c.opts.Budget.beforeTurn(); err != nil {
+			return err
+		}
+	}
+
 	// sessionIDを取得（ロックフリー）
 	sessionID := c.getSessionIDString()
 
+	// ターンのスパンはresultの受信時に終了する
+	ctx, turn := c.traces.startTurn(ctx, Attr(AttrSessionID, sessionID))
+	defer func() {
+		if err != nil {
+			c.traces.abortTurn(turn, err)
+		}
+	}()
+
 	// UserPromptSubmitフックをトリガー
 	hookInput := &hooks.Input{
 		SessionID:     sessionID,
@@ -280,6 +562,7 @@ func (c *Client) Send(ctx context.Context, content string) error {
 		return fmt.Errorf("marshal message: %w", err)
 	}
 
+	c.logger.Debug("sending user message", "session_id", sessionID, logging.Payload("prompt", content))
 	return c.transport.Write(data)
 }
 
@@ -295,21 +578,19 @@ func (c *Client) SendToolResult(ctx context.Context, toolUseID string, result an
 	// sessionIDを取得（ロックフリー）
 	sessionID := c.getSessionIDString()
 
-	msg := map[string]any{
-		"type": "user",
-		"message": map[string]any{
-			"role": "user",
-			"content": []map[string]any{
-				{
-					"type":        "tool_result",
-					"tool_use_id": toolUseID,
-					"content":     result,
-					"is_error":    isError,
-				},
-			},
+	content, err := toolResultContent(result)
+	if err != nil {
+		return fmt.Errorf("marshal tool result: %w", err)
+	}
+
+	msg := protocol.UserMessage{
+		Type: "user",
+		Message: protocol.UserContent{
+			Role:    "user",
+			Content: protocol.NewBlocksContent(protocol.NewToolResultBlock(toolUseID, content, isError)),
 		},
-		"session_id":         sessionID,
-		"parent_tool_use_id": toolUseID,
+		SessionID:       sessionID,
+		ParentToolUseID: &toolUseID,
 	}
 
 	data, err := json.Marshal(msg)
@@ -320,6 +601,28 @@ func (c *Client) SendToolResult(ctx context.Context, toolUseID string, result an
 	return c.transport.Write(data)
 }
 
+// toolResultContent はSendToolResultに渡された結果をtool_resultのcontentに変換する
+// 文字列とコンテンツブロックはそのまま、それ以外はJSON文字列として送信する
+func toolResultContent(result any) (protocol.BlockContent, error) {
+	switch r := result.(type) {
+	case string:
+		return protocol.NewTextContent(r), nil
+	case protocol.BlockContent:
+		return r, nil
+	case protocol.Blocks:
+		return protocol.NewBlocksContent(r...), nil
+	case []protocol.Block:
+		return protocol.NewBlocksContent(r...), nil
+	case *Content:
+		return r.messageContent(), nil
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return protocol.BlockContent{}, err
+	}
+	return protocol.NewTextContent(string(data)), nil
+}
+
 // Interrupt は実行を中断する
 func (c *Client) Interrupt(ctx context.Context) error {
 	c.mu.RLock()
@@ -333,7 +636,9 @@ func (c *Client) Interrupt(ctx context.Context) error {
 		Subtype: "interrupt",
 	}
 
+	ctx, span := c.traces.tracer.Start(ctx, spanControlPrefix+"interrupt", Attr(AttrControlSubtype, "interrupt"))
 	_, err := c.protocol.SendControlRequest(ctx, interruptReq)
+	endSpan(span, err)
 	if err != nil {
 		return &SDKError{Op: "interrupt", Err: err}
 	}
@@ -343,28 +648,101 @@ func (c *Client) Interrupt(ctx context.Context) error {
 
 // RewindFiles はファイルを指定したチェックポイントに巻き戻す
 func (c *Client) RewindFiles(ctx context.Context, userMessageID string) error {
+	rewindReq := protocol.RewindFilesRequest{
+		Subtype:       "rewind_files",
+		UserMessageID: userMessageID,
+	}
+
+	_, err := c.sendControlRequest(ctx, "rewind_files", rewindReq)
+	return err
+}
+
+// SetModel は実行中のセッションのモデルを変更する
+// 成功した場合は再接続時にも新しいモデルを使用する（Optionsは変更しない）
+func (c *Client) SetModel(ctx context.Context, model string) error {
+	req := protocol.SetModelRequest{
+		Subtype: "set_model",
+		Model:   model,
+	}
+
+	if _, err := c.sendControlRequest(ctx, "set_model", req); err != nil {
+		return err
+	}
+
+	c.overridesMu.Lock()
+	c.overrides.model = &model
+	c.overridesMu.Unlock()
+
+	return nil
+}
+
+// SetPermissionMode は実行中のセッションの権限モードを変更する
+// 成功した場合は再接続時にも新しいモードを使用する（Optionsは変更しない）
+func (c *Client) SetPermissionMode(ctx context.Context, mode PermissionMode) error {
+	req := protocol.SetPermissionModeRequest{
+		Subtype: "set_permission_mode",
+		Mode:    string(mode),
+	}
+
+	if _, err := c.sendControlRequest(ctx, "set_permission_mode", req); err != nil {
+		return err
+	}
+
+	c.overridesMu.Lock()
+	c.overrides.permissionMode = &mode
+	c.overridesMu.Unlock()
+
+	return nil
+}
+
+// SetMaxThinkingTokens は実行中のセッションの思考トークン上限を変更する
+// 成功した場合は再接続時にも新しい上限を使用する（Optionsは変更しない）
+func (c *Client) SetMaxThinkingTokens(ctx context.Context, maxThinkingTokens int) error {
+	if maxThinkingTokens < 0 {
+		return &SDKError{Op: "set_max_thinking_tokens", Err: ErrInvalidConfig, Details: "max thinking tokens must not be negative"}
+	}
+
+	req := protocol.SetMaxThinkingTokensRequest{
+		Subtype:           "set_max_thinking_tokens",
+		MaxThinkingTokens: maxThinkingTokens,
+	}
+
+	if _, err := c.sendControlRequest(ctx, "set_max_thinking_tokens", req); err != nil {
+		return err
+	}
+
+	c.overridesMu.Lock()
+	c.overrides.maxThinkingTokens = &maxThinkingTokens
+	c.overridesMu.Unlock()
+
+	return nil
+}
+
+// sendControlRequest は制御リクエストを送信し、CLIが拒否した場合はErrControlRejectedを返す
+func (c *Client) sendControlRequest(ctx context.Context, op string, req any) (_ *protocol.ControlResponse, err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	if c.closed || c.protocol == nil {
-		return fmt.Errorf("client is not connected")
+		return nil, fmt.Errorf("client is not connected")
 	}
 
-	rewindReq := protocol.RewindFilesRequest{
-		Subtype:       "rewind_files",
-		UserMessageID: userMessageID,
-	}
+	ctx, span := c.traces.tracer.Start(ctx, spanControlPrefix+op,
+		Attr(AttrControlSubtype, op),
+		Attr(AttrSessionID, c.getSessionIDString()),
+	)
+	defer func() { endSpan(span, err) }()
 
-	resp, err := c.protocol.SendControlRequest(ctx, rewindReq)
+	resp, err := c.protocol.SendControlRequestWithTimeout(ctx, req, c.opts.GetTimeout("control"))
 	if err != nil {
-		return &SDKError{Op: "rewind_files", Err: err}
+		return nil, &SDKError{Op: op, Err: err}
 	}
 
 	if resp.Response.Subtype == "error" {
-		return &SDKError{Op: "rewind_files", Err: fmt.Errorf("rewind failed"), Details: resp.Response.Error}
+		return nil, &SDKError{Op: op, Err: ErrControlRejected, Details: resp.Response.Error}
 	}
 
-	return nil
+	return resp, nil
 }
 
 // Messages はメッセージチャネルを返す
@@ -389,6 +767,16 @@ func (c *Client) SessionID() (string, error) {
 	return *sid, nil
 }
 
+// Session は現在のセッションと親セッションの関係を返す
+// ParentIDはResumeの場合はそのセッションID、Continueの場合はLineage設定時のみ特定される
+func (c *Client) Session() (*Session, error) {
+	sid, err := c.SessionID()
+	if err != nil {
+		return nil, err
+	}
+	return c.lineage.session(sid), nil
+}
+
 // SessionIDReady はセッションIDが取得可能かどうかを返す
 func (c *Client) SessionIDReady() bool {
 	// ロックフリー: atomic.Pointerを使用
@@ -421,11 +809,25 @@ func (c *Client) Close() error {
 		c.protocol.Close()
 	}
 
+	var err error
 	if c.transport != nil {
-		return c.transport.Close()
+		err = c.transport.Close()
 	}
 
-	return nil
+	if c.recorder != nil {
+		c.recorder.Close()
+	}
+
+	if c.opts.Budget != nil {
+		c.opts.Budget.detach(c)
+	}
+	c.opts.Metrics.detach(c)
+	c.releaseUsage(c.usageSource.Load())
+
+	// resultを受信していないターンとツール呼び出しのスパンを終了する
+	c.traces.endAll(errors.New("client closed"))
+
+	return err
 }
 
 // Stream methods
@@ -445,6 +847,11 @@ func (s *Stream) Send(ctx context.Context, content string) error {
 	return s.client.Send(ctx, content)
 }
 
+// SendContent は画像やドキュメントを含むユーザーメッセージを送信する
+func (s *Stream) SendContent(ctx context.Context, content *Content) error {
+	return s.client.SendContent(ctx, content)
+}
+
 // SendToolResult はツール実行結果を送信する
 func (s *Stream) SendToolResult(ctx context.Context, toolUseID string, result any, isError bool) error {
 	return s.client.SendToolResult(ctx, toolUseID, result, isError)
@@ -460,6 +867,21 @@ func (s *Stream) RewindFiles(ctx context.Context, userMessageID string) error {
 	return s.client.RewindFiles(ctx, userMessageID)
 }
 
+// SetModel は実行中のセッションのモデルを変更する
+func (s *Stream) SetModel(ctx context.Context, model string) error {
+	return s.client.SetModel(ctx, model)
+}
+
+// SetPermissionMode は実行中のセッションの権限モードを変更する
+func (s *Stream) SetPermissionMode(ctx context.Context, mode PermissionMode) error {
+	return s.client.SetPermissionMode(ctx, mode)
+}
+
+// SetMaxThinkingTokens は実行中のセッションの思考トークン上限を変更する
+func (s *Stream) SetMaxThinkingTokens(ctx context.Context, maxThinkingTokens int) error {
+	return s.client.SetMaxThinkingTokens(ctx, maxThinkingTokens)
+}
+
 // Close はストリームをクローズする
 func (s *Stream) Close() error {
 	return s.client.Close()
@@ -545,6 +967,7 @@ func convertHookEntry(entry HookEntry) hooks.Entry {
 					TranscriptPath: input.TranscriptPath,
 					CWD:            input.CWD,
 					ToolName:       input.ToolName,
+					ToolUseID:      input.ToolUseID,
 					ToolInput:      input.ToolInput,
 					ToolOutput:     input.ToolOutput,
 				}
@@ -591,6 +1014,11 @@ func (c *Client) HookManager() *hooks.Manager {
 	return c.hookManager
 }
 
+// MCPManager はMCPマネージャーを返す
+func (c *Client) MCPManager() *mcp.Manager {
+	return c.mcpManager
+}
+
 // Helper functions
 
 func convertPermissionSuggestions(suggestions []protocol.PermissionSuggestion) []PermissionSuggestion {
@@ -2,12 +2,141 @@ package claude
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
 	"github.com/y-oga-819/my-go-claude-agent/internal/transport"
 )
 
+// mockTransport はテスト用のモックTransport
+type mockTransport struct {
+	writtenData [][]byte
+	msgChan     chan transport.RawMessage
+	errChan     chan error
+	mu          sync.Mutex
+	connected   bool
+
+	// onWrite は書き込み時に呼ばれる（制御リクエストへの応答用）
+	onWrite func(data map[string]any)
+}
+
+func newMockTransport() *mockTransport {
+	return &mockTransport{
+		msgChan:   make(chan transport.RawMessage, 100),
+		errChan:   make(chan error, 10),
+		connected: true,
+	}
+}
+
+func (m *mockTransport) Connect(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connected = true
+	return nil
+}
+
+func (m *mockTransport) Write(data []byte) error {
+	m.mu.Lock()
+	m.writtenData = append(m.writtenData, data)
+	onWrite := m.onWrite
+	m.mu.Unlock()
+
+	if onWrite != nil {
+		var parsed map[string]any
+		if err := json.Unmarshal(data, &parsed); err == nil {
+			onWrite(parsed)
+		}
+	}
+	return nil
+}
+
+func (m *mockTransport) Messages() <-chan transport.RawMessage { return m.msgChan }
+func (m *mockTransport) Errors() <-chan error                  { return m.errChan }
+func (m *mockTransport) EndInput() error                       { return nil }
+
+func (m *mockTransport) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connected = false
+	return nil
+}
+
+func (m *mockTransport) IsConnected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.connected
+}
+
+func (m *mockTransport) GetProcessStatus() *transport.ProcessStatus { return nil }
+
+func (m *mockTransport) getWrittenData() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte(nil), m.writtenData...)
+}
+
+// lastWritten は最後に書き込まれたJSONをmapとして返す
+func (m *mockTransport) lastWritten(t *testing.T) map[string]any {
+	t.Helper()
+	written := m.getWrittenData()
+	if len(written) == 0 {
+		t.Fatal("nothing written to transport")
+	}
+	var data map[string]any
+	if err := json.Unmarshal(written[len(written)-1], &data); err != nil {
+		t.Fatalf("unmarshal written data: %v", err)
+	}
+	return data
+}
+
+// newTestClient はモックTransportに接続済みのClientを作成する
+func newTestClient(opts *Options) (*Client, *mockTransport) {
+	client := NewClient(opts)
+	mt := newMockTransport()
+	client.transport = mt
+	client.protocol = protocol.NewProtocolHandlerWithConfig(mt, client.opts.deliveryConfig())
+	return client, mt
+}
+
+// respondToControlRequests はSDKからの制御リクエストにhandlerの結果で応答する
+// handlerがerrMsgを返した場合はエラーレスポンスを返す
+func respondToControlRequests(client *Client, mt *mockTransport, handler func(req map[string]any) (resp any, errMsg string)) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.onWrite = func(data map[string]any) {
+		if data["type"] != "control_request" {
+			return
+		}
+		req, _ := data["request"].(map[string]any)
+		resp, errMsg := handler(req)
+
+		body := map[string]any{
+			"subtype":    "success",
+			"request_id": data["request_id"],
+			"response":   resp,
+		}
+		if errMsg != "" {
+			body["subtype"] = "error"
+			body["error"] = errMsg
+		}
+		go client.protocol.HandleIncoming(context.Background(), rawMessage(map[string]any{
+			"type":     "control_response",
+			"response": body,
+		}))
+	}
+}
+
+// rawMessage はテスト用のRawMessageを作成する
+func rawMessage(data map[string]any) transport.RawMessage {
+	raw, _ := json.Marshal(data)
+	msgType, _ := data["type"].(string)
+	return transport.RawMessage{Type: msgType, Data: data, Raw: raw}
+}
+
 func TestNewClient(t *testing.T) {
 	client := NewClient(nil)
 	if client == nil {
@@ -325,3 +454,139 @@ func TestClient_ExtractSessionIDFromRawMessage_EmptySessionID(t *testing.T) {
 		t.Errorf("SessionID() should return ErrSessionIDNotReady, got %v", err)
 	}
 }
+
+func TestClient_SetModel(t *testing.T) {
+	opts := &Options{Model: "claude-sonnet-4-5"}
+	client, mt := newTestClient(opts)
+
+	var received map[string]any
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		received = req
+		return nil, ""
+	})
+
+	if err := client.SetModel(context.Background(), "claude-opus-4-5"); err != nil {
+		t.Fatalf("SetModel failed: %v", err)
+	}
+
+	if received["subtype"] != "set_model" {
+		t.Errorf("subtype = %v, want set_model", received["subtype"])
+	}
+	if received["model"] != "claude-opus-4-5" {
+		t.Errorf("model = %v, want claude-opus-4-5", received["model"])
+	}
+	// 再接続時のinitializeに反映され、渡されたOptionsは変更しない
+	if got := client.initializeRequest(nil).Model; got != "claude-opus-4-5" {
+		t.Errorf("initialize model = %q, want %q", got, "claude-opus-4-5")
+	}
+	if opts.Model != "claude-sonnet-4-5" || client.opts.Model != "claude-sonnet-4-5" {
+		t.Errorf("opts.Model = %q, want unchanged", opts.Model)
+	}
+}
+
+func TestClient_SetPermissionMode(t *testing.T) {
+	opts := &Options{}
+	client, mt := newTestClient(opts)
+
+	var received map[string]any
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		received = req
+		return nil, ""
+	})
+
+	stream := &Stream{client: client}
+	if err := stream.SetPermissionMode(context.Background(), PermissionModePlan); err != nil {
+		t.Fatalf("SetPermissionMode failed: %v", err)
+	}
+
+	if received["subtype"] != "set_permission_mode" {
+		t.Errorf("subtype = %v, want set_permission_mode", received["subtype"])
+	}
+	if received["mode"] != "plan" {
+		t.Errorf("mode = %v, want plan", received["mode"])
+	}
+	if got := client.initializeRequest(nil).PermissionMode; got != "plan" {
+		t.Errorf("initialize permission mode = %q, want plan", got)
+	}
+	if opts.PermissionMode != "" {
+		t.Errorf("opts.PermissionMode = %q, want unchanged", opts.PermissionMode)
+	}
+}
+
+func TestClient_SetMaxThinkingTokens(t *testing.T) {
+	opts := &Options{}
+	client, mt := newTestClient(opts)
+
+	var received map[string]any
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		received = req
+		return nil, ""
+	})
+
+	if err := client.SetMaxThinkingTokens(context.Background(), 8000); err != nil {
+		t.Fatalf("SetMaxThinkingTokens failed: %v", err)
+	}
+
+	if received["max_thinking_tokens"] != float64(8000) {
+		t.Errorf("max_thinking_tokens = %v, want 8000", received["max_thinking_tokens"])
+	}
+	if got := client.initializeRequest(nil).MaxThinkingTokens; got != 8000 {
+		t.Errorf("initialize max thinking tokens = %d, want 8000", got)
+	}
+	if opts.MaxThinkingTokens != 0 {
+		t.Errorf("opts.MaxThinkingTokens = %d, want unchanged", opts.MaxThinkingTokens)
+	}
+
+	// 負の値は送信前に拒否される
+	if err := client.SetMaxThinkingTokens(context.Background(), -1); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestClient_SetModel_Rejected(t *testing.T) {
+	opts := &Options{Model: "claude-sonnet-4-5"}
+	client, mt := newTestClient(opts)
+
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		return nil, "unknown model: nope"
+	})
+
+	err := client.SetModel(context.Background(), "nope")
+	if !errors.Is(err, ErrControlRejected) {
+		t.Fatalf("expected ErrControlRejected, got %v", err)
+	}
+
+	var sdkErr *SDKError
+	if !errors.As(err, &sdkErr) {
+		t.Fatalf("expected *SDKError, got %T", err)
+	}
+	if sdkErr.Op != "set_model" {
+		t.Errorf("Op = %q, want %q", sdkErr.Op, "set_model")
+	}
+	if sdkErr.Details != "unknown model: nope" {
+		t.Errorf("Details = %q, want %q", sdkErr.Details, "unknown model: nope")
+	}
+	// 拒否された場合は再接続時のモデルを変更しない
+	if got := client.initializeRequest(nil).Model; got != "claude-sonnet-4-5" {
+		t.Errorf("initialize model = %q, want %q", got, "claude-sonnet-4-5")
+	}
+}
+
+func TestClient_SetModel_NotConnected(t *testing.T) {
+	client := NewClient(nil)
+
+	if err := client.SetModel(context.Background(), "claude-opus-4-5"); err == nil {
+		t.Error("SetModel should fail when not connected")
+	}
+}
+
+func TestBuildClientArgs(t *testing.T) {
+	if args := buildClientArgs(&Options{}); len(args) != 0 {
+		t.Errorf("args = %v, want empty", args)
+	}
+
+	args := buildClientArgs(&Options{IncludePartialMessages: true})
+	if len(args) != 1 || args[0] != "--include-partial-messages" {
+		t.Errorf("args = %v, want [--include-partial-messages]", args)
+	}
+}
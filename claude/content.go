@@ -0,0 +1,340 @@
+package claude
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// 添付ファイルのデフォルト上限（Anthropic APIの制限に合わせる）
+const (
+	DefaultMaxImageBytes    = 5 * 1024 * 1024  // 画像1枚あたり5MB
+	DefaultMaxDocumentBytes = 32 * 1024 * 1024 // ドキュメント1件あたり32MB
+	DefaultMaxTotalBytes    = 32 * 1024 * 1024 // 1ターンあたりの合計
+	DefaultMaxImages        = 100              // 1ターンあたりの画像数
+)
+
+// サポートする画像形式
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// ContentLimits は添付ファイルのサイズ制限
+type ContentLimits struct {
+	MaxImageBytes    int // 画像1枚あたりの最大バイト数
+	MaxDocumentBytes int // ドキュメント1件あたりの最大バイト数
+	MaxTotalBytes    int // 添付ファイルの合計最大バイト数
+	MaxImages        int // 画像の最大枚数
+}
+
+// DefaultContentLimits はデフォルトのサイズ制限を返す
+func DefaultContentLimits() ContentLimits {
+	return ContentLimits{
+		MaxImageBytes:    DefaultMaxImageBytes,
+		MaxDocumentBytes: DefaultMaxDocumentBytes,
+		MaxTotalBytes:    DefaultMaxTotalBytes,
+		MaxImages:        DefaultMaxImages,
+	}
+}
+
+// Content はユーザーターンの内容（テキスト・画像・ドキュメント）
+type Content struct {
+	// Blocks はCLIに送信するコンテンツブロック（APIのcontent形式）
+	Blocks protocol.Blocks
+}
+
+// TextContent はテキストのみのContentを作成する
+func TextContent(text string) *Content {
+	return &Content{Blocks: protocol.Blocks{protocol.NewTextBlock(text)}}
+}
+
+// HasAttachments は画像やドキュメントを含むかを返す
+func (c *Content) HasAttachments() bool {
+	if c == nil {
+		return false
+	}
+	for _, block := range c.Blocks {
+		if _, ok := block.(*protocol.TextBlock); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Text はテキストブロックを連結して返す
+func (c *Content) Text() string {
+	if c == nil {
+		return ""
+	}
+	var parts []string
+	for _, block := range c.Blocks {
+		if text, ok := block.(*protocol.TextBlock); ok {
+			parts = append(parts, text.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// messageContent はUserContent.Contentに設定する値を返す
+// 添付ファイルがない場合は従来どおり文字列で送信する
+func (c *Content) messageContent() protocol.BlockContent {
+	if !c.HasAttachments() {
+		return protocol.NewTextContent(c.Text())
+	}
+	return protocol.NewBlocksContent(c.Blocks...)
+}
+
+// ContentBuilder はユーザーターンの内容を組み立てる
+// 途中でエラーが発生した場合は以降の追加を無視し、Build()でエラーを返す
+type ContentBuilder struct {
+	limits     ContentLimits
+	blocks     protocol.Blocks
+	totalBytes int
+	images     int
+	err        error
+}
+
+// NewContentBuilder は新しいContentBuilderを作成する
+func NewContentBuilder() *ContentBuilder {
+	return &ContentBuilder{limits: DefaultContentLimits()}
+}
+
+// WithLimits はサイズ制限を変更する（0の項目はデフォルト値を使用）
+func (b *ContentBuilder) WithLimits(limits ContentLimits) *ContentBuilder {
+	def := DefaultContentLimits()
+	if limits.MaxImageBytes <= 0 {
+		limits.MaxImageBytes = def.MaxImageBytes
+	}
+	if limits.MaxDocumentBytes <= 0 {
+		limits.MaxDocumentBytes = def.MaxDocumentBytes
+	}
+	if limits.MaxTotalBytes <= 0 {
+		limits.MaxTotalBytes = def.MaxTotalBytes
+	}
+	if limits.MaxImages <= 0 {
+		limits.MaxImages = def.MaxImages
+	}
+	b.limits = limits
+	return b
+}
+
+// Text はテキストを追加する
+func (b *ContentBuilder) Text(text string) *ContentBuilder {
+	if b.err != nil {
+		return b
+	}
+	if text == "" {
+		b.err = fmt.Errorf("text must not be empty")
+		return b
+	}
+	b.blocks = append(b.blocks, protocol.NewTextBlock(text))
+	return b
+}
+
+// ImageFile はファイルから画像を追加する
+func (b *ContentBuilder) ImageFile(path string) *ContentBuilder {
+	if b.err != nil {
+		return b
+	}
+	data, err := b.readFile(path, b.limits.MaxImageBytes)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.ImageBytes(data, "")
+}
+
+// ImageBytes は画像データを追加する
+// mediaTypeが空の場合はデータの先頭から判定する
+func (b *ContentBuilder) ImageBytes(data []byte, mediaType string) *ContentBuilder {
+	if b.err != nil {
+		return b
+	}
+	if len(data) == 0 {
+		b.err = fmt.Errorf("image data must not be empty")
+		return b
+	}
+
+	detected := sniffMediaType(data)
+	if mediaType == "" {
+		mediaType = detected
+	}
+	if !supportedImageTypes[mediaType] {
+		b.err = fmt.Errorf("unsupported image type: %s", mediaType)
+		return b
+	}
+	if detected != mediaType {
+		b.err = fmt.Errorf("image data is %s but media type %s was specified", detected, mediaType)
+		return b
+	}
+	if len(data) > b.limits.MaxImageBytes {
+		b.err = fmt.Errorf("image too large: %d bytes (max %d)", len(data), b.limits.MaxImageBytes)
+		return b
+	}
+	if b.images >= b.limits.MaxImages {
+		b.err = fmt.Errorf("too many images (max %d)", b.limits.MaxImages)
+		return b
+	}
+	if !b.reserve(len(data)) {
+		return b
+	}
+
+	b.images++
+	b.blocks = append(b.blocks, &protocol.ImageBlock{
+		Source: &protocol.ContentSource{
+			Type:      "base64",
+			MediaType: mediaType,
+			Data:      base64.StdEncoding.EncodeToString(data),
+		},
+	})
+	return b
+}
+
+// ImageBase64 はbase64エンコード済みの画像を追加する
+// mediaTypeが空の場合はデコードしたデータから判定する
+func (b *ContentBuilder) ImageBase64(encoded string, mediaType string) *ContentBuilder {
+	if b.err != nil {
+		return b
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		b.err = fmt.Errorf("invalid base64 image: %w", err)
+		return b
+	}
+	return b.ImageBytes(data, mediaType)
+}
+
+// DocumentFile はファイルからドキュメント（PDFまたはテキスト）を追加する
+// タイトルにはファイル名を使用する
+func (b *ContentBuilder) DocumentFile(path string) *ContentBuilder {
+	if b.err != nil {
+		return b
+	}
+	data, err := b.readFile(path, b.limits.MaxDocumentBytes)
+	if err != nil {
+		b.err = err
+		return b
+	}
+
+	title := filepath.Base(path)
+	if sniffMediaType(data) == "application/pdf" {
+		return b.PDF(data, title)
+	}
+	if !utf8.Valid(data) {
+		b.err = fmt.Errorf("unsupported document type: %s", path)
+		return b
+	}
+	return b.TextDocument(string(data), title)
+}
+
+// PDF はPDFドキュメントを追加する
+func (b *ContentBuilder) PDF(data []byte, title string) *ContentBuilder {
+	if b.err != nil {
+		return b
+	}
+	if mediaType := sniffMediaType(data); mediaType != "application/pdf" {
+		b.err = fmt.Errorf("document is not a PDF: %s", mediaType)
+		return b
+	}
+	if len(data) > b.limits.MaxDocumentBytes {
+		b.err = fmt.Errorf("document too large: %d bytes (max %d)", len(data), b.limits.MaxDocumentBytes)
+		return b
+	}
+	if !b.reserve(len(data)) {
+		return b
+	}
+
+	b.blocks = append(b.blocks, documentBlock(&protocol.ContentSource{
+		Type:      "base64",
+		MediaType: "application/pdf",
+		Data:      base64.StdEncoding.EncodeToString(data),
+	}, title))
+	return b
+}
+
+// TextDocument はプレーンテキストのドキュメントを追加する
+func (b *ContentBuilder) TextDocument(text string, title string) *ContentBuilder {
+	if b.err != nil {
+		return b
+	}
+	if text == "" {
+		b.err = fmt.Errorf("document must not be empty")
+		return b
+	}
+	if len(text) > b.limits.MaxDocumentBytes {
+		b.err = fmt.Errorf("document too large: %d bytes (max %d)", len(text), b.limits.MaxDocumentBytes)
+		return b
+	}
+	if !b.reserve(len(text)) {
+		return b
+	}
+
+	b.blocks = append(b.blocks, documentBlock(&protocol.ContentSource{
+		Type:      "text",
+		MediaType: "text/plain",
+		Data:      text,
+	}, title))
+	return b
+}
+
+// Build はContentを返す
+func (b *ContentBuilder) Build() (*Content, error) {
+	if b.err != nil {
+		return nil, &SDKError{Op: "content", Err: ErrInvalidContent, Details: b.err.Error()}
+	}
+	if len(b.blocks) == 0 {
+		return nil, &SDKError{Op: "content", Err: ErrInvalidContent, Details: "content is empty"}
+	}
+	return &Content{Blocks: b.blocks}, nil
+}
+
+// reserve は合計サイズの上限を確認して加算する
+func (b *ContentBuilder) reserve(size int) bool {
+	if b.totalBytes+size > b.limits.MaxTotalBytes {
+		b.err = fmt.Errorf("attachments too large: %d bytes (max %d)", b.totalBytes+size, b.limits.MaxTotalBytes)
+		return false
+	}
+	b.totalBytes += size
+	return true
+}
+
+// readFile はサイズ上限を確認してからファイルを読み込む
+func (b *ContentBuilder) readFile(path string, maxBytes int) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > int64(maxBytes) {
+		return nil, fmt.Errorf("file too large: %s (%d bytes, max %d)", path, info.Size(), maxBytes)
+	}
+	return os.ReadFile(path)
+}
+
+// sniffMediaType はデータの先頭からMIMEタイプを判定する（パラメータは除く）
+func sniffMediaType(data []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+func documentBlock(source *protocol.ContentSource, title string) *protocol.DocumentBlock {
+	return &protocol.DocumentBlock{
+		Source: source,
+		Title:  title,
+	}
+}
@@ -0,0 +1,211 @@
+package claude
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// テスト用の最小限のPNGヘッダ
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+
+// テスト用の最小限のPDFヘッダ
+var testPDF = []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\n")
+
+func TestContentBuilder_TextAndImage(t *testing.T) {
+	content, err := NewContentBuilder().
+		Text("この画像を説明して").
+		ImageBytes(testPNG, "").
+		Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if len(content.Blocks) != 2 {
+		t.Fatalf("len(Blocks) = %d, want %d", len(content.Blocks), 2)
+	}
+	if !content.HasAttachments() {
+		t.Error("HasAttachments should be true")
+	}
+
+	image, ok := content.Blocks[1].(*protocol.ImageBlock)
+	if !ok {
+		t.Fatalf("block = %T, want *protocol.ImageBlock", content.Blocks[1])
+	}
+	if image.Source == nil || image.Source.MediaType != "image/png" {
+		t.Fatalf("source = %+v, want image/png", image.Source)
+	}
+	if image.Source.Data != base64.StdEncoding.EncodeToString(testPNG) {
+		t.Error("data should be base64 encoded image")
+	}
+}
+
+func TestContentBuilder_ImageBase64(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testPNG)
+	if _, err := NewContentBuilder().ImageBase64(encoded, "image/png").Build(); err != nil {
+		t.Errorf("Build failed: %v", err)
+	}
+
+	_, err := NewContentBuilder().ImageBase64("not base64!", "").Build()
+	if !errors.Is(err, ErrInvalidContent) {
+		t.Errorf("expected ErrInvalidContent, got %v", err)
+	}
+}
+
+func TestContentBuilder_Validation(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() *ContentBuilder
+	}{
+		{"empty", func() *ContentBuilder { return NewContentBuilder() }},
+		{"empty text", func() *ContentBuilder { return NewContentBuilder().Text("") }},
+		{"unsupported image", func() *ContentBuilder { return NewContentBuilder().ImageBytes([]byte("plain text"), "") }},
+		{"media type mismatch", func() *ContentBuilder { return NewContentBuilder().ImageBytes(testPNG, "image/jpeg") }},
+		{"image too large", func() *ContentBuilder {
+			return NewContentBuilder().WithLimits(ContentLimits{MaxImageBytes: 10}).ImageBytes(testPNG, "")
+		}},
+		{"too many images", func() *ContentBuilder {
+			return NewContentBuilder().WithLimits(ContentLimits{MaxImages: 1}).ImageBytes(testPNG, "").ImageBytes(testPNG, "")
+		}},
+		{"total too large", func() *ContentBuilder {
+			return NewContentBuilder().WithLimits(ContentLimits{MaxTotalBytes: len(testPNG) + 1}).ImageBytes(testPNG, "").ImageBytes(testPNG, "")
+		}},
+		{"not a pdf", func() *ContentBuilder { return NewContentBuilder().PDF(testPNG, "doc") }},
+		{"missing file", func() *ContentBuilder { return NewContentBuilder().ImageFile("/nonexistent/image.png") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.build().Build()
+			if !errors.Is(err, ErrInvalidContent) {
+				t.Errorf("expected ErrInvalidContent, got %v", err)
+			}
+		})
+	}
+}
+
+func TestContentBuilder_Files(t *testing.T) {
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "image.png")
+	pdfPath := filepath.Join(dir, "spec.pdf")
+	textPath := filepath.Join(dir, "notes.md")
+
+	os.WriteFile(imagePath, testPNG, 0644)
+	os.WriteFile(pdfPath, testPDF, 0644)
+	os.WriteFile(textPath, []byte("# メモ\n本文"), 0644)
+
+	content, err := NewContentBuilder().
+		ImageFile(imagePath).
+		DocumentFile(pdfPath).
+		DocumentFile(textPath).
+		Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if len(content.Blocks) != 3 {
+		t.Fatalf("len(Blocks) = %d, want %d", len(content.Blocks), 3)
+	}
+
+	pdf, ok := content.Blocks[1].(*protocol.DocumentBlock)
+	if !ok || pdf.Title != "spec.pdf" {
+		t.Fatalf("pdf block = %+v", content.Blocks[1])
+	}
+	if pdf.Source == nil || pdf.Source.MediaType != "application/pdf" {
+		t.Errorf("pdf source = %+v", pdf.Source)
+	}
+
+	source := content.Blocks[2].(*protocol.DocumentBlock).Source
+	if source == nil || source.Type != "text" || source.Data != "# メモ\n本文" {
+		t.Errorf("text document source = %+v", source)
+	}
+}
+
+func TestContent_MessageContent(t *testing.T) {
+	// テキストのみの場合は文字列として送信する
+	if got := TextContent("Hello").messageContent(); !got.IsText() || got.Text != "Hello" {
+		t.Errorf("messageContent() = %+v, want text %q", got, "Hello")
+	}
+
+	content, _ := NewContentBuilder().Text("Hi").ImageBytes(testPNG, "").Build()
+	if got := content.messageContent(); got.IsText() || len(got.Blocks) != 2 {
+		t.Errorf("messageContent() = %+v, want 2 blocks", got)
+	}
+}
+
+func TestClient_SendContent(t *testing.T) {
+	client, mt := newTestClient(nil)
+
+	content, err := NewContentBuilder().Text("見て").ImageBytes(testPNG, "").Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if err := client.SendContent(context.Background(), content); err != nil {
+		t.Fatalf("SendContent failed: %v", err)
+	}
+
+	written := mt.lastWritten(t)
+	message := written["message"].(map[string]any)
+	blocks, ok := message["content"].([]any)
+	if !ok || len(blocks) != 2 {
+		t.Fatalf("content = %v", message["content"])
+	}
+	if blocks[1].(map[string]any)["type"] != "image" {
+		t.Errorf("blocks[1] = %v", blocks[1])
+	}
+
+	if err := client.SendContent(context.Background(), nil); !errors.Is(err, ErrInvalidContent) {
+		t.Errorf("expected ErrInvalidContent, got %v", err)
+	}
+}
+
+func TestQueryContent_WithAttachments(t *testing.T) {
+	// 入力形式と受け取ったメッセージをresultで返すモックCLI
+	cliPath := writeMockCLI(t, `#!/bin/sh
+case "$*" in
+  *"--input-format stream-json"*) mode=stream ;;
+  *) mode=arg ;;
+esac
+read -r line
+case "$line" in
+  *'"type":"image"'*) image=yes ;;
+  *) image=no ;;
+esac
+echo "{\"type\":\"result\",\"subtype\":\"success\",\"is_error\":false,\"num_turns\":1,\"session_id\":\"s\",\"total_cost_usd\":0,\"usage\":{\"input_tokens\":0,\"output_tokens\":0},\"result\":\"$mode-$image\"}"
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	content, err := NewContentBuilder().Text("describe").ImageBytes(testPNG, "").Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	result, err := QueryContent(ctx, content, &Options{CLIPath: cliPath})
+	if err != nil {
+		t.Fatalf("QueryContent failed: %v", err)
+	}
+	if result.Result.Result != "stream-yes" {
+		t.Errorf("Result = %q, want %q", result.Result.Result, "stream-yes")
+	}
+}
+
+func TestBuildStreamQueryArgs(t *testing.T) {
+	args := buildStreamQueryArgs(&Options{Model: "claude-3-opus"})
+
+	if args[len(args)-1] != "--print" {
+		t.Errorf("last arg = %q, want %q", args[len(args)-1], "--print")
+	}
+	if strings.Contains(strings.Join(args, " "), "-- ") {
+		t.Error("stream query args should not contain a prompt")
+	}
+}
@@ -3,7 +3,10 @@ package claude
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
 )
 
 var (
@@ -13,10 +16,14 @@ var (
 	ErrProcessExited = errors.New("CLI process exited unexpectedly")
 
 	// プロトコルエラー
-	ErrJSONDecode     = errors.New("JSON decode error")
-	ErrMessageParse   = errors.New("message parse error")
-	ErrControlTimeout = errors.New("control request timeout")
-	ErrBufferOverflow = errors.New("JSON buffer overflow")
+	ErrJSONDecode      = errors.New("JSON decode error")
+	ErrMessageParse    = errors.New("message parse error")
+	ErrControlTimeout  = errors.New("control request timeout")
+	ErrControlRejected = errors.New("control request rejected")
+	ErrBufferOverflow  = errors.New("JSON buffer overflow")
+
+	// ErrMessageBufferFull はOverflowErrorポリシーでメッセージを破棄した場合のエラー
+	ErrMessageBufferFull = protocol.ErrMessageBufferFull
 
 	// セッションエラー
 	ErrSessionNotFound = errors.New("session not found")
@@ -45,6 +52,15 @@ var (
 	// 設定エラー
 	ErrInvalidConfig   = errors.New("invalid configuration")
 	ErrModelNotFound   = errors.New("model not found")
+
+	// 構造化出力エラー
+	ErrStructuredOutput = errors.New("structured output does not match schema")
+
+	// 入力エラー
+	ErrInvalidContent = errors.New("invalid content")
+
+	// プールエラー
+	ErrPoolClosed = errors.New("client pool is closed")
 )
 
 // ExitCode はCLI終了コードを表す
@@ -118,6 +134,32 @@ func (e *ResultError) Error() string {
 	return e.Message
 }
 
+// StructuredOutputError は構造化出力がスキーマに適合しない場合のエラー
+type StructuredOutputError struct {
+	Output     map[string]any // CLIが返した構造化出力
+	Violations []string       // スキーマ違反の内容
+	Err        error          // デコードエラー
+}
+
+func (e *StructuredOutputError) Error() string {
+	msg := ErrStructuredOutput.Error()
+	if len(e.Violations) > 0 {
+		msg += ": " + strings.Join(e.Violations, "; ")
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap はErrStructuredOutputと、デコードエラーがあればそのエラーを返す
+func (e *StructuredOutputError) Unwrap() []error {
+	if e.Err != nil {
+		return []error{ErrStructuredOutput, e.Err}
+	}
+	return []error{ErrStructuredOutput}
+}
+
 // NewSDKError は新しいSDKErrorを作成する
 func NewSDKError(op string, err error) *SDKError {
 	return &SDKError{
@@ -0,0 +1,343 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+	"github.com/y-oga-819/my-go-claude-agent/internal/mcp"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// fakeCLIBinary はテスト実行中に1度だけビルドするフェイクCLI
+var fakeCLIBinary struct {
+	once sync.Once
+	dir  string
+	path string
+	err  error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if fakeCLIBinary.dir != "" {
+		os.RemoveAll(fakeCLIBinary.dir)
+	}
+	os.Exit(code)
+}
+
+// installFakeCLI はシナリオを実行するフェイクCLIを配置してCLIPathを返す
+func installFakeCLI(t *testing.T, scenario *fakecli.Scenario) string {
+	t.Helper()
+
+	fakeCLIBinary.once.Do(func() {
+		fakeCLIBinary.dir, fakeCLIBinary.err = os.MkdirTemp("", "fakeclaude-*")
+		if fakeCLIBinary.err == nil {
+			fakeCLIBinary.path, fakeCLIBinary.err = fakecli.Build(fakeCLIBinary.dir)
+		}
+	})
+	if fakeCLIBinary.err != nil {
+		t.Skipf("fake CLI is not available: %v", fakeCLIBinary.err)
+	}
+
+	cliPath, err := fakecli.Install(t.TempDir(), fakeCLIBinary.path, scenario)
+	if err != nil {
+		t.Fatalf("install fake CLI: %v", err)
+	}
+	return cliPath
+}
+
+// runFakeTurn は1ターン送信してresultまでのメッセージを返す
+func runFakeTurn(t *testing.T, opts *Options, prompt string) []protocol.Message {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := NewClient(opts)
+	defer client.Close()
+
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if err := stream.Send(ctx, prompt); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	var messages []protocol.Message
+	for {
+		select {
+		case msg, ok := <-stream.Messages():
+			if !ok {
+				t.Fatalf("stream closed before result (stderr: %s)", fakeStderr(client))
+			}
+			messages = append(messages, msg)
+			if _, ok := msg.(*protocol.ResultMessage); ok {
+				return messages
+			}
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for result (stderr: %s)", fakeStderr(client))
+		}
+	}
+}
+
+func fakeStderr(c *Client) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.transport == nil {
+		return ""
+	}
+	if status := c.transport.GetProcessStatus(); status != nil {
+		return status.Stderr
+	}
+	return ""
+}
+
+func TestFakeCLI_CanUseToolDeny(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser, Text: "clean up"},
+			{Action: fakecli.ActionInit},
+			{Action: fakecli.ActionToolUse, ToolUseID: "toolu_1", ToolName: "Bash", Input: map[string]any{"command": "rm -rf /tmp/x"}},
+			// SDKが拒否しなければフェイクCLIは終了コード2で終了する
+			{Action: fakecli.ActionCanUseTool, ToolName: "Bash", Input: map[string]any{"command": "rm -rf /tmp/x"},
+				Expect: map[string]any{"allow": false, "message": "rm is not allowed"}},
+			{Action: fakecli.ActionToolResult, ToolUseID: "toolu_1", Content: "rm is not allowed", IsError: true},
+			{Action: fakecli.ActionResult, Result: "denied"},
+		},
+	})
+
+	var asked string
+	messages := runFakeTurn(t, &Options{
+		CLIPath: cliPath,
+		CanUseTool: func(ctx context.Context, toolName string, input map[string]any, _ *ToolPermissionContext) (*PermissionResult, error) {
+			asked, _ = input["command"].(string)
+			return &PermissionResult{Allow: false, Message: "rm is not allowed"}, nil
+		},
+	}, "clean up")
+
+	if asked != "rm -rf /tmp/x" {
+		t.Errorf("CanUseTool input = %q", asked)
+	}
+
+	var results []*protocol.ToolResultBlock
+	for _, msg := range messages {
+		if m, ok := msg.(*protocol.UserMessage); ok {
+			results = append(results, m.ToolResults()...)
+		}
+	}
+	if len(results) != 1 || !results[0].IsError {
+		t.Errorf("tool results = %+v", results)
+	}
+}
+
+func TestFakeCLI_PreToolUseHook(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionToolUse, ToolUseID: "toolu_1", ToolName: "Write", Input: map[string]any{"file_path": "/etc/passwd"}},
+			{Action: fakecli.ActionHookCallback, HookEvent: "PreToolUse", ToolName: "Write", ToolUseID: "toolu_1",
+				Input: map[string]any{"file_path": "/etc/passwd"}, Expect: map[string]any{"decision": "block"}},
+			{Action: fakecli.ActionResult},
+		},
+	})
+
+	var hooked *HookInput
+	runFakeTurn(t, &Options{
+		CLIPath: cliPath,
+		Hooks: &HookConfig{
+			PreToolUse: []HookEntry{{
+				Matcher: "Write",
+				Callback: func(ctx context.Context, input *HookInput) (*HookOutput, error) {
+					hooked = input
+					return &HookOutput{Continue: true, Decision: "block", Reason: "protected path"}, nil
+				},
+			}},
+		},
+	}, "edit")
+
+	if hooked == nil || hooked.ToolName != "Write" || hooked.ToolInput["file_path"] != "/etc/passwd" {
+		t.Errorf("hook input = %+v", hooked)
+	}
+}
+
+func TestFakeCLI_QueryWithRetry(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		StateFile: filepath.Join(t.TempDir(), "runs"),
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser, Text: "hello"},
+			{Action: fakecli.ActionResult, OnRuns: []int{1}, IsError: true, Subtype: "error_during_execution", ErrorCode: "rate_limit"},
+			{Action: fakecli.ActionAssistant, Text: "hi", DelayMs: 20},
+			{Action: fakecli.ActionResult, Result: "ok", CostUSD: 0.01},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := QueryWithRetry(ctx, "hello", &Options{CLIPath: cliPath}, &RetryConfig{
+		MaxRetries:     2,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		BackoffFactor:  2,
+	})
+	if err != nil {
+		t.Fatalf("QueryWithRetry failed: %v", err)
+	}
+	if result.Result.Result != "ok" || result.TotalCost != 0.01 {
+		t.Errorf("result = %+v", result.Result)
+	}
+}
+
+func TestFakeCLI_CrashWithoutResult(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionAssistant, Text: "working..."},
+			{Action: fakecli.ActionCrash, ExitCode: 137, Stderr: "killed"},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := Query(ctx, "hello", &Options{CLIPath: cliPath})
+	if !errors.Is(err, ErrProcessExited) && !errors.Is(err, ErrCLIConnection) {
+		var sdkErr *SDKError
+		if !errors.As(err, &sdkErr) || sdkErr.Op != "receive" {
+			t.Fatalf("expected receive error after crash, got %v", err)
+		}
+	}
+}
+
+func TestFakeCLI_QueryWithCanUseTool(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser, Text: "clean up"},
+			{Action: fakecli.ActionToolUse, ToolUseID: "toolu_1", ToolName: "Bash", Input: map[string]any{"command": "rm -rf /tmp/x"}},
+			{Action: fakecli.ActionCanUseTool, ToolName: "Bash", Input: map[string]any{"command": "rm -rf /tmp/x"},
+				Expect: map[string]any{"allow": false}},
+			{Action: fakecli.ActionToolResult, ToolUseID: "toolu_1", Content: "denied", IsError: true},
+			{Action: fakecli.ActionResult, Result: "denied", CostUSD: 0.01},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var asked string
+	result, err := Query(ctx, "clean up", &Options{
+		CLIPath: cliPath,
+		CanUseTool: func(ctx context.Context, toolName string, input map[string]any, _ *ToolPermissionContext) (*PermissionResult, error) {
+			asked = toolName
+			return &PermissionResult{Allow: false, Message: "not allowed"}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if asked != "Bash" {
+		t.Errorf("CanUseTool was called for %q", asked)
+	}
+	if result.Result.Result != "denied" || result.TotalCost != 0.01 || result.SessionID != "fake-session" {
+		t.Errorf("result = %+v", result)
+	}
+	// resultまでのメッセージはワンショットモードと同様に収集する
+	var toolUses, toolResults int
+	for _, msg := range result.Messages {
+		switch m := msg.(type) {
+		case *protocol.AssistantMessage:
+			toolUses += len(m.ToolUses())
+		case *protocol.UserMessage:
+			toolResults += len(m.ToolResults())
+		}
+	}
+	if toolUses != 1 || toolResults != 1 {
+		t.Errorf("collected %d tool uses and %d tool results", toolUses, toolResults)
+	}
+}
+
+func TestFakeCLI_QueryWithHooksAndSDKMCPServer(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionHookCallback, HookEvent: "PreToolUse", ToolName: "mcp__calc__add", ToolUseID: "toolu_1",
+				Input: map[string]any{"a": 1, "b": 2}, Expect: map[string]any{"continue": true}},
+			{Action: fakecli.ActionMCPMessage, ServerName: "calc", Message: map[string]any{
+				"jsonrpc": "2.0", "id": 1, "method": "tools/call",
+				"params": map[string]any{"name": "add", "arguments": map[string]any{"a": 1, "b": 2}},
+			}},
+			{Action: fakecli.ActionResult, Result: "3"},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var hooked, called bool
+	server := mcp.NewSDKMCPServer("calc", "1.0.0")
+	server.AddTool(mcp.Tool{
+		Name: "add",
+		Handler: func(args map[string]any) (*mcp.ToolResult, error) {
+			called = true
+			return &mcp.ToolResult{Content: []mcp.ContentBlock{{Type: "text", Text: "3"}}}, nil
+		},
+	})
+
+	result, err := Query(ctx, "add 1 and 2", &Options{
+		CLIPath: cliPath,
+		Hooks: &HookConfig{
+			PreToolUse: []HookEntry{{
+				Callback: func(ctx context.Context, input *HookInput) (*HookOutput, error) {
+					hooked = true
+					return &HookOutput{Continue: true}, nil
+				},
+			}},
+		},
+		SDKMCPServers: map[string]*mcp.SDKMCPServer{"calc": server},
+	})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if !hooked || !called {
+		t.Errorf("hook called = %v, MCP tool called = %v", hooked, called)
+	}
+	if result.Result.Result != "3" {
+		t.Errorf("result = %+v", result.Result)
+	}
+}
+
+func TestFakeCLI_QueryWithCallbacksCrash(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionAssistant, Text: "working..."},
+			{Action: fakecli.ActionCrash, ExitCode: 137, Stderr: "killed"},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := Query(ctx, "hello", &Options{
+		CLIPath: cliPath,
+		CanUseTool: func(context.Context, string, map[string]any, *ToolPermissionContext) (*PermissionResult, error) {
+			return &PermissionResult{Allow: true}, nil
+		},
+		// Queryではプロセスの終了時に再接続しない
+		Recovery: &RecoveryConfig{MaxAttempts: 3},
+	})
+	var sdkErr *SDKError
+	if !errors.As(err, &sdkErr) || sdkErr.Op != "receive" || !errors.Is(err, ErrProcessExited) {
+		t.Fatalf("expected receive error after crash, got %v", err)
+	}
+	// ワンショットと同様に終了コードとstderrを含める
+	if sdkErr.ExitCode != 137 || !strings.Contains(sdkErr.Details, "killed") {
+		t.Errorf("ExitCode = %d, Details = %q", sdkErr.ExitCode, sdkErr.Details)
+	}
+}
@@ -0,0 +1,168 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/hooks"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// cliHookEvents はCLIからhook_callbackで通知されるイベント
+// UserPromptSubmitはSend()内でSDK側からトリガーするため含めない
+var cliHookEvents = []hooks.Event{
+	hooks.EventPreToolUse,
+	hooks.EventPostToolUse,
+	hooks.EventNotification,
+	hooks.EventStop,
+	hooks.EventSubagentStop,
+	hooks.EventPreCompact,
+}
+
+// registerHookCallbacks はCLIに登録するフックマッチャーを構築し、
+// 対応するコールバックをプロトコルハンドラに登録する
+func (c *Client) registerHookCallbacks() map[string][]protocol.HookMatcher {
+	matchers, events := c.buildHookMatchers()
+	for callbackID, event := range events {
+		c.protocol.AddHookCallback(callbackID, c.newHookCallback(event))
+	}
+	return matchers
+}
+
+// buildHookMatchers はCLIに登録するフックマッチャーと、コールバックIDごとのイベントを返す
+//
+// イベントごとに全ツールにマッチするマッチャーを1つ登録し、
+// ツール名のマッチングはhooks.Manager.Trigger側で行う
+// コールバックIDはフック設定から決まるため、同じ設定のClientでは同じになる
+func (c *Client) buildHookMatchers() (map[string][]protocol.HookMatcher, map[string]hooks.Event) {
+	matchers := make(map[string][]protocol.HookMatcher)
+	events := make(map[string]hooks.Event)
+
+	for _, event := range cliHookEvents {
+		entries := c.hookManager.GetHooks(event)
+		if len(entries) == 0 {
+			continue
+		}
+
+		callbackID := fmt.Sprintf("hook_%d", len(matchers))
+		matchers[string(event)] = []protocol.HookMatcher{
+			{
+				HookCallbackIDs: []string{callbackID},
+				Timeout:         hookTimeout(entries).Seconds(),
+			},
+		}
+		events[callbackID] = event
+	}
+
+	if len(matchers) == 0 {
+		return nil, nil
+	}
+	return matchers, events
+}
+
+// newHookCallback はhook_callbackをhooks.Managerに委譲するコールバックを作成する
+func (c *Client) newHookCallback(event hooks.Event) protocol.HookCallback {
+	return func(ctx context.Context, req *protocol.HookCallbackRequest) (_ *protocol.HookCallbackResponse, err error) {
+		ctx, span := c.traces.startCallback(ctx, SpanHookCallback, req.ToolUseID, req.ToolName,
+			Attr(AttrHookEvent, string(event)),
+			Attr(AttrToolName, req.ToolName),
+			Attr(AttrSessionID, req.SessionID),
+		)
+		defer func() { endSpan(span, err) }()
+
+		input := c.hookInputFromRequest(req)
+
+		output, err := c.hookManager.Trigger(ctx, event, input)
+		if err != nil {
+			return nil, fmt.Errorf("hook error: %w", err)
+		}
+
+		return hookOutputToResponse(output), nil
+	}
+}
+
+// hookInputFromRequest はhook_callbackリクエストをhooks.Inputに変換する
+func (c *Client) hookInputFromRequest(req *protocol.HookCallbackRequest) *hooks.Input {
+	input := &hooks.Input{
+		SessionID:  req.SessionID,
+		CWD:        c.opts.CWD,
+		ToolName:   req.ToolName,
+		ToolUseID:  req.ToolUseID,
+		ToolOutput: req.Output,
+	}
+
+	data := req.Input
+	if sid, ok := data["session_id"].(string); ok && sid != "" {
+		input.SessionID = sid
+	}
+	if path, ok := data["transcript_path"].(string); ok {
+		input.TranscriptPath = path
+	}
+	if cwd, ok := data["cwd"].(string); ok && cwd != "" {
+		input.CWD = cwd
+	}
+	if name, ok := data["tool_name"].(string); ok && name != "" {
+		input.ToolName = name
+	}
+	if id, ok := data["tool_use_id"].(string); ok && id != "" {
+		input.ToolUseID = id
+	}
+	if toolInput, ok := data["tool_input"].(map[string]any); ok {
+		input.ToolInput = toolInput
+	}
+
+	// tool_responseはツールによって文字列の場合もあるためmapに包む
+	switch v := data["tool_response"].(type) {
+	case map[string]any:
+		input.ToolOutput = v
+	case nil:
+	default:
+		input.ToolOutput = map[string]any{"output": v}
+	}
+
+	if input.SessionID == "" {
+		input.SessionID = c.getSessionIDString()
+	}
+
+	return input
+}
+
+// hookOutputToResponse はhooks.OutputをCLIへのレスポンスに変換する
+func hookOutputToResponse(output *hooks.Output) *protocol.HookCallbackResponse {
+	resp := &protocol.HookCallbackResponse{
+		Continue:       output.Continue,
+		StopReason:     output.StopReason,
+		SuppressOutput: output.SuppressOutput,
+		Decision:       output.Decision,
+		SystemMessage:  output.SystemMessage,
+		Reason:         output.Reason,
+	}
+
+	if output.HookSpecificOutput != nil {
+		resp.HookSpecificOutput = &protocol.HookSpecificOutput{
+			HookEventName:            output.HookSpecificOutput.HookEventName,
+			PermissionDecision:       output.HookSpecificOutput.PermissionDecision,
+			PermissionDecisionReason: output.HookSpecificOutput.PermissionDecisionReason,
+			UpdatedInput:             output.HookSpecificOutput.UpdatedInput,
+			AdditionalContext:        output.HookSpecificOutput.AdditionalContext,
+		}
+	}
+
+	return resp
+}
+
+// hookTimeout はエントリのタイムアウトの合計を返す
+// 1つのコールバックでエントリを順に実行するため、合計まで待つようCLIに伝える
+// タイムアウト未設定のエントリはhooks.DefaultTimeoutとみなす
+func hookTimeout(entries []hooks.Entry) time.Duration {
+	var timeout time.Duration
+	for _, entry := range entries {
+		if entry.Timeout > 0 {
+			timeout += entry.Timeout
+		} else {
+			timeout += hooks.DefaultTimeout
+		}
+	}
+	return timeout
+}
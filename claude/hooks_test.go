@@ -0,0 +1,215 @@
+package claude
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+func hookCallbackRequest(callbackID string, input map[string]any) map[string]any {
+	return map[string]any{
+		"type":       "control_request",
+		"request_id": "req-1",
+		"request": map[string]any{
+			"subtype":     "hook_callback",
+			"callback_id": callbackID,
+			"input":       input,
+			"tool_use_id": "toolu_01",
+		},
+	}
+}
+
+func TestClient_RegisterHookCallbacks(t *testing.T) {
+	client, _ := newTestClient(&Options{
+		Hooks: &HookConfig{
+			PreToolUse: []HookEntry{
+				{Matcher: "Bash", Timeout: 90 * time.Second, Callback: func(ctx context.Context, input *HookInput) (*HookOutput, error) {
+					return &HookOutput{Continue: true}, nil
+				}},
+			},
+			PostToolUse: []HookEntry{
+				{Timeout: 10 * time.Second, Callback: func(ctx context.Context, input *HookInput) (*HookOutput, error) {
+					return &HookOutput{Continue: true}, nil
+				}},
+				{Timeout: 20 * time.Second, Callback: func(ctx context.Context, input *HookInput) (*HookOutput, error) {
+					return &HookOutput{Continue: true}, nil
+				}},
+			},
+			Stop: []HookEntry{
+				{Callback: func(ctx context.Context, input *HookInput) (*HookOutput, error) {
+					return &HookOutput{Continue: true}, nil
+				}},
+			},
+			UserPromptSubmit: []HookEntry{
+				{Callback: func(ctx context.Context, input *HookInput) (*HookOutput, error) {
+					return &HookOutput{Continue: true}, nil
+				}},
+			},
+		},
+	})
+
+	matchers := client.registerHookCallbacks()
+
+	if len(matchers) != 3 {
+		t.Fatalf("len(matchers) = %d, want 3", len(matchers))
+	}
+	if _, ok := matchers["UserPromptSubmit"]; ok {
+		t.Error("UserPromptSubmit should be triggered by Send, not registered with CLI")
+	}
+
+	pre := matchers["PreToolUse"]
+	if len(pre) != 1 || len(pre[0].HookCallbackIDs) != 1 {
+		t.Fatalf("PreToolUse matchers = %+v", pre)
+	}
+	if pre[0].Timeout != 90 {
+		t.Errorf("Timeout = %v, want 90", pre[0].Timeout)
+	}
+	// エントリは順に実行されるためタイムアウトの合計（デフォルトより短くてもよい）
+	if matchers["PostToolUse"][0].Timeout != 30 {
+		t.Errorf("PostToolUse Timeout = %v, want 30", matchers["PostToolUse"][0].Timeout)
+	}
+	if matchers["Stop"][0].Timeout != 60 {
+		t.Errorf("Stop Timeout = %v, want 60", matchers["Stop"][0].Timeout)
+	}
+}
+
+func TestClient_RegisterHookCallbacks_NoHooks(t *testing.T) {
+	client, _ := newTestClient(nil)
+
+	if matchers := client.registerHookCallbacks(); matchers != nil {
+		t.Errorf("matchers = %v, want nil", matchers)
+	}
+}
+
+func TestClient_HookCallback_PreToolUseDeny(t *testing.T) {
+	var received *HookInput
+	client, mt := newTestClient(&Options{
+		CWD: "/work",
+		Hooks: &HookConfig{
+			PreToolUse: []HookEntry{
+				{
+					Matcher: "Bash",
+					Callback: func(ctx context.Context, input *HookInput) (*HookOutput, error) {
+						received = input
+						return &HookOutput{
+							Continue: true,
+							HookSpecificOutput: &HookSpecificOutput{
+								HookEventName:            "PreToolUse",
+								PermissionDecision:       "deny",
+								PermissionDecisionReason: "rm is not allowed",
+								UpdatedInput:             map[string]any{"command": "ls"},
+							},
+						}, nil
+					},
+				},
+			},
+		},
+	})
+
+	matchers := client.registerHookCallbacks()
+	callbackID := matchers["PreToolUse"][0].HookCallbackIDs[0]
+
+	raw := rawMessage(hookCallbackRequest(callbackID, map[string]any{
+		"hook_event_name": "PreToolUse",
+		"session_id":      "session-1",
+		"tool_name":       "Bash",
+		"tool_input":      map[string]any{"command": "rm -rf /"},
+	}))
+	if err := client.protocol.HandleIncoming(context.Background(), raw); err != nil {
+		t.Fatalf("HandleIncoming failed: %v", err)
+	}
+
+	if received == nil {
+		t.Fatal("hook callback was not called")
+	}
+	if received.ToolName != "Bash" || received.SessionID != "session-1" || received.ToolUseID != "toolu_01" {
+		t.Errorf("unexpected input: %+v", received)
+	}
+	if received.CWD != "/work" {
+		t.Errorf("CWD = %q, want %q", received.CWD, "/work")
+	}
+	if received.ToolInput["command"] != "rm -rf /" {
+		t.Errorf("ToolInput = %v", received.ToolInput)
+	}
+
+	body := mt.lastWritten(t)["response"].(map[string]any)
+	if body["subtype"] != "success" {
+		t.Fatalf("subtype = %v, want success", body["subtype"])
+	}
+	resp := body["response"].(map[string]any)
+	if resp["continue"] != true {
+		t.Errorf("continue = %v, want true", resp["continue"])
+	}
+	specific, ok := resp["hookSpecificOutput"].(map[string]any)
+	if !ok {
+		t.Fatalf("hookSpecificOutput missing: %v", resp)
+	}
+	if specific["permissionDecision"] != "deny" {
+		t.Errorf("permissionDecision = %v, want deny", specific["permissionDecision"])
+	}
+	if specific["updatedInput"].(map[string]any)["command"] != "ls" {
+		t.Errorf("updatedInput = %v", specific["updatedInput"])
+	}
+}
+
+func TestClient_HookCallback_MatcherMiss(t *testing.T) {
+	called := false
+	client, mt := newTestClient(&Options{
+		Hooks: &HookConfig{
+			PostToolUse: []HookEntry{
+				{
+					Matcher: "Bash",
+					Callback: func(ctx context.Context, input *HookInput) (*HookOutput, error) {
+						called = true
+						return &HookOutput{Continue: false}, nil
+					},
+				},
+			},
+		},
+	})
+
+	matchers := client.registerHookCallbacks()
+	callbackID := matchers["PostToolUse"][0].HookCallbackIDs[0]
+
+	raw := rawMessage(hookCallbackRequest(callbackID, map[string]any{
+		"hook_event_name": "PostToolUse",
+		"tool_name":       "Read",
+		"tool_response":   "file contents",
+	}))
+	if err := client.protocol.HandleIncoming(context.Background(), raw); err != nil {
+		t.Fatalf("HandleIncoming failed: %v", err)
+	}
+
+	if called {
+		t.Error("hook should not be called for non-matching tool")
+	}
+	resp := mt.lastWritten(t)["response"].(map[string]any)["response"].(map[string]any)
+	if resp["continue"] != true {
+		t.Errorf("continue = %v, want true", resp["continue"])
+	}
+}
+
+func TestClient_HookInputFromRequest_ToolResponse(t *testing.T) {
+	client := NewClient(nil)
+
+	input := client.hookInputFromRequest(&protocol.HookCallbackRequest{
+		Input: map[string]any{
+			"tool_name":     "Read",
+			"tool_response": "contents",
+		},
+	})
+	if input.ToolOutput["output"] != "contents" {
+		t.Errorf("ToolOutput = %v", input.ToolOutput)
+	}
+
+	input = client.hookInputFromRequest(&protocol.HookCallbackRequest{
+		Input: map[string]any{
+			"tool_response": map[string]any{"stdout": "ok"},
+		},
+	})
+	if input.ToolOutput["stdout"] != "ok" {
+		t.Errorf("ToolOutput = %v", input.ToolOutput)
+	}
+}
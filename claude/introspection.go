@@ -0,0 +1,129 @@
+package claude
+
+import (
+	"context"
+	"errors"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// storeCapabilities はinitializeレスポンスをCLIの機能スナップショットとして保存する
+func (c *Client) storeCapabilities(resp *protocol.ControlResponse) {
+	if resp == nil || resp.Response.Response == nil {
+		// CLIが情報を返さなかった場合は不明のままにする
+		return
+	}
+	var caps protocol.InitializeResponse
+	if err := resp.Decode(&caps); err != nil {
+		// 形式が異なる場合もinitialize自体は成功として扱う
+		return
+	}
+	c.capabilities.Store(&caps)
+}
+
+// Capabilities はinitialize時に取得したCLIの機能スナップショットを返す
+// 接続前、またはCLIが情報を返さなかった場合はnilを返す
+func (c *Client) Capabilities() *protocol.InitializeResponse {
+	return c.capabilities.Load()
+}
+
+// SupportedModels は接続中のCLIで利用可能なモデル一覧を返す
+// CLIがリクエストに対応していない場合はinitialize時のスナップショットを返す
+func (c *Client) SupportedModels(ctx context.Context) ([]protocol.ModelInfo, error) {
+	req := protocol.SupportedModelsRequest{Subtype: "supported_models"}
+
+	resp, err := c.sendControlRequest(ctx, "supported_models", req)
+	if err != nil {
+		if caps := c.Capabilities(); caps != nil && caps.Models != nil && errors.Is(err, ErrControlRejected) {
+			return caps.Models, nil
+		}
+		return nil, err
+	}
+
+	var result protocol.SupportedModelsResponse
+	if err := resp.Decode(&result); err != nil {
+		return nil, &SDKError{Op: "supported_models", Err: ErrJSONDecode, Details: err.Error()}
+	}
+	return result.Models, nil
+}
+
+// SupportedCommands は接続中のCLIで利用可能なスラッシュコマンド一覧を返す
+// CLIがリクエストに対応していない場合はinitialize時のスナップショットを返す
+func (c *Client) SupportedCommands(ctx context.Context) ([]protocol.SlashCommand, error) {
+	req := protocol.SupportedCommandsRequest{Subtype: "supported_commands"}
+
+	resp, err := c.sendControlRequest(ctx, "supported_commands", req)
+	if err != nil {
+		if caps := c.Capabilities(); caps != nil && caps.Commands != nil && errors.Is(err, ErrControlRejected) {
+			return caps.Commands, nil
+		}
+		return nil, err
+	}
+
+	var result protocol.SupportedCommandsResponse
+	if err := resp.Decode(&result); err != nil {
+		return nil, &SDKError{Op: "supported_commands", Err: ErrJSONDecode, Details: err.Error()}
+	}
+	return result.Commands, nil
+}
+
+// AccountInfo はログイン中のアカウント情報を返す
+// CLIがリクエストに対応していない場合はinitialize時のスナップショットを返す
+func (c *Client) AccountInfo(ctx context.Context) (*protocol.AccountInfo, error) {
+	req := protocol.AccountInfoRequest{Subtype: "account_info"}
+
+	resp, err := c.sendControlRequest(ctx, "account_info", req)
+	if err != nil {
+		if caps := c.Capabilities(); caps != nil && caps.Account != nil && errors.Is(err, ErrControlRejected) {
+			return caps.Account, nil
+		}
+		return nil, err
+	}
+
+	var result protocol.AccountInfoResponse
+	if err := resp.Decode(&result); err != nil {
+		return nil, &SDKError{Op: "account_info", Err: ErrJSONDecode, Details: err.Error()}
+	}
+	return result.Account, nil
+}
+
+// MCPServerStatus は各MCPサーバーの接続状態を返す
+func (c *Client) MCPServerStatus(ctx context.Context) ([]protocol.MCPServerStatus, error) {
+	req := protocol.MCPStatusRequest{Subtype: "mcp_status"}
+
+	resp, err := c.sendControlRequest(ctx, "mcp_status", req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result protocol.MCPStatusResponse
+	if err := resp.Decode(&result); err != nil {
+		return nil, &SDKError{Op: "mcp_status", Err: ErrJSONDecode, Details: err.Error()}
+	}
+	return result.MCPServers, nil
+}
+
+// Capabilities はinitialize時に取得したCLIの機能スナップショットを返す
+func (s *Stream) Capabilities() *protocol.InitializeResponse {
+	return s.client.Capabilities()
+}
+
+// SupportedModels は接続中のCLIで利用可能なモデル一覧を返す
+func (s *Stream) SupportedModels(ctx context.Context) ([]protocol.ModelInfo, error) {
+	return s.client.SupportedModels(ctx)
+}
+
+// SupportedCommands は接続中のCLIで利用可能なスラッシュコマンド一覧を返す
+func (s *Stream) SupportedCommands(ctx context.Context) ([]protocol.SlashCommand, error) {
+	return s.client.SupportedCommands(ctx)
+}
+
+// AccountInfo はログイン中のアカウント情報を返す
+func (s *Stream) AccountInfo(ctx context.Context) (*protocol.AccountInfo, error) {
+	return s.client.AccountInfo(ctx)
+}
+
+// MCPServerStatus は各MCPサーバーの接続状態を返す
+func (s *Stream) MCPServerStatus(ctx context.Context) ([]protocol.MCPServerStatus, error) {
+	return s.client.MCPServerStatus(ctx)
+}
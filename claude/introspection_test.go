@@ -0,0 +1,175 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+func TestClient_StoreCapabilities(t *testing.T) {
+	client := NewClient(nil)
+
+	if client.Capabilities() != nil {
+		t.Error("Capabilities should be nil before initialize")
+	}
+
+	// 応答の本文がない場合は不明のまま
+	client.storeCapabilities(&protocol.ControlResponse{
+		Type:     "control_response",
+		Response: protocol.ControlResponseBody{Subtype: "success"},
+	})
+	if client.Capabilities() != nil {
+		t.Error("Capabilities should stay nil when the response has no body")
+	}
+
+	client.storeCapabilities(&protocol.ControlResponse{
+		Type: "control_response",
+		Response: protocol.ControlResponseBody{
+			Subtype: "success",
+			Response: map[string]any{
+				"commands": []any{
+					map[string]any{"name": "compact", "description": "Compact conversation", "argumentHint": "<instructions>"},
+				},
+				"models": []any{
+					map[string]any{"value": "sonnet", "displayName": "Sonnet"},
+				},
+				"account": map[string]any{
+					"email":            "user@example.com",
+					"subscriptionType": "max",
+				},
+				"output_style": "default",
+			},
+		},
+	})
+
+	caps := client.Capabilities()
+	if caps == nil {
+		t.Fatal("Capabilities should not be nil")
+	}
+	if len(caps.Commands) != 1 || caps.Commands[0].ArgumentHint != "<instructions>" {
+		t.Errorf("Commands = %+v", caps.Commands)
+	}
+	if len(caps.Models) != 1 || caps.Models[0].Value != "sonnet" {
+		t.Errorf("Models = %+v", caps.Models)
+	}
+	if caps.Account == nil || caps.Account.SubscriptionType != "max" {
+		t.Errorf("Account = %+v", caps.Account)
+	}
+	if caps.OutputStyle != "default" {
+		t.Errorf("OutputStyle = %q, want %q", caps.OutputStyle, "default")
+	}
+}
+
+func TestClient_SupportedModels(t *testing.T) {
+	client, mt := newTestClient(nil)
+
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		if req["subtype"] != "supported_models" {
+			t.Errorf("subtype = %v, want supported_models", req["subtype"])
+		}
+		return map[string]any{
+			"models": []any{
+				map[string]any{"value": "opus", "displayName": "Opus", "description": "Most capable"},
+				map[string]any{"value": "sonnet", "displayName": "Sonnet"},
+			},
+		}, ""
+	})
+
+	models, err := client.SupportedModels(context.Background())
+	if err != nil {
+		t.Fatalf("SupportedModels failed: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("len(models) = %d, want 2", len(models))
+	}
+	if models[0].Description != "Most capable" {
+		t.Errorf("Description = %q, want %q", models[0].Description, "Most capable")
+	}
+}
+
+func TestClient_SupportedCommands_FallbackToSnapshot(t *testing.T) {
+	client, mt := newTestClient(nil)
+	client.capabilities.Store(&protocol.InitializeResponse{
+		Commands: []protocol.SlashCommand{{Name: "review", Description: "Review code"}},
+	})
+
+	// 古いCLIはsupported_commandsに対応していない
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		return nil, "unknown subtype: supported_commands"
+	})
+
+	commands, err := client.SupportedCommands(context.Background())
+	if err != nil {
+		t.Fatalf("SupportedCommands failed: %v", err)
+	}
+	if len(commands) != 1 || commands[0].Name != "review" {
+		t.Errorf("commands = %+v", commands)
+	}
+}
+
+func TestClient_AccountInfo(t *testing.T) {
+	client, mt := newTestClient(nil)
+
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		return map[string]any{
+			"account": map[string]any{
+				"email":            "user@example.com",
+				"organization":     "Example",
+				"subscriptionType": "pro",
+			},
+		}, ""
+	})
+
+	account, err := client.AccountInfo(context.Background())
+	if err != nil {
+		t.Fatalf("AccountInfo failed: %v", err)
+	}
+	if account.Email != "user@example.com" || account.SubscriptionType != "pro" {
+		t.Errorf("account = %+v", account)
+	}
+}
+
+func TestClient_MCPServerStatus(t *testing.T) {
+	client, mt := newTestClient(nil)
+
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		if req["subtype"] != "mcp_status" {
+			t.Errorf("subtype = %v, want mcp_status", req["subtype"])
+		}
+		return map[string]any{
+			"mcpServers": []any{
+				map[string]any{"name": "calc", "status": "connected", "serverInfo": map[string]any{"name": "calc", "version": "1.0.0"}},
+				map[string]any{"name": "db", "status": "failed"},
+			},
+		}, ""
+	})
+
+	statuses, err := client.MCPServerStatus(context.Background())
+	if err != nil {
+		t.Fatalf("MCPServerStatus failed: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("len(statuses) = %d, want 2", len(statuses))
+	}
+	if statuses[0].ServerInfo == nil || statuses[0].ServerInfo.Version != "1.0.0" {
+		t.Errorf("statuses[0] = %+v", statuses[0])
+	}
+	if statuses[1].Status != "failed" {
+		t.Errorf("statuses[1].Status = %q, want %q", statuses[1].Status, "failed")
+	}
+}
+
+func TestClient_MCPServerStatus_Rejected(t *testing.T) {
+	client, mt := newTestClient(nil)
+
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		return nil, "not supported"
+	})
+
+	_, err := client.MCPServerStatus(context.Background())
+	if !errors.Is(err, ErrControlRejected) {
+		t.Errorf("expected ErrControlRejected, got %v", err)
+	}
+}
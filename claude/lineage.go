@@ -0,0 +1,370 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LineageKind はセッションが親セッションからどのように作られたかを表す
+type LineageKind string
+
+const (
+	LineageFork     LineageKind = "fork"     // Resume + ForkSessionで分岐した
+	LineageResume   LineageKind = "resume"   // Resumeで再開した（CLIが新しいIDを割り当てた場合）
+	LineageContinue LineageKind = "continue" // Continueで直前のセッションを継続した
+)
+
+// LineageNode はセッションと親セッションの関係
+type LineageNode struct {
+	SessionID   string      `json:"session_id"`
+	ParentID    string      `json:"parent_id,omitempty"`
+	Kind        LineageKind `json:"kind,omitempty"`
+	BranchPoint string      `json:"branch_point,omitempty"` // 分岐時点の親セッションの最後のメッセージUUID
+	CWD         string      `json:"cwd,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// BranchPoint は親セッションの同じメッセージから分岐した子セッションの集まり
+type BranchPoint struct {
+	MessageUUID string        // 分岐したメッセージのUUID（不明な場合は空）
+	Children    []LineageNode // 分岐した子セッション（作成順）
+}
+
+// LineageTree はセッションを根とする系譜の木
+type LineageTree struct {
+	Node     LineageNode
+	Children []*LineageTree
+}
+
+// Walk は木を深さ優先でたどる（depthは根が0）
+func (t *LineageTree) Walk(fn func(node LineageNode, depth int)) {
+	t.walk(fn, 0)
+}
+
+func (t *LineageTree) walk(fn func(node LineageNode, depth int), depth int) {
+	fn(t.Node, depth)
+	for _, child := range t.Children {
+		child.walk(fn, depth+1)
+	}
+}
+
+// Print は木をインデント付きのテキストで出力する
+func (t *LineageTree) Print(w io.Writer) error {
+	var err error
+	t.Walk(func(node LineageNode, depth int) {
+		if err != nil {
+			return
+		}
+		line := strings.Repeat("  ", depth) + node.SessionID
+		if node.Kind != "" {
+			line += " (" + string(node.Kind)
+			if node.BranchPoint != "" {
+				line += " at " + node.BranchPoint
+			}
+			line += ")"
+		}
+		_, err = fmt.Fprintln(w, line)
+	})
+	return err
+}
+
+// LineageStore はセッションの系譜（分岐・継続の親子関係）をローカルのJSONファイルに保存する
+// Options.Lineageに設定すると、Resume/Continueで接続したセッションの実際のIDが親セッションとともに記録される
+type LineageStore struct {
+	path     string
+	sessions *SessionStore
+	mu       sync.Mutex
+}
+
+// NewLineageStore は系譜をpathに保存するLineageStoreを作成する
+// pathが空の場合はDefaultLineagePath()、sessionsがnilの場合はデフォルトのSessionStoreを使用する
+// sessionsは分岐点のメッセージUUIDとContinueの親セッションの特定に使用する
+func NewLineageStore(path string, sessions *SessionStore) *LineageStore {
+	if path == "" {
+		path = DefaultLineagePath()
+	}
+	if sessions == nil {
+		sessions = NewSessionStore("")
+	}
+	return &LineageStore{path: path, sessions: sessions}
+}
+
+// DefaultLineagePath は系譜ファイルのデフォルトの保存先を返す（トランスクリプトの保存先と同じ設定ディレクトリ）
+func DefaultLineagePath() string {
+	return filepath.Join(filepath.Dir(DefaultSessionRoot()), "sdk-lineage.json")
+}
+
+// load は系譜ファイルを読み込む
+func (l *LineageStore) load() (map[string]LineageNode, error) {
+	nodes := make(map[string]LineageNode)
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nodes, nil
+		}
+		return nil, &SDKError{Op: "load_lineage", Err: err}
+	}
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		return nil, &SDKError{Op: "load_lineage", Err: ErrJSONDecode, Details: err.Error()}
+	}
+	return nodes, nil
+}
+
+// snapshot はロックを取得して系譜を読み込む
+func (l *LineageStore) snapshot() (map[string]LineageNode, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.load()
+}
+
+// Record はセッションの親子関係を記録する（同じセッションIDの記録は上書きする）
+func (l *LineageStore) Record(node LineageNode) error {
+	if node.SessionID == "" {
+		return &SDKError{Op: "record_lineage", Err: ErrInvalidConfig, Details: "session ID is empty"}
+	}
+	if node.CreatedAt.IsZero() {
+		node.CreatedAt = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	nodes, err := l.load()
+	if err != nil {
+		return err
+	}
+	nodes[node.SessionID] = node
+
+	data, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return &SDKError{Op: "record_lineage", Err: err}
+	}
+	if err := writeFileAtomic(l.path, data); err != nil {
+		return &SDKError{Op: "record_lineage", Err: err}
+	}
+	return nil
+}
+
+// Node はセッションの記録を返す
+func (l *LineageStore) Node(id string) (*LineageNode, error) {
+	nodes, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	node, ok := nodes[id]
+	if !ok {
+		return nil, &SDKError{Op: "lineage", Err: ErrSessionNotFound, Details: id}
+	}
+	return &node, nil
+}
+
+// Ancestors はセッションの祖先を近い順に返す（記録のないセッションは空）
+func (l *LineageStore) Ancestors(id string) ([]LineageNode, error) {
+	nodes, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	var ancestors []LineageNode
+	seen := map[string]bool{id: true}
+	for parent := nodes[id].ParentID; parent != "" && !seen[parent]; parent = nodes[parent].ParentID {
+		seen[parent] = true
+		node, ok := nodes[parent]
+		if !ok {
+			// 記録のない根のセッション
+			node = LineageNode{SessionID: parent}
+		}
+		ancestors = append(ancestors, node)
+	}
+	return ancestors, nil
+}
+
+// Root はセッションの系譜の根にあたるセッションIDを返す
+func (l *LineageStore) Root(id string) (string, error) {
+	ancestors, err := l.Ancestors(id)
+	if err != nil {
+		return "", err
+	}
+	if len(ancestors) == 0 {
+		return id, nil
+	}
+	return ancestors[len(ancestors)-1].SessionID, nil
+}
+
+// Children はセッションから作られた子セッションを作成順に返す
+func (l *LineageStore) Children(id string) ([]LineageNode, error) {
+	nodes, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return childrenOf(nodes, id), nil
+}
+
+func childrenOf(nodes map[string]LineageNode, id string) []LineageNode {
+	var children []LineageNode
+	for _, node := range nodes {
+		if node.ParentID == id && node.SessionID != id {
+			children = append(children, node)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if !children[i].CreatedAt.Equal(children[j].CreatedAt) {
+			return children[i].CreatedAt.Before(children[j].CreatedAt)
+		}
+		return children[i].SessionID < children[j].SessionID
+	})
+	return children
+}
+
+// BranchPoints はセッションの子セッションを分岐したメッセージUUIDごとにまとめて返す
+func (l *LineageStore) BranchPoints(id string) ([]BranchPoint, error) {
+	children, err := l.Children(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []BranchPoint
+	index := make(map[string]int)
+	for _, child := range children {
+		i, ok := index[child.BranchPoint]
+		if !ok {
+			i = len(points)
+			index[child.BranchPoint] = i
+			points = append(points, BranchPoint{MessageUUID: child.BranchPoint})
+		}
+		points[i].Children = append(points[i].Children, child)
+	}
+	return points, nil
+}
+
+// Tree はセッションを根とする系譜の木を返す
+func (l *LineageStore) Tree(id string) (*LineageTree, error) {
+	nodes, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return buildLineageTree(nodes, id, map[string]bool{}), nil
+}
+
+func buildLineageTree(nodes map[string]LineageNode, id string, seen map[string]bool) *LineageTree {
+	seen[id] = true
+	node, ok := nodes[id]
+	if !ok {
+		node = LineageNode{SessionID: id}
+	}
+	tree := &LineageTree{Node: node}
+	for _, child := range childrenOf(nodes, id) {
+		if !seen[child.SessionID] {
+			tree.Children = append(tree.Children, buildLineageTree(nodes, child.SessionID, seen))
+		}
+	}
+	return tree
+}
+
+// Roots は記録されているすべての系譜の根のセッションIDを返す
+func (l *LineageStore) Roots() ([]string, error) {
+	nodes, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var roots []string
+	for _, node := range nodes {
+		root := node.SessionID
+		visited := map[string]bool{root: true}
+		for parent := node.ParentID; parent != "" && !visited[parent]; parent = nodes[parent].ParentID {
+			visited[parent] = true
+			root = parent
+		}
+		if !seen[root] {
+			seen[root] = true
+			roots = append(roots, root)
+		}
+	}
+	sort.Strings(roots)
+	return roots, nil
+}
+
+// pendingLineage は接続時に特定した親セッション（セッションIDの確定後に記録する）
+type pendingLineage struct {
+	store       *LineageStore
+	parentID    string
+	kind        LineageKind
+	branchPoint string
+	cwd         string
+}
+
+// newPendingLineage はOptionsのセッション設定から親セッションを特定する
+// Lineageが未設定の場合は記録せず、Resumeの親セッションのみを保持する
+// CLIが新しいトランスクリプトを書き始める前に呼び出す必要がある
+func newPendingLineage(opts *Options) *pendingLineage {
+	if opts.Resume == "" && !opts.Continue {
+		return nil
+	}
+
+	p := &pendingLineage{store: opts.Lineage, kind: LineageContinue}
+	if opts.Resume != "" {
+		p.parentID = opts.Resume
+		p.kind = LineageResume
+		if opts.ForkSession {
+			p.kind = LineageFork
+		}
+	}
+	if p.store == nil {
+		return p
+	}
+
+	p.cwd = opts.CWD
+	if p.cwd == "" {
+		p.cwd, _ = os.Getwd()
+	}
+	if p.parentID == "" {
+		// Continueは作業ディレクトリで最後にアクティブだったセッションを継続する
+		sessions, err := p.store.sessions.List(p.cwd)
+		if err != nil || len(sessions) == 0 {
+			return p
+		}
+		p.parentID = sessions[0].ID
+	}
+
+	// トランスクリプトが読めない場合は分岐点なしで記録する
+	p.branchPoint, _ = p.store.sessions.lastMessageUUID(p.cwd, p.parentID)
+	return p
+}
+
+// record は確定したセッションIDを親セッションとともに記録する
+// CLIが親と同じセッションIDを使い続けた場合は記録しない
+func (p *pendingLineage) record(sessionID string) error {
+	if p == nil || p.store == nil || p.parentID == "" || sessionID == "" || sessionID == p.parentID {
+		return nil
+	}
+	return p.store.Record(LineageNode{
+		SessionID:   sessionID,
+		ParentID:    p.parentID,
+		Kind:        p.kind,
+		BranchPoint: p.branchPoint,
+		CWD:         p.cwd,
+	})
+}
+
+// session はセッションIDと系譜からSessionを組み立てる
+func (p *pendingLineage) session(sessionID string) *Session {
+	s := &Session{ID: sessionID}
+	if p == nil {
+		return s
+	}
+	s.ParentID = p.parentID
+	s.IsForked = p.kind == LineageFork
+	s.Continued = p.kind != LineageFork
+	return s
+}
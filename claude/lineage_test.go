@@ -0,0 +1,175 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+)
+
+func TestLineageStore_Tree(t *testing.T) {
+	store := NewLineageStore(filepath.Join(t.TempDir(), "lineage.json"), NewSessionStore(t.TempDir()))
+	base := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	records := []LineageNode{
+		{SessionID: "b", ParentID: "root", Kind: LineageFork, BranchPoint: "m2", CreatedAt: base},
+		{SessionID: "c", ParentID: "root", Kind: LineageFork, BranchPoint: "m2", CreatedAt: base.Add(time.Minute)},
+		{SessionID: "d", ParentID: "root", Kind: LineageFork, BranchPoint: "m5", CreatedAt: base.Add(2 * time.Minute)},
+		{SessionID: "e", ParentID: "c", Kind: LineageContinue, CreatedAt: base.Add(3 * time.Minute)},
+	}
+	for _, r := range records {
+		if err := store.Record(r); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	ancestors, err := store.Ancestors("e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ancestors) != 2 || ancestors[0].SessionID != "c" || ancestors[1].SessionID != "root" {
+		t.Errorf("Ancestors = %+v", ancestors)
+	}
+	if root, _ := store.Root("e"); root != "root" {
+		t.Errorf("Root = %q", root)
+	}
+
+	children, _ := store.Children("root")
+	if len(children) != 3 || children[0].SessionID != "b" || children[2].SessionID != "d" {
+		t.Errorf("Children = %+v", children)
+	}
+
+	points, _ := store.BranchPoints("root")
+	if len(points) != 2 || points[0].MessageUUID != "m2" || len(points[0].Children) != 2 || points[1].MessageUUID != "m5" {
+		t.Errorf("BranchPoints = %+v", points)
+	}
+
+	roots, _ := store.Roots()
+	if len(roots) != 1 || roots[0] != "root" {
+		t.Errorf("Roots = %v", roots)
+	}
+
+	tree, err := store.Tree("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tree.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "root\n  b (fork at m2)\n  c (fork at m2)\n    e (continue)\n  d (fork at m5)\n"
+	if buf.String() != want {
+		t.Errorf("tree =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	if _, err := store.Node("missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+
+	// 別のインスタンスからも読み込める
+	reopened := NewLineageStore(store.path, nil)
+	if node, err := reopened.Node("d"); err != nil || node.BranchPoint != "m5" {
+		t.Errorf("reopened Node = %+v, %v", node, err)
+	}
+}
+
+// lineageFixture は実在する作業ディレクトリに親セッションのトランスクリプトを用意する
+func lineageFixture(t *testing.T) (cwd string, lineage *LineageStore) {
+	t.Helper()
+	cwd = t.TempDir()
+	root := t.TempDir()
+	dir := filepath.Join(root, ProjectDirName(cwd))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	transcript := `{"type":"user","uuid":"p1","sessionId":"sess-parent","timestamp":"2025-06-01T10:00:00Z","message":{"role":"user","content":"hello"}}
+{"type":"assistant","uuid":"p2","parentUuid":"p1","sessionId":"sess-parent","timestamp":"2025-06-01T10:00:01Z","message":{"role":"assistant","model":"m","content":[{"type":"text","text":"hi"}]}}
+`
+	if err := os.WriteFile(filepath.Join(dir, "sess-parent.jsonl"), []byte(transcript), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return cwd, NewLineageStore(filepath.Join(t.TempDir(), "lineage.json"), NewSessionStore(root))
+}
+
+func TestClient_RecordsForkLineage(t *testing.T) {
+	cwd, lineage := lineageFixture(t)
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		SessionID: "sess-forked",
+		Steps:     []fakecli.Step{{Action: fakecli.ActionWaitUser}},
+	})
+
+	client := NewClient(&Options{
+		CLIPath:     cliPath,
+		CWD:         cwd,
+		Resume:      "sess-parent",
+		ForkSession: true,
+		Lineage:     lineage,
+	})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != "sess-forked" || session.ParentID != "sess-parent" || !session.IsForked || session.Continued {
+		t.Errorf("Session = %+v", session)
+	}
+
+	node, err := lineage.Node("sess-forked")
+	if err != nil {
+		t.Fatalf("lineage not recorded: %v", err)
+	}
+	if node.ParentID != "sess-parent" || node.Kind != LineageFork || node.BranchPoint != "p2" || node.CWD != cwd {
+		t.Errorf("node = %+v", node)
+	}
+}
+
+func TestQuery_RecordsContinueLineage(t *testing.T) {
+	cwd, lineage := lineageFixture(t)
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		SessionID: "sess-next",
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionResult, Result: "ok"},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := Query(ctx, "continue", &Options{CLIPath: cliPath, CWD: cwd, Continue: true, Lineage: lineage}); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	node, err := lineage.Node("sess-next")
+	if err != nil {
+		t.Fatalf("lineage not recorded: %v", err)
+	}
+	if node.ParentID != "sess-parent" || node.Kind != LineageContinue || node.BranchPoint != "p2" {
+		t.Errorf("node = %+v", node)
+	}
+}
+
+func TestClient_SessionWithoutLineage(t *testing.T) {
+	client := NewClient(&Options{Resume: "sess-parent"})
+	client.lineage = newPendingLineage(client.opts)
+	client.setSessionID("sess-parent")
+
+	session, err := client.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != "sess-parent" || session.ParentID != "sess-parent" || session.IsForked || !session.Continued {
+		t.Errorf("Session = %+v", session)
+	}
+}
@@ -0,0 +1,17 @@
+package claude
+
+import (
+	"log/slog"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/logging"
+)
+
+// logger はOptionsからロガーを作成する
+// プロンプトやツール入力などのペイロードはLogPayloadsが有効な場合のみ出力する
+func (o *Options) logger() *slog.Logger {
+	logger := logging.OrDiscard(o.Logger)
+	if o.LogPayloads {
+		return logging.WithPayloads(logger)
+	}
+	return logger
+}
@@ -0,0 +1,113 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+)
+
+// logBuffer は複数のgoroutineから書き込まれるログを保持する
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestLogger(buf *logBuffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// waitForLog はログにwantが含まれるまで待ち、ログ全体を返す
+func waitForLog(t *testing.T, buf *logBuffer, want string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out := buf.String()
+		if strings.Contains(out, want) {
+			return out
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("log %s not found: %s", want, out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQuery_LogsStderrAndRedactsPrompt(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionCrash, ExitCode: 3, Stderr: "fatal: out of memory"},
+		},
+	})
+
+	var buf logBuffer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := Query(ctx, "secret prompt", &Options{CLIPath: cliPath, Logger: newTestLogger(&buf)}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// stderrとプロセス終了はQueryが返った後に記録される場合がある
+	out := waitForLog(t, &buf, `"level":"DEBUG","msg":"CLI stderr","line":"[REDACTED]"`)
+	if strings.Contains(out, "secret prompt") || !strings.Contains(out, `"args":"[REDACTED]"`) {
+		t.Errorf("prompt should be redacted: %s", out)
+	}
+	if strings.Contains(out, "fatal: out of memory") {
+		t.Errorf("stderr should be redacted: %s", out)
+	}
+
+	// LogPayloadsを有効にするとstderrの内容を出力する
+	var revealed logBuffer
+	if _, err := Query(ctx, "secret prompt", &Options{CLIPath: cliPath, Logger: newTestLogger(&revealed), LogPayloads: true}); err == nil {
+		t.Fatal("expected error")
+	}
+	waitForLog(t, &revealed, `"msg":"CLI stderr","line":"fatal: out of memory"`)
+}
+
+func TestClient_LogPayloads(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionCanUseTool, ToolName: "Bash", Input: map[string]any{"command": "rm -rf tmp"},
+				Expect: map[string]any{"allow": true}},
+			{Action: fakecli.ActionResult, Result: "done"},
+		},
+	})
+
+	canUseTool := func(context.Context, string, map[string]any, *ToolPermissionContext) (*PermissionResult, error) {
+		return &PermissionResult{Allow: true}, nil
+	}
+
+	var redacted, revealed logBuffer
+	runFakeTurn(t, &Options{CLIPath: cliPath, Logger: newTestLogger(&redacted), CanUseTool: canUseTool}, "secret prompt")
+	runFakeTurn(t, &Options{CLIPath: cliPath, Logger: newTestLogger(&revealed), LogPayloads: true, CanUseTool: canUseTool}, "secret prompt")
+
+	if out := redacted.String(); strings.Contains(out, "secret prompt") || strings.Contains(out, "rm -rf tmp") {
+		t.Errorf("payloads should be redacted: %s", out)
+	}
+	out := revealed.String()
+	if !strings.Contains(out, `"msg":"sending user message"`) || !strings.Contains(out, `"prompt":"secret prompt"`) {
+		t.Errorf("prompt should be logged: %s", out)
+	}
+	if !strings.Contains(out, `"msg":"checking tool permission"`) || !strings.Contains(out, `"input":{"command":"rm -rf tmp"}`) {
+		t.Errorf("tool input should be logged: %s", out)
+	}
+}
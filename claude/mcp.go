@@ -0,0 +1,88 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/mcp"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// registerMCPServers はOptionsからMCPサーバーを登録する
+func (c *Client) registerMCPServers() {
+	// 外部MCPサーバー（CLIが起動・通信する）
+	for name, config := range c.opts.MCPServers {
+		c.mcpManager.AddExternalServer(name, convertMCPServerConfig(config))
+	}
+
+	// SDK MCPサーバー（mcp_message経由でインプロセス実行する）
+	for name, server := range c.opts.SDKMCPServers {
+		c.mcpManager.AddSDKServer(name, server)
+	}
+}
+
+// handleMCPMessage はCLIからのmcp_messageをSDK MCPサーバーにルーティングする
+func (c *Client) handleMCPMessage(ctx context.Context, req *protocol.MCPMessageRequest) (_ *protocol.MCPMessageResponse, err error) {
+	method, _ := req.Message["method"].(string)
+	_, span := c.traces.startCallback(ctx, SpanMCPMessage, "", mcpToolName(req.ServerName, req.Message),
+		Attr(AttrMCPServer, req.ServerName),
+		Attr(AttrMCPMethod, method),
+	)
+	defer func() { endSpan(span, err) }()
+
+	var msg mcp.Message
+	if err := remarshal(req.Message, &msg); err != nil {
+		return nil, fmt.Errorf("decode mcp message: %w", err)
+	}
+
+	start := time.Now()
+	resp, err := c.mcpManager.HandleMCPMessage(req.ServerName, &msg)
+	if err != nil {
+		c.opts.Metrics.observeMCPRequest(req.ServerName, method, time.Since(start), err)
+		return nil, err
+	}
+	var rpcErr error
+	if resp.Error != nil {
+		rpcErr = fmt.Errorf("mcp error %d: %s", resp.Error.Code, resp.Error.Message)
+		span.RecordError(rpcErr)
+	}
+	c.opts.Metrics.observeMCPRequest(req.ServerName, method, time.Since(start), rpcErr)
+
+	var respData map[string]any
+	if err := remarshal(resp, &respData); err != nil {
+		return nil, fmt.Errorf("encode mcp response: %w", err)
+	}
+	// HandleMCPMessageが返すエラーレスポンスにもjsonrpcを付与する
+	respData["jsonrpc"] = "2.0"
+
+	return &protocol.MCPMessageResponse{MCPResponse: respData}, nil
+}
+
+// convertMCPServerConfig はclaude.MCPServerConfigをmcp.ServerConfigに変換する
+func convertMCPServerConfig(config MCPServerConfig) *mcp.ServerConfig {
+	transportType := mcp.TransportType(config.Type)
+	if transportType == "" {
+		// Typeが省略された場合はstdioとして扱う
+		transportType = mcp.TransportStdio
+	}
+
+	return &mcp.ServerConfig{
+		Type:    transportType,
+		Command: config.Command,
+		Args:    config.Args,
+		URL:     config.URL,
+		Headers: config.Headers,
+		Env:     config.Env,
+	}
+}
+
+// remarshal はJSONを経由してsrcをdstに変換する
+func remarshal(src any, dst any) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
@@ -0,0 +1,137 @@
+package claude
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/mcp"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+func newCalcServer() *mcp.SDKMCPServer {
+	server := mcp.NewSDKMCPServer("calc", "1.0.0")
+	server.AddTool(mcp.Tool{
+		Name:        "add",
+		Description: "Add two numbers",
+		Handler: func(args map[string]any) (*mcp.ToolResult, error) {
+			a, _ := args["a"].(float64)
+			b, _ := args["b"].(float64)
+			return &mcp.ToolResult{
+				Content: []mcp.ContentBlock{{Type: "text", Text: strconv.FormatFloat(a+b, 'f', -1, 64)}},
+			}, nil
+		},
+	})
+	return server
+}
+
+func TestClient_RegisterMCPServers(t *testing.T) {
+	client := NewClient(&Options{
+		MCPServers: map[string]MCPServerConfig{
+			"external": {Command: "mcp-server", Args: []string{"--verbose"}},
+			"remote":   {Type: "http", URL: "http://localhost:8080"},
+		},
+		SDKMCPServers: map[string]*mcp.SDKMCPServer{
+			"calc": newCalcServer(),
+		},
+	})
+
+	config := client.MCPManager().BuildCLIConfig()
+	if len(config) != 3 {
+		t.Fatalf("len(config) = %d, want 3", len(config))
+	}
+
+	ext := config["external"].(map[string]any)
+	if ext["type"] != "stdio" {
+		t.Errorf("external type = %v, want stdio", ext["type"])
+	}
+	if ext["command"] != "mcp-server" {
+		t.Errorf("external command = %v, want mcp-server", ext["command"])
+	}
+
+	remote := config["remote"].(map[string]any)
+	if remote["url"] != "http://localhost:8080" {
+		t.Errorf("remote url = %v, want http://localhost:8080", remote["url"])
+	}
+
+	sdk := config["calc"].(map[string]any)
+	if sdk["type"] != "sdk" {
+		t.Errorf("sdk type = %v, want sdk", sdk["type"])
+	}
+}
+
+func TestClient_HandleMCPMessage_ToolsCall(t *testing.T) {
+	client := NewClient(&Options{
+		SDKMCPServers: map[string]*mcp.SDKMCPServer{
+			"calc": newCalcServer(),
+		},
+	})
+
+	resp, err := client.handleMCPMessage(context.Background(), &protocol.MCPMessageRequest{
+		ServerName: "calc",
+		Message: map[string]any{
+			"jsonrpc": "2.0",
+			"id":      float64(7),
+			"method":  "tools/call",
+			"params": map[string]any{
+				"name":      "add",
+				"arguments": map[string]any{"a": float64(2), "b": float64(3)},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("handleMCPMessage failed: %v", err)
+	}
+
+	if resp.MCPResponse["jsonrpc"] != "2.0" {
+		t.Errorf("jsonrpc = %v, want 2.0", resp.MCPResponse["jsonrpc"])
+	}
+	if resp.MCPResponse["id"] != float64(7) {
+		t.Errorf("id = %v, want 7", resp.MCPResponse["id"])
+	}
+
+	result, ok := resp.MCPResponse["result"].(map[string]any)
+	if !ok {
+		t.Fatalf("result should be map, got %v", resp.MCPResponse)
+	}
+	content := result["content"].([]any)
+	block := content[0].(map[string]any)
+	if block["text"] != "5" {
+		t.Errorf("text = %v, want 5", block["text"])
+	}
+}
+
+func TestClient_HandleMCPMessage_UnknownServer(t *testing.T) {
+	client := NewClient(nil)
+
+	resp, err := client.handleMCPMessage(context.Background(), &protocol.MCPMessageRequest{
+		ServerName: "unknown",
+		Message: map[string]any{
+			"jsonrpc": "2.0",
+			"id":      float64(1),
+			"method":  "tools/list",
+		},
+	})
+	if err != nil {
+		t.Fatalf("handleMCPMessage failed: %v", err)
+	}
+
+	if _, ok := resp.MCPResponse["error"]; !ok {
+		t.Errorf("expected JSON-RPC error, got %v", resp.MCPResponse)
+	}
+	if resp.MCPResponse["jsonrpc"] != "2.0" {
+		t.Errorf("jsonrpc = %v, want 2.0", resp.MCPResponse["jsonrpc"])
+	}
+}
+
+func TestConvertMCPServerConfig_DefaultType(t *testing.T) {
+	config := convertMCPServerConfig(MCPServerConfig{Command: "node"})
+	if config.Type != mcp.TransportStdio {
+		t.Errorf("Type = %q, want %q", config.Type, mcp.TransportStdio)
+	}
+
+	config = convertMCPServerConfig(MCPServerConfig{Type: "sse", URL: "http://example.com"})
+	if config.Type != mcp.TransportSSE {
+		t.Errorf("Type = %q, want %q", config.Type, mcp.TransportSSE)
+	}
+}
@@ -0,0 +1,282 @@
+package claude
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/hooks"
+	"github.com/y-oga-819/my-go-claude-agent/internal/metrics"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// turnDurationBuckets はターンの所要時間のヒストグラムのバケット（秒）
+var turnDurationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// poolWaitBuckets はプールの待ち時間のヒストグラムのバケット（秒）
+var poolWaitBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// 権限確認（CanUseTool）の結果
+const (
+	permissionAllow = "allow"
+	permissionDeny  = "deny"
+	permissionError = "error"
+)
+
+// Metrics はSDKの稼働状況をPrometheusのテキスト形式で公開するメトリクス
+// Options.Metricsに設定すると、複数のClient・Queryで共有して集計できる
+type Metrics struct {
+	registry *metrics.Registry
+	// usage はモデルごとのトークン数とコストの集計（スクレイプ時に読み出す）
+	usage *UsageTracker
+
+	clients             *metrics.Gauge
+	turns               *metrics.Counter
+	turnDuration        *metrics.Histogram
+	toolCalls           *metrics.Counter
+	permissionDecisions *metrics.Counter
+	hookExecutions      *metrics.Counter
+	mcpRequestDuration  *metrics.Histogram
+	mcpRequestErrors    *metrics.Counter
+	controlTimeouts     *metrics.Counter
+	droppedMessages     *metrics.Counter
+	poolWait            *metrics.Histogram
+
+	mu    sync.Mutex
+	live  map[*Client]struct{}
+	pools map[*Pool]struct{}
+}
+
+// NewMetrics は新しいMetricsを作成する
+func NewMetrics() *Metrics {
+	r := metrics.NewRegistry()
+	m := &Metrics{
+		registry: r,
+		usage:    NewUsageTracker(),
+		live:     make(map[*Client]struct{}),
+		pools:    make(map[*Pool]struct{}),
+	}
+
+	m.clients = r.NewGauge("claude_clients",
+		"Number of connected Clients.")
+	m.turns = r.NewCounter("claude_turns_total",
+		"Completed turns by result subtype.", "result")
+	m.turnDuration = r.NewHistogram("claude_turn_duration_seconds",
+		"Turn latency reported by the CLI result message.", turnDurationBuckets)
+	r.NewFunc("claude_tokens_total",
+		"Tokens used by model and token type.", metrics.TypeCounter, []string{"model", "type"}, m.collectTokens)
+	r.NewFunc("claude_cost_usd_total",
+		"Cost in USD by model.", metrics.TypeCounter, []string{"model"}, m.collectCost)
+	m.toolCalls = r.NewCounter("claude_tool_calls_total",
+		"Tool calls requested by the model, by tool name.", "tool")
+	m.permissionDecisions = r.NewCounter("claude_tool_permission_decisions_total",
+		"CanUseTool decisions by tool name and outcome (allow, deny, error).", "tool", "outcome")
+	m.hookExecutions = r.NewCounter("claude_hook_executions_total",
+		"Command hook executions by event and exit code (-1 if the command could not run).", "event", "exit_code")
+	m.mcpRequestDuration = r.NewHistogram("claude_mcp_request_duration_seconds",
+		"MCP request latency by server and method.", nil, "server", "method")
+	m.mcpRequestErrors = r.NewCounter("claude_mcp_request_errors_total",
+		"Failed MCP requests by server and method.", "server", "method")
+	m.controlTimeouts = r.NewCounter("claude_control_timeouts_total",
+		"Control requests sent to the CLI that timed out, by subtype.", "subtype")
+	m.droppedMessages = r.NewCounter("claude_dropped_messages_total",
+		"Messages dropped because the message buffer was full, by message type.", "type")
+	r.NewFunc("claude_pool_clients",
+		"Clients held by Pools, by state (active, idle).", metrics.TypeGauge, []string{"state"}, m.collectPoolClients)
+	r.NewFunc("claude_pool_queue_depth",
+		"Requests waiting to acquire a Client from Pools.", metrics.TypeGauge, nil, m.collectPoolQueue)
+	m.poolWait = r.NewHistogram("claude_pool_wait_seconds",
+		"Time spent waiting to acquire a Client from Pools.", poolWaitBuckets)
+
+	return m
+}
+
+// Handler はメトリクスをPrometheusのテキスト形式で返すhttp.Handlerを返す
+func (m *Metrics) Handler() http.Handler {
+	return m.registry.Handler()
+}
+
+// Write はメトリクスをPrometheusのテキスト形式でwに書き出す
+func (m *Metrics) Write(w io.Writer) error {
+	return m.registry.Write(w)
+}
+
+// collectTokens はモデルごとのトークン数を収集する
+func (m *Metrics) collectTokens(emit func(float64, ...string)) {
+	for model, u := range m.usage.Snapshot().ByModel {
+		emit(float64(u.InputTokens), model, "input")
+		emit(float64(u.OutputTokens), model, "output")
+		emit(float64(u.CacheCreationTokens), model, "cache_creation")
+		emit(float64(u.CacheReadTokens), model, "cache_read")
+	}
+}
+
+// collectCost はモデルごとのコストを収集する
+// resultにモデルごとの内訳（modelUsage）がないコストはmodel="unknown"に計上する
+func (m *Metrics) collectCost(emit func(float64, ...string)) {
+	snapshot := m.usage.Snapshot()
+	var attributed float64
+	for model, u := range snapshot.ByModel {
+		emit(u.CostUSD, model)
+		attributed += u.CostUSD
+	}
+	if rest := snapshot.Total.CostUSD - attributed; rest > 1e-9 {
+		emit(rest, "unknown")
+	}
+}
+
+// poolStats は登録中の全プールの状態を返す
+func (m *Metrics) poolStats() []PoolStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]PoolStats, 0, len(m.pools))
+	for p := range m.pools {
+		stats = append(stats, p.Stats())
+	}
+	return stats
+}
+
+// collectPoolClients はプールの貸し出し中とアイドルのClient数を収集する
+func (m *Metrics) collectPoolClients(emit func(float64, ...string)) {
+	var active, idle int
+	for _, s := range m.poolStats() {
+		active += s.Active
+		idle += s.Idle
+	}
+	emit(float64(active), "active")
+	emit(float64(idle), "idle")
+}
+
+// collectPoolQueue はプールの待ち数を収集する
+func (m *Metrics) collectPoolQueue(emit func(float64, ...string)) {
+	var depth int
+	for _, s := range m.poolStats() {
+		depth += s.QueueDepth
+	}
+	emit(float64(depth))
+}
+
+// 以下の記録用メソッドはMetricsがnilの場合は何もしない
+
+// attach は接続済みのClientを登録する
+func (m *Metrics) attach(c *Client) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.live[c] = struct{}{}
+	m.clients.Set(float64(len(m.live)))
+}
+
+// detach はClientの登録を解除する
+func (m *Metrics) detach(c *Client) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.live, c)
+	m.clients.Set(float64(len(m.live)))
+}
+
+// observe は受信メッセージからターン・トークン・コスト・ツール呼び出しを集計する
+func (m *Metrics) observe(source uint64, msg protocol.Message) {
+	if m == nil {
+		return
+	}
+	m.usage.track(source, msg)
+
+	switch msg := msg.(type) {
+	case *protocol.AssistantMessage:
+		for _, block := range msg.ToolUses() {
+			m.toolCalls.Inc(block.Name)
+		}
+	case *protocol.ResultMessage:
+		m.turns.Inc(msg.Subtype)
+		m.turnDuration.Observe(float64(msg.DurationMs) / 1000)
+	}
+}
+
+// observePermission はCanUseToolの結果を記録する
+func (m *Metrics) observePermission(tool, outcome string) {
+	if m == nil {
+		return
+	}
+	m.permissionDecisions.Inc(tool, outcome)
+}
+
+// observeHookCommand はコマンドフックの実行結果を記録する
+func (m *Metrics) observeHookCommand(event string, exitCode int) {
+	if m == nil {
+		return
+	}
+	m.hookExecutions.Inc(event, strconv.Itoa(exitCode))
+}
+
+// observeMCPRequest はMCPリクエストの所要時間とエラーを記録する
+func (m *Metrics) observeMCPRequest(server, method string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mcpRequestDuration.Observe(elapsed.Seconds(), server, method)
+	if err != nil {
+		m.mcpRequestErrors.Inc(server, method)
+	}
+}
+
+// observeControlTimeout は制御リクエストのタイムアウトを記録する
+func (m *Metrics) observeControlTimeout(subtype string) {
+	if m == nil {
+		return
+	}
+	m.controlTimeouts.Inc(subtype)
+}
+
+// observeDrop はバッファ満杯によるメッセージの破棄を記録する
+func (m *Metrics) observeDrop(msg protocol.Message) {
+	if m == nil {
+		return
+	}
+	m.droppedMessages.Inc(msg.MessageType())
+}
+
+// attachPool はプールを登録する
+func (m *Metrics) attachPool(p *Pool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pools[p] = struct{}{}
+}
+
+// detachPool はプールの登録を解除する
+func (m *Metrics) detachPool(p *Pool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pools, p)
+}
+
+// observePoolWait はプールからの取得までの待ち時間を記録する
+func (m *Metrics) observePoolWait(wait time.Duration) {
+	if m == nil {
+		return
+	}
+	m.poolWait.Observe(wait.Seconds())
+}
+
+// observeHookCommand はコマンドフックの実行をトレースとメトリクスに記録する
+func (c *Client) observeHookCommand(ctx context.Context, command string, input *hooks.Input) (context.Context, func(int, error)) {
+	ctx, done := c.traces.observeHookCommand(ctx, command, input)
+	return ctx, func(exitCode int, err error) {
+		done(exitCode, err)
+		c.opts.Metrics.observeHookCommand(input.HookEventName, exitCode)
+	}
+}
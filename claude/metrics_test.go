@@ -0,0 +1,129 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+	"github.com/y-oga-819/my-go-claude-agent/internal/mcp"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// scrape はメトリクスをHTTPで取得する
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func assertSamples(t *testing.T, out string, samples ...string) {
+	t.Helper()
+	for _, sample := range samples {
+		if !strings.Contains(out, sample+"\n") {
+			t.Errorf("sample %q not found in:\n%s", sample, out)
+		}
+	}
+}
+
+func TestClient_Metrics(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionAssistant, Text: "checking", Usage: &fakecli.Usage{InputTokens: 12, OutputTokens: 3}},
+			{Action: fakecli.ActionToolUse, ToolUseID: "toolu_1", ToolName: "Bash", Input: map[string]any{"command": "ls"}},
+			{Action: fakecli.ActionCanUseTool, ToolName: "Bash", Input: map[string]any{"command": "ls"},
+				Expect: map[string]any{"allow": true}},
+			{Action: fakecli.ActionHookCallback, HookEvent: "PreToolUse", ToolName: "Bash", ToolUseID: "toolu_1",
+				Input: map[string]any{"command": "ls"}},
+			{Action: fakecli.ActionToolResult, ToolUseID: "toolu_1", Content: "file.txt"},
+			{Action: fakecli.ActionMCPMessage, ServerName: "calc", Message: map[string]any{
+				"jsonrpc": "2.0", "id": 1, "method": "tools/call",
+				"params": map[string]any{"name": "add", "arguments": map[string]any{"a": 1, "b": 2}},
+			}},
+			{Action: fakecli.ActionResult, Result: "done", CostUSD: 0.02},
+		},
+	})
+
+	m := NewMetrics()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := NewClient(&Options{
+		CLIPath: cliPath,
+		Metrics: m,
+		CanUseTool: func(context.Context, string, map[string]any, *ToolPermissionContext) (*PermissionResult, error) {
+			return &PermissionResult{Allow: true}, nil
+		},
+		Hooks: &HookConfig{
+			PreToolUse: []HookEntry{{Type: HookTypeCommand, Command: `echo '{"continue": true}'`}},
+		},
+		SDKMCPServers: map[string]*mcp.SDKMCPServer{"calc": newCalcServer()},
+	})
+	defer client.Close()
+
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	assertSamples(t, scrape(t, m), "claude_clients 1")
+
+	if err := stream.Send(ctx, "list files"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	for done := false; !done; {
+		select {
+		case msg := <-stream.Messages():
+			_, done = msg.(*protocol.ResultMessage)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for result (stderr: %s)", fakeStderr(client))
+		}
+	}
+	client.Close()
+
+	assertSamples(t, scrape(t, m),
+		"claude_clients 0",
+		`claude_turns_total{result="success"} 1`,
+		"claude_turn_duration_seconds_count 1",
+		`claude_tokens_total{model="fake-model",type="input"} 12`,
+		`claude_tokens_total{model="fake-model",type="output"} 3`,
+		`claude_cost_usd_total{model="unknown"} 0.02`,
+		`claude_tool_calls_total{tool="Bash"} 1`,
+		`claude_tool_permission_decisions_total{tool="Bash",outcome="allow"} 1`,
+		`claude_hook_executions_total{event="PreToolUse",exit_code="0"} 1`,
+		`claude_mcp_request_duration_seconds_count{server="calc",method="tools/call"} 1`,
+	)
+}
+
+func TestMetrics_ProtocolEvents(t *testing.T) {
+	m := NewMetrics()
+	var dropped []string
+	opts := &Options{
+		Metrics: m,
+		Buffer:  &BufferConfig{OnDrop: func(msg protocol.Message) { dropped = append(dropped, msg.MessageType()) }},
+	}
+
+	opts.deliveryConfig().OnDrop(&protocol.AssistantMessage{Type: "assistant"})
+	m.observeControlTimeout("interrupt")
+	m.observeMCPRequest("calc", "tools/call", time.Millisecond, io.EOF)
+
+	// 利用者のOnDropも引き続き呼ばれる
+	if len(dropped) != 1 {
+		t.Errorf("OnDrop called %d times, want 1", len(dropped))
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	assertSamples(t, buf.String(),
+		`claude_dropped_messages_total{type="assistant"} 1`,
+		`claude_control_timeouts_total{subtype="interrupt"} 1`,
+		`claude_mcp_request_errors_total{server="calc",method="tools/call"} 1`,
+	)
+}
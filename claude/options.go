@@ -2,7 +2,10 @@ package claude
 
 import (
 	"context"
+	"log/slog"
 	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/mcp"
 )
 
 // Options はClaude SDKの設定を表す
@@ -20,8 +23,9 @@ type Options struct {
 	FallbackModel string
 
 	// 制限設定
-	MaxTurns     int
-	MaxBudgetUSD float64
+	MaxTurns          int
+	MaxBudgetUSD      float64
+	MaxThinkingTokens int // 思考トークンの上限
 
 	// 権限設定
 	PermissionMode  PermissionMode
@@ -34,8 +38,21 @@ type Options struct {
 	Continue                bool   // 直前のセッションを継続
 	EnableFileCheckpointing bool   // ファイルチェックポイントを有効化
 
+	// 分岐・継続したセッションの親子関係の記録先（nilの場合は記録しない）
+	Lineage *LineageStore
+
+	// ストリーミング設定
+	IncludePartialMessages bool // 部分メッセージ（StreamEvent）を受信する
+
+	// 出力設定
+	OutputFormat *OutputFormat // 構造化出力の形式（JSON Schema）
+
 	// MCP設定
-	MCPServers map[string]MCPServerConfig
+	MCPServers    map[string]MCPServerConfig
+	SDKMCPServers map[string]*mcp.SDKMCPServer // インプロセスMCPサーバー（Goで定義したツール）
+
+	// サブエージェント設定（名前 → 定義）
+	Agents map[string]AgentDefinition
 
 	// フック設定
 	Hooks *HookConfig
@@ -46,6 +63,38 @@ type Options struct {
 	// リトライ設定
 	Retry *RetryConfig
 
+	// 自動復旧設定（nilの場合は無効）
+	Recovery *RecoveryConfig
+
+	// バッファ設定（nilの場合はデフォルト）
+	Buffer *BufferConfig
+
+	// SDK側で強制する予算（複数のClientで共有できる。nilの場合は制限しない）
+	Budget *Budget
+
+	// 使用量・コストの集計先（複数のClientで共有できる。nilの場合はClientごとに集計する）
+	Usage *UsageTracker
+
+	// トレーサー（nilの場合はスパンを記録しない）
+	Tracer Tracer
+
+	// メトリクスの集計先（複数のClientで共有できる。nilの場合は集計しない）
+	Metrics *Metrics
+
+	// ログ設定（Loggerがnilの場合は出力しない）
+	Logger      *slog.Logger
+	LogPayloads bool // プロンプトやツール入力をマスクせずに出力する（デバッグ用）
+
+	// 初期化済みのCLIプロセスを待機させるプール（nilの場合はConnectごとに起動する）
+	// オプションがプールと異なる場合は使用しない
+	WarmPool *WarmPool
+
+	// 記録設定（nilの場合は記録しない）
+	Recording *RecordingConfig
+
+	// Transport はCLIサブプロセスの代わりに使用するTransportを作成する（テスト用、nilの場合はCLIを起動する）
+	Transport TransportFactory
+
 	// コールバック
 	CanUseTool CanUseToolFunc
 }
@@ -218,6 +267,7 @@ type HookInput struct {
 	TranscriptPath string
 	CWD            string
 	ToolName       string
+	ToolUseID      string
 	ToolInput      map[string]any
 	ToolOutput     map[string]any
 }
@@ -0,0 +1,325 @@
+package claude
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// otlpScopeName はOTLPの計装スコープ名
+const otlpScopeName = "github.com/y-oga-819/my-go-claude-agent/claude"
+
+// OTLPのSpanKind（INTERNAL）とStatusCode
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+// OTLPTracer は終了したスパンをOTLP/JSON形式で書き出すTracer
+// 1スパンごとに1行のExportTraceServiceRequestを追記するため、
+// OpenTelemetry CollectorのOTLP JSONファイルレシーバー等でネットワークを使わずに取り込める
+type OTLPTracer struct {
+	resource []otlpKeyValue
+
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	err    error
+}
+
+// NewOTLPTracer はwにスパンを書き出すOTLPTracerを作成する
+// serviceNameはリソース属性service.nameとして記録される
+func NewOTLPTracer(w io.Writer, serviceName string) *OTLPTracer {
+	return &OTLPTracer{
+		resource: otlpAttributes([]Attribute{Attr("service.name", serviceName)}),
+		w:        w,
+	}
+}
+
+// NewOTLPFileTracer はpathのファイルにスパンを追記するOTLPTracerを作成する
+// 使用後はCloseでファイルを閉じる
+func NewOTLPFileTracer(path string, serviceName string) (*OTLPTracer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open trace file: %w", err)
+	}
+	t := NewOTLPTracer(f, serviceName)
+	t.closer = f
+	return t, nil
+}
+
+// Err は最初に発生した書き込みエラーを返す
+func (t *OTLPTracer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Close はファイルを閉じる（NewOTLPTracerで作成した場合は何もしない）
+// Close後に終了したスパンは書き出されない
+func (t *OTLPTracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	closer := t.closer
+	t.closer = nil
+	t.w = nil
+	if closer == nil {
+		return nil
+	}
+	return closer.Close()
+}
+
+// otlpSpanKey はコンテキストに現在のスパンを格納するキー
+type otlpSpanKey struct{}
+
+// Start はスパンを開始する
+// ctxにOTLPTracerのスパンが含まれている場合はその子スパンとし、同じトレースIDを引き継ぐ
+func (t *OTLPTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	span := &otlpSpan{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+		attrs:  append([]Attribute(nil), attrs...),
+	}
+	if parent, ok := ctx.Value(otlpSpanKey{}).(*otlpSpan); ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+
+	return context.WithValue(ctx, otlpSpanKey{}, span), span
+}
+
+// export は終了したスパンを1行のJSONとして書き出す
+func (t *OTLPTracer) export(data otlpSpanData) {
+	req := otlpExportRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: t.resource},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: otlpScopeName},
+				Spans: []otlpSpanData{data},
+			}},
+		}},
+	}
+	line, err := json.Marshal(req)
+	if err != nil {
+		t.setErr(fmt.Errorf("marshal span: %w", err))
+		return
+	}
+	line = append(line, '\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.w == nil {
+		return
+	}
+	if _, err := t.w.Write(line); err != nil && t.err == nil {
+		t.err = fmt.Errorf("write span: %w", err)
+	}
+}
+
+func (t *OTLPTracer) setErr(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// otlpSpan はOTLPTracerのスパン
+type otlpSpan struct {
+	tracer   *OTLPTracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+
+	mu     sync.Mutex
+	attrs  []Attribute
+	events []otlpEvent
+	err    error
+	ended  bool
+}
+
+func (s *otlpSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// RecordError はエラーをexceptionイベントとして記録し、スパンのステータスをエラーにする
+func (s *otlpSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	s.events = append(s.events, otlpEvent{
+		TimeUnixNano: otlpTime(time.Now()),
+		Name:         "exception",
+		Attributes: otlpAttributes([]Attribute{
+			Attr("exception.type", fmt.Sprintf("%T", err)),
+			Attr("exception.message", err.Error()),
+		}),
+	})
+}
+
+// End はスパンを終了して書き出す（2回目以降の呼び出しは無視する）
+func (s *otlpSpan) End() {
+	end := time.Now()
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := otlpSpanData{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: otlpTime(s.start),
+		EndTimeUnixNano:   otlpTime(end),
+		Attributes:        otlpAttributes(s.attrs),
+		Events:            s.events,
+		Status:            otlpStatus{Code: otlpStatusOK},
+	}
+	if s.parentID != [8]byte{} {
+		data.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != nil {
+		data.Status = otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+	}
+	s.mu.Unlock()
+
+	s.tracer.export(data)
+}
+
+// OTLP/JSON（ExportTraceServiceRequest）の構造
+// IDは16進文字列、64ビット整数と時刻（ナノ秒）は10進文字列で表す
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope      `json:"scope"`
+	Spans []otlpSpanData `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpanData struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpAttributes は属性をOTLPのKeyValueに変換する（同じキーは後の値を優先する）
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	index := make(map[string]int, len(attrs))
+	var result []otlpKeyValue
+	for _, attr := range attrs {
+		kv := otlpKeyValue{Key: attr.Key, Value: otlpValue(attr.Value)}
+		if i, ok := index[attr.Key]; ok {
+			result[i] = kv
+			continue
+		}
+		index[attr.Key] = len(result)
+		result = append(result, kv)
+	}
+	return result
+}
+
+func otlpValue(v any) otlpAnyValue {
+	intValue := func(n int64) otlpAnyValue {
+		s := strconv.FormatInt(n, 10)
+		return otlpAnyValue{IntValue: &s}
+	}
+	doubleValue := func(f float64) otlpAnyValue {
+		return otlpAnyValue{DoubleValue: &f}
+	}
+
+	switch val := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &val}
+	case bool:
+		return otlpAnyValue{BoolValue: &val}
+	case int:
+		return intValue(int64(val))
+	case int32:
+		return intValue(int64(val))
+	case int64:
+		return intValue(val)
+	case uint32:
+		return intValue(int64(val))
+	case uint64:
+		return intValue(int64(val))
+	case float32:
+		return doubleValue(float64(val))
+	case float64:
+		return doubleValue(val)
+	case time.Duration:
+		return intValue(val.Milliseconds())
+	}
+	s := fmt.Sprint(v)
+	return otlpAnyValue{StringValue: &s}
+}
@@ -0,0 +1,583 @@
+package claude
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PoolConfig はClientプールの設定
+type PoolConfig struct {
+	MaxClients          int           // 同時に起動するCLIプロセスの上限（貸し出し中とアイドルの合計、デフォルト: 10）
+	MaxClientsPerTenant int           // テナントごとの上限（0の場合はMaxClientsのみ）
+	IdleTTL             time.Duration // アイドルのClientを閉じるまでの時間（デフォルト: 5分）
+}
+
+// DefaultPoolConfig はデフォルトのプール設定を返す
+func DefaultPoolConfig() *PoolConfig {
+	return &PoolConfig{
+		MaxClients: 10,
+		IdleTTL:    5 * time.Minute,
+	}
+}
+
+// withDefaults は未設定の項目をデフォルト値で補完した設定を返す
+func (c *PoolConfig) withDefaults() PoolConfig {
+	def := DefaultPoolConfig()
+	cfg := PoolConfig{}
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = def.MaxClients
+	}
+	if cfg.MaxClientsPerTenant <= 0 || cfg.MaxClientsPerTenant > cfg.MaxClients {
+		cfg.MaxClientsPerTenant = cfg.MaxClients
+	}
+	if cfg.IdleTTL <= 0 {
+		cfg.IdleTTL = def.IdleTTL
+	}
+	return cfg
+}
+
+// PoolStats はプールの状態
+type PoolStats struct {
+	Active             int            // 貸し出し中（起動中を含む）のClient数
+	Idle               int            // アイドルのClient数
+	QueueDepth         int            // 取得を待っているリクエスト数
+	QueueDepthByTenant map[string]int // テナントごとの待ち数
+	Acquired           uint64         // 取得に成功した回数
+	Reused             uint64         // アイドルのClientを再利用した回数
+	Evicted            uint64         // TTL切れまたは容量確保のために閉じたアイドルのClient数
+	TotalWait          time.Duration  // 取得までの待ち時間の合計
+	MaxWait            time.Duration  // 取得までの待ち時間の最大値
+}
+
+// AverageWait は取得までの平均待ち時間を返す
+func (s PoolStats) AverageWait() time.Duration {
+	if s.Acquired == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Acquired)
+}
+
+// Pool はCLIプロセス（Client）の数を全体とテナントごとに制限して貸し出す
+// 上限に達している場合、リクエストはテナントごとのキューで待ち、
+// 空きができるとテナント間でラウンドロビンに割り当てる（同じテナント内は先着順）
+// 返却されたClientはセッションIDごとにアイドルとして保持し、同じセッションの次のターンで再利用する
+type Pool struct {
+	opts   *Options
+	config PoolConfig
+
+	// ctx は貸し出すClientの受信ループの寿命（Closeでキャンセルする）
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	// idle はアイドルのClient（返却が古い順）
+	idle []*poolEntry
+	// active は貸し出し中（起動中を含む）のClient数
+	active int
+	// live はテナントごとの貸し出し中とアイドルのClient数
+	live map[string]int
+	// busy は貸し出し中のセッション（同じセッションのCLIプロセスを複数起動しないため）
+	busy map[poolKey]bool
+	// unbound はセッションIDを指定せずに貸し出し、セッションIDが未確定のClient
+	unbound map[*poolEntry]bool
+	// queues はテナントごとの待ちキュー、tenantsは待ちのあるテナントのラウンドロビン順
+	queues  map[string][]*poolWaiter
+	tenants []string
+	next    int
+	stats   PoolStats
+}
+
+// poolKey はテナントとセッションIDの組
+type poolKey struct {
+	tenant    string
+	sessionID string
+}
+
+// poolEntry はプールが管理するClient
+type poolEntry struct {
+	client    *Client
+	stream    *Stream
+	tenant    string
+	sessionID string
+	idleSince time.Time
+}
+
+// poolWaiter は取得を待っているリクエスト
+type poolWaiter struct {
+	tenant    string
+	sessionID string
+	// ready は割り当て結果（entryがnilの場合はClientの起動枠）
+	ready chan *poolEntry
+}
+
+// NewPool はoptsでClientを作成するプールを作成する
+// configがnilの場合はデフォルト設定を使用する
+func NewPool(opts *Options, config *PoolConfig) *Pool {
+	if opts == nil {
+		opts = &Options{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		opts:    opts,
+		config:  config.withDefaults(),
+		ctx:     ctx,
+		cancel:  cancel,
+		live:    make(map[string]int),
+		busy:    make(map[poolKey]bool),
+		unbound: make(map[*poolEntry]bool),
+		queues:  make(map[string][]*poolWaiter),
+	}
+	opts.Metrics.attachPool(p)
+	go p.evictLoop()
+	return p
+}
+
+// Acquire はtenantのClientを取得する
+// sessionIDを指定した場合は、そのセッションのアイドルのClientを再利用するか、セッションを再開するClientを起動する
+// 同じセッションのClientが貸し出し中の場合は返却を待つ
+// 上限に達している場合はctxがキャンセルされるまで待つ
+// 使用後はReleaseで返却するか、Discardで破棄する
+func (p *Pool) Acquire(ctx context.Context, tenant, sessionID string) (*PooledClient, error) {
+	start := time.Now()
+	key := poolKey{tenant: tenant, sessionID: sessionID}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, &SDKError{Op: "pool", Err: ErrPoolClosed}
+	}
+
+	// 同じテナントの待ちがある場合は後ろに並ぶ
+	var entry *poolEntry
+	granted := false
+	if len(p.queues[tenant]) == 0 {
+		entry, granted = p.grant(key)
+	}
+	if !granted {
+		w := &poolWaiter{tenant: tenant, sessionID: sessionID, ready: make(chan *poolEntry, 1)}
+		p.enqueue(w)
+		p.mu.Unlock()
+
+		select {
+		case entry = <-w.ready:
+		case <-ctx.Done():
+			p.cancelWait(w)
+			return nil, ctx.Err()
+		case <-p.ctx.Done():
+			p.cancelWait(w)
+			return nil, &SDKError{Op: "pool", Err: ErrPoolClosed}
+		}
+		p.mu.Lock()
+	}
+	wait := time.Since(start)
+	if entry != nil {
+		p.recordAcquire(wait, true)
+		p.mu.Unlock()
+		return &PooledClient{pool: p, entry: entry, key: key, reused: true, waitTime: wait}, nil
+	}
+	p.mu.Unlock()
+
+	// 起動枠を確保したのでClientを起動する
+	entry, err := p.connect(ctx, key)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.active--
+		p.removeLive(tenant)
+		delete(p.busy, key)
+		p.dispatch()
+		return nil, err
+	}
+	p.recordAcquire(wait, false)
+	if key.sessionID == "" {
+		p.unbound[entry] = true
+	}
+	return &PooledClient{pool: p, entry: entry, key: key, waitTime: wait}, nil
+}
+
+// connect は新しいClientを起動する
+// 接続はAcquireのctxとプールのどちらかが終了した時点で中断し、
+// CLIプロセスと受信ループはプールの寿命に合わせる
+func (p *Pool) connect(ctx context.Context, key poolKey) (*poolEntry, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(p.ctx, cancel)
+	defer stop()
+
+	opts := *p.opts
+	if key.sessionID != "" {
+		opts.Resume = key.sessionID
+		opts.ForkSession = false
+		opts.Continue = false
+	}
+
+	client := NewClient(&opts)
+	client.lifetime = p.ctx
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &poolEntry{client: client, stream: stream, tenant: key.tenant, sessionID: key.sessionID}, nil
+}
+
+// grant は割り当てを試みる（p.muを保持して呼ぶ）
+// アイドルのClientを再利用できる場合はそのentryを、Clientを起動できる場合はnilを返す
+func (p *Pool) grant(key poolKey) (*poolEntry, bool) {
+	if p.closed {
+		return nil, false
+	}
+	if key.sessionID != "" {
+		p.bindSessions()
+		if p.busy[key] {
+			return nil, false
+		}
+		if entry := p.takeIdle(key); entry != nil {
+			p.busy[key] = true
+			p.active++
+			return entry, true
+		}
+	}
+
+	if !p.reserve(key.tenant) {
+		return nil, false
+	}
+	if key.sessionID != "" {
+		p.busy[key] = true
+	}
+	return nil, true
+}
+
+// bindSessions は貸し出し中にセッションIDが確定したClientのセッションを貸し出し中にする（p.muを保持して呼ぶ）
+// 同じセッションを再開するCLIプロセスを別に起動しないため
+func (p *Pool) bindSessions() {
+	for entry := range p.unbound {
+		sessionID, err := entry.client.SessionID()
+		if err != nil {
+			continue
+		}
+		delete(p.unbound, entry)
+		key := poolKey{tenant: entry.tenant, sessionID: sessionID}
+		if !p.busy[key] {
+			p.busy[key] = true
+			entry.sessionID = sessionID
+		}
+	}
+}
+
+// reserve はClientの起動枠を確保する（p.muを保持して呼ぶ）
+// 上限に達している場合は、アイドルのClientを閉じて枠を空ける
+func (p *Pool) reserve(tenant string) bool {
+	tenantFull := p.live[tenant] >= p.config.MaxClientsPerTenant
+	globalFull := p.active+len(p.idle) >= p.config.MaxClients
+
+	if tenantFull || globalFull {
+		// テナントの上限に達している場合は同じテナントのアイドルを、それ以外は最も古いアイドルを閉じる
+		victim := -1
+		for i, entry := range p.idle {
+			if !tenantFull || entry.tenant == tenant {
+				victim = i
+				break
+			}
+		}
+		if victim < 0 {
+			return false
+		}
+		p.evict(victim)
+	}
+
+	p.active++
+	p.live[tenant]++
+	return true
+}
+
+// takeIdle はセッションのアイドルのClientを取り出す（p.muを保持して呼ぶ）
+func (p *Pool) takeIdle(key poolKey) *poolEntry {
+	for i, entry := range p.idle {
+		if entry.tenant == key.tenant && entry.sessionID == key.sessionID {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			return entry
+		}
+	}
+	return nil
+}
+
+// evict はi番目のアイドルのClientを閉じる（p.muを保持して呼ぶ）
+func (p *Pool) evict(i int) {
+	entry := p.idle[i]
+	p.idle = append(p.idle[:i], p.idle[i+1:]...)
+	p.removeLive(entry.tenant)
+	p.stats.Evicted++
+	go entry.client.Close()
+}
+
+func (p *Pool) removeLive(tenant string) {
+	p.live[tenant]--
+	if p.live[tenant] <= 0 {
+		delete(p.live, tenant)
+	}
+}
+
+// enqueue は待ちキューに追加する（p.muを保持して呼ぶ）
+func (p *Pool) enqueue(w *poolWaiter) {
+	if len(p.queues[w.tenant]) == 0 {
+		p.tenants = append(p.tenants, w.tenant)
+	}
+	p.queues[w.tenant] = append(p.queues[w.tenant], w)
+}
+
+// dequeue は待ちキューから取り除き、取り除いたかを返す（p.muを保持して呼ぶ）
+func (p *Pool) dequeue(w *poolWaiter) bool {
+	queue := p.queues[w.tenant]
+	for i, waiter := range queue {
+		if waiter != w {
+			continue
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		if len(queue) > 0 {
+			p.queues[w.tenant] = queue
+		} else {
+			delete(p.queues, w.tenant)
+			p.removeTenant(w.tenant)
+		}
+		return true
+	}
+	return false
+}
+
+// removeTenant はラウンドロビン順からテナントを取り除く（p.muを保持して呼ぶ）
+func (p *Pool) removeTenant(tenant string) {
+	for i, t := range p.tenants {
+		if t != tenant {
+			continue
+		}
+		p.tenants = append(p.tenants[:i], p.tenants[i+1:]...)
+		if i < p.next {
+			p.next--
+		}
+		if p.next >= len(p.tenants) {
+			p.next = 0
+		}
+		return
+	}
+}
+
+// dispatch は空きに応じて待ちのリクエストに割り当てる（p.muを保持して呼ぶ）
+// 前回割り当てたテナントの次から順に、各テナントの先頭のリクエストを確認する
+func (p *Pool) dispatch() {
+	for assigned := true; assigned && len(p.tenants) > 0; {
+		assigned = false
+		for i := 0; i < len(p.tenants); i++ {
+			idx := (p.next + i) % len(p.tenants)
+			w := p.queues[p.tenants[idx]][0]
+			entry, ok := p.grant(poolKey{tenant: w.tenant, sessionID: w.sessionID})
+			if !ok {
+				continue
+			}
+			// 次回は割り当てたテナントの次から確認する
+			p.next = idx + 1
+			p.dequeue(w)
+			w.ready <- entry
+			assigned = true
+			break
+		}
+	}
+}
+
+// cancelWait は待ちを取り消す
+// 既に割り当て済みの場合は割り当てを戻す
+func (p *Pool) cancelWait(w *poolWaiter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.dequeue(w) {
+		return
+	}
+
+	entry := <-w.ready
+	key := poolKey{tenant: w.tenant, sessionID: w.sessionID}
+	delete(p.busy, key)
+	p.active--
+	if entry != nil && !p.closed {
+		p.idle = append(p.idle, entry)
+	} else {
+		p.removeLive(w.tenant)
+		if entry != nil {
+			go entry.client.Close()
+		}
+	}
+	p.dispatch()
+}
+
+// release はClientを返却する
+func (p *Pool) release(pc *PooledClient, discard bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry := pc.entry
+	delete(p.busy, pc.key)
+	delete(p.unbound, entry)
+	if pc.key.sessionID == "" && entry.sessionID != "" {
+		// 貸し出し中に確定したセッション
+		delete(p.busy, poolKey{tenant: entry.tenant, sessionID: entry.sessionID})
+	}
+	p.active--
+
+	if discard || p.closed || !entry.client.isAlive() {
+		p.removeLive(entry.tenant)
+		go entry.client.Close()
+	} else {
+		// ターン中に確定したセッションIDで再利用できるようにする
+		if sessionID, err := entry.client.SessionID(); err == nil {
+			entry.sessionID = sessionID
+		}
+		entry.idleSince = time.Now()
+		p.idle = append(p.idle, entry)
+	}
+	p.dispatch()
+}
+
+// evictLoop はTTLを過ぎたアイドルのClientを定期的に閉じる
+func (p *Pool) evictLoop() {
+	interval := p.config.IdleTTL / 2
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.evictExpired(now)
+		}
+	}
+}
+
+// evictExpired はnow時点でTTLを過ぎたアイドルのClientを閉じる
+func (p *Pool) evictExpired(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := 0; i < len(p.idle); {
+		if now.Sub(p.idle[i].idleSince) >= p.config.IdleTTL {
+			p.evict(i)
+			continue
+		}
+		i++
+	}
+	p.dispatch()
+}
+
+// Stats はプールの状態を返す
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.stats
+	stats.Active = p.active
+	stats.Idle = len(p.idle)
+	stats.QueueDepthByTenant = make(map[string]int, len(p.queues))
+	for tenant, queue := range p.queues {
+		stats.QueueDepthByTenant[tenant] = len(queue)
+		stats.QueueDepth += len(queue)
+	}
+	return stats
+}
+
+// Close はアイドルのClientを閉じ、待ちのリクエストをErrPoolClosedで終了する
+// 貸し出し中のClientは返却時に閉じる
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	for _, entry := range idle {
+		p.removeLive(entry.tenant)
+	}
+	p.mu.Unlock()
+
+	p.cancel()
+	p.opts.Metrics.detachPool(p)
+	for _, entry := range idle {
+		entry.client.Close()
+	}
+	return nil
+}
+
+// recordAcquire は取得までの待ち時間を記録する（p.muを保持して呼ぶ）
+func (p *Pool) recordAcquire(wait time.Duration, reused bool) {
+	p.stats.Acquired++
+	if reused {
+		p.stats.Reused++
+	}
+	p.stats.TotalWait += wait
+	p.stats.MaxWait = max(p.stats.MaxWait, wait)
+	p.opts.Metrics.observePoolWait(wait)
+}
+
+// PooledClient はプールから貸し出されたClient
+type PooledClient struct {
+	pool     *Pool
+	entry    *poolEntry
+	key      poolKey
+	reused   bool
+	waitTime time.Duration
+	once     sync.Once
+}
+
+// Client は接続済みのClientを返す
+func (pc *PooledClient) Client() *Client {
+	return pc.entry.client
+}
+
+// Stream はClientのストリームを返す
+func (pc *PooledClient) Stream() *Stream {
+	return pc.entry.stream
+}
+
+// Tenant は取得したテナントを返す
+func (pc *PooledClient) Tenant() string {
+	return pc.entry.tenant
+}
+
+// Reused はアイドルのClientを再利用したかを返す
+func (pc *PooledClient) Reused() bool {
+	return pc.reused
+}
+
+// WaitTime は取得までの待ち時間を返す
+func (pc *PooledClient) WaitTime() time.Duration {
+	return pc.waitTime
+}
+
+// Release はClientをプールに返却する
+// 次のターンでは、Client().SessionID()で取得したセッションIDを指定すると同じClientを再利用できる
+// 2回目以降の呼び出しは何もしない
+func (pc *PooledClient) Release() {
+	pc.once.Do(func() { pc.pool.release(pc, false) })
+}
+
+// Discard はClientを閉じてプールの枠を空ける（エラーが発生した場合など）
+func (pc *PooledClient) Discard() {
+	pc.once.Do(func() { pc.pool.release(pc, true) })
+}
+
+// isAlive はClientが接続中で再利用できるかを返す
+func (c *Client) isAlive() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.closed && c.transport != nil && c.transport.IsConnected()
+}
@@ -0,0 +1,345 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// newFakePool は1ターンに応答するフェイクCLIでClientを起動するプールを作成する
+func newFakePool(t *testing.T, config *PoolConfig) *Pool {
+	t.Helper()
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionResult, Result: "done"},
+		},
+	})
+	pool := NewPool(&Options{CLIPath: cliPath}, config)
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func mustAcquire(t *testing.T, pool *Pool, tenant, sessionID string) *PooledClient {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pc, err := pool.Acquire(ctx, tenant, sessionID)
+	if err != nil {
+		t.Fatalf("Acquire(%q, %q) failed: %v", tenant, sessionID, err)
+	}
+	return pc
+}
+
+// acquireAsync はAcquireを別のゴルーチンで実行し、結果をチャネルで返す
+func acquireAsync(pool *Pool, tenant string) <-chan *PooledClient {
+	ch := make(chan *PooledClient, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		pc, err := pool.Acquire(ctx, tenant, "")
+		if err != nil {
+			close(ch)
+			return
+		}
+		ch <- pc
+	}()
+	return ch
+}
+
+// waitQueueDepth はtenantの待ち数がwantになるまで待つ
+func waitQueueDepth(t *testing.T, pool *Pool, tenant string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().QueueDepthByTenant[tenant] != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth of %q = %d, want %d", tenant, pool.Stats().QueueDepthByTenant[tenant], want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPool_ReuseBySessionID(t *testing.T) {
+	pool := newFakePool(t, nil)
+
+	pc := mustAcquire(t, pool, "acme", "")
+	if pc.Reused() {
+		t.Error("first Acquire should start a new client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := pc.Stream().Send(ctx, "hello"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	for done := false; !done; {
+		select {
+		case msg := <-pc.Stream().Messages():
+			_, done = msg.(*protocol.ResultMessage)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for result (stderr: %s)", fakeStderr(pc.Client()))
+		}
+	}
+	sessionID, err := pc.Client().SessionID()
+	if err != nil {
+		t.Fatalf("SessionID failed: %v", err)
+	}
+	client := pc.Client()
+	pc.Release()
+	pc.Release() // 2回目は何もしない
+
+	if stats := pool.Stats(); stats.Active != 0 || stats.Idle != 1 {
+		t.Errorf("stats after release = %+v", stats)
+	}
+
+	// 同じセッションのClientを再利用する
+	pc = mustAcquire(t, pool, "acme", sessionID)
+	defer pc.Release()
+	if !pc.Reused() || pc.Client() != client {
+		t.Error("expected the idle client of the session to be reused")
+	}
+	if stats := pool.Stats(); stats.Acquired != 2 || stats.Reused != 1 {
+		t.Errorf("Acquired = %d, Reused = %d", stats.Acquired, stats.Reused)
+	}
+}
+
+func TestPool_LimitsAndQueueing(t *testing.T) {
+	pool := newFakePool(t, &PoolConfig{MaxClients: 3, MaxClientsPerTenant: 1})
+
+	a := mustAcquire(t, pool, "a", "")
+	b := mustAcquire(t, pool, "b", "")
+
+	// テナントaは上限に達しているので待つ
+	waiting := acquireAsync(pool, "a")
+	waitQueueDepth(t, pool, "a", 1)
+
+	// 他のテナントは全体の上限まで取得できる
+	c := mustAcquire(t, pool, "c", "")
+	defer c.Release()
+	defer b.Release()
+
+	a.Release()
+	select {
+	case pc, ok := <-waiting:
+		if !ok {
+			t.Fatal("waiting Acquire failed")
+		}
+		defer pc.Release()
+		if pc.WaitTime() <= 0 {
+			t.Error("WaitTime should be recorded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("waiting Acquire was not granted after Release")
+	}
+
+	stats := pool.Stats()
+	if stats.Active != 3 || stats.QueueDepth != 0 || stats.MaxWait <= 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestPool_AcquireCanceled(t *testing.T) {
+	pool := newFakePool(t, &PoolConfig{MaxClients: 1})
+
+	pc := mustAcquire(t, pool, "a", "")
+	defer pc.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Acquire(ctx, "a", ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire error = %v, want DeadlineExceeded", err)
+	}
+	if stats := pool.Stats(); stats.QueueDepth != 0 {
+		t.Errorf("QueueDepth = %d, want 0", stats.QueueDepth)
+	}
+}
+
+func TestPool_AcquireCanceledDuringConnect(t *testing.T) {
+	// initializeに応答しないCLI
+	cliPath := writeMockCLI(t, "#!/bin/sh\ncat > /dev/null\n")
+	pool := NewPool(&Options{CLIPath: cliPath}, nil)
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := pool.Acquire(ctx, "a", ""); err == nil {
+		t.Fatal("Acquire should fail when ctx is done during connect")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Acquire returned after %v, want it to honor ctx", elapsed)
+	}
+	if stats := pool.Stats(); stats.Active != 0 {
+		t.Errorf("Active = %d, want 0", stats.Active)
+	}
+}
+
+func TestPool_SessionLearnedDuringLeaseIsBusy(t *testing.T) {
+	pool := newFakePool(t, nil)
+
+	pc := mustAcquire(t, pool, "acme", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := pc.Stream().Send(ctx, "hello"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	for done := false; !done; {
+		select {
+		case msg := <-pc.Stream().Messages():
+			_, done = msg.(*protocol.ResultMessage)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for result (stderr: %s)", fakeStderr(pc.Client()))
+		}
+	}
+	sessionID, err := pc.Client().SessionID()
+	if err != nil {
+		t.Fatalf("SessionID failed: %v", err)
+	}
+
+	// 貸し出し中のセッションは返却されるまで待つ（再開するCLIを別に起動しない）
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer waitCancel()
+	if _, err := pool.Acquire(waitCtx, "acme", sessionID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire error = %v, want DeadlineExceeded", err)
+	}
+	if stats := pool.Stats(); stats.Active != 1 {
+		t.Errorf("Active = %d, want 1", stats.Active)
+	}
+
+	client := pc.Client()
+	pc.Release()
+	pc = mustAcquire(t, pool, "acme", sessionID)
+	defer pc.Release()
+	if !pc.Reused() || pc.Client() != client {
+		t.Error("expected the released client of the session to be reused")
+	}
+}
+
+func TestPool_FairQueueing(t *testing.T) {
+	pool := &Pool{
+		config: PoolConfig{MaxClients: 1, MaxClientsPerTenant: 1},
+		opts:   &Options{},
+		live:   make(map[string]int),
+		busy:   make(map[poolKey]bool),
+		queues: make(map[string][]*poolWaiter),
+	}
+	pool.active = 1
+
+	// テナントaが3件、テナントbが1件待っている
+	var waiters []*poolWaiter
+	for _, tenant := range []string{"a", "a", "a", "b"} {
+		w := &poolWaiter{tenant: tenant, ready: make(chan *poolEntry, 1)}
+		pool.enqueue(w)
+		waiters = append(waiters, w)
+	}
+
+	// 枠が1つずつ空くたびに、テナント間で交互に割り当てる
+	var order []string
+	for range waiters {
+		pool.active--
+		pool.live = map[string]int{}
+		pool.dispatch()
+		for _, w := range waiters {
+			select {
+			case <-w.ready:
+				order = append(order, w.tenant)
+			default:
+			}
+		}
+	}
+	want := []string{"a", "b", "a", "a"}
+	if len(order) != len(want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestPool_EvictExpired(t *testing.T) {
+	pool := newFakePool(t, &PoolConfig{IdleTTL: time.Hour})
+
+	pc := mustAcquire(t, pool, "a", "")
+	pc.Release()
+
+	pool.evictExpired(time.Now())
+	if stats := pool.Stats(); stats.Idle != 1 {
+		t.Fatalf("Idle = %d before TTL, want 1", stats.Idle)
+	}
+	pool.evictExpired(time.Now().Add(time.Hour))
+	if stats := pool.Stats(); stats.Idle != 0 || stats.Evicted != 1 {
+		t.Errorf("stats after TTL = %+v", stats)
+	}
+}
+
+func TestPool_EvictIdleForCapacity(t *testing.T) {
+	pool := newFakePool(t, &PoolConfig{MaxClients: 1})
+
+	a := mustAcquire(t, pool, "a", "")
+	a.Release()
+
+	// アイドルのClientを閉じて別のテナントに枠を空ける
+	b := mustAcquire(t, pool, "b", "")
+	defer b.Release()
+	if b.Reused() {
+		t.Error("a client of another tenant must not be reused")
+	}
+	if stats := pool.Stats(); stats.Idle != 0 || stats.Evicted != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestPool_Close(t *testing.T) {
+	pool := newFakePool(t, &PoolConfig{MaxClients: 1})
+
+	pc := mustAcquire(t, pool, "a", "")
+	waiting := acquireAsync(pool, "a")
+	waitQueueDepth(t, pool, "a", 1)
+
+	pool.Close()
+	if _, ok := <-waiting; ok {
+		t.Error("waiting Acquire should fail after Close")
+	}
+	if _, err := pool.Acquire(context.Background(), "a", ""); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Acquire error = %v, want ErrPoolClosed", err)
+	}
+
+	// 貸し出し中のClientは返却時に閉じる
+	client := pc.Client()
+	pc.Release()
+	deadline := time.Now().Add(5 * time.Second)
+	for client.isAlive() {
+		if time.Now().After(deadline) {
+			t.Fatal("client released after Close should be closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPool_Metrics(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{{Action: fakecli.ActionWaitUser}},
+	})
+	m := NewMetrics()
+	pool := NewPool(&Options{CLIPath: cliPath, Metrics: m}, nil)
+	defer pool.Close()
+
+	pc := mustAcquire(t, pool, "a", "")
+	assertSamples(t, scrape(t, m),
+		`claude_pool_clients{state="active"} 1`,
+		`claude_pool_clients{state="idle"} 0`,
+		"claude_pool_wait_seconds_count 1",
+	)
+	pc.Release()
+	assertSamples(t, scrape(t, m),
+		`claude_pool_clients{state="active"} 0`,
+		`claude_pool_clients{state="idle"} 1`,
+		"claude_pool_queue_depth 0",
+	)
+}
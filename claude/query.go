@@ -2,6 +2,8 @@ package claude
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -18,33 +20,135 @@ type QueryResult struct {
 	Usage     protocol.Usage
 }
 
+// Subagents はMessagesをサブエージェントごとに集計して返す
+func (r *QueryResult) Subagents() []SubagentActivity {
+	tracker := NewSubagentTracker()
+	for _, msg := range r.Messages {
+		tracker.Track(msg)
+	}
+	return tracker.Activities()
+}
+
 // Query は単一のプロンプトを送信し、結果を返す
 func Query(ctx context.Context, prompt string, opts *Options) (*QueryResult, error) {
 	if opts == nil {
 		opts = &Options{}
 	}
 
+	if err := validateAgents(opts.Agents); err != nil {
+		return nil, &SDKError{Op: "query", Err: ErrInvalidConfig, Details: err.Error()}
+	}
+
+	if opts.usesControlProtocol() {
+		return runControlQuery(ctx, TextContent(prompt), opts)
+	}
+	return runQuery(ctx, TextContent(prompt), opts)
+}
+
+// QueryContent は画像やドキュメントを含むプロンプトを送信し、結果を返す
+// 添付ファイルがある場合はstream-json入力でCLIにメッセージを渡す
+func QueryContent(ctx context.Context, content *Content, opts *Options) (*QueryResult, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if content == nil || len(content.Blocks) == 0 {
+		return nil, &SDKError{Op: "query", Err: ErrInvalidContent, Details: "content is empty"}
+	}
+
+	if err := validateAgents(opts.Agents); err != nil {
+		return nil, &SDKError{Op: "query", Err: ErrInvalidConfig, Details: err.Error()}
+	}
+
+	if opts.usesControlProtocol() {
+		return runControlQuery(ctx, content, opts)
+	}
+	return runQuery(ctx, content, opts)
+}
+
+func runQuery(ctx context.Context, content *Content, opts *Options) (_ *QueryResult, err error) {
+	streaming := content.HasAttachments()
+
 	// Transport設定
 	config := transport.Config{
 		CLIPath:       opts.CLIPath,
 		CWD:           opts.CWD,
-		StreamingMode: false, // ワンショットモード
-		Args:          buildQueryArgs(prompt, opts),
+		StreamingMode: streaming, // 添付ファイルがある場合のみstream-json入力
+		Logger:        opts.logger(),
+	}
+	if streaming {
+		config.Args = buildStreamQueryArgs(opts)
+	} else {
+		config.Args = buildQueryArgs(content.Text(), opts)
+	}
+
+	if opts.Budget != nil {
+		if err := opts.Budget.beforeTurn(); err != nil {
+			return nil, err
+		}
+	}
+
+	recorder, err := opts.Recording.newRecorder()
+	if err != nil {
+		return nil, &SDKError{Op: "connect", Err: ErrInvalidConfig, Details: err.Error()}
+	}
+	if recorder != nil {
+		defer recorder.Close()
 	}
 
-	t := transport.NewSubprocessTransport(config)
+	// CLIが新しいトランスクリプトを書き始める前に親セッションを特定する
+	lineage := newPendingLineage(opts)
+
+	t := opts.newTransport(config, recorder)
+
+	traces := newTurnTracer(opts.tracer())
+	// resultを受信せずに終了した場合は未完了のスパンをエラーで終了する
+	defer func() { traces.endAll(err) }()
 
 	// 接続
-	if err := t.Connect(ctx); err != nil {
+	connectCtx, span := traces.tracer.Start(ctx, SpanConnect, Attr(AttrModel, opts.Model))
+	err = t.Connect(connectCtx)
+	endSpan(span, err)
+	if err != nil {
 		return nil, &SDKError{Op: "connect", Err: ErrCLIConnection, Details: err.Error()}
 	}
 	defer t.Close()
 
+	// ワンショットモードではプロセスの起動時にプロンプトを渡すため、接続後からresultまでをターンとする
+	traces.startTurn(ctx)
+
+	// stream-json入力の場合はユーザーメッセージを書き込む
+	if streaming {
+		data, err := json.Marshal(protocol.UserMessage{
+			Type: "user",
+			Message: protocol.UserContent{
+				Role:    "user",
+				Content: content.messageContent(),
+			},
+		})
+		if err != nil {
+			return nil, &SDKError{Op: "send", Err: err}
+		}
+		if err := t.Write(data); err != nil {
+			return nil, &SDKError{Op: "send", Err: ErrCLIConnection, Details: err.Error()}
+		}
+	}
+
 	// stdinをクローズしてプロンプト送信完了を通知
 	if err := t.EndInput(); err != nil {
 		return nil, &SDKError{Op: "end_input", Err: err}
 	}
 
+	usageSource := nextUsageSource()
+	defer func() {
+		opts.Usage.release(usageSource)
+		if opts.Budget != nil {
+			opts.Budget.release(usageSource)
+		}
+		if opts.Metrics != nil {
+			opts.Metrics.usage.release(usageSource)
+		}
+	}()
+
 	// メッセージ収集
 	result := &QueryResult{
 		Messages: make([]protocol.Message, 0),
@@ -55,6 +159,10 @@ func Query(ctx context.Context, prompt string, opts *Options) (*QueryResult, err
 		case <-ctx.Done():
 			return nil, ctx.Err()
 
+		case <-opts.Budget.exceededChan():
+			// 予算超過時はCLIプロセスを終了する（deferでClose）
+			return result, opts.Budget.Err()
+
 		case err := <-t.Errors():
 			if err != nil {
 				return nil, &SDKError{Op: "receive", Err: err}
@@ -73,29 +181,132 @@ func Query(ctx context.Context, prompt string, opts *Options) (*QueryResult, err
 			if err != nil {
 				return nil, &SDKError{Op: "parse", Err: ErrMessageParse, Details: err.Error()}
 			}
+			traces.observe(msg)
+			if opts.Usage != nil {
+				opts.Usage.track(usageSource, msg)
+			}
+			if opts.Budget != nil {
+				opts.Budget.observe(usageSource, msg)
+			}
+			opts.Metrics.observe(usageSource, msg)
 
-			switch m := msg.(type) {
-			case *protocol.AssistantMessage:
-				result.Messages = append(result.Messages, m)
+			if !result.add(msg) {
+				continue
+			}
+
+			if err := lineage.record(result.SessionID); err != nil {
+				return result, err
+			}
+			// エラーチェック
+			if result.Result.IsError {
+				return result, resultMessageToError(result.Result)
+			}
+			// 結果を受け取ったら終了
+			return result, nil
+		}
+	}
+}
 
-			case *protocol.SystemMessage:
-				result.Messages = append(result.Messages, m)
+// add はメッセージを結果に追加し、resultメッセージを受け取った場合はtrueを返す
+func (r *QueryResult) add(msg protocol.Message) bool {
+	switch m := msg.(type) {
+	case *protocol.AssistantMessage:
+		r.Messages = append(r.Messages, m)
 
-			case *protocol.ResultMessage:
-				result.Result = m
-				result.SessionID = m.SessionID
-				result.TotalCost = m.TotalCostUSD
-				result.Usage = m.Usage
+	case *protocol.UserMessage:
+		// ツール結果やサブエージェントのメッセージ
+		r.Messages = append(r.Messages, m)
 
-				// エラーチェック
-				if m.IsError {
-					err := resultMessageToError(m)
-					return result, err
-				}
-				// 結果を受け取ったら終了
-				return result, nil
+	case *protocol.SystemMessage:
+		r.Messages = append(r.Messages, m)
+
+	case *protocol.StreamEvent:
+		r.Messages = append(r.Messages, m)
+
+	case *protocol.ResultMessage:
+		r.Result = m
+		r.SessionID = m.SessionID
+		r.TotalCost = m.TotalCostUSD
+		r.Usage = m.Usage
+		return true
+	}
+	return false
+}
+
+// usesControlProtocol はCLIからの制御リクエストで呼び出すコールバック
+// （フック・CanUseTool・SDK MCPサーバー）が設定されているかを返す
+// ワンショットモードのCLIは制御リクエストを送信しないため、これらはClientと同じ双方向の制御プロトコルが必要になる
+func (o *Options) usesControlProtocol() bool {
+	return o.CanUseTool != nil || len(o.SDKMCPServers) > 0 || o.Hooks.hasEntries()
+}
+
+// hasEntries はフックが1つ以上設定されているかを返す
+func (h *HookConfig) hasEntries() bool {
+	return h != nil && (len(h.PreToolUse) > 0 ||
+		len(h.PostToolUse) > 0 ||
+		len(h.UserPromptSubmit) > 0 ||
+		len(h.Notification) > 0 ||
+		len(h.Stop) > 0 ||
+		len(h.SubagentStop) > 0 ||
+		len(h.PreCompact) > 0)
+}
+
+// runControlQuery はClientで1ターン送信し、resultまでのメッセージをQueryResultにまとめる
+// 使用量・予算・トレース・メトリクスの計測はClientが行う
+func runControlQuery(ctx context.Context, content *Content, opts *Options) (*QueryResult, error) {
+	// ワンショットと同様に、プロセスが終了した場合は再接続せずにエラーとする
+	clientOpts := *opts
+	clientOpts.Recovery = nil
+
+	client := NewClient(&clientOpts)
+	defer client.Close()
+
+	if _, err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+	if err := client.SendContent(ctx, content); err != nil {
+		return nil, err
+	}
+
+	result := &QueryResult{
+		Messages: make([]protocol.Message, 0),
+	}
+
+	for {
+		var msg protocol.Message
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case err := <-client.Errors():
+			// 予算超過などのSDKErrorはそれまでの結果と共に返す
+			// フックの実行エラーなどはワンショットと同様にターンを中断しない
+			var sdkErr *SDKError
+			if errors.As(err, &sdkErr) {
+				return result, err
+			}
+			client.logger.Warn("error during query", "error", err)
+			continue
+
+		case msg = <-client.Messages():
+
+		case <-client.exited:
+			// 終了前に受信したメッセージを処理してからエラーとする
+			select {
+			case msg = <-client.Messages():
+			default:
+				return nil, client.exitErr
 			}
 		}
+
+		if !result.add(msg) {
+			continue
+		}
+		// エラーチェック
+		if result.Result.IsError {
+			return result, resultMessageToError(result.Result)
+		}
+		return result, nil
 	}
 }
 
@@ -133,6 +344,21 @@ func resultMessageToError(m *protocol.ResultMessage) error {
 }
 
 func buildQueryArgs(prompt string, opts *Options) []string {
+	args := buildQueryOptionArgs(opts)
+
+	// プロンプト（ワンショットモード）- 最後に配置
+	args = append(args, "--print", "--", prompt)
+
+	return args
+}
+
+// buildStreamQueryArgs はstream-json入力でプロンプトを渡す場合の引数を構築する
+func buildStreamQueryArgs(opts *Options) []string {
+	return append(buildQueryOptionArgs(opts), "--print")
+}
+
+// buildQueryOptionArgs はOptionsからCLI引数を構築する（プロンプトを除く）
+func buildQueryOptionArgs(opts *Options) []string {
 	args := []string{}
 
 	// システムプロンプト
@@ -158,6 +384,9 @@ func buildQueryArgs(prompt string, opts *Options) []string {
 	if opts.MaxBudgetUSD > 0 {
 		args = append(args, "--max-budget-usd", fmt.Sprintf("%.2f", opts.MaxBudgetUSD))
 	}
+	if opts.MaxThinkingTokens > 0 {
+		args = append(args, "--max-thinking-tokens", fmt.Sprintf("%d", opts.MaxThinkingTokens))
+	}
 
 	// 権限設定
 	if opts.PermissionMode != "" {
@@ -170,6 +399,21 @@ func buildQueryArgs(prompt string, opts *Options) []string {
 		args = append(args, "--disallowedTools", strings.Join(opts.DisallowedTools, ","))
 	}
 
+	// ストリーミング設定
+	if opts.IncludePartialMessages {
+		args = append(args, "--include-partial-messages")
+	}
+
+	// サブエージェント設定
+	if agents := agentsArg(opts.Agents); agents != "" {
+		args = append(args, "--agents", agents)
+	}
+
+	// 出力設定
+	if schema := opts.OutputFormat.jsonSchema(); schema != "" {
+		args = append(args, "--json-schema", schema)
+	}
+
 	// セッション設定
 	if opts.Resume != "" {
 		args = append(args, "--resume", opts.Resume)
@@ -181,8 +425,5 @@ func buildQueryArgs(prompt string, opts *Options) []string {
 		args = append(args, "--continue")
 	}
 
-	// プロンプト（ワンショットモード）- 最後に配置
-	args = append(args, "--print", "--", prompt)
-
 	return args
 }
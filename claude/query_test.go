@@ -268,3 +268,34 @@ func TestBuildQueryArgs_SessionOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildQueryArgs_MaxThinkingTokens(t *testing.T) {
+	args := buildQueryArgs("test", &Options{MaxThinkingTokens: 4096})
+
+	found := false
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "--max-thinking-tokens" {
+			found = true
+			if args[i+1] != "4096" {
+				t.Errorf("--max-thinking-tokens = %q, want %q", args[i+1], "4096")
+			}
+		}
+	}
+	if !found {
+		t.Error("missing arg: --max-thinking-tokens")
+	}
+}
+
+func TestBuildQueryArgs_IncludePartialMessages(t *testing.T) {
+	args := buildQueryArgs("test", &Options{IncludePartialMessages: true})
+
+	found := false
+	for _, arg := range args {
+		if arg == "--include-partial-messages" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("missing arg: --include-partial-messages")
+	}
+}
@@ -0,0 +1,81 @@
+package claude
+
+import (
+	"io"
+	"os"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/transport"
+)
+
+// RecordingConfig はCLIとのやり取り（stdout/stdinの全行）を記録する設定
+// 記録はJSON Lines形式で、各行に方向・経過時間（単調時計）・セッションIDが付与される
+type RecordingConfig struct {
+	Path   string    // 記録ファイルのパス（既存のファイルは上書きする）
+	Writer io.Writer // 記録先（設定されている場合はPathより優先。Close時にクローズしない）
+}
+
+// newRecorder は設定からRecorderを作成する（記録が無効な場合はnil）
+func (c *RecordingConfig) newRecorder() (*transport.Recorder, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.Writer != nil {
+		return transport.NewRecorder(c.Writer), nil
+	}
+	if c.Path == "" {
+		return nil, nil
+	}
+	return transport.CreateRecorder(c.Path)
+}
+
+// wrapTransport は記録が有効な場合にTransportを記録用ラッパーで包む
+func wrapTransport(t transport.Transport, recorder *transport.Recorder) transport.Transport {
+	if recorder == nil {
+		return t
+	}
+	return transport.NewRecordingTransport(t, recorder)
+}
+
+// PrintTimeline は記録をタイムライン形式で出力する
+func PrintTimeline(w io.Writer, r io.Reader) error {
+	return transport.WriteTimeline(w, r)
+}
+
+// PrintTimelineFile は記録ファイルをタイムライン形式で出力する
+func PrintTimelineFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return transport.WriteTimeline(w, f)
+}
+
+// TransportFactory はCLIとの通信に使用するTransportを作成する
+// Options.Transportに設定すると、CLIサブプロセスの代わりに任意のTransport（記録の再生など）を使用できる
+type TransportFactory func(config transport.Config) transport.Transport
+
+// newTransport はOptionsに従ってTransportを作成する（記録が有効な場合はラッパーで包む）
+func (o *Options) newTransport(config transport.Config, recorder *transport.Recorder) transport.Transport {
+	var t transport.Transport
+	if o.Transport != nil {
+		t = o.Transport(config)
+	} else {
+		t = transport.NewSubprocessTransport(config)
+	}
+	return wrapTransport(t, recorder)
+}
+
+// ReplayFile は記録ファイルを再生するTransportFactoryを返す（テスト用）
+// 返されたReplayTransportのVerify()で、記録どおりにやり取りが行われたかを確認できる
+func ReplayFile(path string, matchers ...transport.Matcher) (TransportFactory, *transport.ReplayTransport, error) {
+	cfg := transport.ReplayConfig{}
+	if len(matchers) > 0 {
+		cfg.Matchers = append(transport.DefaultReplayMatchers(), matchers...)
+	}
+	replay, err := transport.LoadReplayTransport(path, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(transport.Config) transport.Transport { return replay }, replay, nil
+}
@@ -0,0 +1,200 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+	"github.com/y-oga-819/my-go-claude-agent/internal/transport"
+)
+
+func TestQuery_Recording(t *testing.T) {
+	cliPath := writeMockCLI(t, `#!/bin/sh
+read -r line
+echo '{"type":"system","subtype":"init","session_id":"rec-session","model":"claude-sonnet"}'
+echo '{"type":"assistant","message":{"role":"assistant","model":"claude-sonnet","content":[{"type":"text","text":"見ました"}]},"session_id":"rec-session"}'
+echo '{"type":"result","subtype":"success","is_error":false,"num_turns":1,"session_id":"rec-session","total_cost_usd":0.002,"usage":{"input_tokens":1,"output_tokens":1}}'
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	content, err := NewContentBuilder().Text("見て").ImageBytes(testPNG, "").Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	if _, err := QueryContent(ctx, content, &Options{
+		CLIPath:   cliPath,
+		Recording: &RecordingConfig{Path: path},
+	}); err != nil {
+		t.Fatalf("QueryContent failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := PrintTimelineFile(&out, path); err != nil {
+		t.Fatalf("PrintTimelineFile failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	// 送信したユーザーメッセージと受信した3行が記録される
+	var sends, recvs int
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "→"):
+			sends++
+		case strings.Contains(line, "←"):
+			recvs++
+		}
+	}
+	if sends != 1 || recvs != 3 {
+		t.Errorf("sends = %d, recvs = %d, want 1 and 3\n%s", sends, recvs, out.String())
+	}
+	if !strings.Contains(out.String(), "== session rec-session ==") {
+		t.Errorf("timeline should contain session header\n%s", out.String())
+	}
+}
+
+func TestClient_RecordingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := transport.NewRecorder(&buf)
+
+	client, mt := newTestClient(nil)
+	client.transport = wrapTransport(mt, recorder)
+
+	if err := client.Send(context.Background(), "Hello"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	recorder.Close()
+
+	records, err := transport.ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords failed: %v", err)
+	}
+	if len(records) != 1 || records[0].Direction != transport.DirectionSend || records[0].Type != "user" {
+		t.Errorf("records = %+v", records)
+	}
+}
+
+func TestRecordingConfig_NewRecorder(t *testing.T) {
+	var nilConfig *RecordingConfig
+	if r, err := nilConfig.newRecorder(); r != nil || err != nil {
+		t.Errorf("nil config should disable recording, got %v, %v", r, err)
+	}
+
+	if _, err := (&RecordingConfig{Path: filepath.Join(t.TempDir(), "missing", "x.jsonl")}).newRecorder(); err == nil {
+		t.Error("expected error for unwritable path")
+	}
+}
+
+func TestClient_RecordingClosedOnConnectError(t *testing.T) {
+	client := NewClient(&Options{
+		CLIPath:   filepath.Join(t.TempDir(), "missing-cli"),
+		Recording: &RecordingConfig{Path: filepath.Join(t.TempDir(), "session.jsonl")},
+	})
+	defer client.Close()
+
+	if _, err := client.Connect(context.Background()); err == nil {
+		t.Fatal("Connect should fail for a missing CLI")
+	}
+	if client.recorder != nil {
+		t.Error("recorder should be closed and cleared when Connect fails")
+	}
+}
+
+// echoSessionCLIScript はinitializeに応答し、ユーザーメッセージごとに応答とresultを返すモックCLI
+const echoSessionCLIScript = `#!/bin/sh
+while read -r line; do
+  id=$(echo "$line" | sed -n 's/.*"request_id":"\([^"]*\)".*/\1/p')
+  case "$line" in
+    *'"subtype":"initialize"'*)
+      echo "{\"type\":\"control_response\",\"response\":{\"subtype\":\"success\",\"request_id\":\"$id\",\"response\":{}}}"
+      ;;
+    *'"type":"user"'*)
+      echo '{"type":"system","subtype":"init","session_id":"replay-session"}'
+      echo '{"type":"assistant","message":{"role":"assistant","model":"claude-sonnet","content":[{"type":"text","text":"pong"}]},"session_id":"replay-session"}'
+      echo '{"type":"result","subtype":"success","is_error":false,"num_turns":1,"session_id":"replay-session","total_cost_usd":0.001,"usage":{"input_tokens":1,"output_tokens":1}}'
+      ;;
+  esac
+done
+`
+
+// runPingSession はClientで1ターン実行してアシスタントの応答テキストを返す
+func runPingSession(t *testing.T, opts *Options, prompt string) (string, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := NewClient(opts)
+	defer client.Close()
+
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := stream.Send(ctx, prompt); err != nil {
+		return "", err
+	}
+
+	var text string
+	for {
+		select {
+		case msg, ok := <-stream.Messages():
+			if !ok {
+				return text, nil
+			}
+			switch m := msg.(type) {
+			case *protocol.AssistantMessage:
+				text += m.Text()
+			case *protocol.ResultMessage:
+				return text, nil
+			}
+		case <-ctx.Done():
+			return text, ctx.Err()
+		}
+	}
+}
+
+func TestClient_ReplayRecordedSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	// 実際の（モック）CLIとのやり取りを記録する
+	text, err := runPingSession(t, &Options{
+		CLIPath:   writeMockCLI(t, echoSessionCLIScript),
+		Recording: &RecordingConfig{Path: path},
+	}, "ping")
+	if err != nil || text != "pong" {
+		t.Fatalf("recording session: text = %q, err = %v", text, err)
+	}
+
+	// CLIなしで記録を再生する
+	factory, replay, err := ReplayFile(path)
+	if err != nil {
+		t.Fatalf("ReplayFile failed: %v", err)
+	}
+	text, err = runPingSession(t, &Options{Transport: factory}, "ping")
+	if err != nil || text != "pong" {
+		t.Fatalf("replayed session: text = %q, err = %v", text, err)
+	}
+	if err := replay.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	// 記録と異なるプロンプトは差分付きのエラーになる
+	factory, _, _ = ReplayFile(path)
+	_, err = runPingSession(t, &Options{Transport: factory}, "hello")
+	var divErr *transport.ReplayDivergenceError
+	if !errors.As(err, &divErr) {
+		t.Fatalf("expected ReplayDivergenceError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `message.content: expected "ping", got "hello"`) {
+		t.Errorf("error should contain diff: %v", err)
+	}
+}
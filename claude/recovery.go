@@ -0,0 +1,225 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/transport"
+)
+
+// RecoveryConfig はCLIプロセス異常終了時の自動復旧の設定
+// Options.Recoveryに設定すると有効になる
+type RecoveryConfig struct {
+	MaxAttempts    int                   // 最大再接続試行回数（デフォルト: 3）
+	InitialBackoff time.Duration         // 初回再接続までの待ち時間（デフォルト: 1秒）
+	MaxBackoff     time.Duration         // 最大待ち時間（デフォルト: 30秒）
+	OnReconnect    func(*ReconnectEvent) // 再接続成功時のコールバック（任意）
+}
+
+// DefaultRecoveryConfig はデフォルトの自動復旧設定を返す
+func DefaultRecoveryConfig() *RecoveryConfig {
+	return &RecoveryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+// withDefaults は未設定の項目をデフォルト値で補完した設定を返す
+func (r *RecoveryConfig) withDefaults() RecoveryConfig {
+	def := DefaultRecoveryConfig()
+	cfg := *r
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = def.MaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = def.InitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = def.MaxBackoff
+	}
+	return cfg
+}
+
+// ReconnectEvent はCLIプロセスの再起動に成功したことを通知するメッセージ
+// Stream.Messages()に配信される。処理中だったターンは失われている可能性があるため、
+// 必要に応じて再送する
+type ReconnectEvent struct {
+	SessionID string // 再開したセッションID
+	Attempt   int    // 成功までの試行回数
+	ExitCode  int    // 異常終了したプロセスの終了コード
+	Stderr    string // 異常終了したプロセスのstderr
+}
+
+// MessageType はprotocol.Messageインターフェースを実装する
+func (e *ReconnectEvent) MessageType() string { return "reconnect" }
+
+// handleTransportExit はCLIプロセスの終了を検出した際に呼ばれ、必要に応じて自動復旧を開始する
+func (c *Client) handleTransportExit(ctx context.Context, t transport.Transport) {
+	// 復旧処理は同時に1つだけ実行する
+	c.recoveryMu.Lock()
+	defer c.recoveryMu.Unlock()
+
+	c.mu.RLock()
+	closed := c.closed
+	recovery := c.opts.Recovery
+	current := c.transport == t
+	c.mu.RUnlock()
+
+	// 再接続中に破棄したプロセスなど、現在のTransport以外の終了は無視する
+	if closed || !current || !c.ready.Load() {
+		return
+	}
+
+	status, exitErr, ok := c.waitProcessExit(ctx, t)
+	if !ok {
+		return
+	}
+
+	if recovery == nil {
+		details := status.Stderr
+		if exitErr != nil {
+			details = strings.TrimSpace(exitErr.Error() + "\n" + status.Stderr)
+		}
+		c.exitErr = &SDKError{
+			Op:       "receive",
+			Err:      ErrProcessExited,
+			Details:  details,
+			ExitCode: ExitCode(status.ExitCode),
+		}
+		c.markExited()
+		return
+	}
+
+	c.reconnect(ctx, recovery.withDefaults(), status)
+}
+
+// waitProcessExit はCLIプロセスの終了を待ち、終了状態と最後のTransportエラーを返す
+// （Errorsはプロセス終了後にクローズされる）。待機中にClientが閉じられた場合はokがfalse
+func (c *Client) waitProcessExit(ctx context.Context, t transport.Transport) (status *transport.ProcessStatus, lastErr error, ok bool) {
+	for done := false; !done; {
+		select {
+		case err, open := <-t.Errors():
+			if err != nil {
+				lastErr = err
+			}
+			done = !open
+		case <-ctx.Done():
+			return nil, nil, false
+		case <-c.closeChan:
+			return nil, nil, false
+		}
+	}
+
+	status = t.GetProcessStatus()
+	if status == nil {
+		status = &transport.ProcessStatus{}
+	}
+	return status, lastErr, true
+}
+
+// reconnect はキャプチャ済みのセッションIDでCLIを再起動し、initializeをやり直す
+// 試行回数はターンが完了する（resultを受信する）までリセットしないため、
+// 起動直後に異常終了を繰り返す場合もMaxAttemptsで打ち切られる
+func (c *Client) reconnect(ctx context.Context, cfg RecoveryConfig, status *transport.ProcessStatus) {
+	sessionID := c.getSessionIDString()
+	backoff := cfg.InitialBackoff
+
+	var lastErr error
+	for int(c.recoveryAttempts.Load()) < cfg.MaxAttempts {
+		attempt := int(c.recoveryAttempts.Add(1))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		case <-c.closeChan:
+			return
+		}
+
+		c.logger.Warn("CLI process exited unexpectedly, reconnecting",
+			"session_id", sessionID,
+			"attempt", attempt,
+			"exit_code", status.ExitCode,
+		)
+		if err := c.respawn(ctx, sessionID); err != nil {
+			c.logger.Warn("reconnect attempt failed", "session_id", sessionID, "attempt", attempt, "error", err)
+			lastErr = err
+			backoff = min(backoff*2, cfg.MaxBackoff)
+			continue
+		}
+		c.logger.Info("reconnected to CLI", "session_id", sessionID, "attempt", attempt)
+
+		event := &ReconnectEvent{
+			SessionID: sessionID,
+			Attempt:   attempt,
+			ExitCode:  status.ExitCode,
+			Stderr:    status.Stderr,
+		}
+		c.protocol.Emit(event)
+		if cfg.OnReconnect != nil {
+			cfg.OnReconnect(event)
+		}
+		return
+	}
+
+	// 復旧を断念した
+	c.ready.Store(false)
+
+	details := fmt.Sprintf("gave up after %d attempts", cfg.MaxAttempts)
+	if lastErr != nil {
+		details += ": " + lastErr.Error()
+	}
+	err := &SDKError{
+		Op:       "reconnect",
+		Err:      ErrProcessExited,
+		Details:  details,
+		ExitCode: ExitCode(status.ExitCode),
+	}
+	c.logger.Error("gave up reconnecting to CLI", "session_id", sessionID, "attempts", cfg.MaxAttempts, "error", lastErr)
+	c.sendError(err)
+	c.exitErr = err
+	c.markExited()
+}
+
+// markExited はCLIプロセスが終了し、以降メッセージが届かないことを通知する
+func (c *Client) markExited() {
+	c.exitOnce.Do(func() { close(c.exited) })
+}
+
+// respawn は新しいCLIプロセスを起動してTransportを差し替え、initializeを送信する
+// ProtocolHandlerは引き継ぐため、メッセージチャネルと登録済みコールバックはそのまま使える
+func (c *Client) respawn(ctx context.Context, sessionID string) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return fmt.Errorf("client is closed")
+	}
+
+	if sessionID != "" {
+		c.resumeSessionID.Store(&sessionID)
+	}
+
+	t := c.opts.newTransport(c.transportConfig(), c.recorder)
+	if err := t.Connect(ctx); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+
+	c.transport = t
+	c.protocol.SetTransport(t)
+	// 新しいプロセスのコストは0から累計される
+	c.releaseUsage(c.usageSource.Swap(nextUsageSource()))
+	c.mu.Unlock()
+
+	go c.receiveLoop(ctx, t)
+
+	if err := c.initialize(ctx); err != nil {
+		t.Close()
+		return err
+	}
+
+	return nil
+}
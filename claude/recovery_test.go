@@ -0,0 +1,174 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// crashingCLIScript は初回起動時のみinitialize直後に異常終了するモックCLI
+// 2回目以降はinitializeリクエストのresumeの値をsystemメッセージで返す
+func crashingCLIScript(stateFile string, crashes int) string {
+	return `#!/bin/sh
+STATE="` + stateFile + `"
+n=$(cat "$STATE" 2>/dev/null || echo 0)
+n=$((n+1))
+echo $n > "$STATE"
+while read -r line; do
+  id=$(echo "$line" | sed -n 's/.*"request_id":"\([^"]*\)".*/\1/p')
+  case "$line" in
+    *'"subtype":"initialize"'*)
+      echo "{\"type\":\"control_response\",\"response\":{\"subtype\":\"success\",\"request_id\":\"$id\",\"response\":{\"session_id\":\"sess-1\"}}}"
+      if [ $n -le ` + strconv.Itoa(crashes) + ` ]; then
+        echo '{"type":"system","subtype":"init","data":{"session_id":"sess-1"}}'
+        echo "crash $n" >&2
+        exit 3
+      fi
+      resume=$(echo "$line" | sed -n 's/.*"resume":"\([^"]*\)".*/\1/p')
+      echo "{\"type\":\"system\",\"subtype\":\"resumed\",\"data\":{\"resume\":\"$resume\",\"run\":$n}}"
+      ;;
+  esac
+done
+`
+}
+
+func TestClient_RecoversFromCrash(t *testing.T) {
+	cliPath := writeMockCLI(t, crashingCLIScript(filepath.Join(t.TempDir(), "runs"), 1))
+
+	reconnected := make(chan *ReconnectEvent, 1)
+	opts := &Options{
+		CLIPath: cliPath,
+		Recovery: &RecoveryConfig{
+			InitialBackoff: 10 * time.Millisecond,
+			OnReconnect: func(e *ReconnectEvent) {
+				reconnected <- e
+			},
+		},
+	}
+	client := NewClient(opts)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	messages := stream.Messages()
+
+	var event *ReconnectEvent
+	var resumed *protocol.SystemMessage
+	for event == nil || resumed == nil {
+		select {
+		case msg := <-messages:
+			switch m := msg.(type) {
+			case *ReconnectEvent:
+				event = m
+			case *protocol.SystemMessage:
+				if m.Subtype == "resumed" {
+					resumed = m
+				}
+			}
+		case err := <-stream.Errors():
+			if errors.Is(err, ErrProcessExited) {
+				t.Fatalf("recovery failed: %v", err)
+			}
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for reconnect")
+		}
+	}
+
+	if event.SessionID != "sess-1" {
+		t.Errorf("SessionID = %q, want %q", event.SessionID, "sess-1")
+	}
+	if event.Attempt != 1 {
+		t.Errorf("Attempt = %d, want %d", event.Attempt, 1)
+	}
+	if event.ExitCode != 3 {
+		t.Errorf("ExitCode = %d, want %d", event.ExitCode, 3)
+	}
+	if !strings.Contains(event.Stderr, "crash 1") {
+		t.Errorf("Stderr = %q", event.Stderr)
+	}
+	if resumed.Data["resume"] != "sess-1" {
+		t.Errorf("resume = %v, want %q", resumed.Data["resume"], "sess-1")
+	}
+
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Error("OnReconnect was not called")
+	}
+
+	// 同じチャネルが引き続き使われる
+	if stream.Messages() != messages {
+		t.Error("Messages channel should be preserved across reconnect")
+	}
+
+	// 再開するセッションは渡されたOptionsに書き込まない
+	if opts.Resume != "" || client.opts.Resume != "" {
+		t.Errorf("Resume = %q (caller), %q (client), want empty", opts.Resume, client.opts.Resume)
+	}
+}
+
+func TestClient_RecoveryGivesUp(t *testing.T) {
+	cliPath := writeMockCLI(t, crashingCLIScript(filepath.Join(t.TempDir(), "runs"), 9))
+
+	client := NewClient(&Options{
+		CLIPath: cliPath,
+		Recovery: &RecoveryConfig{
+			MaxAttempts:    2,
+			InitialBackoff: 10 * time.Millisecond,
+		},
+	})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	for {
+		select {
+		case <-stream.Messages():
+		case err := <-stream.Errors():
+			var sdkErr *SDKError
+			if errors.As(err, &sdkErr) && sdkErr.Op == "reconnect" {
+				if !errors.Is(err, ErrProcessExited) {
+					t.Errorf("expected ErrProcessExited, got %v", err)
+				}
+				select {
+				case <-client.exited:
+				case <-time.After(time.Second):
+					t.Error("exited should be closed after giving up")
+				}
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for reconnect failure")
+		}
+	}
+}
+
+func TestRecoveryConfig_WithDefaults(t *testing.T) {
+	cfg := (&RecoveryConfig{MaxAttempts: 5}).withDefaults()
+	if cfg.MaxAttempts != 5 {
+		t.Errorf("MaxAttempts = %d, want %d", cfg.MaxAttempts, 5)
+	}
+	if cfg.InitialBackoff != time.Second {
+		t.Errorf("InitialBackoff = %v, want %v", cfg.InitialBackoff, time.Second)
+	}
+	if cfg.MaxBackoff != 30*time.Second {
+		t.Errorf("MaxBackoff = %v, want %v", cfg.MaxBackoff, 30*time.Second)
+	}
+}
@@ -0,0 +1,412 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaFor はGoの型からJSON Schemaを生成する
+//
+// 以下の規則で変換する:
+//   - フィールド名はjsonタグに従う（"-"は除外、埋め込み構造体は展開）
+//   - omitemptyまたはポインタ型のフィールドは任意、それ以外は必須（ポインタ型はnullも許可）
+//   - jsonschemaタグで description=..., enum=a|b|c, required, optional を指定できる
+//     （descriptionは以降のタグ全体を値とするため、カンマを含める場合は最後に指定する）
+//   - 構造体はobject、スライス・配列はarray、mapはadditionalPropertiesを持つobject
+//   - []byteはencoding/jsonと同じくbase64の文字列
+//   - time.Timeはformat: date-timeの文字列
+func SchemaFor[T any]() (map[string]any, error) {
+	return GenerateSchema(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// GenerateSchema はreflect.TypeからJSON Schemaを生成する
+func GenerateSchema(t reflect.Type) (map[string]any, error) {
+	g := &schemaGenerator{visiting: make(map[reflect.Type]bool)}
+	return g.generate(t)
+}
+
+type schemaGenerator struct {
+	visiting map[reflect.Type]bool
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) generate(t reflect.Type) (map[string]any, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Interface:
+		// 任意の値
+		return map[string]any{}, nil
+
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}, nil
+		}
+		items, err := g.generate(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type: %s", t.Key())
+		}
+		values, err := g.generate(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+
+	case reflect.Struct:
+		if g.visiting[t] {
+			return nil, fmt.Errorf("recursive type is not supported: %s", t)
+		}
+		g.visiting[t] = true
+		defer delete(g.visiting, t)
+
+		properties := make(map[string]any)
+		required := []string{}
+		if err := g.addFields(t, properties, &required); err != nil {
+			return nil, err
+		}
+		sort.Strings(required)
+
+		schema := map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported type: %s", t)
+	}
+}
+
+// addFields は構造体のフィールドをpropertiesに追加する（埋め込み構造体は展開）
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]any, required *[]string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		name, omitempty, skip := parseJSONTag(field)
+		if skip {
+			continue
+		}
+
+		// タグのない埋め込み構造体はフィールドを展開する
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			ft := field.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := g.addFields(ft, properties, required); err != nil {
+					return err
+				}
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+		}
+
+		prop, err := g.generate(field.Type)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+
+		isRequired := !omitempty && field.Type.Kind() != reflect.Pointer
+		if err := applySchemaTag(field, prop, &isRequired); err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if field.Type.Kind() == reflect.Pointer {
+			allowNull(prop)
+		}
+
+		properties[name] = prop
+		if isRequired {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
+
+// allowNull はスキーマの型にnullを追加する（nilのポインタはnullとして出力されるため）
+func allowNull(prop map[string]any) {
+	typ, ok := prop["type"].(string)
+	if !ok {
+		return
+	}
+	prop["type"] = []any{typ, "null"}
+	if enum, ok := prop["enum"].([]any); ok {
+		prop["enum"] = append(enum, nil)
+	}
+}
+
+// parseJSONTag はjsonタグからプロパティ名とomitemptyを取得する
+func parseJSONTag(field reflect.StructField) (name string, omitempty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" || opt == "omitzero" {
+			omitempty = true
+		}
+	}
+	return name, omitempty, false
+}
+
+// applySchemaTag はjsonschemaタグの内容をスキーマに反映する
+func applySchemaTag(field reflect.StructField, prop map[string]any, required *bool) error {
+	tag := field.Tag.Get("jsonschema")
+	if tag == "" {
+		return nil
+	}
+
+	for rest := tag; rest != ""; {
+		part, next, more := strings.Cut(rest, ",")
+		rest = next
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "required":
+			*required = true
+		case "optional":
+			*required = false
+		case "description":
+			// 説明文はカンマを含みうるため、以降のタグ全体を値とする
+			if more {
+				value += "," + rest
+				rest = ""
+			}
+			prop["description"] = value
+		case "enum":
+			values, err := parseEnumValues(field.Type, strings.Split(value, "|"))
+			if err != nil {
+				return err
+			}
+			prop["enum"] = values
+		default:
+			return fmt.Errorf("unknown jsonschema tag option: %s", key)
+		}
+	}
+	return nil
+}
+
+// parseEnumValues はenumの値をフィールドの型に合わせて変換する
+func parseEnumValues(t reflect.Type, raw []string) ([]any, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	values := make([]any, 0, len(raw))
+	for _, v := range raw {
+		switch t.Kind() {
+		case reflect.String:
+			values = append(values, v)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid enum value %q: %w", v, err)
+			}
+			values = append(values, n)
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid enum value %q: %w", v, err)
+			}
+			values = append(values, f)
+		default:
+			return nil, fmt.Errorf("enum is not supported for type %s", t)
+		}
+	}
+	return values, nil
+}
+
+// validateSchema は値がJSON Schemaに適合するかを検証し、違反内容を返す
+// SchemaForが生成するキーワード（type, properties, required, additionalProperties, items, enum）に対応する
+func validateSchema(value any, schema map[string]any, path string) []string {
+	var violations []string
+
+	typ, nullable := schemaType(schema["type"])
+	if value == nil && nullable {
+		return nil
+	}
+
+	if enum, ok := schema["enum"]; ok && !enumContains(enum, value) {
+		violations = append(violations, fmt.Sprintf("%s: value %v is not one of %v", path, value, enum))
+	}
+
+	switch typ {
+	case "":
+		return violations
+
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return append(violations, fmt.Sprintf("%s: expected object, got %s", path, jsonTypeName(value)))
+		}
+
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range toStringSlice(schema["required"]) {
+			if _, ok := obj[name]; !ok {
+				violations = append(violations, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			childPath := path + "." + key
+			if propSchema, ok := properties[key].(map[string]any); ok {
+				violations = append(violations, validateSchema(obj[key], propSchema, childPath)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					violations = append(violations, fmt.Sprintf("%s: unexpected property", childPath))
+				}
+			case map[string]any:
+				violations = append(violations, validateSchema(obj[key], additional, childPath)...)
+			}
+		}
+
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return append(violations, fmt.Sprintf("%s: expected array, got %s", path, jsonTypeName(value)))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range arr {
+				violations = append(violations, validateSchema(item, items, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+
+	case "string":
+		if _, ok := value.(string); !ok {
+			violations = append(violations, fmt.Sprintf("%s: expected string, got %s", path, jsonTypeName(value)))
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			violations = append(violations, fmt.Sprintf("%s: expected boolean, got %s", path, jsonTypeName(value)))
+		}
+
+	case "number":
+		if _, ok := value.(float64); !ok {
+			violations = append(violations, fmt.Sprintf("%s: expected number, got %s", path, jsonTypeName(value)))
+		}
+
+	case "integer":
+		f, ok := value.(float64)
+		if !ok || f != float64(int64(f)) {
+			violations = append(violations, fmt.Sprintf("%s: expected integer, got %s", path, jsonTypeName(value)))
+		}
+	}
+
+	return violations
+}
+
+// schemaType はtypeキーワードから型名と、nullを許可するかを返す（["string", "null"]形式にも対応）
+func schemaType(v any) (typ string, nullable bool) {
+	if s, ok := v.(string); ok {
+		return s, false
+	}
+	for _, t := range toStringSlice(v) {
+		if t == "null" {
+			nullable = true
+		} else {
+			typ = t
+		}
+	}
+	return typ, nullable
+}
+
+func enumContains(enum any, value any) bool {
+	values, ok := enum.([]any)
+	if !ok {
+		return true
+	}
+	for _, v := range values {
+		if fmt.Sprint(v) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func toStringSlice(v any) []string {
+	switch s := v.(type) {
+	case []string:
+		return s
+	case []any:
+		result := make([]string, 0, len(s))
+		for _, item := range s {
+			if str, ok := item.(string); ok {
+				result = append(result, str)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, json.Number:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,220 @@
+package claude
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type schemaTestAddress struct {
+	City    string `json:"city"`
+	ZipCode string `json:"zip_code,omitempty"`
+}
+
+type schemaTestBase struct {
+	ID int `json:"id"`
+}
+
+type schemaTestPerson struct {
+	schemaTestBase
+	Name      string              `json:"name" jsonschema:"description=氏名"`
+	Age       int                 `json:"age"`
+	Score     float64             `json:"score,omitempty"`
+	Role      string              `json:"role" jsonschema:"enum=admin|member"`
+	Tags      []string            `json:"tags"`
+	Address   schemaTestAddress   `json:"address"`
+	Previous  []schemaTestAddress `json:"previous,omitempty"`
+	Nickname  *string             `json:"nickname"`
+	Meta      map[string]int      `json:"meta,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+	Internal  string              `json:"-"`
+	private   string
+}
+
+func TestSchemaFor_Struct(t *testing.T) {
+	schema, err := SchemaFor[schemaTestPerson]()
+	if err != nil {
+		t.Fatalf("SchemaFor failed: %v", err)
+	}
+
+	if schema["type"] != "object" {
+		t.Errorf("type = %v, want object", schema["type"])
+	}
+	if schema["additionalProperties"] != false {
+		t.Errorf("additionalProperties = %v, want false", schema["additionalProperties"])
+	}
+
+	wantRequired := []string{"address", "age", "created_at", "id", "name", "role", "tags"}
+	if !reflect.DeepEqual(schema["required"], wantRequired) {
+		t.Errorf("required = %v, want %v", schema["required"], wantRequired)
+	}
+
+	props := schema["properties"].(map[string]any)
+	for _, name := range []string{"Internal", "private"} {
+		if _, ok := props[name]; ok {
+			t.Errorf("property %q should be excluded", name)
+		}
+	}
+
+	name := props["name"].(map[string]any)
+	if name["description"] != "氏名" {
+		t.Errorf("name.description = %v, want 氏名", name["description"])
+	}
+
+	role := props["role"].(map[string]any)
+	if !reflect.DeepEqual(role["enum"], []any{"admin", "member"}) {
+		t.Errorf("role.enum = %v", role["enum"])
+	}
+
+	tags := props["tags"].(map[string]any)
+	if tags["type"] != "array" || tags["items"].(map[string]any)["type"] != "string" {
+		t.Errorf("tags = %v", tags)
+	}
+
+	address := props["address"].(map[string]any)
+	if address["type"] != "object" {
+		t.Errorf("address.type = %v, want object", address["type"])
+	}
+	if !reflect.DeepEqual(address["required"], []string{"city"}) {
+		t.Errorf("address.required = %v, want [city]", address["required"])
+	}
+
+	previous := props["previous"].(map[string]any)
+	if previous["items"].(map[string]any)["type"] != "object" {
+		t.Errorf("previous.items = %v", previous["items"])
+	}
+
+	meta := props["meta"].(map[string]any)
+	if meta["additionalProperties"].(map[string]any)["type"] != "integer" {
+		t.Errorf("meta = %v", meta)
+	}
+
+	// ポインタ型はnullも許可する
+	nickname := props["nickname"].(map[string]any)
+	if !reflect.DeepEqual(nickname["type"], []any{"string", "null"}) {
+		t.Errorf("nickname.type = %v, want [string null]", nickname["type"])
+	}
+
+	createdAt := props["created_at"].(map[string]any)
+	if createdAt["format"] != "date-time" {
+		t.Errorf("created_at.format = %v, want date-time", createdAt["format"])
+	}
+}
+
+func TestSchemaFor_DescriptionWithComma(t *testing.T) {
+	type named struct {
+		Name string `json:"name" jsonschema:"optional,description=Name, in full"`
+	}
+	schema, err := SchemaFor[named]()
+	if err != nil {
+		t.Fatalf("SchemaFor failed: %v", err)
+	}
+	name := schema["properties"].(map[string]any)["name"].(map[string]any)
+	if name["description"] != "Name, in full" {
+		t.Errorf("name.description = %q, want %q", name["description"], "Name, in full")
+	}
+	if schema["required"] != nil {
+		t.Errorf("required = %v, want none", schema["required"])
+	}
+}
+
+func TestSchemaFor_Bytes(t *testing.T) {
+	schema, err := SchemaFor[struct {
+		Data []byte `json:"data"`
+	}]()
+	if err != nil {
+		t.Fatalf("SchemaFor failed: %v", err)
+	}
+
+	// encoding/jsonと同じくbase64の文字列として扱う
+	data := schema["properties"].(map[string]any)["data"].(map[string]any)
+	if data["type"] != "string" {
+		t.Errorf("data = %v, want string", data)
+	}
+	if v := validateSchema(map[string]any{"data": "aGVsbG8="}, schema, "$"); len(v) != 0 {
+		t.Errorf("violations = %v, want none", v)
+	}
+}
+
+func TestSchemaFor_Errors(t *testing.T) {
+	type recursive struct {
+		Children []recursive `json:"children"`
+	}
+	if _, err := SchemaFor[recursive](); err == nil {
+		t.Error("expected error for recursive type")
+	}
+
+	type badEnum struct {
+		Level int `json:"level" jsonschema:"enum=low|high"`
+	}
+	if _, err := SchemaFor[badEnum](); err == nil {
+		t.Error("expected error for invalid enum value")
+	}
+
+	if _, err := SchemaFor[chan int](); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	schema, err := SchemaFor[schemaTestAddress]()
+	if err != nil {
+		t.Fatalf("SchemaFor failed: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		value          any
+		wantViolations int
+	}{
+		{"valid", map[string]any{"city": "Tokyo"}, 0},
+		{"missing required", map[string]any{"zip_code": "100-0001"}, 1},
+		{"wrong type", map[string]any{"city": 1.0}, 1},
+		{"unexpected property", map[string]any{"city": "Tokyo", "country": "JP"}, 1},
+		{"not an object", []any{}, 1},
+	}
+
+	// ポインタ型のフィールドはnullを許可する
+	nullable, err := SchemaFor[struct {
+		Nickname *string `json:"nickname" jsonschema:"enum=a|b"`
+		Name     string  `json:"name"`
+	}]()
+	if err != nil {
+		t.Fatalf("SchemaFor failed: %v", err)
+	}
+	if v := validateSchema(map[string]any{"nickname": nil, "name": "x"}, nullable, "$"); len(v) != 0 {
+		t.Errorf("violations = %v, want none for null pointer field", v)
+	}
+	if v := validateSchema(map[string]any{"nickname": "a", "name": nil}, nullable, "$"); len(v) != 1 {
+		t.Errorf("violations = %v, want 1 for null non-pointer field", v)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := validateSchema(tt.value, schema, "$")
+			if len(violations) != tt.wantViolations {
+				t.Errorf("violations = %v, want %d", violations, tt.wantViolations)
+			}
+		})
+	}
+}
+
+func TestValidateSchema_EnumAndInteger(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"role":  map[string]any{"type": "string", "enum": []any{"admin", "member"}},
+			"count": map[string]any{"type": "integer"},
+		},
+	}
+
+	violations := validateSchema(map[string]any{"role": "guest", "count": 1.5}, schema, "$")
+	if len(violations) != 2 {
+		t.Errorf("violations = %v, want 2", violations)
+	}
+
+	violations = validateSchema(map[string]any{"role": "admin", "count": 3.0}, schema, "$")
+	if len(violations) != 0 {
+		t.Errorf("violations = %v, want none", violations)
+	}
+}
@@ -16,6 +16,7 @@ func NewSession(id string) *Session {
 }
 
 // Fork はセッションを分岐する設定を返す
+// 分岐後の実際のセッションIDはCLIが割り当てるため、接続後にClient.Session()で取得する
 func (s *Session) Fork() *Session {
 	return &Session{
 		ParentID: s.ID,
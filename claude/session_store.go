@@ -0,0 +1,540 @@
+package claude
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// sessionLabelsFile はセッションのラベルを保存するファイル名（プロジェクトディレクトリ内）
+const sessionLabelsFile = "sdk-labels.json"
+
+// SessionStore はCLIがプロジェクトごとに保存するトランスクリプト（JSON Lines）からセッションを一覧・読み込みする
+// トランスクリプトは <root>/<エンコードした作業ディレクトリ>/<セッションID>.jsonl に保存されている
+type SessionStore struct {
+	root string
+	mu   sync.Mutex // ラベルファイルの読み書きを保護
+}
+
+// NewSessionStore はrootを起点とするSessionStoreを作成する
+// rootが空の場合はDefaultSessionRoot()を使用する
+func NewSessionStore(root string) *SessionStore {
+	if root == "" {
+		root = DefaultSessionRoot()
+	}
+	return &SessionStore{root: root}
+}
+
+// DefaultSessionRoot はCLIのトランスクリプト保存先を返す
+// CLAUDE_CONFIG_DIRが設定されている場合はその下のprojects、それ以外は ~/.claude/projects
+func DefaultSessionRoot() string {
+	if dir := os.Getenv("CLAUDE_CONFIG_DIR"); dir != "" {
+		return filepath.Join(dir, "projects")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".claude", "projects")
+	}
+	return filepath.Join(home, ".claude", "projects")
+}
+
+// ProjectDirName は作業ディレクトリをCLIのプロジェクトディレクトリ名に変換する
+// 英数字以外の文字はすべて"-"に置き換えられる（例: /home/user/app → -home-user-app）
+func ProjectDirName(cwd string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, cwd)
+}
+
+// SessionInfo はトランスクリプトから読み取ったセッションのメタデータ
+type SessionInfo struct {
+	ID           string    // セッションID
+	CWD          string    // 作業ディレクトリ
+	Path         string    // トランスクリプトファイルのパス
+	FirstPrompt  string    // 最初のユーザープロンプト
+	Summary      string    // CLIが生成した要約（ある場合）
+	StartedAt    time.Time // 最初のメッセージの時刻
+	LastActivity time.Time // 最後のメッセージの時刻
+	MessageCount int       // ユーザー・アシスタントメッセージの数（サブエージェントを除く）
+	Model        string    // 最後に使用されたモデル
+	CostUSD      float64   // トランスクリプトに記録されたコストの合計
+	ForkParent   string    // 分岐元のセッションID（分岐したセッションの場合のみ）
+	Labels       []string  // ユーザーが付けたラベル
+}
+
+// Session はこのセッションを再開するためのSessionを返す
+func (i *SessionInfo) Session() *Session {
+	return &Session{
+		ID:       i.ID,
+		IsForked: i.ForkParent != "",
+		ParentID: i.ForkParent,
+	}
+}
+
+// HasLabel はラベルが付いているかを返す
+func (i *SessionInfo) HasLabel(label string) bool {
+	for _, l := range i.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// transcriptEntry はトランスクリプトの1行
+type transcriptEntry struct {
+	Type        string          `json:"type"` // "user", "assistant", "system", "summary" など
+	UUID        string          `json:"uuid"`
+	ParentUUID  *string         `json:"parentUuid"`
+	SessionID   string          `json:"sessionId"`
+	Timestamp   time.Time       `json:"timestamp"`
+	CWD         string          `json:"cwd"`
+	IsSidechain bool            `json:"isSidechain"`
+	IsMeta      bool            `json:"isMeta"`
+	Message     json.RawMessage `json:"message"`
+	CostUSD     float64         `json:"costUSD"`
+	Subtype     string          `json:"subtype"`
+	Content     any             `json:"content"`
+	Summary     string          `json:"summary"`
+}
+
+// readTranscript はトランスクリプトを1行ずつ読み込む
+// 書き込み途中などで壊れている行は読み飛ばす
+func readTranscript(path string, fn func(e *transcriptEntry)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var e transcriptEntry
+			if json.Unmarshal(line, &e) == nil {
+				fn(&e)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scanSession はトランスクリプトを読み込んでメタデータを集計する
+func scanSession(id, path string) (*SessionInfo, error) {
+	info := &SessionInfo{ID: id, Path: path}
+	err := readTranscript(path, func(e *transcriptEntry) {
+		if e.Type == "summary" {
+			info.Summary = e.Summary
+			return
+		}
+		if !e.Timestamp.IsZero() {
+			if info.StartedAt.IsZero() || e.Timestamp.Before(info.StartedAt) {
+				info.StartedAt = e.Timestamp
+			}
+			if e.Timestamp.After(info.LastActivity) {
+				info.LastActivity = e.Timestamp
+			}
+		}
+		if info.CWD == "" {
+			info.CWD = e.CWD
+		}
+		// 分岐したセッションは分岐元の履歴を元のセッションIDのまま引き継ぐ
+		if info.ForkParent == "" && e.SessionID != "" && e.SessionID != id {
+			info.ForkParent = e.SessionID
+		}
+		info.CostUSD += e.CostUSD
+		if e.IsSidechain {
+			return
+		}
+
+		switch e.Type {
+		case "user":
+			info.MessageCount++
+			if info.FirstPrompt == "" && !e.IsMeta {
+				var content protocol.UserContent
+				if json.Unmarshal(e.Message, &content) == nil {
+					info.FirstPrompt = (&protocol.UserMessage{Message: content}).Text()
+				}
+			}
+		case "assistant":
+			info.MessageCount++
+			var body protocol.AssistantBody
+			if json.Unmarshal(e.Message, &body) == nil && body.Model != "" && body.Model != "<synthetic>" {
+				info.Model = body.Model
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info.LastActivity.IsZero() {
+		// タイムスタンプがない場合はファイルの更新時刻を使う
+		if st, err := os.Stat(path); err == nil {
+			info.LastActivity = st.ModTime()
+		}
+	}
+	return info, nil
+}
+
+// projectDir は作業ディレクトリに対応するプロジェクトディレクトリを返す
+func (s *SessionStore) projectDir(cwd string) string {
+	return filepath.Join(s.root, ProjectDirName(cwd))
+}
+
+// sessionPath はセッションのトランスクリプトのパスを返す
+// プロジェクトディレクトリ外を指すIDはErrInvalidConfigのSDKErrorを返す
+func (s *SessionStore) sessionPath(op, cwd, id string) (string, error) {
+	if id == "" || id == "." || strings.Contains(id, "..") || strings.ContainsAny(id, `/\`) || filepath.Base(id) != id {
+		return "", &SDKError{Op: op, Err: ErrInvalidConfig, Details: fmt.Sprintf("invalid session ID %q", id)}
+	}
+	return filepath.Join(s.projectDir(cwd), id+".jsonl"), nil
+}
+
+// List は作業ディレクトリのセッションを最終アクティビティの新しい順に返す
+func (s *SessionStore) List(cwd string) ([]*SessionInfo, error) {
+	dir := s.projectDir(cwd)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, &SDKError{Op: "list_sessions", Err: err, Details: dir}
+	}
+
+	labels, err := s.loadLabels(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*SessionInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".jsonl") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".jsonl")
+		info, err := scanSession(id, filepath.Join(dir, name))
+		if err != nil {
+			return nil, &SDKError{Op: "list_sessions", Err: err, Details: name}
+		}
+		if info.CWD == "" {
+			info.CWD = cwd
+		}
+		info.Labels = labels[id]
+		sessions = append(sessions, info)
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastActivity.After(sessions[j].LastActivity)
+	})
+	return sessions, nil
+}
+
+// Get はセッションのメタデータを返す
+func (s *SessionStore) Get(cwd, id string) (*SessionInfo, error) {
+	path, err := s.sessionPath("get_session", cwd, id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := scanSession(id, path)
+	if err != nil {
+		return nil, s.sessionError("get_session", id, err)
+	}
+	if info.CWD == "" {
+		info.CWD = cwd
+	}
+	labels, err := s.loadLabels(cwd)
+	if err != nil {
+		return nil, err
+	}
+	info.Labels = labels[id]
+	return info, nil
+}
+
+// Messages はセッションのユーザー・アシスタント・システムメッセージを記録順に読み込む
+// サブエージェントのメッセージと要約などのメタデータ行は含まない
+func (s *SessionStore) Messages(cwd, id string) ([]protocol.Message, error) {
+	path, err := s.sessionPath("load_session", cwd, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []protocol.Message
+	var parseErr error
+	err = readTranscript(path, func(e *transcriptEntry) {
+		if e.IsSidechain || parseErr != nil {
+			return
+		}
+		msg, err := e.toMessage()
+		if err != nil {
+			parseErr = err
+			return
+		}
+		if msg != nil {
+			messages = append(messages, msg)
+		}
+	})
+	if err != nil {
+		return nil, s.sessionError("load_session", id, err)
+	}
+	if parseErr != nil {
+		return nil, &SDKError{Op: "load_session", Err: ErrMessageParse, Details: parseErr.Error()}
+	}
+	return messages, nil
+}
+
+// toMessage はトランスクリプトの行を型付きメッセージに変換する（対象外の行はnil）
+func (e *transcriptEntry) toMessage() (protocol.Message, error) {
+	switch e.Type {
+	case "user":
+		msg := &protocol.UserMessage{Type: "user", SessionID: e.SessionID, UUID: e.UUID}
+		if err := json.Unmarshal(e.Message, &msg.Message); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	case "assistant":
+		msg := &protocol.AssistantMessage{Type: "assistant"}
+		if err := json.Unmarshal(e.Message, &msg.Message); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	case "system":
+		return &protocol.SystemMessage{
+			Type:    "system",
+			Subtype: e.Subtype,
+			Data:    map[string]any{"content": e.Content, "uuid": e.UUID, "session_id": e.SessionID},
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Delete はセッションのトランスクリプトとラベルを削除する
+func (s *SessionStore) Delete(cwd, id string) error {
+	path, err := s.sessionPath("delete_session", cwd, id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return s.sessionError("delete_session", id, err)
+	}
+	// サブエージェントのトランスクリプトなど、セッションIDのディレクトリがあれば合わせて削除する
+	if err := os.RemoveAll(strings.TrimSuffix(path, ".jsonl")); err != nil {
+		return &SDKError{Op: "delete_session", Err: err, Details: id}
+	}
+	return s.updateLabels(cwd, func(labels map[string][]string) {
+		delete(labels, id)
+	})
+}
+
+// sessionError はファイル操作のエラーをSDKErrorに変換する
+func (s *SessionStore) sessionError(op, id string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return &SDKError{Op: op, Err: ErrSessionNotFound, Details: id}
+	}
+	return &SDKError{Op: op, Err: err, Details: id}
+}
+
+// SetLabels はセッションのラベルを置き換える（空の場合はラベルを削除する）
+func (s *SessionStore) SetLabels(cwd, id string, labels ...string) error {
+	path, err := s.sessionPath("label_session", cwd, id)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return s.sessionError("label_session", id, err)
+	}
+	return s.updateLabels(cwd, func(all map[string][]string) {
+		if len(labels) == 0 {
+			delete(all, id)
+			return
+		}
+		all[id] = dedupeLabels(labels)
+	})
+}
+
+// AddLabel はセッションにラベルを追加する
+func (s *SessionStore) AddLabel(cwd, id, label string) error {
+	path, err := s.sessionPath("label_session", cwd, id)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return s.sessionError("label_session", id, err)
+	}
+	return s.updateLabels(cwd, func(all map[string][]string) {
+		all[id] = dedupeLabels(append(all[id], label))
+	})
+}
+
+// RemoveLabel はセッションからラベルを取り除く
+func (s *SessionStore) RemoveLabel(cwd, id, label string) error {
+	return s.updateLabels(cwd, func(all map[string][]string) {
+		var kept []string
+		for _, l := range all[id] {
+			if l != label {
+				kept = append(kept, l)
+			}
+		}
+		if len(kept) == 0 {
+			delete(all, id)
+			return
+		}
+		all[id] = kept
+	})
+}
+
+// dedupeLabels は重複と空文字列を取り除く
+func dedupeLabels(labels []string) []string {
+	seen := make(map[string]bool, len(labels))
+	var result []string
+	for _, l := range labels {
+		if l == "" || seen[l] {
+			continue
+		}
+		seen[l] = true
+		result = append(result, l)
+	}
+	return result
+}
+
+// loadLabels はプロジェクトのラベルファイルを読み込む
+func (s *SessionStore) loadLabels(cwd string) (map[string][]string, error) {
+	labels := make(map[string][]string)
+	data, err := os.ReadFile(filepath.Join(s.projectDir(cwd), sessionLabelsFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return labels, nil
+		}
+		return nil, &SDKError{Op: "load_labels", Err: err}
+	}
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, &SDKError{Op: "load_labels", Err: ErrJSONDecode, Details: err.Error()}
+	}
+	return labels, nil
+}
+
+// updateLabels はラベルファイルを読み込んで更新し、書き戻す
+func (s *SessionStore) updateLabels(cwd string, fn func(labels map[string][]string)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	labels, err := s.loadLabels(cwd)
+	if err != nil {
+		return err
+	}
+	fn(labels)
+
+	path := filepath.Join(s.projectDir(cwd), sessionLabelsFile)
+	if len(labels) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return &SDKError{Op: "save_labels", Err: err}
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(labels, "", "  ")
+	if err != nil {
+		return &SDKError{Op: "save_labels", Err: err}
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return &SDKError{Op: "save_labels", Err: err}
+	}
+	return nil
+}
+
+// writeFileAtomic は書き込み途中の状態が読まれないよう一時ファイルから置き換える
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// lastMessageUUID はトランスクリプトの最後のユーザー・アシスタントメッセージのUUIDを返す
+func (s *SessionStore) lastMessageUUID(cwd, id string) (string, error) {
+	path, err := s.sessionPath("load_session", cwd, id)
+	if err != nil {
+		return "", err
+	}
+
+	var last string
+	err = readTranscript(path, func(e *transcriptEntry) {
+		if !e.IsSidechain && e.UUID != "" && (e.Type == "user" || e.Type == "assistant") {
+			last = e.UUID
+		}
+	})
+	return last, err
+}
+
+// RetentionPolicy はセッションの保持ポリシー
+// ゼロ値のフィールドは条件として使用しない
+type RetentionPolicy struct {
+	MaxAge      time.Duration // 最終アクティビティからこの期間を過ぎたセッションを削除する
+	MaxSessions int           // 新しい順にこの件数を超えたセッションを削除する
+	KeepLabels  []string      // いずれかのラベルが付いたセッションは削除しない
+	KeepLabeled bool          // ラベルが1つでも付いたセッションは削除しない
+}
+
+// keeps はポリシーによって保護されるセッションかを返す
+func (p *RetentionPolicy) keeps(info *SessionInfo) bool {
+	if p.KeepLabeled && len(info.Labels) > 0 {
+		return true
+	}
+	for _, l := range p.KeepLabels {
+		if info.HasLabel(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune は保持ポリシーに従って作業ディレクトリのセッションを削除し、削除したセッションIDを返す
+// 保護されたセッションもMaxSessionsの件数に数える
+func (s *SessionStore) Prune(cwd string, policy RetentionPolicy) ([]string, error) {
+	return s.prune(cwd, policy, time.Now())
+}
+
+func (s *SessionStore) prune(cwd string, policy RetentionPolicy, now time.Time) ([]string, error) {
+	sessions, err := s.List(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for i, info := range sessions {
+		expired := policy.MaxAge > 0 && now.Sub(info.LastActivity) > policy.MaxAge
+		overflow := policy.MaxSessions > 0 && i >= policy.MaxSessions
+		if !expired && !overflow || policy.keeps(info) {
+			continue
+		}
+		if err := s.Delete(cwd, info.ID); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, info.ID)
+	}
+	return deleted, nil
+}
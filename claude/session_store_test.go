@@ -0,0 +1,218 @@
+package claude
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+const testProjectCWD = "/home/user/my_app"
+
+// writeTranscript はテスト用のトランスクリプトを作成する
+func writeTranscript(t *testing.T, root, id string, lines ...string) string {
+	t.Helper()
+	dir := filepath.Join(root, ProjectDirName(testProjectCWD))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, id+".jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sessionStoreFixture(t *testing.T) (*SessionStore, string) {
+	t.Helper()
+	root := t.TempDir()
+	writeTranscript(t, root, "sess-a",
+		`{"type":"user","uuid":"u1","parentUuid":null,"sessionId":"sess-a","cwd":"/home/user/my_app","timestamp":"2025-06-01T10:00:00Z","isMeta":true,"message":{"role":"user","content":"<local-command-caveat>"}}`,
+		`{"type":"user","uuid":"u2","parentUuid":"u1","sessionId":"sess-a","cwd":"/home/user/my_app","timestamp":"2025-06-01T10:00:01Z","message":{"role":"user","content":"fix the build"}}`,
+		`{"type":"assistant","uuid":"a1","parentUuid":"u2","sessionId":"sess-a","timestamp":"2025-06-01T10:00:05Z","costUSD":0.02,"message":{"role":"assistant","model":"claude-sonnet-4","content":[{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"go build"}}]}}`,
+		`{"type":"user","uuid":"u3","parentUuid":"a1","sessionId":"sess-a","timestamp":"2025-06-01T10:00:06Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"ok"}]}}`,
+		`{"type":"assistant","uuid":"s1","parentUuid":"u3","sessionId":"sess-a","isSidechain":true,"timestamp":"2025-06-01T10:00:07Z","costUSD":0.01,"message":{"role":"assistant","model":"claude-haiku","content":[{"type":"text","text":"sub"}]}}`,
+		`{"type":"assistant","uuid":"a2","parentUuid":"u3","sessionId":"sess-a","timestamp":"2025-06-01T10:00:08Z","message":{"role":"assistant","model":"claude-opus-4","content":[{"type":"text","text":"done"}]}}`,
+		`{"type":"summary","summary":"Fix build","leafUuid":"a2"}`,
+		`{"type":"user","uuid":"broken`,
+	)
+	writeTranscript(t, root, "sess-b",
+		`{"type":"user","uuid":"u1","sessionId":"sess-a","timestamp":"2025-06-01T10:00:01Z","message":{"role":"user","content":"fix the build"}}`,
+		`{"type":"user","uuid":"b1","parentUuid":"u1","sessionId":"sess-b","timestamp":"2025-06-02T09:00:00Z","message":{"role":"user","content":[{"type":"text","text":"try another way"}]}}`,
+	)
+	return NewSessionStore(root), root
+}
+
+func TestProjectDirName(t *testing.T) {
+	if got := ProjectDirName("/home/user/my_app.v2"); got != "-home-user-my-app-v2" {
+		t.Errorf("ProjectDirName = %q", got)
+	}
+}
+
+func TestSessionStore_List(t *testing.T) {
+	store, _ := sessionStoreFixture(t)
+
+	sessions, err := store.List(testProjectCWD)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("sessions = %d, want 2", len(sessions))
+	}
+	// 最終アクティビティの新しい順
+	if sessions[0].ID != "sess-b" || sessions[1].ID != "sess-a" {
+		t.Errorf("order = %s, %s", sessions[0].ID, sessions[1].ID)
+	}
+
+	a := sessions[1]
+	if a.FirstPrompt != "fix the build" {
+		t.Errorf("FirstPrompt = %q", a.FirstPrompt)
+	}
+	if a.MessageCount != 5 {
+		t.Errorf("MessageCount = %d, want 5", a.MessageCount)
+	}
+	if a.Model != "claude-opus-4" {
+		t.Errorf("Model = %q", a.Model)
+	}
+	if a.CostUSD < 0.0299 || a.CostUSD > 0.0301 {
+		t.Errorf("CostUSD = %v", a.CostUSD)
+	}
+	if a.Summary != "Fix build" || a.CWD != testProjectCWD || a.ForkParent != "" {
+		t.Errorf("info = %+v", a)
+	}
+	if want := time.Date(2025, 6, 1, 10, 0, 8, 0, time.UTC); !a.LastActivity.Equal(want) {
+		t.Errorf("LastActivity = %v", a.LastActivity)
+	}
+
+	b := sessions[0]
+	if b.ForkParent != "sess-a" || !b.Session().IsForked {
+		t.Errorf("ForkParent = %q", b.ForkParent)
+	}
+
+	none, err := store.List("/nonexistent")
+	if err != nil || len(none) != 0 {
+		t.Errorf("List(nonexistent) = %v, %v", none, err)
+	}
+}
+
+func TestSessionStore_Messages(t *testing.T) {
+	store, _ := sessionStoreFixture(t)
+
+	messages, err := store.Messages(testProjectCWD, "sess-a")
+	if err != nil {
+		t.Fatalf("Messages failed: %v", err)
+	}
+	if len(messages) != 5 {
+		t.Fatalf("messages = %d, want 5", len(messages))
+	}
+	user, ok := messages[1].(*protocol.UserMessage)
+	if !ok || user.Text() != "fix the build" || user.UUID != "u2" {
+		t.Errorf("messages[1] = %+v", messages[1])
+	}
+	assistant, ok := messages[2].(*protocol.AssistantMessage)
+	if !ok || len(assistant.ToolUses()) != 1 || assistant.ToolUses()[0].Name != "Bash" {
+		t.Errorf("messages[2] = %+v", messages[2])
+	}
+
+	_, err = store.Messages(testProjectCWD, "missing")
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestSessionStore_Labels(t *testing.T) {
+	store, _ := sessionStoreFixture(t)
+
+	if err := store.AddLabel(testProjectCWD, "sess-a", "keep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddLabel(testProjectCWD, "sess-a", "keep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetLabels(testProjectCWD, "sess-b", "wip", "bug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveLabel(testProjectCWD, "sess-b", "wip"); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := store.Get(testProjectCWD, "sess-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Labels) != 1 || !a.HasLabel("keep") {
+		t.Errorf("sess-a labels = %v", a.Labels)
+	}
+	b, _ := store.Get(testProjectCWD, "sess-b")
+	if len(b.Labels) != 1 || b.Labels[0] != "bug" {
+		t.Errorf("sess-b labels = %v", b.Labels)
+	}
+
+	if err := store.AddLabel(testProjectCWD, "missing", "x"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestSessionStore_Prune(t *testing.T) {
+	store, root := sessionStoreFixture(t)
+	writeTranscript(t, root, "sess-c",
+		`{"type":"user","uuid":"c1","sessionId":"sess-c","timestamp":"2025-06-03T09:00:00Z","message":{"role":"user","content":"latest"}}`,
+	)
+	if err := store.AddLabel(testProjectCWD, "sess-a", "pinned"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2025, 6, 3, 12, 0, 0, 0, time.UTC)
+	deleted, err := store.prune(testProjectCWD, RetentionPolicy{MaxAge: 24 * time.Hour, KeepLabels: []string{"pinned"}}, now)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "sess-b" {
+		t.Errorf("deleted = %v, want [sess-b]", deleted)
+	}
+
+	deleted, err = store.prune(testProjectCWD, RetentionPolicy{MaxSessions: 1}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "sess-a" {
+		t.Errorf("deleted = %v, want [sess-a]", deleted)
+	}
+
+	sessions, _ := store.List(testProjectCWD)
+	if len(sessions) != 1 || sessions[0].ID != "sess-c" {
+		t.Errorf("remaining = %+v", sessions)
+	}
+	if _, err := os.Stat(filepath.Join(root, ProjectDirName(testProjectCWD), sessionLabelsFile)); !os.IsNotExist(err) {
+		t.Errorf("labels file should be removed with the last label: %v", err)
+	}
+}
+
+func TestSessionStore_RejectsInvalidID(t *testing.T) {
+	store, root := sessionStoreFixture(t)
+
+	// プロジェクトディレクトリ外のディレクトリを削除できない
+	victim := filepath.Join(root, "victim")
+	if err := os.MkdirAll(victim, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(victim+".jsonl", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"", ".", "../victim", "a/b", `a\b`, "..", "sess..a"} {
+		if err := store.Delete(testProjectCWD, id); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Delete(%q) error = %v, want ErrInvalidConfig", id, err)
+		}
+		if _, err := store.Get(testProjectCWD, id); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Get(%q) error = %v, want ErrInvalidConfig", id, err)
+		}
+	}
+	if _, err := os.Stat(victim); err != nil {
+		t.Errorf("directory outside the project was removed: %v", err)
+	}
+}
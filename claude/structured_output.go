@@ -0,0 +1,145 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// OutputFormatTypeJSONSchema はJSON Schemaによる構造化出力
+const OutputFormatTypeJSONSchema = "json_schema"
+
+// OutputFormat は構造化出力の形式を表す
+type OutputFormat struct {
+	Type   string         // "json_schema"
+	Schema map[string]any // JSON Schema
+}
+
+// NewJSONSchemaOutputFormat はJSON Schemaを指定したOutputFormatを作成する
+func NewJSONSchemaOutputFormat(schema map[string]any) *OutputFormat {
+	return &OutputFormat{
+		Type:   OutputFormatTypeJSONSchema,
+		Schema: schema,
+	}
+}
+
+// OutputFormatFor はGoの型から導出したJSON SchemaのOutputFormatを作成する
+// 構造化出力はJSONオブジェクトとして返されるため、Tは構造体またはmapに限る
+func OutputFormatFor[T any]() (*OutputFormat, error) {
+	schema, err := structuredOutputSchema[T]()
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONSchemaOutputFormat(schema), nil
+}
+
+// structuredOutputSchema はTがオブジェクトとして表現できる型か確認し、スキーマを生成する
+func structuredOutputSchema[T any]() (map[string]any, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if (t.Kind() != reflect.Struct && t.Kind() != reflect.Map) || t == timeType {
+		return nil, fmt.Errorf("structured output type must be a struct or map, got %s", t)
+	}
+	return SchemaFor[T]()
+}
+
+// jsonSchema はCLI引数用にスキーマをJSON文字列に変換する
+func (f *OutputFormat) jsonSchema() string {
+	if f == nil || f.Schema == nil {
+		return ""
+	}
+	if f.Type != "" && f.Type != OutputFormatTypeJSONSchema {
+		return ""
+	}
+	data, err := json.Marshal(f.Schema)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// toProtocol はプロトコル層の型に変換する
+func (f *OutputFormat) toProtocol() *protocol.OutputFormat {
+	if f == nil || f.Schema == nil {
+		return nil
+	}
+	typ := f.Type
+	if typ == "" {
+		typ = OutputFormatTypeJSONSchema
+	}
+	return &protocol.OutputFormat{
+		Type:   typ,
+		Schema: f.Schema,
+	}
+}
+
+// QueryTyped はTの型から導出したスキーマで構造化出力を要求し、結果をTにデコードする
+//
+// 構造化出力がスキーマに適合しない場合は*StructuredOutputErrorを含むSDKErrorを返す。
+// optsは変更しない。
+func QueryTyped[T any](ctx context.Context, prompt string, opts *Options) (T, *QueryResult, error) {
+	var zero T
+
+	format, err := OutputFormatFor[T]()
+	if err != nil {
+		return zero, nil, &SDKError{Op: "structured_output", Err: ErrInvalidConfig, Details: err.Error()}
+	}
+
+	queryOpts := Options{}
+	if opts != nil {
+		queryOpts = *opts
+	}
+	queryOpts.OutputFormat = format
+
+	result, err := Query(ctx, prompt, &queryOpts)
+	if err != nil {
+		return zero, result, err
+	}
+
+	output, err := decodeStructuredOutput[T](result.Result, format.Schema)
+	if err != nil {
+		return zero, result, err
+	}
+	return output, result, nil
+}
+
+// DecodeStructuredOutput はResultMessageの構造化出力を検証してTにデコードする
+func DecodeStructuredOutput[T any](msg *protocol.ResultMessage) (T, error) {
+	schema, err := structuredOutputSchema[T]()
+	if err != nil {
+		var zero T
+		return zero, &SDKError{Op: "structured_output", Err: ErrInvalidConfig, Details: err.Error()}
+	}
+	return decodeStructuredOutput[T](msg, schema)
+}
+
+func decodeStructuredOutput[T any](msg *protocol.ResultMessage, schema map[string]any) (T, error) {
+	var output T
+
+	if msg == nil || msg.StructuredOutput == nil {
+		return output, &SDKError{
+			Op:  "structured_output",
+			Err: &StructuredOutputError{Violations: []string{"structured output is missing"}},
+		}
+	}
+
+	if violations := validateSchema(msg.StructuredOutput, schema, "$"); len(violations) > 0 {
+		return output, &SDKError{
+			Op:  "structured_output",
+			Err: &StructuredOutputError{Output: msg.StructuredOutput, Violations: violations},
+		}
+	}
+
+	if err := remarshal(msg.StructuredOutput, &output); err != nil {
+		return output, &SDKError{
+			Op:  "structured_output",
+			Err: &StructuredOutputError{Output: msg.StructuredOutput, Err: err},
+		}
+	}
+	return output, nil
+}
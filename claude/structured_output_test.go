@@ -0,0 +1,194 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+type structuredTestAnswer struct {
+	Summary string   `json:"summary"`
+	Score   int      `json:"score"`
+	Labels  []string `json:"labels,omitempty"`
+}
+
+// writeMockCLI はモックCLIスクリプトを一時ファイルに書き出す
+func writeMockCLI(t *testing.T, script string) string {
+	t.Helper()
+
+	tmpFile, err := os.CreateTemp("", "mock-cli-*.sh")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	if _, err := tmpFile.WriteString(script); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	tmpFile.Close()
+
+	if err := os.Chmod(tmpFile.Name(), 0755); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+	return tmpFile.Name()
+}
+
+func TestBuildQueryArgs_OutputFormat(t *testing.T) {
+	format, err := OutputFormatFor[structuredTestAnswer]()
+	if err != nil {
+		t.Fatalf("OutputFormatFor failed: %v", err)
+	}
+
+	args := buildQueryArgs("test", &Options{OutputFormat: format})
+
+	var schemaArg string
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "--json-schema" {
+			schemaArg = args[i+1]
+		}
+	}
+	if schemaArg == "" {
+		t.Fatal("missing arg: --json-schema")
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(schemaArg), &schema); err != nil {
+		t.Fatalf("--json-schema is not valid JSON: %v", err)
+	}
+	if schema["type"] != "object" {
+		t.Errorf("schema.type = %v, want object", schema["type"])
+	}
+}
+
+func TestClient_InitializeSendsOutputFormat(t *testing.T) {
+	format := NewJSONSchemaOutputFormat(map[string]any{"type": "object"})
+	client, mt := newTestClient(&Options{OutputFormat: format})
+
+	var got *protocol.OutputFormat
+	respondToControlRequests(client, mt, func(req map[string]any) (any, string) {
+		var initReq protocol.InitializeRequest
+		if err := remarshal(req, &initReq); err == nil {
+			got = initReq.OutputFormat
+		}
+		return map[string]any{}, ""
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := client.initialize(ctx); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+
+	if got == nil {
+		t.Fatal("output_format should be sent")
+	}
+	if got.Type != OutputFormatTypeJSONSchema {
+		t.Errorf("Type = %q, want %q", got.Type, OutputFormatTypeJSONSchema)
+	}
+	if got.Schema["type"] != "object" {
+		t.Errorf("Schema = %v", got.Schema)
+	}
+}
+
+func TestQueryTyped(t *testing.T) {
+	cliPath := writeMockCLI(t, `#!/bin/sh
+echo '{"type":"result","subtype":"success","is_error":false,"num_turns":1,"session_id":"typed-session","total_cost_usd":0.01,"usage":{"input_tokens":10,"output_tokens":5},"structured_output":{"summary":"ok","score":3,"labels":["a"]}}'
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := &Options{CLIPath: cliPath}
+	answer, result, err := QueryTyped[structuredTestAnswer](ctx, "Summarize", opts)
+	if err != nil {
+		t.Fatalf("QueryTyped failed: %v", err)
+	}
+
+	if answer.Summary != "ok" || answer.Score != 3 || len(answer.Labels) != 1 {
+		t.Errorf("answer = %+v", answer)
+	}
+	if result.SessionID != "typed-session" {
+		t.Errorf("SessionID = %q, want %q", result.SessionID, "typed-session")
+	}
+	if opts.OutputFormat != nil {
+		t.Error("QueryTyped should not modify caller's options")
+	}
+}
+
+func TestQueryTyped_InvalidOutput(t *testing.T) {
+	cliPath := writeMockCLI(t, `#!/bin/sh
+echo '{"type":"result","subtype":"success","is_error":false,"num_turns":1,"session_id":"typed-session","total_cost_usd":0.01,"usage":{"input_tokens":10,"output_tokens":5},"structured_output":{"summary":"ok","score":"high"}}'
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, result, err := QueryTyped[structuredTestAnswer](ctx, "Summarize", &Options{CLIPath: cliPath})
+	if err == nil {
+		t.Fatal("expected error for invalid structured output")
+	}
+	if result == nil {
+		t.Error("result should be returned with validation error")
+	}
+	if !errors.Is(err, ErrStructuredOutput) {
+		t.Errorf("expected ErrStructuredOutput, got %v", err)
+	}
+
+	var outErr *StructuredOutputError
+	if !errors.As(err, &outErr) {
+		t.Fatalf("expected *StructuredOutputError, got %T", err)
+	}
+	if len(outErr.Violations) != 1 {
+		t.Errorf("Violations = %v, want 1", outErr.Violations)
+	}
+	if outErr.Output["score"] != "high" {
+		t.Errorf("Output = %v", outErr.Output)
+	}
+}
+
+func TestQueryTyped_NonObjectType(t *testing.T) {
+	// CLIを起動する前に拒否する
+	opts := &Options{CLIPath: filepath.Join(t.TempDir(), "no-such-cli")}
+
+	if _, _, err := QueryTyped[[]structuredTestAnswer](context.Background(), "Summarize", opts); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("QueryTyped[[]T] error = %v, want ErrInvalidConfig", err)
+	}
+	if _, _, err := QueryTyped[string](context.Background(), "Summarize", opts); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("QueryTyped[string] error = %v, want ErrInvalidConfig", err)
+	}
+	if _, err := OutputFormatFor[map[string]int](); err != nil {
+		t.Errorf("OutputFormatFor[map] failed: %v", err)
+	}
+}
+
+func TestDecodeStructuredOutput_Missing(t *testing.T) {
+	_, err := DecodeStructuredOutput[structuredTestAnswer](&protocol.ResultMessage{Type: "result"})
+	if !errors.Is(err, ErrStructuredOutput) {
+		t.Errorf("expected ErrStructuredOutput, got %v", err)
+	}
+}
+
+func TestDecodeStructuredOutput_DecodeError(t *testing.T) {
+	type small struct {
+		Level int8 `json:"level"`
+	}
+	// スキーマ上は整数だが、int8に収まらない
+	_, err := DecodeStructuredOutput[small](&protocol.ResultMessage{
+		Type:             "result",
+		StructuredOutput: map[string]any{"level": 300.0},
+	})
+	if !errors.Is(err, ErrStructuredOutput) {
+		t.Errorf("expected ErrStructuredOutput, got %v", err)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Errorf("expected *json.UnmarshalTypeError to be reachable, got %v", err)
+	}
+}
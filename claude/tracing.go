@@ -0,0 +1,341 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/hooks"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// スパン名
+const (
+	SpanConnect      = "claude.connect"               // Connect（CLIプロセスの起動からinitializeまで）
+	SpanInitialize   = "claude.initialize"            // initializeの制御リクエスト
+	SpanTurn         = "claude.turn"                  // ユーザーメッセージの送信からresultまで
+	SpanToolUse      = "claude.tool_use"              // tool_useからtool_resultまで
+	SpanCanUseTool   = "claude.control.can_use_tool"  // CLIからの権限確認
+	SpanHookCallback = "claude.control.hook_callback" // CLIからのフック呼び出し
+	SpanMCPMessage   = "claude.control.mcp_message"   // CLIからのSDK MCPサーバー呼び出し
+	SpanHookCommand  = "claude.hook.command"          // コマンドフックの実行
+	// SDKからCLIへの制御リクエスト（interrupt, set_model等）は "claude.control.<subtype>" で表す
+	spanControlPrefix = "claude.control."
+)
+
+// スパン属性のキー
+const (
+	AttrSessionID           = "claude.session_id"
+	AttrModel               = "claude.model"
+	AttrToolName            = "claude.tool.name"
+	AttrToolUseID           = "claude.tool.use_id"
+	AttrToolIsError         = "claude.tool.is_error"
+	AttrInputTokens         = "claude.usage.input_tokens"
+	AttrOutputTokens        = "claude.usage.output_tokens"
+	AttrCacheCreationTokens = "claude.usage.cache_creation_input_tokens"
+	AttrCacheReadTokens     = "claude.usage.cache_read_input_tokens"
+	AttrCostUSD             = "claude.cost_usd"
+	AttrNumTurns            = "claude.num_turns"
+	AttrResultSubtype       = "claude.result.subtype"
+	AttrControlSubtype      = "claude.control.subtype"
+	AttrPermissionAllow     = "claude.permission.allow"
+	AttrHookEvent           = "claude.hook.event"
+	AttrHookCommand         = "claude.hook.command"
+	AttrHookExitCode        = "claude.hook.exit_code"
+	AttrMCPServer           = "claude.mcp.server"
+	AttrMCPMethod           = "claude.mcp.method"
+)
+
+// Tracer はSDKの処理をスパンとして記録する
+// OpenTelemetry等のトレーサーはこのインターフェースのアダプタを実装して利用する
+type Tracer interface {
+	// Start はスパンを開始し、スパンを含むコンテキストを返す
+	// 親スパンはctxから特定する
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span は開始済みのスパン
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Attribute はスパンの属性
+// Valueはstring, bool, 整数型, 浮動小数点型, time.Duration（ミリ秒）のいずれか（それ以外は文字列に変換される）
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Attr は属性を作成する
+func Attr(key string, value any) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// noopTracer はOptions.Tracer未設定時に使用する何も記録しないトレーサー
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+
+// tracer は設定されたトレーサー（未設定の場合は何も記録しないトレーサー）を返す
+func (o *Options) tracer() Tracer {
+	if o.Tracer == nil {
+		return noopTracer{}
+	}
+	return o.Tracer
+}
+
+// endSpan はエラーがあれば記録してスパンを終了する
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// spanContext はキャンセルと期限をContextから、値（スパン）をvaluesから引き継ぐコンテキスト
+// ターンやツール呼び出しのスパンを親にしつつ、制御リクエストのキャンセルに従うために使う
+type spanContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c spanContext) Value(key any) any {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+// tracedSpan は未完了のターンまたはツール呼び出しのスパン
+type tracedSpan struct {
+	// ctx はスパンを含むコンテキスト（子スパンの親として使用し、キャンセルは引き継がない）
+	ctx  context.Context
+	span Span
+	tool string // ツール名（ツール呼び出しのみ）
+
+	// usage はターン中のアシスタントメッセージの使用量（ターンのみ）
+	usage    protocol.Usage
+	messages map[string]bool // 集計済みのメッセージID
+}
+
+// turnTracer はメッセージからターンとツール呼び出しのスパンを記録する
+type turnTracer struct {
+	tracer Tracer
+
+	mu    sync.Mutex
+	turns []*tracedSpan          // resultを待っているターン（送信順）
+	tools map[string]*tracedSpan // tool_use ID → tool_resultを待っているツール呼び出し
+}
+
+func newTurnTracer(tracer Tracer) *turnTracer {
+	return &turnTracer{
+		tracer: tracer,
+		tools:  make(map[string]*tracedSpan),
+	}
+}
+
+// startTurn はターンのスパンを開始し、送信処理に使うコンテキストを返す
+// 送信に失敗した場合はabortTurnでスパンを終了する
+func (t *turnTracer) startTurn(ctx context.Context, attrs ...Attribute) (context.Context, *tracedSpan) {
+	spanCtx, span := t.tracer.Start(context.WithoutCancel(ctx), SpanTurn, attrs...)
+	turn := &tracedSpan{ctx: spanCtx, span: span, messages: make(map[string]bool)}
+
+	t.mu.Lock()
+	t.turns = append(t.turns, turn)
+	t.mu.Unlock()
+	return spanContext{Context: ctx, values: spanCtx}, turn
+}
+
+// abortTurn はresultを受信せずに終わったターンのスパンをエラーで終了する
+func (t *turnTracer) abortTurn(turn *tracedSpan, err error) {
+	t.mu.Lock()
+	for i, open := range t.turns {
+		if open == turn {
+			t.turns = append(t.turns[:i], t.turns[i+1:]...)
+			break
+		}
+	}
+	t.mu.Unlock()
+	endSpan(turn.span, err)
+}
+
+// observe は受信メッセージに応じてツール呼び出しとターンのスパンを開始・終了する
+func (t *turnTracer) observe(msg protocol.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch m := msg.(type) {
+	case *protocol.AssistantMessage:
+		parentID := ParentToolUseID(m)
+		if len(t.turns) > 0 {
+			t.turns[0].addUsage(m)
+			if parentID == "" && m.Message.Model != "" {
+				t.turns[0].span.SetAttributes(Attr(AttrModel, m.Message.Model))
+			}
+		}
+		for _, block := range m.ToolUses() {
+			if _, ok := t.tools[block.ID]; ok {
+				continue
+			}
+			// サブエージェントのツール呼び出しは起動したツール呼び出しの子とする
+			parent := t.parentContext(parentID, "")
+			if parent == nil {
+				parent = context.Background()
+			}
+			spanCtx, span := t.tracer.Start(parent, SpanToolUse,
+				Attr(AttrToolName, block.Name),
+				Attr(AttrToolUseID, block.ID),
+				Attr(AttrSessionID, m.SessionID),
+			)
+			t.tools[block.ID] = &tracedSpan{ctx: spanCtx, span: span, tool: block.Name}
+		}
+
+	case *protocol.UserMessage:
+		for _, block := range m.ToolResults() {
+			tool, ok := t.tools[block.ToolUseID]
+			if !ok {
+				continue
+			}
+			delete(t.tools, block.ToolUseID)
+			tool.span.SetAttributes(Attr(AttrToolIsError, block.IsError))
+			if block.IsError {
+				tool.span.RecordError(fmt.Errorf("tool %s returned an error", tool.tool))
+			}
+			tool.span.End()
+		}
+
+	case *protocol.ResultMessage:
+		if len(t.turns) == 0 {
+			return
+		}
+		turn := t.turns[0]
+		t.turns = t.turns[1:]
+		turn.span.SetAttributes(turn.resultAttributes(m)...)
+		if m.IsError {
+			turn.span.RecordError(resultMessageToError(m))
+		}
+		turn.span.End()
+	}
+}
+
+// addUsage はアシスタントメッセージの使用量をターンに加算する（同じメッセージIDは1回のみ）
+func (s *tracedSpan) addUsage(m *protocol.AssistantMessage) {
+	usage := m.Message.Usage
+	if usage == nil {
+		return
+	}
+	if id := m.Message.ID; id != "" {
+		if s.messages[id] {
+			return
+		}
+		s.messages[id] = true
+	}
+	s.usage.InputTokens += usage.InputTokens
+	s.usage.OutputTokens += usage.OutputTokens
+	s.usage.CacheCreationTokens += usage.CacheCreationTokens
+	s.usage.CacheReadTokens += usage.CacheReadTokens
+}
+
+// resultAttributes はターンの使用量とresultのコストをスパン属性に変換する
+// メッセージ単位の使用量が得られなかった場合はresultの使用量を使う
+func (s *tracedSpan) resultAttributes(m *protocol.ResultMessage) []Attribute {
+	usage := s.usage
+	if usage == (protocol.Usage{}) {
+		usage = m.Usage
+	}
+	return []Attribute{
+		Attr(AttrSessionID, m.SessionID),
+		Attr(AttrResultSubtype, m.Subtype),
+		Attr(AttrNumTurns, m.NumTurns),
+		Attr(AttrCostUSD, m.TotalCostUSD),
+		Attr(AttrInputTokens, usage.InputTokens),
+		Attr(AttrOutputTokens, usage.OutputTokens),
+		Attr(AttrCacheCreationTokens, usage.CacheCreationTokens),
+		Attr(AttrCacheReadTokens, usage.CacheReadTokens),
+	}
+}
+
+// parentContext は子スパンの親とするコンテキストを返す（t.muを保持して呼ぶ）
+// tool_use ID、ツール名、現在のターンの順に探し、いずれもない場合はnilを返す
+func (t *turnTracer) parentContext(toolUseID, toolName string) context.Context {
+	if tool, ok := t.tools[toolUseID]; ok {
+		return tool.ctx
+	}
+	if toolName != "" {
+		for _, tool := range t.tools {
+			if tool.tool == toolName {
+				return tool.ctx
+			}
+		}
+	}
+	if len(t.turns) > 0 {
+		return t.turns[0].ctx
+	}
+	return nil
+}
+
+// startCallback はCLIからの制御リクエストのスパンを、対応するツール呼び出しまたはターンの子として開始する
+func (t *turnTracer) startCallback(ctx context.Context, name, toolUseID, toolName string, attrs ...Attribute) (context.Context, Span) {
+	t.mu.Lock()
+	parent := t.parentContext(toolUseID, toolName)
+	t.mu.Unlock()
+	if parent == nil {
+		return t.tracer.Start(ctx, name, attrs...)
+	}
+
+	spanCtx, span := t.tracer.Start(parent, name, attrs...)
+	return spanContext{Context: ctx, values: spanCtx}, span
+}
+
+// endAll は未完了のスパンをすべてエラーで終了する
+func (t *turnTracer) endAll(err error) {
+	t.mu.Lock()
+	turns, tools := t.turns, t.tools
+	t.turns = nil
+	t.tools = make(map[string]*tracedSpan)
+	t.mu.Unlock()
+
+	for _, tool := range tools {
+		endSpan(tool.span, err)
+	}
+	for _, turn := range turns {
+		endSpan(turn.span, err)
+	}
+}
+
+// observeHookCommand はコマンドフックの実行をスパンとして記録する（hooks.ExecuteObserver）
+func (t *turnTracer) observeHookCommand(ctx context.Context, command string, input *hooks.Input) (context.Context, func(int, error)) {
+	ctx, span := t.tracer.Start(ctx, SpanHookCommand,
+		Attr(AttrHookEvent, input.HookEventName),
+		Attr(AttrHookCommand, command),
+		Attr(AttrToolName, input.ToolName),
+		Attr(AttrSessionID, input.SessionID),
+	)
+	return ctx, func(exitCode int, err error) {
+		span.SetAttributes(Attr(AttrHookExitCode, exitCode))
+		endSpan(span, err)
+	}
+}
+
+// mcpToolName はtools/callのMCPメッセージから、CLIが使用するツール名（mcp__<サーバー>__<ツール>）を返す
+func mcpToolName(serverName string, message map[string]any) string {
+	if method, _ := message["method"].(string); method != "tools/call" {
+		return ""
+	}
+	params, _ := message["params"].(map[string]any)
+	name, _ := params["name"].(string)
+	if name == "" {
+		return ""
+	}
+	return "mcp__" + serverName + "__" + name
+}
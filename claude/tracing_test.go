@@ -0,0 +1,218 @@
+package claude
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+)
+
+// exportedSpan はテストで読み取るOTLP/JSONのスパン
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+// attr は属性の値（stringValue, intValue等）を返す
+func (s exportedSpan) attr(key string) any {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			for _, v := range a.Value {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// readSpans はOTLP/JSONの各行からスパンを読み取る
+func readSpans(t *testing.T, data []byte) []exportedSpan {
+	t.Helper()
+	var spans []exportedSpan
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var req struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []map[string]any `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatalf("invalid OTLP JSON line %q: %v", scanner.Text(), err)
+		}
+		for _, rs := range req.ResourceSpans {
+			if len(rs.Resource.Attributes) == 0 {
+				t.Errorf("resource attributes missing: %s", scanner.Text())
+			}
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func spansByName(spans []exportedSpan) map[string][]exportedSpan {
+	result := make(map[string][]exportedSpan)
+	for _, s := range spans {
+		result[s.Name] = append(result[s.Name], s)
+	}
+	return result
+}
+
+func TestOTLPTracer_Format(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewOTLPTracer(&buf, "test-service")
+
+	ctx, parent := tracer.Start(context.Background(), "parent", Attr("s", "v"), Attr("n", 3))
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttributes(Attr("f", 0.5), Attr("b", true), Attr("d", 1500*time.Millisecond), Attr("n", int64(1)))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End() // 2回目は無視される
+	parent.End()
+
+	spans := readSpans(t, buf.Bytes())
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if len(p.TraceID) != 32 || len(p.SpanID) != 16 || p.ParentSpanID != "" {
+		t.Errorf("parent ids = %+v", p)
+	}
+	if c.TraceID != p.TraceID || c.ParentSpanID != p.SpanID {
+		t.Errorf("child not linked to parent: %+v / %+v", c, p)
+	}
+	if p.attr("s") != "v" || p.attr("n") != "3" || p.Status.Code != otlpStatusOK {
+		t.Errorf("parent = %+v", p)
+	}
+	// 同じキーは後の値を優先し、64ビット整数は文字列で表す
+	if c.attr("f") != 0.5 || c.attr("b") != true || c.attr("d") != "1500" || c.attr("n") != "1" {
+		t.Errorf("child attributes = %+v", c.Attributes)
+	}
+	if c.Status.Code != otlpStatusError || c.Status.Message != "boom" {
+		t.Errorf("child status = %+v", c.Status)
+	}
+}
+
+func TestClient_TracesTurn(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		SessionID: "sess-trace",
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionAssistant, Text: "checking", Usage: &fakecli.Usage{InputTokens: 12, OutputTokens: 3}},
+			{Action: fakecli.ActionToolUse, ToolUseID: "toolu_1", ToolName: "Bash", Input: map[string]any{"command": "ls"}},
+			{Action: fakecli.ActionCanUseTool, ToolName: "Bash", Input: map[string]any{"command": "ls"},
+				Expect: map[string]any{"allow": true}},
+			{Action: fakecli.ActionHookCallback, HookEvent: "PreToolUse", ToolName: "Bash", ToolUseID: "toolu_1",
+				Input: map[string]any{"command": "ls"}},
+			{Action: fakecli.ActionToolResult, ToolUseID: "toolu_1", Content: "file.txt"},
+			{Action: fakecli.ActionResult, Result: "done", CostUSD: 0.01},
+		},
+	})
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	tracer, err := NewOTLPFileTracer(path, "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runFakeTurn(t, &Options{
+		CLIPath: cliPath,
+		Tracer:  tracer,
+		CanUseTool: func(context.Context, string, map[string]any, *ToolPermissionContext) (*PermissionResult, error) {
+			return &PermissionResult{Allow: true}, nil
+		},
+		Hooks: &HookConfig{
+			PreToolUse: []HookEntry{{Type: HookTypeCommand, Command: `echo '{"continue": true}'`}},
+		},
+	}, "list files")
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := spansByName(readSpans(t, data))
+	for _, name := range []string{SpanConnect, SpanInitialize, SpanTurn, SpanToolUse, SpanCanUseTool, SpanHookCallback, SpanHookCommand} {
+		if len(spans[name]) != 1 {
+			t.Fatalf("%s: got %d spans (all: %v)", name, len(spans[name]), spans)
+		}
+	}
+
+	connect, initialize := spans[SpanConnect][0], spans[SpanInitialize][0]
+	if initialize.ParentSpanID != connect.SpanID {
+		t.Error("initialize should be a child of connect")
+	}
+
+	turn := spans[SpanTurn][0]
+	if turn.attr(AttrSessionID) != "sess-trace" || turn.attr(AttrModel) != "fake-model" {
+		t.Errorf("turn attributes = %+v", turn.Attributes)
+	}
+	if turn.attr(AttrInputTokens) != "12" || turn.attr(AttrOutputTokens) != "3" || turn.attr(AttrCostUSD) != 0.01 {
+		t.Errorf("turn usage attributes = %+v", turn.Attributes)
+	}
+
+	tool := spans[SpanToolUse][0]
+	if tool.ParentSpanID != turn.SpanID || tool.attr(AttrToolName) != "Bash" || tool.attr(AttrToolIsError) != false {
+		t.Errorf("tool span = %+v", tool)
+	}
+	permission := spans[SpanCanUseTool][0]
+	if permission.ParentSpanID != tool.SpanID || permission.attr(AttrPermissionAllow) != true {
+		t.Errorf("can_use_tool span = %+v", permission)
+	}
+	hook := spans[SpanHookCallback][0]
+	if hook.ParentSpanID != tool.SpanID || hook.attr(AttrHookEvent) != "PreToolUse" {
+		t.Errorf("hook_callback span = %+v", hook)
+	}
+	command := spans[SpanHookCommand][0]
+	if command.ParentSpanID != hook.SpanID || command.attr(AttrHookExitCode) != "0" {
+		t.Errorf("hook command span = %+v", command)
+	}
+}
+
+func TestQuery_TracesTurn(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionResult, IsError: true, Result: "failed"},
+		},
+	})
+
+	var buf bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := Query(ctx, "hello", &Options{CLIPath: cliPath, Tracer: NewOTLPTracer(&buf, "agent")}); err == nil {
+		t.Fatal("expected error result")
+	}
+
+	spans := spansByName(readSpans(t, buf.Bytes()))
+	if len(spans[SpanConnect]) != 1 || len(spans[SpanTurn]) != 1 {
+		t.Fatalf("spans = %v", spans)
+	}
+	if turn := spans[SpanTurn][0]; turn.Status.Code != otlpStatusError || turn.attr(AttrSessionID) != "fake-session" {
+		t.Errorf("turn = %+v", turn)
+	}
+}
@@ -0,0 +1,397 @@
+package claude
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// UsageTotals はトークン使用量とコストの累計
+type UsageTotals struct {
+	InputTokens         int64
+	OutputTokens        int64
+	CacheCreationTokens int64 // キャッシュ作成の入力トークン
+	CacheReadTokens     int64 // キャッシュ読み込みの入力トークン
+	CostUSD             float64
+	APICalls            int // 使用量を含むアシスタントメッセージ（API呼び出し）の数
+	Turns               int // 受信したresultの数
+}
+
+// TotalTokens はキャッシュを含むすべてのトークン数を返す
+func (u UsageTotals) TotalTokens() int64 {
+	return u.InputTokens + u.OutputTokens + u.CacheCreationTokens + u.CacheReadTokens
+}
+
+func (u *UsageTotals) addUsage(usage protocol.Usage) {
+	u.InputTokens += int64(usage.InputTokens)
+	u.OutputTokens += int64(usage.OutputTokens)
+	u.CacheCreationTokens += int64(usage.CacheCreationTokens)
+	u.CacheReadTokens += int64(usage.CacheReadTokens)
+}
+
+// UsageSnapshot はある時点の使用量の集計
+type UsageSnapshot struct {
+	Total      UsageTotals
+	BySession  map[string]UsageTotals // セッションIDごと
+	ByModel    map[string]UsageTotals // モデルごと
+	BySubagent map[string]UsageTotals // サブエージェント名（subagent_type）ごと。CLIはサブエージェント単位のコストを報告しないためCostUSDは0
+	UpdatedAt  time.Time
+}
+
+// usageSourceCounter はCLIプロセス（接続）ごとに一意な集計元IDを発行する
+var usageSourceCounter atomic.Uint64
+
+func nextUsageSource() uint64 {
+	return usageSourceCounter.Add(1)
+}
+
+// usageBaseline はCLIプロセス内の累計値（コスト）の前回値
+type usageBaseline struct {
+	cost          float64
+	modelCost     map[string]float64
+	turnHasUsages bool // 前回のresult以降にメッセージ単位の使用量を集計したか
+
+	// ターン内で記録したseenMessages・subagentsのキー（resultでターンが閉じたら削除する）
+	messageIDs []string
+	toolUseIDs []string
+}
+
+// UsageTracker はメッセージから使用量とコストを累計する
+// トークン数はアシスタントメッセージの使用量（同じメッセージIDは1回のみ）から集計し、
+// コストはresultのtotal_cost_usd・modelUsage（CLIプロセス内の累計）の増分から集計する
+// 複数のClientで共有でき、Options.Usageに設定すると自動的に集計される
+type UsageTracker struct {
+	mu         sync.Mutex
+	total      UsageTotals
+	bySession  map[string]*UsageTotals
+	byModel    map[string]*UsageTotals
+	bySubagent map[string]*UsageTotals
+	updatedAt  time.Time
+
+	seenMessages map[string]bool   // 集計済みのアシスタントメッセージID
+	subagents    map[string]string // サブエージェントを起動したtool_use ID → サブエージェント名
+	baselines    map[usageKey]*usageBaseline
+
+	listeners   map[int]func(UsageSnapshot)
+	nextID      int
+	subscribers map[int]chan UsageSnapshot
+}
+
+type usageKey struct {
+	source    uint64
+	sessionID string
+}
+
+// NewUsageTracker は新しいUsageTrackerを作成する
+func NewUsageTracker() *UsageTracker {
+	t := &UsageTracker{
+		listeners:   make(map[int]func(UsageSnapshot)),
+		subscribers: make(map[int]chan UsageSnapshot),
+	}
+	t.reset()
+	return t
+}
+
+func (t *UsageTracker) reset() {
+	t.total = UsageTotals{}
+	t.bySession = make(map[string]*UsageTotals)
+	t.byModel = make(map[string]*UsageTotals)
+	t.bySubagent = make(map[string]*UsageTotals)
+	t.seenMessages = make(map[string]bool)
+	t.subagents = make(map[string]string)
+	t.baselines = make(map[usageKey]*usageBaseline)
+}
+
+// Reset は集計をすべてクリアする
+func (t *UsageTracker) Reset() {
+	t.mu.Lock()
+	t.reset()
+	snapshot, listeners := t.publish()
+	t.mu.Unlock()
+	notifyListeners(listeners, snapshot)
+}
+
+// Track はメッセージを集計に反映する
+// Clientを使わずにメッセージを集計する場合（QueryResult.Messagesなど）に使用する
+func (t *UsageTracker) Track(msg protocol.Message) {
+	t.track(0, msg)
+}
+
+// track は集計元（CLIプロセス）を区別してメッセージを集計する
+func (t *UsageTracker) track(source uint64, msg protocol.Message) {
+	t.mu.Lock()
+	var changed bool
+	switch m := msg.(type) {
+	case *protocol.AssistantMessage:
+		changed = t.trackAssistant(source, m)
+	case *protocol.ResultMessage:
+		changed = t.trackResult(source, m)
+	}
+	if !changed {
+		t.mu.Unlock()
+		return
+	}
+	snapshot, listeners := t.publish()
+	t.mu.Unlock()
+
+	notifyListeners(listeners, snapshot)
+}
+
+// trackAssistant はアシスタントメッセージの使用量を集計する（t.muを保持して呼ぶ）
+func (t *UsageTracker) trackAssistant(source uint64, m *protocol.AssistantMessage) bool {
+	base := t.baseline(source, m.SessionID)
+	for _, block := range m.ToolUses() {
+		if subagentToolNames[block.Name] {
+			agent, _ := block.Input["subagent_type"].(string)
+			t.subagents[block.ID] = agent
+			base.toolUseIDs = append(base.toolUseIDs, block.ID)
+		}
+	}
+
+	usage := m.Message.Usage
+	if usage == nil {
+		return false
+	}
+	// CLIは1回のAPI応答をコンテンツブロックごとに同じメッセージIDで複数回出力する
+	if id := m.Message.ID; id != "" {
+		if t.seenMessages[id] {
+			return false
+		}
+		t.seenMessages[id] = true
+		base.messageIDs = append(base.messageIDs, id)
+	}
+
+	apply := func(u *UsageTotals) {
+		u.addUsage(*usage)
+		u.APICalls++
+	}
+	apply(&t.total)
+	if m.SessionID != "" {
+		apply(totalsFor(t.bySession, m.SessionID))
+	}
+	if m.Message.Model != "" {
+		apply(totalsFor(t.byModel, m.Message.Model))
+	}
+	if parent := ParentToolUseID(m); parent != "" {
+		apply(totalsFor(t.bySubagent, t.subagents[parent]))
+	}
+	base.turnHasUsages = true
+	return true
+}
+
+// trackResult はresultのコストを集計する（t.muを保持して呼ぶ）
+func (t *UsageTracker) trackResult(source uint64, m *protocol.ResultMessage) bool {
+	base := t.baseline(source, m.SessionID)
+	session := totalsFor(t.bySession, m.SessionID)
+
+	cost := cumulativeDelta(base.cost, m.TotalCostUSD)
+	base.cost = m.TotalCostUSD
+	t.total.CostUSD += cost
+	session.CostUSD += cost
+	t.total.Turns++
+	session.Turns++
+
+	for model, usage := range m.ModelUsage {
+		delta := cumulativeDelta(base.modelCost[model], usage.CostUSD)
+		base.modelCost[model] = usage.CostUSD
+		totalsFor(t.byModel, model).CostUSD += delta
+	}
+
+	// メッセージ単位の使用量が得られなかったターンはresultの使用量で補う
+	if !base.turnHasUsages {
+		t.total.addUsage(m.Usage)
+		session.addUsage(m.Usage)
+	}
+	base.turnHasUsages = false
+
+	// ターンが閉じたのでメッセージ単位の重複排除の状態を破棄する
+	t.endTurn(base)
+	if m.SessionID != "" {
+		if unnamed, ok := t.baselines[usageKey{source: source}]; ok {
+			t.endTurn(unnamed)
+		}
+	}
+	return true
+}
+
+// endTurn はターン内で記録したメッセージIDとサブエージェントを破棄する（t.muを保持して呼ぶ）
+func (t *UsageTracker) endTurn(base *usageBaseline) {
+	for _, id := range base.messageIDs {
+		delete(t.seenMessages, id)
+	}
+	for _, id := range base.toolUseIDs {
+		delete(t.subagents, id)
+	}
+	base.messageIDs = nil
+	base.toolUseIDs = nil
+}
+
+// release はCLIプロセスの終了時に集計元の前回値と重複排除の状態を破棄する
+func (t *UsageTracker) release(source uint64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, base := range t.baselines {
+		if key.source == source {
+			t.endTurn(base)
+			delete(t.baselines, key)
+		}
+	}
+}
+
+// baseline は集計元とセッションの前回値を取得または作成する（t.muを保持して呼ぶ）
+func (t *UsageTracker) baseline(source uint64, sessionID string) *usageBaseline {
+	key := usageKey{source: source, sessionID: sessionID}
+	base, ok := t.baselines[key]
+	if !ok {
+		base = &usageBaseline{modelCost: make(map[string]float64)}
+		t.baselines[key] = base
+	}
+	return base
+}
+
+// cumulativeDelta は累計値の増分を返す
+// 値が減少した場合はCLIプロセスが入れ替わったとみなし、現在値をそのまま増分とする
+func cumulativeDelta(prev, current float64) float64 {
+	if current < prev {
+		return current
+	}
+	return current - prev
+}
+
+func totalsFor(m map[string]*UsageTotals, key string) *UsageTotals {
+	u, ok := m[key]
+	if !ok {
+		u = &UsageTotals{}
+		m[key] = u
+	}
+	return u
+}
+
+// Snapshot は現在の集計を返す
+func (t *UsageTracker) Snapshot() UsageSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot()
+}
+
+// snapshot は集計のコピーを作成する（t.muを保持して呼ぶ）
+func (t *UsageTracker) snapshot() UsageSnapshot {
+	return UsageSnapshot{
+		Total:      t.total,
+		BySession:  copyTotals(t.bySession),
+		ByModel:    copyTotals(t.byModel),
+		BySubagent: copyTotals(t.bySubagent),
+		UpdatedAt:  t.updatedAt,
+	}
+}
+
+func copyTotals(m map[string]*UsageTotals) map[string]UsageTotals {
+	result := make(map[string]UsageTotals, len(m))
+	for k, v := range m {
+		result[k] = *v
+	}
+	return result
+}
+
+// OnChange は集計が更新されるたびに呼ばれるコールバックを登録し、登録を解除する関数を返す
+// コールバックはメッセージの受信ループ内で同期的に呼ばれるため、ブロックしてはならない
+func (t *UsageTracker) OnChange(fn func(UsageSnapshot)) (remove func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := t.nextID
+	t.nextID++
+	t.listeners[id] = fn
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.listeners, id)
+	}
+}
+
+// Subscribe は集計の更新を受け取るチャネルを返す
+// 受信が追いつかない場合は古いスナップショットを捨てて最新のものだけを保持する
+// 返された関数を呼ぶと購読を解除してチャネルをクローズする
+func (t *UsageTracker) Subscribe() (<-chan UsageSnapshot, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := t.nextID
+	t.nextID++
+	ch := make(chan UsageSnapshot, 1)
+	t.subscribers[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			delete(t.subscribers, id)
+			close(ch)
+		})
+	}
+}
+
+// publish は更新時刻を記録して購読者に最新のスナップショットを送り、通知するコールバックを返す（t.muを保持して呼ぶ）
+// 購読者への送信をロック内で行うことで、スナップショットが更新順に届くようにする
+func (t *UsageTracker) publish() (UsageSnapshot, []func(UsageSnapshot)) {
+	t.updatedAt = time.Now()
+	snapshot := t.snapshot()
+
+	for _, ch := range t.subscribers {
+		// 未受信の古いスナップショットを最新のものに置き換える
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- snapshot:
+		default:
+		}
+	}
+
+	listeners := make([]func(UsageSnapshot), 0, len(t.listeners))
+	for _, fn := range t.listeners {
+		listeners = append(listeners, fn)
+	}
+	return snapshot, listeners
+}
+
+func notifyListeners(listeners []func(UsageSnapshot), snapshot UsageSnapshot) {
+	for _, fn := range listeners {
+		fn(snapshot)
+	}
+}
+
+// observeMessage は受信メッセージを配信前に集計し、予算に計上してスパンとメトリクスを記録する
+func (c *Client) observeMessage(msg protocol.Message) {
+	c.traces.observe(msg)
+
+	source := c.usageSource.Load()
+	c.usage.track(source, msg)
+	if c.opts.Budget != nil {
+		c.opts.Budget.observe(source, msg)
+	}
+	c.opts.Metrics.observe(source, msg)
+}
+
+// releaseUsage は終了したCLIプロセスの集計状態を破棄する
+func (c *Client) releaseUsage(source uint64) {
+	c.usage.release(source)
+	if c.opts.Budget != nil {
+		c.opts.Budget.release(source)
+	}
+	if c.opts.Metrics != nil {
+		c.opts.Metrics.usage.release(source)
+	}
+}
+
+// Usage はこのClientの使用量の集計を返す（Options.Usageを設定した場合は共有の集計）
+func (c *Client) Usage() *UsageTracker {
+	return c.usage
+}
@@ -0,0 +1,214 @@
+package claude
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func assistantWithUsage(id, model, sessionID string, parent *string, usage protocol.Usage, blocks ...protocol.Block) *protocol.AssistantMessage {
+	return &protocol.AssistantMessage{
+		Type:            "assistant",
+		SessionID:       sessionID,
+		ParentToolUseID: parent,
+		Message: protocol.AssistantBody{
+			ID:      id,
+			Role:    "assistant",
+			Model:   model,
+			Content: blocks,
+			Usage:   &usage,
+		},
+	}
+}
+
+func TestUsageTracker_Aggregates(t *testing.T) {
+	tracker := NewUsageTracker()
+	parent := "toolu_task"
+
+	taskBlock := &protocol.ToolUseBlock{ID: parent, Name: "Task", Input: map[string]any{"subagent_type": "reviewer"}}
+	tracker.Track(assistantWithUsage("msg_1", "opus", "s1", nil,
+		protocol.Usage{InputTokens: 100, OutputTokens: 10, CacheCreationTokens: 50, CacheReadTokens: 200}, taskBlock))
+	// 同じメッセージIDは1回のみ集計する
+	tracker.Track(assistantWithUsage("msg_1", "opus", "s1", nil,
+		protocol.Usage{InputTokens: 100, OutputTokens: 10, CacheCreationTokens: 50, CacheReadTokens: 200}))
+	tracker.Track(assistantWithUsage("msg_2", "haiku", "s1", &parent, protocol.Usage{InputTokens: 30, OutputTokens: 3}))
+	tracker.Track(&protocol.ResultMessage{
+		Type: "result", SessionID: "s1", TotalCostUSD: 0.05,
+		Usage: protocol.Usage{InputTokens: 999},
+		ModelUsage: map[string]protocol.ModelUsage{
+			"opus":  {CostUSD: 0.04},
+			"haiku": {CostUSD: 0.01},
+		},
+	})
+
+	snap := tracker.Snapshot()
+	if snap.Total.InputTokens != 130 || snap.Total.OutputTokens != 13 || snap.Total.CacheCreationTokens != 50 || snap.Total.CacheReadTokens != 200 {
+		t.Errorf("Total = %+v", snap.Total)
+	}
+	if snap.Total.TotalTokens() != 393 || snap.Total.APICalls != 2 || snap.Total.Turns != 1 {
+		t.Errorf("Total = %+v", snap.Total)
+	}
+	if !approxEqual(snap.Total.CostUSD, 0.05) || !approxEqual(snap.BySession["s1"].CostUSD, 0.05) {
+		t.Errorf("cost = %v / %v", snap.Total.CostUSD, snap.BySession["s1"].CostUSD)
+	}
+	if opus := snap.ByModel["opus"]; opus.InputTokens != 100 || !approxEqual(opus.CostUSD, 0.04) {
+		t.Errorf("ByModel[opus] = %+v", opus)
+	}
+	if reviewer := snap.BySubagent["reviewer"]; reviewer.InputTokens != 30 || reviewer.APICalls != 1 {
+		t.Errorf("BySubagent[reviewer] = %+v", reviewer)
+	}
+	if snap.UpdatedAt.IsZero() {
+		t.Error("UpdatedAt should be set")
+	}
+}
+
+func TestUsageTracker_CumulativeCost(t *testing.T) {
+	tracker := NewUsageTracker()
+
+	// 同じプロセスのresultは累計値
+	tracker.track(1, &protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: 0.02})
+	tracker.track(1, &protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: 0.05})
+	// 別のプロセスは0から累計される
+	tracker.track(2, &protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: 0.01})
+	// 値が減少した場合はプロセスの入れ替わりとみなす
+	tracker.track(2, &protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: 0.004})
+
+	snap := tracker.Snapshot()
+	if !approxEqual(snap.Total.CostUSD, 0.064) {
+		t.Errorf("CostUSD = %v, want 0.064", snap.Total.CostUSD)
+	}
+	if snap.BySession["s1"].Turns != 4 {
+		t.Errorf("Turns = %d", snap.BySession["s1"].Turns)
+	}
+}
+
+func TestUsageTracker_PrunesTurnState(t *testing.T) {
+	tracker := NewUsageTracker()
+	taskBlock := &protocol.ToolUseBlock{ID: "toolu_task", Name: "Task", Input: map[string]any{"subagent_type": "reviewer"}}
+	tracker.track(1, assistantWithUsage("msg_1", "opus", "s1", nil, protocol.Usage{InputTokens: 10}, taskBlock))
+	tracker.track(1, assistantWithUsage("msg_2", "opus", "", nil, protocol.Usage{InputTokens: 5}))
+	if len(tracker.seenMessages) != 2 || len(tracker.subagents) != 1 {
+		t.Fatalf("seenMessages = %d, subagents = %d", len(tracker.seenMessages), len(tracker.subagents))
+	}
+
+	// resultでターンが閉じたらメッセージ単位の状態を破棄する
+	tracker.track(1, &protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: 0.02})
+	if len(tracker.seenMessages) != 0 || len(tracker.subagents) != 0 {
+		t.Errorf("after result: seenMessages = %d, subagents = %d", len(tracker.seenMessages), len(tracker.subagents))
+	}
+
+	// 累計コストの前回値はプロセスが終了するまで保持する
+	tracker.track(1, &protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: 0.05})
+	if got := tracker.Snapshot().Total.CostUSD; !approxEqual(got, 0.05) {
+		t.Errorf("CostUSD = %v, want 0.05", got)
+	}
+	tracker.release(1)
+	if len(tracker.baselines) != 0 {
+		t.Errorf("baselines = %d after release", len(tracker.baselines))
+	}
+}
+
+func TestUsageTracker_ResultUsageFallback(t *testing.T) {
+	tracker := NewUsageTracker()
+	tracker.Track(&protocol.ResultMessage{Type: "result", SessionID: "s1", Usage: protocol.Usage{InputTokens: 7, OutputTokens: 3}})
+
+	if got := tracker.Snapshot().BySession["s1"]; got.InputTokens != 7 || got.OutputTokens != 3 {
+		t.Errorf("BySession[s1] = %+v", got)
+	}
+}
+
+func TestUsageTracker_Notifications(t *testing.T) {
+	tracker := NewUsageTracker()
+
+	var calls int
+	remove := tracker.OnChange(func(UsageSnapshot) { calls++ })
+	ch, cancel := tracker.Subscribe()
+
+	for i := 1; i <= 3; i++ {
+		tracker.Track(&protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: float64(i)})
+	}
+	if calls != 3 {
+		t.Errorf("OnChange calls = %d, want 3", calls)
+	}
+	// 受信しなかったスナップショットは最新のものに置き換わる
+	select {
+	case snap := <-ch:
+		if snap.Total.Turns != 3 {
+			t.Errorf("latest snapshot Turns = %d, want 3", snap.Total.Turns)
+		}
+	default:
+		t.Fatal("expected a snapshot")
+	}
+
+	remove()
+	cancel()
+	tracker.Track(&protocol.ResultMessage{Type: "result", SessionID: "s1", TotalCostUSD: 4})
+	if calls != 3 {
+		t.Errorf("OnChange called after remove")
+	}
+	if _, ok := <-ch; ok {
+		t.Error("channel should be closed after cancel")
+	}
+}
+
+func TestClient_UsageAcrossTurns(t *testing.T) {
+	cliPath := installFakeCLI(t, &fakecli.Scenario{
+		SessionID: "sess-usage",
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionAssistant, Text: "one", Usage: &fakecli.Usage{InputTokens: 10, OutputTokens: 1, CacheReadInputTokens: 5}},
+			{Action: fakecli.ActionResult, CostUSD: 0.01},
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionAssistant, Text: "two", Usage: &fakecli.Usage{InputTokens: 20, OutputTokens: 2}},
+			{Action: fakecli.ActionResult, CostUSD: 0.03},
+		},
+	})
+
+	shared := NewUsageTracker()
+	client := NewClient(&Options{CLIPath: cliPath, Usage: shared})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	for _, prompt := range []string{"first", "second"} {
+		if err := stream.Send(ctx, prompt); err != nil {
+			t.Fatal(err)
+		}
+		for done := false; !done; {
+			select {
+			case msg := <-stream.Messages():
+				_, done = msg.(*protocol.ResultMessage)
+			case <-ctx.Done():
+				t.Fatal("timeout")
+			}
+		}
+	}
+
+	if client.Usage() != shared {
+		t.Error("Client should use the shared tracker")
+	}
+	snap := shared.Snapshot()
+	session := snap.BySession["sess-usage"]
+	if session.InputTokens != 30 || session.OutputTokens != 3 || session.CacheReadTokens != 5 || session.Turns != 2 {
+		t.Errorf("BySession = %+v", session)
+	}
+	if !approxEqual(session.CostUSD, 0.03) {
+		t.Errorf("CostUSD = %v, want 0.03", session.CostUSD)
+	}
+	if snap.ByModel["fake-model"].APICalls != 2 {
+		t.Errorf("ByModel = %+v", snap.ByModel)
+	}
+}
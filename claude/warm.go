@@ -0,0 +1,337 @@
+package claude
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+	"github.com/y-oga-819/my-go-claude-agent/internal/transport"
+)
+
+// WarmPoolConfig はウォームプールの設定
+type WarmPoolConfig struct {
+	Size          int           // 待機させる初期化済みプロセス数（デフォルト: 2）
+	RetryInterval time.Duration // 起動・初期化に失敗した場合に再試行するまでの間隔（デフォルト: 5秒）
+}
+
+// DefaultWarmPoolConfig はデフォルトのウォームプール設定を返す
+func DefaultWarmPoolConfig() *WarmPoolConfig {
+	return &WarmPoolConfig{
+		Size:          2,
+		RetryInterval: 5 * time.Second,
+	}
+}
+
+// withDefaults は未設定の項目をデフォルト値で補完した設定を返す
+func (c *WarmPoolConfig) withDefaults() WarmPoolConfig {
+	def := DefaultWarmPoolConfig()
+	cfg := WarmPoolConfig{}
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = def.Size
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = def.RetryInterval
+	}
+	return cfg
+}
+
+// WarmPoolStats はウォームプールの状態
+type WarmPoolStats struct {
+	Ready      int    // 初期化済みで待機中のプロセス数
+	Starting   int    // 起動・初期化中のプロセス数
+	Hits       uint64 // Connectで初期化済みプロセスを使用した回数
+	Misses     uint64 // 待機中のプロセスがなくConnectが通常どおり起動した回数
+	Mismatches uint64 // オプションが異なるため使用を拒否した回数
+	Failures   uint64 // 起動・初期化に失敗した回数
+	Discarded  uint64 // 待機中に終了していたため破棄したプロセス数
+}
+
+// WarmPool はCLIプロセスを起動してinitializeまで済ませた状態で待機させ、Connectの待ち時間を短縮する
+// Options.WarmPoolに設定すると、Connectは待機中のプロセスを使用し、バックグラウンドで補充する
+//
+// プロセスはNewWarmPoolに渡したオプションの指紋（CLI・作業ディレクトリ・モデル・ツール・MCP・フック等、
+// CLIの起動引数とinitializeの内容）に対して用意され、指紋が異なるClientには使用しない
+type WarmPool struct {
+	spec   warmSpec
+	config WarmPoolConfig
+
+	newTransport   func(transport.Config) transport.Transport
+	connectTimeout time.Duration
+	logger         *slog.Logger
+
+	// ctx は起動・初期化中の処理の寿命（Closeでキャンセルする）
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// wake は補充ループを起こす
+	wake chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	ready    []*warmProcess
+	starting int
+	stats    WarmPoolStats
+}
+
+// warmSpec はプロセスの起動と初期化の内容と、その指紋
+type warmSpec struct {
+	fingerprint string
+	transport   transport.Config
+	initialize  protocol.InitializeRequest
+}
+
+// warmProcess は初期化済みのCLIプロセス
+type warmProcess struct {
+	transport transport.Transport
+	initResp  *protocol.ControlResponse
+}
+
+// NewWarmPool はoptsの設定で初期化したCLIプロセスを待機させるプールを作成する
+// configがnilの場合はデフォルト設定を使用する
+func NewWarmPool(opts *Options, config *WarmPoolConfig) *WarmPool {
+	if opts == nil {
+		opts = &Options{}
+	}
+	template := *opts
+	template.WarmPool = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &WarmPool{
+		spec:   NewClient(&template).warmSpec(),
+		config: config.withDefaults(),
+		newTransport: func(config transport.Config) transport.Transport {
+			return template.newTransport(config, nil)
+		},
+		connectTimeout: template.GetTimeout("connect"),
+		logger:         template.logger(),
+		ctx:            ctx,
+		cancel:         cancel,
+		wake:           make(chan struct{}, 1),
+	}
+
+	p.wg.Add(1)
+	go p.refillLoop()
+	return p
+}
+
+// warmSpec はClientのオプションからプロセスの起動と初期化の内容を構築する
+// フックのコールバックIDはフック設定から決まるため、同じオプションのClientでは同じ指紋になる
+func (c *Client) warmSpec() warmSpec {
+	matchers, _ := c.buildHookMatchers()
+	spec := warmSpec{
+		transport:  c.transportConfig(),
+		initialize: c.initializeRequest(matchers),
+	}
+
+	// ログ出力先やバッファサイズはプロセスの状態に影響しないため指紋に含めない
+	data, _ := json.Marshal(struct {
+		CLIPath                  string                     `json:"cli_path"`
+		CWD                      string                     `json:"cwd"`
+		Args                     []string                   `json:"args"`
+		Env                      map[string]string          `json:"env"`
+		PermissionPromptToolName string                     `json:"permission_prompt_tool_name"`
+		Initialize               protocol.InitializeRequest `json:"initialize"`
+	}{
+		CLIPath:                  spec.transport.CLIPath,
+		CWD:                      spec.transport.CWD,
+		Args:                     spec.transport.Args,
+		Env:                      spec.transport.Env,
+		PermissionPromptToolName: spec.transport.PermissionPromptToolName,
+		Initialize:               spec.initialize,
+	})
+	sum := sha256.Sum256(data)
+	spec.fingerprint = hex.EncodeToString(sum[:])
+	return spec
+}
+
+// warmable はClientが初期化済みプロセスを使用できるかを返す
+// 記録はプロセスの起動から、親セッションの特定はCLIの起動前に行う必要があるため、
+// Recording・Resume・Continueを使用する場合は通常どおり起動する
+func (c *Client) warmable() bool {
+	return c.opts.WarmPool != nil && c.recorder == nil && c.lineage == nil
+}
+
+// refillLoop は待機中と起動中のプロセスがSizeになるまで補充する
+func (p *WarmPool) refillLoop() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for !p.closed && len(p.ready)+p.starting < p.config.Size {
+			p.starting++
+			p.wg.Add(1)
+			go p.start()
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.wake:
+		}
+	}
+}
+
+// notify は補充ループを起こす
+func (p *WarmPool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// start はプロセスを1つ起動して待機させる
+func (p *WarmPool) start() {
+	defer p.wg.Done()
+
+	proc, err := p.spawn()
+	if err != nil {
+		p.mu.Lock()
+		p.stats.Failures++
+		p.mu.Unlock()
+		if p.ctx.Err() == nil {
+			p.logger.Warn("failed to start warm CLI process", "error", err)
+		}
+
+		// CLIが起動できない状態で再試行を繰り返さないよう、間隔を空けてから枠を空ける
+		select {
+		case <-time.After(p.config.RetryInterval):
+		case <-p.ctx.Done():
+		}
+		p.mu.Lock()
+		p.starting--
+		p.mu.Unlock()
+		p.notify()
+		return
+	}
+
+	p.mu.Lock()
+	p.starting--
+	if p.closed {
+		p.mu.Unlock()
+		proc.transport.Close()
+		return
+	}
+	p.ready = append(p.ready, proc)
+	p.mu.Unlock()
+	p.logger.Debug("warm CLI process ready")
+}
+
+// spawn はCLIプロセスを起動し、initializeの応答を受け取るまで待つ
+func (p *WarmPool) spawn() (*warmProcess, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.connectTimeout)
+	defer cancel()
+
+	// プロセスの寿命は使用するClientに引き継ぐため、プールのコンテキストでは起動しない
+	t := p.newTransport(p.spec.transport)
+	if err := t.Connect(context.Background()); err != nil {
+		return nil, err
+	}
+
+	handler := protocol.NewProtocolHandler(t)
+	handler.SetLogger(p.logger)
+	defer handler.Close()
+
+	// initializeの応答を受け取るまでメッセージを読み取る
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case raw, ok := <-t.Messages():
+				if !ok {
+					// 初期化中にプロセスが終了した
+					cancel()
+					return
+				}
+				handler.HandleIncoming(ctx, raw)
+			}
+		}
+	}()
+
+	resp, err := handler.SendControlRequest(ctx, p.spec.initialize)
+	close(stop)
+	<-done
+
+	if err == nil && resp.Response.Subtype == "error" {
+		err = fmt.Errorf("initialization failed: %s", resp.Response.Error)
+	}
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	return &warmProcess{transport: t, initResp: resp}, nil
+}
+
+// take は指紋が一致する初期化済みプロセスを取り出す（使用できない場合はnil）
+func (p *WarmPool) take(fingerprint string) *warmProcess {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	if fingerprint != p.spec.fingerprint {
+		p.stats.Mismatches++
+		return nil
+	}
+
+	for len(p.ready) > 0 {
+		proc := p.ready[0]
+		p.ready = p.ready[1:]
+		p.notify()
+
+		if proc.transport.IsConnected() {
+			p.stats.Hits++
+			return proc
+		}
+		// 待機中に終了していた
+		p.stats.Discarded++
+		go proc.transport.Close()
+	}
+	p.stats.Misses++
+	return nil
+}
+
+// Stats はウォームプールの状態を返す
+func (p *WarmPool) Stats() WarmPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.stats
+	stats.Ready = len(p.ready)
+	stats.Starting = p.starting
+	return stats
+}
+
+// Close は待機中のプロセスを終了し、補充を停止する
+// 既にClientが使用しているプロセスには影響しない
+func (p *WarmPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	ready := p.ready
+	p.ready = nil
+	p.mu.Unlock()
+
+	p.cancel()
+	for _, proc := range ready {
+		proc.transport.Close()
+	}
+	p.wg.Wait()
+	return nil
+}
@@ -0,0 +1,154 @@
+package claude
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+	"github.com/y-oga-819/my-go-claude-agent/internal/protocol"
+)
+
+// waitWarmReady は待機中のプロセス数がwantになるまで待つ
+func waitWarmReady(t *testing.T, pool *WarmPool, want int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for pool.Stats().Ready != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("warm pool stats = %+v, want %d ready", pool.Stats(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newFakeWarmPool(t *testing.T, opts *Options) *WarmPool {
+	t.Helper()
+	opts.CLIPath = installFakeCLI(t, &fakecli.Scenario{
+		Steps: []fakecli.Step{
+			{Action: fakecli.ActionWaitUser},
+			{Action: fakecli.ActionResult, Result: "done"},
+		},
+	})
+	pool := NewWarmPool(opts, &WarmPoolConfig{Size: 1})
+	t.Cleanup(func() { pool.Close() })
+	waitWarmReady(t, pool, 1)
+	return pool
+}
+
+func TestWarmPool_Connect(t *testing.T) {
+	pool := newFakeWarmPool(t, &Options{Model: "fake-model"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := NewClient(&Options{CLIPath: pool.spec.transport.CLIPath, Model: "fake-model", WarmPool: pool})
+	defer client.Close()
+
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if stats := pool.Stats(); stats.Hits != 1 {
+		t.Fatalf("stats = %+v, want 1 hit", stats)
+	}
+	if client.Capabilities() == nil {
+		t.Error("capabilities of the warm process were not taken over")
+	}
+
+	if err := stream.Send(ctx, "hello"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	for done := false; !done; {
+		select {
+		case msg := <-stream.Messages():
+			if result, ok := msg.(*protocol.ResultMessage); ok {
+				done = true
+				if result.Result != "done" {
+					t.Errorf("result = %q", result.Result)
+				}
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for result (stderr: %s)", fakeStderr(client))
+		}
+	}
+
+	// 使用した分をバックグラウンドで補充する
+	waitWarmReady(t, pool, 1)
+}
+
+func TestWarmPool_RefusesMismatchedOptions(t *testing.T) {
+	pool := newFakeWarmPool(t, &Options{Model: "fake-model"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := NewClient(&Options{CLIPath: pool.spec.transport.CLIPath, Model: "other-model", WarmPool: pool})
+	defer client.Close()
+
+	if _, err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	stats := pool.Stats()
+	if stats.Mismatches != 1 || stats.Hits != 0 || stats.Ready != 1 {
+		t.Errorf("stats = %+v, want the warm process to be kept", stats)
+	}
+}
+
+func TestClient_WarmSpecFingerprint(t *testing.T) {
+	base := func() *Options {
+		return &Options{
+			CLIPath:      "claude",
+			CWD:          "/work",
+			Model:        "claude-sonnet-4-5",
+			AllowedTools: []string{"Read"},
+			MCPServers:   map[string]MCPServerConfig{"fs": {Command: "mcp-fs"}},
+			Hooks: &HookConfig{
+				PreToolUse: []HookEntry{{Type: HookTypeCommand, Command: "check.sh"}},
+			},
+		}
+	}
+	fingerprint := func(opts *Options) string {
+		return NewClient(opts).warmSpec().fingerprint
+	}
+	want := fingerprint(base())
+
+	// プロセスの状態に影響しない設定は指紋に含めない
+	same := base()
+	same.Logger = slog.Default()
+	same.Buffer = &BufferConfig{MessageBufferSize: 10}
+	if got := fingerprint(same); got != want {
+		t.Error("logger and buffer settings should not change the fingerprint")
+	}
+
+	for name, modify := range map[string]func(*Options){
+		"model": func(o *Options) { o.Model = "claude-opus-4" },
+		"tools": func(o *Options) { o.AllowedTools = append(o.AllowedTools, "Bash") },
+		"cwd":   func(o *Options) { o.CWD = "/other" },
+		"mcp":   func(o *Options) { o.MCPServers["git"] = MCPServerConfig{Command: "mcp-git"} },
+		"hooks": func(o *Options) { o.Hooks = nil },
+		"canUseTool": func(o *Options) {
+			o.CanUseTool = func(context.Context, string, map[string]any, *ToolPermissionContext) (*PermissionResult, error) {
+				return &PermissionResult{Allow: true}, nil
+			}
+		},
+	} {
+		opts := base()
+		modify(opts)
+		if fingerprint(opts) == want {
+			t.Errorf("%s: fingerprint should differ", name)
+		}
+	}
+}
+
+func TestWarmPool_Close(t *testing.T) {
+	pool := newFakeWarmPool(t, &Options{})
+	pool.Close()
+
+	if stats := pool.Stats(); stats.Ready != 0 {
+		t.Errorf("Ready = %d after Close, want 0", stats.Ready)
+	}
+	if proc := pool.take(pool.spec.fingerprint); proc != nil {
+		t.Error("take should return nil after Close")
+	}
+}
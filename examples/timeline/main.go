@@ -0,0 +1,24 @@
+// 記録ファイルをタイムライン形式で表示するサンプル
+// Options.Recordingで記録したCLIとのやり取りを読みやすく表示する
+//
+// 実行: go run examples/timeline/main.go session.jsonl
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/y-oga-819/my-go-claude-agent/claude"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: timeline <recording.jsonl>")
+		os.Exit(2)
+	}
+
+	if err := claude.PrintTimelineFile(os.Stdout, os.Args[1]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
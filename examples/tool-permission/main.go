@@ -234,8 +234,8 @@ func receiveResponse(ctx context.Context, stream *claude.Stream) error {
 			case *protocol.AssistantMessage:
 				// テキストコンテンツを表示
 				for _, block := range m.Message.Content {
-					if block.Type == "text" {
-						fmt.Print(block.Text)
+					if text, ok := block.(*protocol.TextBlock); ok {
+						fmt.Print(text.Text)
 					}
 				}
 
@@ -0,0 +1,20 @@
+package fakecli
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+// Package はフェイクCLIのmainパッケージ
+const Package = "github.com/y-oga-819/my-go-claude-agent/internal/fakecli/cmd/fakeclaude"
+
+// Build はフェイクCLIをdirにビルドし、バイナリのパスを返す（goコマンドが必要）
+func Build(dir string) (string, error) {
+	binary := filepath.Join(dir, "fakeclaude")
+	out, err := exec.Command("go", "build", "-o", binary, Package).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("build fakeclaude: %w\n%s", err, out)
+	}
+	return binary, nil
+}
@@ -0,0 +1,25 @@
+// fakeclaude はシナリオファイルに従って動作するフェイクのClaude CLI
+//
+// シナリオファイルは環境変数FAKE_CLAUDE_SCENARIOで指定する。
+// 未設定の場合は実行ファイルのパスに".json"を付けたファイルを読み込む。
+//
+// ビルド: go build -o /tmp/claude ./internal/fakecli/cmd/fakeclaude
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/fakecli"
+)
+
+func main() {
+	path := fakecli.ScenarioPath(os.Args[0])
+	scenario, err := fakecli.LoadScenario(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakeclaude: %v\n", err)
+		os.Exit(1)
+	}
+
+	os.Exit(fakecli.Run(scenario, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
@@ -0,0 +1,527 @@
+package fakecli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 制御リクエストの応答待ちのデフォルトタイムアウト
+const defaultControlTimeout = 10 * time.Second
+
+// 期待と異なる応答を受け取った場合の終了コード
+const exitCodeExpectation = 2
+
+// hookMatcher はinitializeで登録されたフックマッチャー
+type hookMatcher struct {
+	Matcher         string   `json:"matcher"`
+	HookCallbackIDs []string `json:"hookCallbackIds"`
+}
+
+// runner はシナリオを実行する
+type runner struct {
+	scenario  *Scenario
+	streaming bool
+	prompt    string
+	run       int
+
+	outMu  sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+
+	users       chan map[string]any
+	stdinClosed chan struct{}
+
+	mu        sync.Mutex
+	pending   map[string]chan map[string]any
+	hooks     map[string][]hookMatcher
+	requestID int
+}
+
+// stepError はステップの失敗（終了コード付き）
+type stepError struct {
+	code int
+	msg  string
+}
+
+// Run はシナリオを実行し、プロセスの終了コードを返す
+// argsはCLIに渡されたコマンドライン引数（プログラム名を除く）
+func Run(scenario *Scenario, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	r := &runner{
+		scenario:    scenario,
+		stdout:      stdout,
+		stderr:      stderr,
+		users:       make(chan map[string]any, 100),
+		stdinClosed: make(chan struct{}),
+		pending:     make(map[string]chan map[string]any),
+		hooks:       make(map[string][]hookMatcher),
+	}
+	r.parseArgs(args)
+
+	run, err := nextRun(scenario.StateFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "fakeclaude: %v\n", err)
+		return 1
+	}
+	r.run = run
+
+	if r.streaming {
+		go r.readLoop(stdin)
+	}
+
+	for i, step := range scenario.Steps {
+		if len(step.OnRuns) > 0 && !slices.Contains(step.OnRuns, r.run) {
+			continue
+		}
+		if step.DelayMs > 0 {
+			time.Sleep(time.Duration(step.DelayMs) * time.Millisecond)
+		}
+		if err := r.runStep(step); err != nil {
+			if err.msg != "" {
+				fmt.Fprintf(stderr, "fakeclaude: step %d (%s): %s\n", i+1, step.Action, err.msg)
+			}
+			return err.code
+		}
+	}
+
+	// ストリーミングモードではSDKがstdinをクローズするまで待つ
+	if r.streaming {
+		<-r.stdinClosed
+	}
+	return 0
+}
+
+// parseArgs はストリーミングモードとプロンプトを判定する
+func (r *runner) parseArgs(args []string) {
+	for i, arg := range args {
+		switch arg {
+		case "--input-format":
+			if i+1 < len(args) && args[i+1] == "stream-json" {
+				r.streaming = true
+			}
+		case "--":
+			r.prompt = strings.Join(args[i+1:], " ")
+			return
+		}
+	}
+}
+
+// nextRun は起動回数を更新して返す
+func nextRun(stateFile string) (int, error) {
+	if stateFile == "" {
+		return 1, nil
+	}
+	n := 0
+	if data, err := os.ReadFile(stateFile); err == nil {
+		n, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	n++
+	if err := os.WriteFile(stateFile, []byte(strconv.Itoa(n)), 0o644); err != nil {
+		return 0, fmt.Errorf("write state file: %w", err)
+	}
+	return n, nil
+}
+
+// readLoop はstdinからSDKのメッセージを読み取る
+func (r *runner) readLoop(stdin io.Reader) {
+	defer close(r.stdinClosed)
+
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 64*1024*1024)
+	for scanner.Scan() {
+		var msg map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			fmt.Fprintf(r.stderr, "fakeclaude: invalid input: %v\n", err)
+			continue
+		}
+
+		switch msg["type"] {
+		case "control_request":
+			r.handleControlRequest(msg)
+		case "control_response":
+			response, _ := msg["response"].(map[string]any)
+			id, _ := response["request_id"].(string)
+			r.mu.Lock()
+			ch, ok := r.pending[id]
+			delete(r.pending, id)
+			r.mu.Unlock()
+			if ok {
+				ch <- response
+			}
+		case "user":
+			r.users <- msg
+		}
+	}
+}
+
+// handleControlRequest はSDKからの制御リクエストに成功で応答する
+func (r *runner) handleControlRequest(msg map[string]any) {
+	id, _ := msg["request_id"].(string)
+	request, _ := msg["request"].(map[string]any)
+
+	response := map[string]any{}
+	if request["subtype"] == "initialize" {
+		// フックのコールバックIDを保存する
+		if hooks, ok := request["hooks"]; ok {
+			data, _ := json.Marshal(hooks)
+			r.mu.Lock()
+			json.Unmarshal(data, &r.hooks)
+			r.mu.Unlock()
+		}
+		response["session_id"] = r.sessionID()
+		response["models"] = []map[string]any{{"value": r.model(), "displayName": r.model()}}
+	}
+
+	r.emit(map[string]any{
+		"type": "control_response",
+		"response": map[string]any{
+			"subtype":    "success",
+			"request_id": id,
+			"response":   response,
+		},
+	})
+}
+
+// runStep は1ステップを実行する
+func (r *runner) runStep(step Step) *stepError {
+	switch step.Action {
+	case ActionWaitUser:
+		return r.waitUser(step)
+
+	case ActionInit:
+		r.emit(map[string]any{
+			"type":       "system",
+			"subtype":    "init",
+			"session_id": r.sessionID(),
+			"model":      r.model(),
+			"tools":      []string{},
+		})
+
+	case ActionAssistant:
+		r.emitAssistant(step, []map[string]any{{"type": "text", "text": step.Text}})
+
+	case ActionToolUse:
+		input := step.Input
+		if input == nil {
+			input = map[string]any{}
+		}
+		var blocks []map[string]any
+		if step.Text != "" {
+			blocks = append(blocks, map[string]any{"type": "text", "text": step.Text})
+		}
+		blocks = append(blocks, map[string]any{
+			"type":  "tool_use",
+			"id":    step.ToolUseID,
+			"name":  step.ToolName,
+			"input": input,
+		})
+		r.emitAssistant(step, blocks)
+
+	case ActionToolResult:
+		r.emit(map[string]any{
+			"type": "user",
+			"message": map[string]any{
+				"role": "user",
+				"content": []map[string]any{{
+					"type":        "tool_result",
+					"tool_use_id": step.ToolUseID,
+					"content":     step.Content,
+					"is_error":    step.IsError,
+				}},
+			},
+			"session_id": r.sessionID(),
+		})
+
+	case ActionCanUseTool:
+		input := step.Input
+		if input == nil {
+			input = map[string]any{}
+		}
+		return r.controlRequest(step, map[string]any{
+			"subtype":    "can_use_tool",
+			"tool_name":  step.ToolName,
+			"input":      input,
+			"session_id": r.sessionID(),
+		})
+
+	case ActionHookCallback:
+		return r.hookCallback(step)
+
+	case ActionMCPMessage:
+		return r.controlRequest(step, map[string]any{
+			"subtype":     "mcp_message",
+			"server_name": step.ServerName,
+			"message":     step.Message,
+		})
+
+	case ActionSleep:
+		time.Sleep(time.Duration(step.DurationMs) * time.Millisecond)
+
+	case ActionCrash:
+		if step.Stderr != "" {
+			fmt.Fprintln(r.stderr, step.Stderr)
+		}
+		code := step.ExitCode
+		if code == 0 {
+			code = 1
+		}
+		return &stepError{code: code}
+
+	case ActionOversized:
+		r.emitOversized(step.Size)
+
+	case ActionEmit:
+		r.writeLine(step.Raw)
+
+	case ActionResult:
+		r.emitResult(step)
+
+	default:
+		return &stepError{code: exitCodeExpectation, msg: "unknown action"}
+	}
+	return nil
+}
+
+// waitUser はユーザーメッセージを待つ
+func (r *runner) waitUser(step Step) *stepError {
+	var text string
+	if r.streaming {
+		select {
+		case msg := <-r.users:
+			text = userText(msg)
+		case <-r.stdinClosed:
+			// SDKが入力を終えた場合は正常終了する
+			return &stepError{code: 0}
+		}
+	} else {
+		text = r.prompt
+	}
+
+	if step.Text != "" && text != step.Text {
+		return &stepError{code: exitCodeExpectation, msg: fmt.Sprintf("expected user message %q, got %q", step.Text, text)}
+	}
+	return nil
+}
+
+// hookCallback は登録されたフックのコールバックを呼び出す
+func (r *runner) hookCallback(step Step) *stepError {
+	callbackIDs := []string{step.CallbackID}
+	if step.CallbackID == "" {
+		callbackIDs = r.resolveHooks(step.HookEvent, step.ToolName)
+	}
+
+	input := map[string]any{
+		"hook_event_name": step.HookEvent,
+		"session_id":      r.sessionID(),
+	}
+	if step.ToolName != "" {
+		input["tool_name"] = step.ToolName
+		input["tool_input"] = step.Input
+	}
+
+	for _, id := range callbackIDs {
+		req := map[string]any{
+			"subtype":     "hook_callback",
+			"callback_id": id,
+			"input":       input,
+		}
+		if step.ToolUseID != "" {
+			req["tool_use_id"] = step.ToolUseID
+		}
+		if err := r.controlRequest(step, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveHooks はイベントとツール名に一致する登録済みコールバックIDを返す
+func (r *runner) resolveHooks(event, toolName string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []string
+	for _, m := range r.hooks[event] {
+		if m.Matcher == "" || m.Matcher == "*" || m.Matcher == toolName {
+			ids = append(ids, m.HookCallbackIDs...)
+			continue
+		}
+		if re, err := regexp.Compile("^(?:" + m.Matcher + ")$"); err == nil && re.MatchString(toolName) {
+			ids = append(ids, m.HookCallbackIDs...)
+		}
+	}
+	return ids
+}
+
+// controlRequest は制御リクエストを送信し、応答を期待値と照合する
+func (r *runner) controlRequest(step Step, request map[string]any) *stepError {
+	r.mu.Lock()
+	r.requestID++
+	id := fmt.Sprintf("fake-%d", r.requestID)
+	ch := make(chan map[string]any, 1)
+	r.pending[id] = ch
+	r.mu.Unlock()
+
+	r.emit(map[string]any{
+		"type":       "control_request",
+		"request_id": id,
+		"request":    request,
+	})
+
+	timeout := defaultControlTimeout
+	if step.TimeoutMs > 0 {
+		timeout = time.Duration(step.TimeoutMs) * time.Millisecond
+	}
+
+	var response map[string]any
+	select {
+	case response = <-ch:
+	case <-r.stdinClosed:
+		return &stepError{code: exitCodeExpectation, msg: "stdin closed while waiting for control response"}
+	case <-time.After(timeout):
+		return &stepError{code: exitCodeExpectation, msg: fmt.Sprintf("timeout waiting for control response %s", id)}
+	}
+
+	isError := response["subtype"] == "error"
+	if isError != step.ExpectError {
+		return &stepError{code: exitCodeExpectation, msg: fmt.Sprintf("unexpected control response: %s", compact(response))}
+	}
+
+	body, _ := response["response"].(map[string]any)
+	for key, want := range step.Expect {
+		if got := body[key]; !sameJSON(got, want) {
+			return &stepError{code: exitCodeExpectation, msg: fmt.Sprintf("response %s = %s, want %s", key, compact(got), compact(want))}
+		}
+	}
+	return nil
+}
+
+func (r *runner) emitAssistant(step Step, content []map[string]any) {
+	message := map[string]any{
+		"role":    "assistant",
+		"model":   r.model(),
+		"content": content,
+	}
+	if step.Usage != nil {
+		message["usage"] = step.Usage
+	}
+	r.emit(map[string]any{
+		"type":       "assistant",
+		"message":    message,
+		"session_id": r.sessionID(),
+	})
+}
+
+// emitOversized は指定サイズのアシスタントメッセージ1行を出力する
+func (r *runner) emitOversized(size int) {
+	prefix := `{"type":"assistant","message":{"role":"assistant","model":` + strconv.Quote(r.model()) + `,"content":[{"type":"text","text":"`
+	suffix := `"}]},"session_id":` + strconv.Quote(r.sessionID()) + `}`
+	padding := size - len(prefix) - len(suffix)
+	if padding < 0 {
+		padding = 0
+	}
+	r.writeLine([]byte(prefix + strings.Repeat("x", padding) + suffix))
+}
+
+func (r *runner) emitResult(step Step) {
+	subtype := step.Subtype
+	if subtype == "" {
+		subtype = "success"
+	}
+	numTurns := step.NumTurns
+	if numTurns == 0 {
+		numTurns = 1
+	}
+	usage := step.Usage
+	if usage == nil {
+		usage = &Usage{}
+	}
+	result := map[string]any{
+		"type":           "result",
+		"subtype":        subtype,
+		"is_error":       step.IsError,
+		"duration_ms":    0,
+		"num_turns":      numTurns,
+		"session_id":     r.sessionID(),
+		"total_cost_usd": step.CostUSD,
+		"usage":          usage,
+		"result":         step.Result,
+	}
+	if step.ErrorCode != "" {
+		result["error_code"] = step.ErrorCode
+	}
+	r.emit(result)
+}
+
+func (r *runner) emit(msg map[string]any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintf(r.stderr, "fakeclaude: marshal: %v\n", err)
+		return
+	}
+	r.writeLine(data)
+}
+
+func (r *runner) writeLine(data []byte) {
+	r.outMu.Lock()
+	defer r.outMu.Unlock()
+	r.stdout.Write(append(data, '\n'))
+}
+
+func (r *runner) sessionID() string {
+	if r.scenario.SessionID != "" {
+		return r.scenario.SessionID
+	}
+	return "fake-session"
+}
+
+func (r *runner) model() string {
+	if r.scenario.Model != "" {
+		return r.scenario.Model
+	}
+	return "fake-model"
+}
+
+// userText はユーザーメッセージのテキストを取り出す
+func userText(msg map[string]any) string {
+	message, _ := msg["message"].(map[string]any)
+	switch content := message["content"].(type) {
+	case string:
+		return content
+	case []any:
+		var parts []string
+		for _, b := range content {
+			if block, ok := b.(map[string]any); ok && block["type"] == "text" {
+				text, _ := block["text"].(string)
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// sameJSON はJSONとして同じ値かを返す（数値型の違いを無視する）
+func sameJSON(a, b any) bool {
+	var x, y any
+	json.Unmarshal([]byte(compact(a)), &x)
+	json.Unmarshal([]byte(compact(b)), &y)
+	return reflect.DeepEqual(x, y)
+}
+
+func compact(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,203 @@
+package fakecli
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// session はRunnerをインプロセスで起動し、SDK側として入出力を操作する
+type session struct {
+	t      *testing.T
+	stdin  *io.PipeWriter
+	lines  chan map[string]any
+	exit   chan int
+	stderr *strings.Builder
+}
+
+func startSession(t *testing.T, scenario *Scenario, args ...string) *session {
+	t.Helper()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	s := &session{
+		t:      t,
+		stdin:  inW,
+		lines:  make(chan map[string]any, 100),
+		exit:   make(chan int, 1),
+		stderr: &strings.Builder{},
+	}
+
+	go func() {
+		s.exit <- Run(scenario, args, inR, outW, s.stderr)
+		outW.Close()
+	}()
+	go func() {
+		defer close(s.lines)
+		scanner := bufio.NewScanner(outR)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var msg map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				msg = map[string]any{"type": "invalid", "len": len(scanner.Bytes())}
+			}
+			s.lines <- msg
+		}
+	}()
+	t.Cleanup(func() { inW.Close() })
+	return s
+}
+
+func (s *session) send(msg string) {
+	s.t.Helper()
+	if _, err := io.WriteString(s.stdin, msg+"\n"); err != nil {
+		s.t.Fatalf("write stdin: %v", err)
+	}
+}
+
+func (s *session) next() map[string]any {
+	s.t.Helper()
+	select {
+	case msg, ok := <-s.lines:
+		if !ok {
+			s.t.Fatal("stdout closed")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		s.t.Fatal("timeout waiting for output")
+	}
+	return nil
+}
+
+func (s *session) wait() int {
+	s.t.Helper()
+	select {
+	case code := <-s.exit:
+		return code
+	case <-time.After(2 * time.Second):
+		s.t.Fatal("timeout waiting for exit")
+	}
+	return -1
+}
+
+var streamArgs = []string{"--output-format", "stream-json", "--input-format", "stream-json"}
+
+func TestRun_StreamingControlRequests(t *testing.T) {
+	s := startSession(t, &Scenario{
+		SessionID: "s1",
+		Steps: []Step{
+			{Action: ActionWaitUser, Text: "ls"},
+			{Action: ActionToolUse, ToolUseID: "toolu_1", ToolName: "Bash", Input: map[string]any{"command": "ls"}},
+			{Action: ActionCanUseTool, ToolName: "Bash", Expect: map[string]any{"allow": false}},
+			{Action: ActionHookCallback, HookEvent: "PreToolUse", ToolName: "Bash", Expect: map[string]any{"continue": true}},
+			{Action: ActionResult, Result: "done", CostUSD: 0.5},
+		},
+	}, streamArgs...)
+
+	// initializeには自動で応答し、登録されたフックを記憶する
+	s.send(`{"type":"control_request","request_id":"sdk-1","request":{"subtype":"initialize","hooks":{"PreToolUse":[{"hookCallbackIds":["hook_0"]}]}}}`)
+	resp := s.next()
+	if resp["type"] != "control_response" || resp["response"].(map[string]any)["request_id"] != "sdk-1" {
+		t.Fatalf("initialize response = %v", resp)
+	}
+
+	s.send(`{"type":"user","message":{"role":"user","content":"ls"}}`)
+	if msg := s.next(); msg["type"] != "assistant" {
+		t.Fatalf("expected assistant, got %v", msg)
+	}
+
+	req := s.next()
+	request := req["request"].(map[string]any)
+	if request["subtype"] != "can_use_tool" || request["tool_name"] != "Bash" {
+		t.Fatalf("expected can_use_tool, got %v", req)
+	}
+	s.send(`{"type":"control_response","response":{"subtype":"success","request_id":"` + req["request_id"].(string) + `","response":{"allow":false}}}`)
+
+	req = s.next()
+	request = req["request"].(map[string]any)
+	if request["subtype"] != "hook_callback" || request["callback_id"] != "hook_0" {
+		t.Fatalf("expected hook_callback, got %v", req)
+	}
+	s.send(`{"type":"control_response","response":{"subtype":"success","request_id":"` + req["request_id"].(string) + `","response":{"continue":true}}}`)
+
+	result := s.next()
+	if result["type"] != "result" || result["result"] != "done" || result["session_id"] != "s1" {
+		t.Fatalf("result = %v", result)
+	}
+
+	// stdinをクローズすると正常終了する
+	s.stdin.Close()
+	if code := s.wait(); code != 0 {
+		t.Errorf("exit code = %d, want 0 (stderr: %s)", code, s.stderr)
+	}
+}
+
+func TestRun_UnexpectedControlResponse(t *testing.T) {
+	s := startSession(t, &Scenario{
+		Steps: []Step{
+			{Action: ActionCanUseTool, ToolName: "Write", Expect: map[string]any{"allow": false}},
+			{Action: ActionResult},
+		},
+	}, streamArgs...)
+
+	req := s.next()
+	s.send(`{"type":"control_response","response":{"subtype":"success","request_id":"` + req["request_id"].(string) + `","response":{"allow":true}}}`)
+
+	if code := s.wait(); code != exitCodeExpectation {
+		t.Errorf("exit code = %d, want %d", code, exitCodeExpectation)
+	}
+	if !strings.Contains(s.stderr.String(), `response allow = true, want false`) {
+		t.Errorf("stderr = %q", s.stderr)
+	}
+}
+
+func TestRun_PrintModeCrashAndOversized(t *testing.T) {
+	s := startSession(t, &Scenario{
+		Steps: []Step{
+			{Action: ActionWaitUser, Text: "hello"},
+			{Action: ActionOversized, Size: 1 << 20},
+			{Action: ActionCrash, ExitCode: 3, Stderr: "boom", DelayMs: 10},
+		},
+	}, "--print", "--", "hello")
+
+	msg := s.next()
+	if msg["type"] != "assistant" {
+		t.Fatalf("expected oversized assistant message, got %v", msg["type"])
+	}
+	text := msg["message"].(map[string]any)["content"].([]any)[0].(map[string]any)["text"].(string)
+	if len(text) < (1<<20)-200 {
+		t.Errorf("oversized text length = %d", len(text))
+	}
+
+	if code := s.wait(); code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+	if strings.TrimSpace(s.stderr.String()) != "boom" {
+		t.Errorf("stderr = %q", s.stderr)
+	}
+}
+
+func TestRun_OnRuns(t *testing.T) {
+	scenario := &Scenario{
+		StateFile: t.TempDir() + "/runs",
+		Steps: []Step{
+			{Action: ActionCrash, OnRuns: []int{1}},
+			{Action: ActionResult, Result: "second run"},
+		},
+	}
+
+	if code := startSession(t, scenario, "--print", "--", "x").wait(); code != 1 {
+		t.Errorf("first run exit code = %d, want 1", code)
+	}
+
+	s := startSession(t, scenario, "--print", "--", "x")
+	if msg := s.next(); msg["result"] != "second run" {
+		t.Errorf("second run = %v", msg)
+	}
+	if code := s.wait(); code != 0 {
+		t.Errorf("second run exit code = %d, want 0", code)
+	}
+}
@@ -0,0 +1,141 @@
+// Package fakecli はClaude CLIの代わりに使用できる、シナリオ駆動のフェイクCLIを提供する
+//
+// cmd/fakeclaudeをビルドしてOptions.CLIPathに指定すると、stream-jsonで入出力を行い、
+// シナリオファイルに記述したとおりにメッセージの出力や制御リクエストの送信を行う。
+// 実際のclaudeバイナリなしで、リトライ・権限確認・フックなどをエンドツーエンドでテストできる。
+package fakecli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ScenarioEnv はシナリオファイルのパスを指定する環境変数
+// 未設定の場合は「実行ファイルのパス + .json」を読み込む
+const ScenarioEnv = "FAKE_CLAUDE_SCENARIO"
+
+// ステップの種類
+const (
+	ActionWaitUser     = "wait_user"     // ユーザーメッセージ（printモードではプロンプト引数）を待つ
+	ActionInit         = "init"          // system/initメッセージを出力する
+	ActionAssistant    = "assistant"     // テキストのアシスタントメッセージを出力する
+	ActionToolUse      = "tool_use"      // tool_useを含むアシスタントメッセージを出力する
+	ActionToolResult   = "tool_result"   // tool_resultを含むユーザーメッセージを出力する
+	ActionCanUseTool   = "can_use_tool"  // can_use_tool制御リクエストを送信して応答を待つ
+	ActionHookCallback = "hook_callback" // hook_callback制御リクエストを送信して応答を待つ
+	ActionMCPMessage   = "mcp_message"   // mcp_message制御リクエストを送信して応答を待つ
+	ActionSleep        = "sleep"         // 指定時間待つ
+	ActionCrash        = "crash"         // stderrに出力して異常終了する
+	ActionOversized    = "oversized"     // 指定サイズの1行を出力する
+	ActionEmit         = "emit"          // 任意のJSON行を出力する
+	ActionResult       = "result"        // resultメッセージを出力する
+)
+
+// Scenario はフェイクCLIの動作を記述する
+type Scenario struct {
+	SessionID string `json:"session_id,omitempty"` // 出力するメッセージのセッションID（デフォルト: "fake-session"）
+	Model     string `json:"model,omitempty"`      // 出力するメッセージのモデル（デフォルト: "fake-model"）
+	// StateFile は起動回数を保存するファイル（Step.OnRunsを使う場合に必要）
+	StateFile string `json:"state_file,omitempty"`
+	Steps     []Step `json:"steps"`
+}
+
+// Step はシナリオの1ステップ
+// Actionに応じて使用するフィールドが異なる
+type Step struct {
+	Action  string `json:"action"`
+	DelayMs int    `json:"delay_ms,omitempty"` // ステップ実行前の待ち時間（遅い出力のシミュレーション）
+	OnRuns  []int  `json:"on_runs,omitempty"`  // 指定した起動回（1始まり）でのみ実行する（空の場合は毎回）
+
+	// assistant / wait_user
+	Text string `json:"text,omitempty"`
+
+	// tool_use / tool_result / can_use_tool / hook_callback
+	ToolUseID string         `json:"tool_use_id,omitempty"`
+	ToolName  string         `json:"tool_name,omitempty"`
+	Input     map[string]any `json:"input,omitempty"`
+	Content   string         `json:"content,omitempty"`  // tool_result
+	IsError   bool           `json:"is_error,omitempty"` // tool_result / result
+
+	// hook_callback（CallbackIDが空の場合はinitializeで登録されたHookEventのコールバックを呼ぶ）
+	CallbackID string `json:"callback_id,omitempty"`
+	HookEvent  string `json:"hook_event,omitempty"`
+
+	// mcp_message
+	ServerName string         `json:"server_name,omitempty"`
+	Message    map[string]any `json:"message,omitempty"`
+
+	// 制御リクエストの応答に期待する値（responseに含まれるフィールドの部分一致）
+	Expect      map[string]any `json:"expect,omitempty"`
+	ExpectError bool           `json:"expect_error,omitempty"` // エラー応答を期待する
+	TimeoutMs   int            `json:"timeout_ms,omitempty"`   // 応答待ちのタイムアウト（デフォルト: 10秒）
+
+	// sleep
+	DurationMs int `json:"duration_ms,omitempty"`
+
+	// crash
+	ExitCode int    `json:"exit_code,omitempty"` // デフォルト: 1
+	Stderr   string `json:"stderr,omitempty"`
+
+	// oversized
+	Size int `json:"size,omitempty"` // 出力する行のバイト数
+
+	// emit
+	Raw json.RawMessage `json:"raw,omitempty"`
+
+	// result
+	Subtype   string  `json:"subtype,omitempty"` // デフォルト: "success"
+	Result    string  `json:"result,omitempty"`
+	ErrorCode string  `json:"error_code,omitempty"` // is_error時のエラーコード（"rate_limit"など）
+	CostUSD   float64 `json:"cost_usd,omitempty"`
+	NumTurns  int     `json:"num_turns,omitempty"`
+	Usage     *Usage  `json:"usage,omitempty"`
+}
+
+// Usage はresultやアシスタントメッセージに含めるトークン使用量
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// LoadScenario はシナリオファイルを読み込む
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse scenario %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// ScenarioPath はフェイクCLIが読み込むシナリオファイルのパスを返す
+func ScenarioPath(argv0 string) string {
+	if path := os.Getenv(ScenarioEnv); path != "" {
+		return path
+	}
+	return argv0 + ".json"
+}
+
+// Install はフェイクCLIのバイナリとシナリオをdirに配置し、CLIPathに指定するパスを返す
+// binaryはBuildでビルドしたフェイクCLIのパス
+func Install(dir, binary string, scenario *Scenario) (string, error) {
+	cliPath := filepath.Join(dir, "claude")
+	if err := os.Symlink(binary, cliPath); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(scenario, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(cliPath+".json", data, 0o644); err != nil {
+		return "", err
+	}
+	return cliPath, nil
+}
@@ -5,23 +5,45 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/logging"
 )
 
 // Executor はシェルコマンドフックを実行する
 type Executor struct {
-	shell string
+	shell    string
+	observer ExecuteObserver
+	logger   *slog.Logger
 }
 
 // NewExecutor は新しいExecutorを作成する
 func NewExecutor() *Executor {
 	return &Executor{
-		shell: "sh",
+		shell:  "sh",
+		logger: logging.Discard(),
 	}
 }
 
+// ExecuteObserver はコマンドフックの実行開始時に呼ばれ、実行を観測する
+// 返されたコンテキストでコマンドを実行し、終了時に返された関数を終了コード（実行できなかった場合は-1）とエラーで呼ぶ
+type ExecuteObserver func(ctx context.Context, command string, input *Input) (context.Context, func(exitCode int, err error))
+
+// SetObserver はコマンドフックの実行を観測するObserverを設定する
+// フックの実行開始前に設定すること
+func (e *Executor) SetObserver(observer ExecuteObserver) {
+	e.observer = observer
+}
+
+// SetLogger はログの出力先を設定する
+// フックの実行開始前に設定すること
+func (e *Executor) SetLogger(logger *slog.Logger) {
+	e.logger = logging.OrDiscard(logger)
+}
+
 // CommandInput はコマンドに渡すJSON
 type CommandInput struct {
 	SessionID      string         `json:"session_id"`
@@ -56,6 +78,31 @@ type CommandSpecificOutput struct {
 
 // Execute はコマンドを実行しOutputを返す
 func (e *Executor) Execute(ctx context.Context, command string, input *Input, timeout time.Duration) (*Output, error) {
+	logger := e.logger.With("event", input.HookEventName, "command", command)
+	logger.Debug("executing hook command", "tool", input.ToolName, logging.Payload("tool_input", input.ToolInput))
+
+	done := func(int, error) {}
+	if e.observer != nil {
+		ctx, done = e.observer(ctx, command, input)
+	}
+	output, exitCode, err := e.execute(ctx, command, input, timeout)
+	done(exitCode, err)
+
+	switch {
+	case err != nil:
+		logger.Error("hook command failed", "exit_code", exitCode, "error", err)
+	case exitCode == 2:
+		logger.Info("hook command blocked", "exit_code", exitCode, "reason", output.Reason)
+	case exitCode != 0:
+		logger.Warn("hook command exited with non-blocking error", "exit_code", exitCode, "stderr", output.SystemMessage)
+	default:
+		logger.Debug("hook command completed", "exit_code", exitCode)
+	}
+	return output, err
+}
+
+// execute はコマンドを実行し、Outputと終了コードを返す
+func (e *Executor) execute(ctx context.Context, command string, input *Input, timeout time.Duration) (*Output, int, error) {
 	// タイムアウト付きコンテキスト
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
@@ -81,11 +128,12 @@ func (e *Executor) Execute(ctx context.Context, command string, input *Input, ti
 		ToolName:       input.ToolName,
 		ToolInput:      input.ToolInput,
 		ToolOutput:     input.ToolOutput,
+		ToolUseID:      input.ToolUseID,
 	}
 
 	inputJSON, err := json.Marshal(cmdInput)
 	if err != nil {
-		return nil, fmt.Errorf("marshal input: %w", err)
+		return nil, -1, fmt.Errorf("marshal input: %w", err)
 	}
 
 	// stdin/stdout/stderrを設定
@@ -99,7 +147,7 @@ func (e *Executor) Execute(ctx context.Context, command string, input *Input, ti
 
 	// コンテキストエラーを先にチェック
 	if ctx.Err() != nil {
-		return nil, fmt.Errorf("execute command: %w", ctx.Err())
+		return nil, -1, fmt.Errorf("execute command: %w", ctx.Err())
 	}
 
 	// 終了コードを取得
@@ -109,11 +157,11 @@ func (e *Executor) Execute(ctx context.Context, command string, input *Input, ti
 			exitCode = exitErr.ExitCode()
 			// シグナルで終了した場合（-1）はエラーとして扱う
 			if exitCode == -1 {
-				return nil, fmt.Errorf("execute command: process killed")
+				return nil, -1, fmt.Errorf("execute command: process killed")
 			}
 		} else {
 			// その他のエラー
-			return nil, fmt.Errorf("execute command: %w", err)
+			return nil, -1, fmt.Errorf("execute command: %w", err)
 		}
 	}
 
@@ -121,7 +169,8 @@ func (e *Executor) Execute(ctx context.Context, command string, input *Input, ti
 	switch exitCode {
 	case 0:
 		// 成功: stdoutをJSONとしてパース
-		return e.parseSuccessOutput(stdout.Bytes())
+		output, err := e.parseSuccessOutput(stdout.Bytes())
+		return output, exitCode, err
 
 	case 2:
 		// ブロック: stderrをエラーメッセージとして使用
@@ -129,14 +178,14 @@ func (e *Executor) Execute(ctx context.Context, command string, input *Input, ti
 			Continue: false,
 			Decision: "block",
 			Reason:   stderr.String(),
-		}, nil
+		}, exitCode, nil
 
 	default:
 		// 非ブロッキングエラー: 処理継続
 		return &Output{
 			Continue:      true,
 			SystemMessage: stderr.String(),
-		}, nil
+		}, exitCode, nil
 	}
 }
 
@@ -170,6 +219,7 @@ func (e *Executor) parseSuccessOutput(data []byte) (*Output, error) {
 			PermissionDecision:       cmdOutput.HookSpecificOutput.PermissionDecision,
 			PermissionDecisionReason: cmdOutput.HookSpecificOutput.PermissionDecisionReason,
 			UpdatedInput:             cmdOutput.HookSpecificOutput.UpdatedInput,
+			AdditionalContext:        cmdOutput.HookSpecificOutput.AdditionalContext,
 		}
 	}
 
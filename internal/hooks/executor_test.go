@@ -262,3 +262,37 @@ func TestExecutor_Execute_EnvironmentVariables(t *testing.T) {
 		t.Error("Continue should be true (env var was set correctly)")
 	}
 }
+
+func TestExecutor_Execute_Observer(t *testing.T) {
+	e := NewExecutor()
+
+	type observed struct {
+		command  string
+		exitCode int
+		err      error
+	}
+	var got []observed
+	e.SetObserver(func(ctx context.Context, command string, input *Input) (context.Context, func(int, error)) {
+		return ctx, func(exitCode int, err error) {
+			got = append(got, observed{command, exitCode, err})
+		}
+	})
+
+	input := &Input{SessionID: "test-session", HookEventName: "PreToolUse"}
+	if _, err := e.Execute(context.Background(), `exit 2`, input, 10*time.Second); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if _, err := e.Execute(context.Background(), `sleep 5`, input, 50*time.Millisecond); err == nil {
+		t.Fatal("expected timeout error")
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("observed %d executions, want 2", len(got))
+	}
+	if got[0].command != "exit 2" || got[0].exitCode != 2 || got[0].err != nil {
+		t.Errorf("first = %+v", got[0])
+	}
+	if got[1].exitCode != -1 || got[1].err == nil {
+		t.Errorf("second = %+v", got[1])
+	}
+}
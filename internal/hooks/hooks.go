@@ -2,8 +2,11 @@ package hooks
 
 import (
 	"context"
+	"log/slog"
 	"sync"
 	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/logging"
 )
 
 // HookType はフックの種類
@@ -34,6 +37,7 @@ type Input struct {
 	TranscriptPath string
 	CWD            string
 	ToolName       string
+	ToolUseID      string
 	ToolInput      map[string]any
 	ToolOutput     map[string]any // PostToolUse用
 }
@@ -77,6 +81,7 @@ type Entry struct {
 type Manager struct {
 	hooks    map[Event][]Entry
 	executor *Executor
+	logger   *slog.Logger
 	mu       sync.RWMutex
 }
 
@@ -85,9 +90,21 @@ func NewManager() *Manager {
 	return &Manager{
 		hooks:    make(map[Event][]Entry),
 		executor: NewExecutor(),
+		logger:   logging.Discard(),
 	}
 }
 
+// SetExecuteObserver はコマンドフックの実行を観測するObserverを設定する
+func (m *Manager) SetExecuteObserver(observer ExecuteObserver) {
+	m.executor.SetObserver(observer)
+}
+
+// SetLogger はログの出力先を設定する（コマンドフックの実行ログにも使われる）
+func (m *Manager) SetLogger(logger *slog.Logger) {
+	m.logger = logging.OrDiscard(logger)
+	m.executor.SetLogger(logger)
+}
+
 // Register はフックを登録する
 func (m *Manager) Register(event Event, entry Entry) {
 	m.mu.Lock()
@@ -107,6 +124,9 @@ func (m *Manager) Trigger(ctx context.Context, event Event, input *Input) (*Outp
 
 	input.HookEventName = string(event)
 
+	// 全フックが続行した場合もdecisionやhookSpecificOutputを呼び出し元に返す
+	result := &Output{Continue: true}
+
 	for _, entry := range entries {
 		// マッチャーが設定されている場合はマッチングを確認
 		if entry.Matcher != nil && !entry.Matcher.Match(input.ToolName) {
@@ -134,6 +154,7 @@ func (m *Manager) Trigger(ctx context.Context, event Event, input *Input) (*Outp
 		}
 
 		if err != nil {
+			m.logger.Warn("hook failed", "event", event, "tool", input.ToolName, "error", err)
 			return nil, err
 		}
 
@@ -141,9 +162,33 @@ func (m *Manager) Trigger(ctx context.Context, event Event, input *Input) (*Outp
 		if !output.Continue {
 			return output, nil
 		}
+
+		mergeOutput(result, output)
 	}
 
-	return &Output{Continue: true}, nil
+	return result, nil
+}
+
+// mergeOutput は続行したフックの出力をdstに反映する（後のフックが優先）
+func mergeOutput(dst, src *Output) {
+	if src.StopReason != "" {
+		dst.StopReason = src.StopReason
+	}
+	if src.SuppressOutput {
+		dst.SuppressOutput = true
+	}
+	if src.Decision != "" {
+		dst.Decision = src.Decision
+	}
+	if src.SystemMessage != "" {
+		dst.SystemMessage = src.SystemMessage
+	}
+	if src.Reason != "" {
+		dst.Reason = src.Reason
+	}
+	if src.HookSpecificOutput != nil {
+		dst.HookSpecificOutput = src.HookSpecificOutput
+	}
 }
 
 // GetHooks は登録されたフックを取得する
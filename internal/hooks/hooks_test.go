@@ -402,3 +402,43 @@ func TestManager_Trigger_CommandHookWithMatcher(t *testing.T) {
 		t.Error("Read should continue")
 	}
 }
+
+func TestManager_Trigger_ReturnsSpecificOutputOnContinue(t *testing.T) {
+	m := NewManager()
+
+	m.Register(EventPreToolUse, Entry{
+		Callback: func(ctx context.Context, input *Input) (*Output, error) {
+			return &Output{
+				Continue: true,
+				HookSpecificOutput: &SpecificOutput{
+					HookEventName:      "PreToolUse",
+					PermissionDecision: "allow",
+					UpdatedInput:       map[string]any{"command": "ls -la"},
+				},
+			}, nil
+		},
+	})
+	m.Register(EventPreToolUse, Entry{
+		Callback: func(ctx context.Context, input *Input) (*Output, error) {
+			return &Output{Continue: true, SystemMessage: "checked"}, nil
+		},
+	})
+
+	output, err := m.Trigger(context.Background(), EventPreToolUse, &Input{ToolName: "Bash"})
+	if err != nil {
+		t.Fatalf("Trigger failed: %v", err)
+	}
+
+	if !output.Continue {
+		t.Error("Continue should be true")
+	}
+	if output.HookSpecificOutput == nil {
+		t.Fatal("HookSpecificOutput should be preserved")
+	}
+	if output.HookSpecificOutput.PermissionDecision != "allow" {
+		t.Errorf("PermissionDecision = %q, want %q", output.HookSpecificOutput.PermissionDecision, "allow")
+	}
+	if output.SystemMessage != "checked" {
+		t.Errorf("SystemMessage = %q, want %q", output.SystemMessage, "checked")
+	}
+}
@@ -0,0 +1,101 @@
+// Package logging はSDK内部で共有するlog/slogのヘルパーを提供する
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Redacted はペイロードの代わりに出力する値
+const Redacted = "[REDACTED]"
+
+// discardHandler は何も出力しないslog.Handler
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discard = slog.New(discardHandler{})
+
+// Discard は何も出力しないLoggerを返す
+func Discard() *slog.Logger {
+	return discard
+}
+
+// OrDiscard はloggerがnilの場合に何も出力しないLoggerを返す
+func OrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discard
+	}
+	return logger
+}
+
+// payload はプロンプトやツール入力などの内容を表す値
+type payload struct {
+	value any
+}
+
+// LogValue は内容を伏せた値を返す（WithPayloadsで作成したLoggerでは元の値に置き換えられる）
+func (p payload) LogValue() slog.Value {
+	return slog.StringValue(Redacted)
+}
+
+// Payload はプロンプトやツール入力などの内容を表す属性を作成する
+// WithPayloadsで作成したLogger以外では内容を出力せずRedactedに置き換える
+func Payload(key string, value any) slog.Attr {
+	return slog.Any(key, payload{value: value})
+}
+
+// WithPayloads はPayloadの内容をそのまま出力するLoggerを返す（デバッグ用）
+func WithPayloads(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return nil
+	}
+	return slog.New(payloadHandler{logger.Handler()})
+}
+
+// payloadHandler はPayloadの属性を元の値に置き換えて委譲するslog.Handler
+type payloadHandler struct {
+	slog.Handler
+}
+
+func (h payloadHandler) Handle(ctx context.Context, r slog.Record) error {
+	revealed := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		revealed.AddAttrs(reveal(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, revealed)
+}
+
+func (h payloadHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	revealed := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		revealed[i] = reveal(a)
+	}
+	return payloadHandler{h.Handler.WithAttrs(revealed)}
+}
+
+func (h payloadHandler) WithGroup(name string) slog.Handler {
+	return payloadHandler{h.Handler.WithGroup(name)}
+}
+
+// reveal はPayloadの属性（グループ内を含む）を元の値に置き換える
+func reveal(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindLogValuer:
+		if p, ok := a.Value.Any().(payload); ok {
+			return slog.Any(a.Key, p.value)
+		}
+	case slog.KindGroup:
+		group := a.Value.Group()
+		revealed := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			revealed[i] = reveal(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(revealed...)}
+	}
+	return a
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestPayload_RedactedByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	logger.Info("send", Payload("prompt", "secret prompt"), "bytes", 13)
+
+	out := buf.String()
+	if strings.Contains(out, "secret prompt") || !strings.Contains(out, "prompt="+Redacted) {
+		t.Errorf("payload not redacted: %s", out)
+	}
+	if !strings.Contains(out, "bytes=13") {
+		t.Errorf("other attributes should be kept: %s", out)
+	}
+}
+
+func TestWithPayloads(t *testing.T) {
+	var buf bytes.Buffer
+	logger := WithPayloads(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	logger.With(Payload("input", map[string]any{"command": "ls"})).
+		Info("tool", slog.Group("req", Payload("prompt", "hello")))
+
+	out := buf.String()
+	if strings.Contains(out, Redacted) {
+		t.Errorf("payload should be revealed: %s", out)
+	}
+	if !strings.Contains(out, `"input":{"command":"ls"}`) || !strings.Contains(out, `"req":{"prompt":"hello"}`) {
+		t.Errorf("unexpected output: %s", out)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	if OrDiscard(nil) != Discard() {
+		t.Error("OrDiscard(nil) should return Discard()")
+	}
+	if Discard().Enabled(context.Background(), slog.LevelError) {
+		t.Error("Discard should be disabled")
+	}
+	if WithPayloads(nil) != nil {
+		t.Error("WithPayloads(nil) should return nil")
+	}
+}
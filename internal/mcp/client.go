@@ -5,14 +5,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/logging"
 )
 
 // MCPClient は外部MCPサーバーとの通信を管理する
 type MCPClient struct {
 	name      string
 	transport Transport
+	logger    *slog.Logger
+	observer  RequestObserver
 
 	serverInfo   *ServerInfo
 	capabilities *Capabilities
@@ -61,12 +67,28 @@ func NewMCPClient(name string, transport Transport) *MCPClient {
 	return &MCPClient{
 		name:        name,
 		transport:   transport,
+		logger:      logging.Discard(),
 		pendingReqs: make(map[any]chan *Message),
 		stopChan:    make(chan struct{}),
 		msgChan:     make(chan *Message, 100),
 	}
 }
 
+// SetLogger はログの出力先を設定する
+// Connect前に設定すること
+func (c *MCPClient) SetLogger(logger *slog.Logger) {
+	c.logger = logging.OrDiscard(logger).With("server", c.name)
+}
+
+// RequestObserver はMCPサーバーへのリクエストの完了時に呼ばれ、所要時間とエラーを受け取る
+type RequestObserver func(server, method string, elapsed time.Duration, err error)
+
+// SetRequestObserver はリクエストを観測するObserverを設定する
+// Connect前に設定すること
+func (c *MCPClient) SetRequestObserver(observer RequestObserver) {
+	c.observer = observer
+}
+
 // Connect は接続と初期化を行う
 func (c *MCPClient) Connect(ctx context.Context) error {
 	c.mu.Lock()
@@ -109,6 +131,12 @@ func (c *MCPClient) receiveLoop() {
 		msg, err := c.transport.Receive()
 		if err != nil {
 			// 接続が閉じられた場合は終了
+			select {
+			case <-c.stopChan:
+				c.logger.Debug("MCP receive loop stopped")
+			default:
+				c.logger.Warn("MCP receive loop exited", "error", err)
+			}
 			return
 		}
 
@@ -132,6 +160,7 @@ func (c *MCPClient) receiveLoop() {
 			select {
 			case c.msgChan <- msg:
 			default:
+				c.logger.Warn("MCP notification dropped: buffer full", "method", msg.Method)
 			}
 		}
 	}
@@ -223,7 +252,12 @@ func (c *MCPClient) parseInitializeResult(msg *Message) error {
 }
 
 // request はリクエストを送信してレスポンスを待つ
-func (c *MCPClient) request(ctx context.Context, msg *Message) (*Message, error) {
+func (c *MCPClient) request(ctx context.Context, msg *Message) (_ *Message, err error) {
+	if c.observer != nil {
+		start := time.Now()
+		defer func() { c.observer(c.name, msg.Method, time.Since(start), err) }()
+	}
+
 	respChan := make(chan *Message, 1)
 
 	c.mu.Lock()
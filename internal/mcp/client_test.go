@@ -1,9 +1,12 @@
 package mcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
+	"log/slog"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -177,6 +180,56 @@ func TestMCPClient_Connect(t *testing.T) {
 	}
 }
 
+func TestMCPClient_ReceiveLoopExitLogged(t *testing.T) {
+	var buf bytes.Buffer
+	transport := newMockTransport()
+	client := NewMCPClient("test", transport)
+	client.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	// 接続が切れるとReceiveはエラーを返し、受信ループが終了する
+	transport.Close()
+	client.receiveLoop()
+
+	out := buf.String()
+	if !strings.Contains(out, "MCP receive loop exited") || !strings.Contains(out, "server=test") || !strings.Contains(out, "error=EOF") {
+		t.Errorf("unexpected log: %s", out)
+	}
+}
+
+func TestMCPClient_RequestObserver(t *testing.T) {
+	transport := newMockTransport()
+	client := NewMCPClient("test", transport)
+
+	var observed []string
+	client.SetRequestObserver(func(server, method string, elapsed time.Duration, err error) {
+		observed = append(observed, server+" "+method)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	transport.setResponse(1, &Message{
+		JSONRPC: "2.0",
+		ID:      json.Number("1"),
+		Result: map[string]any{
+			"protocolVersion": "2025-06-18",
+			"serverInfo":      map[string]any{"name": "test", "version": "1.0.0"},
+			"capabilities":    map[string]any{},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	// initialized通知はリクエストではないため観測しない
+	if len(observed) != 1 || observed[0] != "test initialize" {
+		t.Errorf("observed = %v, want [test initialize]", observed)
+	}
+}
+
 func TestMCPClient_ListTools(t *testing.T) {
 	transport := newMockTransport()
 	client := NewMCPClient("test", transport)
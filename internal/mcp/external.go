@@ -3,7 +3,10 @@ package mcp
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"sync"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/logging"
 )
 
 // TransportType はMCPトランスポートの種類
@@ -61,6 +64,8 @@ type Manager struct {
 	servers    map[string]*ServerConfig
 	sdkServers map[string]*SDKMCPServer
 	clients    map[string]*MCPClient // 接続中のクライアント
+	logger     *slog.Logger
+	observer   RequestObserver
 	mu         sync.RWMutex
 }
 
@@ -70,9 +75,30 @@ func NewManager() *Manager {
 		servers:    make(map[string]*ServerConfig),
 		sdkServers: make(map[string]*SDKMCPServer),
 		clients:    make(map[string]*MCPClient),
+		logger:     logging.Discard(),
 	}
 }
 
+// SetLogger はログの出力先を設定する（接続するクライアントにも引き継がれる）
+func (m *Manager) SetLogger(logger *slog.Logger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logger = logging.OrDiscard(logger)
+}
+
+// SetRequestObserver は接続するクライアントのリクエストを観測するObserverを設定する
+func (m *Manager) SetRequestObserver(observer RequestObserver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observer = observer
+}
+
+func (m *Manager) log() *slog.Logger {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.logger
+}
+
 // AddExternalServer は外部MCPサーバーを追加する
 func (m *Manager) AddExternalServer(name string, config *ServerConfig) {
 	m.servers[name] = config
@@ -126,6 +152,7 @@ func (m *Manager) BuildCLIConfig() map[string]any {
 	for name := range m.sdkServers {
 		result[name] = map[string]any{
 			"type": "sdk",
+			"name": name,
 		}
 	}
 
@@ -134,12 +161,15 @@ func (m *Manager) BuildCLIConfig() map[string]any {
 
 // HandleMCPMessage はMCPメッセージを処理する
 func (m *Manager) HandleMCPMessage(serverName string, msg *Message) (*Response, error) {
+	m.log().Debug("handling MCP message", "server", serverName, "method", msg.Method, logging.Payload("params", msg.Params))
+
 	// SDKサーバーを優先
 	if server, ok := m.sdkServers[serverName]; ok {
 		return server.HandleMessage(msg)
 	}
 
 	// 外部サーバーの場合はエラー（外部サーバーへの転送はCLIが行う）
+	m.log().Warn("MCP message for unknown SDK server", "server", serverName, "method", msg.Method)
 	return &Response{
 		ID:    msg.ID,
 		Error: &ResponseError{Code: -32000, Message: "server not found or external server"},
@@ -154,6 +184,7 @@ func (m *Manager) ConnectServer(ctx context.Context, name string) error {
 		m.mu.Unlock()
 		return fmt.Errorf("server not found: %s", name)
 	}
+	observer := m.observer
 	m.mu.Unlock()
 
 	var transport Transport
@@ -166,10 +197,15 @@ func (m *Manager) ConnectServer(ctx context.Context, name string) error {
 		return fmt.Errorf("unsupported transport type: %s", config.Type)
 	}
 
+	logger := m.log()
 	client := NewMCPClient(name, transport)
+	client.SetLogger(logger)
+	client.SetRequestObserver(observer)
 	if err := client.Connect(ctx); err != nil {
+		logger.Error("failed to connect MCP server", "server", name, "type", config.Type, "error", err)
 		return fmt.Errorf("failed to connect: %w", err)
 	}
+	logger.Info("MCP server connected", "server", name, "type", config.Type)
 
 	m.mu.Lock()
 	m.clients[name] = client
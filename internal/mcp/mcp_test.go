@@ -418,3 +418,40 @@ func TestManager_CallTool_NotFound(t *testing.T) {
 		t.Error("expected error for unknown server")
 	}
 }
+
+func TestSDKMCPServer_HandleMessage_Initialize(t *testing.T) {
+	s := NewSDKMCPServer("calc", "1.0.0")
+
+	resp, err := s.HandleMessage(&Message{
+		JSONRPC: "2.0",
+		ID:      0,
+		Method:  "initialize",
+	})
+	if err != nil {
+		t.Fatalf("HandleMessage failed: %v", err)
+	}
+
+	if resp.JSONRPC != "2.0" {
+		t.Errorf("JSONRPC = %q, want %q", resp.JSONRPC, "2.0")
+	}
+	result, ok := resp.Result.(map[string]any)
+	if !ok {
+		t.Fatal("result should be map")
+	}
+	if result["protocolVersion"] != ProtocolVersion {
+		t.Errorf("protocolVersion = %v, want %v", result["protocolVersion"], ProtocolVersion)
+	}
+	serverInfo := result["serverInfo"].(map[string]any)
+	if serverInfo["name"] != "calc" {
+		t.Errorf("serverInfo.name = %v, want calc", serverInfo["name"])
+	}
+
+	// initialized通知はエラーにならない
+	resp, err = s.HandleMessage(&Message{JSONRPC: "2.0", Method: "notifications/initialized"})
+	if err != nil {
+		t.Fatalf("HandleMessage failed: %v", err)
+	}
+	if resp.Error != nil {
+		t.Errorf("unexpected error: %v", resp.Error)
+	}
+}
@@ -107,9 +107,43 @@ func (s *SDKMCPServer) HandleCall(toolName string, args map[string]any) (*ToolRe
 	return tool.Handler(args)
 }
 
+// ProtocolVersion はSDKサーバーが応答するMCPプロトコルバージョン
+const ProtocolVersion = "2025-06-18"
+
 // HandleMessage はMCPメッセージを処理する
 func (s *SDKMCPServer) HandleMessage(msg *Message) (*Response, error) {
+	resp, err := s.handleMessage(msg)
+	if resp != nil {
+		resp.JSONRPC = "2.0"
+	}
+	return resp, err
+}
+
+func (s *SDKMCPServer) handleMessage(msg *Message) (*Response, error) {
 	switch msg.Method {
+	case "initialize":
+		// CLIからの初期化ハンドシェイク
+		return &Response{
+			ID: msg.ID,
+			Result: map[string]any{
+				"protocolVersion": ProtocolVersion,
+				"capabilities": map[string]any{
+					"tools": map[string]any{},
+				},
+				"serverInfo": map[string]any{
+					"name":    s.Name,
+					"version": s.Version,
+				},
+			},
+		}, nil
+
+	case "notifications/initialized":
+		// 通知には空の結果を返す
+		return &Response{
+			ID:     msg.ID,
+			Result: map[string]any{},
+		}, nil
+
 	case "tools/list":
 		tools := s.ListTools()
 		return &Response{
@@ -0,0 +1,415 @@
+// Package metrics は外部依存なしでPrometheusのテキスト形式（0.0.4）を出力するメトリクスレジストリ
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType はPrometheusのテキスト形式のContent-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets はヒストグラムのデフォルトのバケット（秒）
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// メトリクスの種類
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// Registry はメトリクスを登録順に保持する
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+// collector はメトリクス1つ分（HELP/TYPEと系列）を書き出す
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// NewRegistry は新しいRegistryを作成する
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register はメトリクスを登録する（同じ名前の登録はプログラムの誤りとしてpanicする）
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[c.name()] {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	r.names[c.name()] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// Write は全メトリクスをテキスト形式で書き出す
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler はメトリクスをテキスト形式で返すhttp.Handlerを返す
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.Write(w)
+	})
+}
+
+// desc はメトリクスの名前・説明・ラベル名
+type desc struct {
+	metricName string
+	help       string
+	typ        string
+	labels     []string
+}
+
+func (d *desc) name() string { return d.metricName }
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, d.typ)
+}
+
+// checkLabels はラベル値の数がラベル名と一致するかを確認する
+func (d *desc) checkLabels(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.metricName, len(d.labels), len(values)))
+	}
+}
+
+// seriesKey はラベル値の組を連結したマップのキー
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// series はラベル値の組ごとの値
+type series struct {
+	labels []string
+	value  float64
+}
+
+// valueVec はラベル値の組ごとに1つの値を持つメトリクス（カウンタ・ゲージ）
+type valueVec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (v *valueVec) add(delta float64, values []string) {
+	v.checkLabels(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(values).value += delta
+}
+
+func (v *valueVec) set(value float64, values []string) {
+	v.checkLabels(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(values).value = value
+}
+
+// get はラベル値の組の系列を返す（v.muを保持して呼ぶ）
+func (v *valueVec) get(values []string) *series {
+	key := seriesKey(values)
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *valueVec) value(values []string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[seriesKey(values)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (v *valueVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	list := make([]series, 0, len(v.series))
+	for _, s := range v.series {
+		list = append(list, *s)
+	}
+	v.mu.Unlock()
+
+	v.writeHeader(w)
+	sortSeries(list)
+	for _, s := range list {
+		writeSample(w, v.metricName, v.labels, s.labels, "", "", s.value)
+	}
+}
+
+// Counter は単調増加する値（ラベルごと）
+type Counter struct {
+	valueVec
+}
+
+// NewCounter はカウンタを登録する
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{valueVec{
+		desc:   desc{metricName: name, help: help, typ: TypeCounter, labels: labels},
+		series: make(map[string]*series),
+	}}
+	r.register(c)
+	return c
+}
+
+// Inc はカウンタを1増やす
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Add はカウンタをdelta増やす（負の値は無視する）
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.add(delta, labelValues)
+}
+
+// Value は現在の値を返す
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.value(labelValues)
+}
+
+// Gauge は増減する値（ラベルごと）
+type Gauge struct {
+	valueVec
+}
+
+// NewGauge はゲージを登録する
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{valueVec{
+		desc:   desc{metricName: name, help: help, typ: TypeGauge, labels: labels},
+		series: make(map[string]*series),
+	}}
+	r.register(g)
+	return g
+}
+
+// Set は値を設定する
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+// Add は値をdelta増減する
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+// Value は現在の値を返す
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.value(labelValues)
+}
+
+// Histogram は観測値の分布（ラベルごと）
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // バケットごとの件数（累積ではない）
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram はヒストグラムを登録する（bucketsがnilの場合はDefaultBuckets）
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{
+		desc:    desc{metricName: name, help: help, typ: TypeHistogram, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe は値を記録する
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.checkLabels(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := seriesKey(labelValues)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += value
+}
+
+// Count は記録した件数を返す
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[seriesKey(labelValues)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	list := make([]histogramSeries, 0, len(h.series))
+	for _, s := range h.series {
+		c := *s
+		c.counts = append([]uint64(nil), s.counts...)
+		list = append(list, c)
+	}
+	h.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return lessLabels(list[i].labels, list[j].labels) })
+
+	h.writeHeader(w)
+	for _, s := range list {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.metricName+"_bucket", h.labels, s.labels, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, h.metricName+"_bucket", h.labels, s.labels, "le", "+Inf", float64(s.count))
+		writeSample(w, h.metricName+"_sum", h.labels, s.labels, "", "", s.sum)
+		writeSample(w, h.metricName+"_count", h.labels, s.labels, "", "", float64(s.count))
+	}
+}
+
+// Func はスクレイプ時に値を収集するメトリクス
+// 他の集計（使用量等）から値を読み出す場合に使う
+type Func struct {
+	desc
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// NewFunc はスクレイプ時にcollectを呼び出して値を収集するメトリクスを登録する
+// typにはTypeCounterまたはTypeGaugeを指定する
+func (r *Registry) NewFunc(name, help, typ string, labels []string, collect func(emit func(value float64, labelValues ...string))) *Func {
+	f := &Func{
+		desc:    desc{metricName: name, help: help, typ: typ, labels: labels},
+		collect: collect,
+	}
+	r.register(f)
+	return f
+}
+
+func (f *Func) write(w *bufio.Writer) {
+	var list []series
+	f.collect(func(value float64, labelValues ...string) {
+		f.checkLabels(labelValues)
+		list = append(list, series{labels: append([]string(nil), labelValues...), value: value})
+	})
+
+	f.writeHeader(w)
+	sortSeries(list)
+	for _, s := range list {
+		writeSample(w, f.metricName, f.labels, s.labels, "", "", s.value)
+	}
+}
+
+func sortSeries(list []series) {
+	sort.Slice(list, func(i, j int) bool { return lessLabels(list[i].labels, list[j].labels) })
+}
+
+func lessLabels(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// writeSample はサンプル1行を書き出す（extraNameが空でない場合はラベルを1つ追加する）
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, label, labelValues[i])
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	w.WriteString(escapeLabelValue(value))
+	w.WriteByte('"')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("test_requests_total", "Requests by path.", "path", "code")
+	live := r.NewGauge("test_live", "Live connections.")
+	latency := r.NewHistogram("test_latency_seconds", "Latency.", []float64{0.5, 0.1}, "op")
+	r.NewFunc("test_tokens_total", "Tokens.", TypeCounter, []string{"model"}, func(emit func(float64, ...string)) {
+		emit(7, "b")
+		emit(3, "a")
+	})
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "500")
+	requests.Add(-1, "/a", "500") // カウンタは減らない
+	requests.Inc("/q\"\n", "200")
+	live.Add(2)
+	live.Add(-1)
+	latency.Observe(0.1, "send")
+	latency.Observe(0.3, "send")
+	latency.Observe(3, "send")
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_requests_total Requests by path.
+# TYPE test_requests_total counter
+test_requests_total{path="/a",code="500"} 2
+test_requests_total{path="/b",code="200"} 1
+test_requests_total{path="/q\"\n",code="200"} 1
+# HELP test_live Live connections.
+# TYPE test_live gauge
+test_live 1
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{op="send",le="0.1"} 1
+test_latency_seconds_bucket{op="send",le="0.5"} 2
+test_latency_seconds_bucket{op="send",le="+Inf"} 3
+test_latency_seconds_sum{op="send"} 3.4
+test_latency_seconds_count{op="send"} 3
+# HELP test_tokens_total Tokens.
+# TYPE test_tokens_total counter
+test_tokens_total{model="a"} 3
+test_tokens_total{model="b"} 7
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "test_total 1\n") {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestRegistry_InvalidUse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test.", "a")
+
+	assertPanics(t, "duplicate", func() { r.NewGauge("test_total", "Test.") })
+	assertPanics(t, "label count", func() { c.Inc() })
+}
+
+func assertPanics(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s: expected panic", name)
+		}
+	}()
+	fn()
+}
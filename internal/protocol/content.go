@@ -0,0 +1,576 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// コンテンツブロックの種類
+const (
+	BlockTypeText                   = "text"
+	BlockTypeThinking               = "thinking"
+	BlockTypeRedactedThinking       = "redacted_thinking"
+	BlockTypeToolUse                = "tool_use"
+	BlockTypeToolResult             = "tool_result"
+	BlockTypeImage                  = "image"
+	BlockTypeDocument               = "document"
+	BlockTypeServerToolUse          = "server_tool_use"
+	BlockTypeWebSearchToolResult    = "web_search_tool_result"
+	BlockTypeWebSearchResult        = "web_search_result"
+	BlockTypeWebSearchToolResultErr = "web_search_tool_result_error"
+)
+
+// Block はメッセージ内のコンテンツブロック
+// 実装はこのパッケージのブロック型（*TextBlock、*ToolUseBlock等）に限られ、型switchで種類を判別する
+type Block interface {
+	// BlockType はブロックの種類（"text"、"tool_use"等）を返す
+	BlockType() string
+	isBlock()
+}
+
+// Extra は型で定義していないフィールド（citations、cache_control等）の元のJSON
+// 各ブロック型に埋め込まれ、再出力時にそのまま含める
+type Extra map[string]json.RawMessage
+
+// TextBlock はテキストブロック
+type TextBlock struct {
+	Text  string `json:"text"`
+	Extra `json:"-"`
+}
+
+// ThinkingBlock は思考ブロック
+type ThinkingBlock struct {
+	Thinking  string `json:"thinking"`
+	Signature string `json:"signature,omitempty"`
+	Extra     `json:"-"`
+}
+
+// RedactedThinkingBlock は暗号化された思考ブロック
+type RedactedThinkingBlock struct {
+	Data  string `json:"data"`
+	Extra `json:"-"`
+}
+
+// ToolUseBlock はツール呼び出しブロック
+type ToolUseBlock struct {
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Input map[string]any `json:"input"`
+	Extra `json:"-"`
+}
+
+// ToolResultBlock はツール実行結果のブロック
+type ToolResultBlock struct {
+	ToolUseID string        `json:"tool_use_id"`
+	Content   *BlockContent `json:"content,omitempty"`
+	IsError   bool          `json:"is_error,omitempty"`
+	Extra     `json:"-"`
+}
+
+// ImageBlock は画像ブロック
+type ImageBlock struct {
+	Source *ContentSource `json:"source"`
+	Extra  `json:"-"`
+}
+
+// DocumentBlock はドキュメントブロック
+type DocumentBlock struct {
+	Source *ContentSource `json:"source"`
+	Title  string         `json:"title,omitempty"`
+	Extra  `json:"-"`
+}
+
+// ServerToolUseBlock はサーバー側ツール（web_search等）の呼び出しブロック
+type ServerToolUseBlock struct {
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Input map[string]any `json:"input"`
+	Extra `json:"-"`
+}
+
+// WebSearchToolResultBlock はweb_searchの実行結果のブロック
+// contentは検索結果の配列、またはエラーの単一ブロック
+type WebSearchToolResultBlock struct {
+	ToolUseID string        `json:"tool_use_id"`
+	Content   *BlockContent `json:"content,omitempty"`
+	Extra     `json:"-"`
+}
+
+// WebSearchResultBlock はweb_searchの検索結果1件
+type WebSearchResultBlock struct {
+	Title            string `json:"title,omitempty"`
+	URL              string `json:"url,omitempty"`
+	EncryptedContent string `json:"encrypted_content,omitempty"`
+	PageAge          string `json:"page_age,omitempty"`
+	Extra            `json:"-"`
+}
+
+// WebSearchToolResultErrorBlock はweb_searchのエラー
+type WebSearchToolResultErrorBlock struct {
+	ErrorCode string `json:"error_code"`
+	Extra     `json:"-"`
+}
+
+// UnknownBlock は未知の種類のブロック（元のJSONをそのまま再出力する）
+type UnknownBlock struct {
+	Type string
+	Raw  json.RawMessage
+}
+
+func (*TextBlock) BlockType() string                     { return BlockTypeText }
+func (*ThinkingBlock) BlockType() string                 { return BlockTypeThinking }
+func (*RedactedThinkingBlock) BlockType() string         { return BlockTypeRedactedThinking }
+func (*ToolUseBlock) BlockType() string                  { return BlockTypeToolUse }
+func (*ToolResultBlock) BlockType() string               { return BlockTypeToolResult }
+func (*ImageBlock) BlockType() string                    { return BlockTypeImage }
+func (*DocumentBlock) BlockType() string                 { return BlockTypeDocument }
+func (*ServerToolUseBlock) BlockType() string            { return BlockTypeServerToolUse }
+func (*WebSearchToolResultBlock) BlockType() string      { return BlockTypeWebSearchToolResult }
+func (*WebSearchResultBlock) BlockType() string          { return BlockTypeWebSearchResult }
+func (*WebSearchToolResultErrorBlock) BlockType() string { return BlockTypeWebSearchToolResultErr }
+func (b *UnknownBlock) BlockType() string                { return b.Type }
+
+func (*TextBlock) isBlock()                     {}
+func (*ThinkingBlock) isBlock()                 {}
+func (*RedactedThinkingBlock) isBlock()         {}
+func (*ToolUseBlock) isBlock()                  {}
+func (*ToolResultBlock) isBlock()               {}
+func (*ImageBlock) isBlock()                    {}
+func (*DocumentBlock) isBlock()                 {}
+func (*ServerToolUseBlock) isBlock()            {}
+func (*WebSearchToolResultBlock) isBlock()      {}
+func (*WebSearchResultBlock) isBlock()          {}
+func (*WebSearchToolResultErrorBlock) isBlock() {}
+func (*UnknownBlock) isBlock()                  {}
+
+// newBlock は種類に対応する空のブロックを作成する
+func newBlock(blockType string) Block {
+	switch blockType {
+	case BlockTypeText:
+		return &TextBlock{}
+	case BlockTypeThinking:
+		return &ThinkingBlock{}
+	case BlockTypeRedactedThinking:
+		return &RedactedThinkingBlock{}
+	case BlockTypeToolUse:
+		return &ToolUseBlock{}
+	case BlockTypeToolResult:
+		return &ToolResultBlock{}
+	case BlockTypeImage:
+		return &ImageBlock{}
+	case BlockTypeDocument:
+		return &DocumentBlock{}
+	case BlockTypeServerToolUse:
+		return &ServerToolUseBlock{}
+	case BlockTypeWebSearchToolResult:
+		return &WebSearchToolResultBlock{}
+	case BlockTypeWebSearchResult:
+		return &WebSearchResultBlock{}
+	case BlockTypeWebSearchToolResultErr:
+		return &WebSearchToolResultErrorBlock{}
+	}
+	return &UnknownBlock{Type: blockType}
+}
+
+// DecodeBlock はtypeフィールドで種類を判別してブロックをデコードする
+func DecodeBlock(data []byte) (Block, error) {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+	block := newBlock(head.Type)
+	if err := json.Unmarshal(data, block); err != nil {
+		return nil, fmt.Errorf("decode %s block: %w", head.Type, err)
+	}
+	return block, nil
+}
+
+// Blocks はコンテンツブロックの配列（要素の種類ごとにデコードする）
+type Blocks []Block
+
+// UnmarshalJSON は各要素をtypeに応じたブロック型でデコードする
+func (bs *Blocks) UnmarshalJSON(data []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return err
+	}
+	if raws == nil {
+		*bs = nil
+		return nil
+	}
+	blocks := make(Blocks, 0, len(raws))
+	for _, raw := range raws {
+		block, err := DecodeBlock(raw)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+	}
+	*bs = blocks
+	return nil
+}
+
+// marshalBlock はtypeとフィールドにExtraを加えたJSONを出力する
+func marshalBlock(blockType string, fields any, extra Extra) ([]byte, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := obj[key]; !ok {
+			obj[key] = value
+		}
+	}
+	obj["type"], _ = json.Marshal(blockType)
+	return json.Marshal(obj)
+}
+
+// unmarshalBlock はフィールドをデコードし、型で定義していないフィールドをExtraに保持する
+func unmarshalBlock(data []byte, fields any, extra *Extra) error {
+	if err := json.Unmarshal(data, fields); err != nil {
+		return err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	delete(obj, "type")
+	for name := range jsonFieldNames(reflect.TypeOf(fields).Elem()) {
+		delete(obj, name)
+	}
+	*extra = nil
+	if len(obj) > 0 {
+		*extra = obj
+	}
+	return nil
+}
+
+// blockFieldNames はブロック型ごとのJSONフィールド名のキャッシュ
+var blockFieldNames sync.Map // reflect.Type -> map[string]bool
+
+// jsonFieldNames は構造体のJSONフィールド名を返す
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	if names, ok := blockFieldNames.Load(t); ok {
+		return names.(map[string]bool)
+	}
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	blockFieldNames.Store(t, names)
+	return names
+}
+
+func (b TextBlock) MarshalJSON() ([]byte, error) {
+	type fields TextBlock
+	return marshalBlock(BlockTypeText, fields(b), b.Extra)
+}
+
+func (b *TextBlock) UnmarshalJSON(data []byte) error {
+	type fields TextBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b ThinkingBlock) MarshalJSON() ([]byte, error) {
+	type fields ThinkingBlock
+	return marshalBlock(BlockTypeThinking, fields(b), b.Extra)
+}
+
+func (b *ThinkingBlock) UnmarshalJSON(data []byte) error {
+	type fields ThinkingBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b RedactedThinkingBlock) MarshalJSON() ([]byte, error) {
+	type fields RedactedThinkingBlock
+	return marshalBlock(BlockTypeRedactedThinking, fields(b), b.Extra)
+}
+
+func (b *RedactedThinkingBlock) UnmarshalJSON(data []byte) error {
+	type fields RedactedThinkingBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b ToolUseBlock) MarshalJSON() ([]byte, error) {
+	type fields ToolUseBlock
+	// inputは空でも省略しない
+	if b.Input == nil {
+		b.Input = map[string]any{}
+	}
+	return marshalBlock(BlockTypeToolUse, fields(b), b.Extra)
+}
+
+func (b *ToolUseBlock) UnmarshalJSON(data []byte) error {
+	type fields ToolUseBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b ToolResultBlock) MarshalJSON() ([]byte, error) {
+	type fields ToolResultBlock
+	return marshalBlock(BlockTypeToolResult, fields(b), b.Extra)
+}
+
+func (b *ToolResultBlock) UnmarshalJSON(data []byte) error {
+	type fields ToolResultBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b ImageBlock) MarshalJSON() ([]byte, error) {
+	type fields ImageBlock
+	return marshalBlock(BlockTypeImage, fields(b), b.Extra)
+}
+
+func (b *ImageBlock) UnmarshalJSON(data []byte) error {
+	type fields ImageBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b DocumentBlock) MarshalJSON() ([]byte, error) {
+	type fields DocumentBlock
+	return marshalBlock(BlockTypeDocument, fields(b), b.Extra)
+}
+
+func (b *DocumentBlock) UnmarshalJSON(data []byte) error {
+	type fields DocumentBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b ServerToolUseBlock) MarshalJSON() ([]byte, error) {
+	type fields ServerToolUseBlock
+	if b.Input == nil {
+		b.Input = map[string]any{}
+	}
+	return marshalBlock(BlockTypeServerToolUse, fields(b), b.Extra)
+}
+
+func (b *ServerToolUseBlock) UnmarshalJSON(data []byte) error {
+	type fields ServerToolUseBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b WebSearchToolResultBlock) MarshalJSON() ([]byte, error) {
+	type fields WebSearchToolResultBlock
+	return marshalBlock(BlockTypeWebSearchToolResult, fields(b), b.Extra)
+}
+
+func (b *WebSearchToolResultBlock) UnmarshalJSON(data []byte) error {
+	type fields WebSearchToolResultBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b WebSearchResultBlock) MarshalJSON() ([]byte, error) {
+	type fields WebSearchResultBlock
+	return marshalBlock(BlockTypeWebSearchResult, fields(b), b.Extra)
+}
+
+func (b *WebSearchResultBlock) UnmarshalJSON(data []byte) error {
+	type fields WebSearchResultBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b WebSearchToolResultErrorBlock) MarshalJSON() ([]byte, error) {
+	type fields WebSearchToolResultErrorBlock
+	return marshalBlock(BlockTypeWebSearchToolResultErr, fields(b), b.Extra)
+}
+
+func (b *WebSearchToolResultErrorBlock) UnmarshalJSON(data []byte) error {
+	type fields WebSearchToolResultErrorBlock
+	return unmarshalBlock(data, (*fields)(b), &b.Extra)
+}
+
+func (b UnknownBlock) MarshalJSON() ([]byte, error) {
+	if b.Raw != nil {
+		return b.Raw, nil
+	}
+	return json.Marshal(map[string]string{"type": b.Type})
+}
+
+func (b *UnknownBlock) UnmarshalJSON(data []byte) error {
+	b.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// ContentSource は画像・ドキュメントのデータソース
+type ContentSource struct {
+	Type      string `json:"type"` // "base64", "url", "text"
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// NewTextBlock はテキストブロックを作成する
+func NewTextBlock(text string) *TextBlock {
+	return &TextBlock{Text: text}
+}
+
+// NewToolResultBlock はツール実行結果のブロックを作成する
+func NewToolResultBlock(toolUseID string, content BlockContent, isError bool) *ToolResultBlock {
+	return &ToolResultBlock{
+		ToolUseID: toolUseID,
+		Content:   &content,
+		IsError:   isError,
+	}
+}
+
+// ResultText はtool_resultの内容をテキストとして返す
+func (b *ToolResultBlock) ResultText() string {
+	if b.Content == nil {
+		return ""
+	}
+	return b.Content.String()
+}
+
+// Results はweb_search_tool_resultの検索結果を返す（エラーの場合はnil）
+func (b *WebSearchToolResultBlock) Results() []*WebSearchResultBlock {
+	if b.Content == nil {
+		return nil
+	}
+	return blocksOf[*WebSearchResultBlock](b.Content.Blocks)
+}
+
+// SearchError はweb_search_tool_resultのエラーを返す（成功した場合はnil）
+func (b *WebSearchToolResultBlock) SearchError() *WebSearchToolResultErrorBlock {
+	if b.Content == nil {
+		return nil
+	}
+	if errs := blocksOf[*WebSearchToolResultErrorBlock](b.Content.Blocks); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// BlockContent はメッセージやtool_resultのcontentフィールド
+// 文字列・ブロック配列・単一ブロック（web_search_tool_resultのエラー）のいずれかの形式をとる
+type BlockContent struct {
+	Text   string // 文字列形式の場合
+	Blocks Blocks // 配列形式（または単一ブロック）の場合
+
+	// single は単一ブロック（オブジェクト）形式だったか
+	single bool
+}
+
+// NewTextContent は文字列形式のBlockContentを作成する
+func NewTextContent(text string) BlockContent {
+	return BlockContent{Text: text}
+}
+
+// NewBlocksContent は配列形式のBlockContentを作成する
+func NewBlocksContent(blocks ...Block) BlockContent {
+	if blocks == nil {
+		blocks = Blocks{}
+	}
+	return BlockContent{Blocks: blocks}
+}
+
+// IsText は文字列形式かを返す
+func (c BlockContent) IsText() bool {
+	return c.Blocks == nil
+}
+
+// String はテキストを連結して返す
+func (c BlockContent) String() string {
+	if c.IsText() {
+		return c.Text
+	}
+	var parts []string
+	for _, block := range blocksOf[*TextBlock](c.Blocks) {
+		parts = append(parts, block.Text)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// MarshalJSON は元の形式でJSONを出力する
+func (c BlockContent) MarshalJSON() ([]byte, error) {
+	if c.single && len(c.Blocks) == 1 {
+		return json.Marshal(c.Blocks[0])
+	}
+	if c.Blocks != nil {
+		return json.Marshal(c.Blocks)
+	}
+	return json.Marshal(c.Text)
+}
+
+// UnmarshalJSON は文字列・配列・オブジェクトのいずれの形式もデコードする
+func (c *BlockContent) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	*c = BlockContent{}
+
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	switch data[0] {
+	case '"':
+		return json.Unmarshal(data, &c.Text)
+	case '[':
+		c.Blocks = Blocks{}
+		return json.Unmarshal(data, &c.Blocks)
+	case '{':
+		block, err := DecodeBlock(data)
+		if err != nil {
+			return err
+		}
+		c.Blocks = Blocks{block}
+		c.single = true
+		return nil
+	default:
+		return fmt.Errorf("unsupported content format: %s", data)
+	}
+}
+
+// Text はテキストブロックを連結して返す
+func (m *AssistantMessage) Text() string {
+	var sb strings.Builder
+	for _, block := range blocksOf[*TextBlock](m.Message.Content) {
+		sb.WriteString(block.Text)
+	}
+	return sb.String()
+}
+
+// ToolUses はtool_useブロックを返す
+func (m *AssistantMessage) ToolUses() []*ToolUseBlock {
+	return blocksOf[*ToolUseBlock](m.Message.Content)
+}
+
+// Thinking は思考ブロックを連結して返す
+func (m *AssistantMessage) Thinking() string {
+	var sb strings.Builder
+	for _, block := range blocksOf[*ThinkingBlock](m.Message.Content) {
+		sb.WriteString(block.Thinking)
+	}
+	return sb.String()
+}
+
+// Text はユーザーメッセージのテキストを返す
+func (m *UserMessage) Text() string {
+	return m.Message.Content.String()
+}
+
+// ToolResults はtool_resultブロックを返す
+func (m *UserMessage) ToolResults() []*ToolResultBlock {
+	return blocksOf[*ToolResultBlock](m.Message.Content.Blocks)
+}
+
+// blocksOf はblocksのうち型Tのブロックを返す
+func blocksOf[T Block](blocks Blocks) []T {
+	var result []T
+	for _, block := range blocks {
+		if b, ok := block.(T); ok {
+			result = append(result, b)
+		}
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestContentBlock_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"text", `{"type":"text","text":"Hello"}`},
+		{"empty text", `{"type":"text","text":""}`},
+		{"thinking", `{"type":"thinking","thinking":"考え中","signature":"sig-1"}`},
+		{"redacted_thinking", `{"type":"redacted_thinking","data":"ZW5jcnlwdGVk"}`},
+		{"tool_use", `{"type":"tool_use","id":"toolu_1","name":"Read","input":{"file_path":"/tmp/a.txt"}}`},
+		{"tool_use without input", `{"type":"tool_use","id":"toolu_2","name":"TodoRead","input":{}}`},
+		{"tool_result string", `{"type":"tool_result","tool_use_id":"toolu_1","content":"ok"}`},
+		{"tool_result blocks", `{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"line"}],"is_error":true}`},
+		{"image", `{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}}`},
+		{"document", `{"type":"document","source":{"type":"text","media_type":"text/plain","data":"本文"},"title":"memo.txt"}`},
+		{"server_tool_use", `{"type":"server_tool_use","id":"srvtoolu_1","name":"web_search","input":{"query":"golang"}}`},
+		{"web_search_tool_result", `{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":[{"type":"web_search_result","title":"Go","url":"https://go.dev","encrypted_content":"abc","page_age":"1 day"}]}`},
+		{"web_search_tool_result error", `{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":{"type":"web_search_tool_result_error","error_code":"max_uses_exceeded"}}`},
+		{"text with citations", `{"type":"text","text":"Go","citations":[{"type":"web_search_result_location","url":"https://go.dev"}],"cache_control":{"type":"ephemeral"}}`},
+		{"tool_use with cache_control", `{"type":"tool_use","id":"toolu_3","name":"Read","input":{},"cache_control":{"type":"ephemeral"}}`},
+		{"unknown", `{"type":"future_block","payload":{"nested":[1,2,3]}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := DecodeBlock([]byte(tt.json))
+			if err != nil {
+				t.Fatalf("DecodeBlock failed: %v", err)
+			}
+
+			data, err := json.Marshal(block)
+			if err != nil {
+				t.Fatalf("json.Marshal failed: %v", err)
+			}
+
+			var want, got any
+			json.Unmarshal([]byte(tt.json), &want)
+			json.Unmarshal(data, &got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip mismatch\n got: %s\nwant: %s", data, tt.json)
+			}
+		})
+	}
+}
+
+func TestContentBlock_DecodeByType(t *testing.T) {
+	block, err := DecodeBlock([]byte(`{"type":"text","text":"Go","citations":[{"url":"https://go.dev"}]}`))
+	if err != nil {
+		t.Fatalf("DecodeBlock failed: %v", err)
+	}
+	text, ok := block.(*TextBlock)
+	if !ok {
+		t.Fatalf("block = %T, want *TextBlock", block)
+	}
+	// 型で定義していないフィールドはExtraに保持する
+	if text.Text != "Go" || len(text.Extra) != 1 || text.Extra["citations"] == nil {
+		t.Errorf("text block = %+v", text)
+	}
+
+	unknown, err := DecodeBlock([]byte(`{"type":"future_block"}`))
+	if err != nil {
+		t.Fatalf("DecodeBlock failed: %v", err)
+	}
+	if u, ok := unknown.(*UnknownBlock); !ok || u.BlockType() != "future_block" {
+		t.Errorf("unknown block = %+v", unknown)
+	}
+}
+
+func TestContentBlock_WebSearchResults(t *testing.T) {
+	data := `{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":[{"type":"web_search_result","title":"Go","url":"https://go.dev"}]}`
+	block, err := DecodeBlock([]byte(data))
+	if err != nil {
+		t.Fatalf("DecodeBlock failed: %v", err)
+	}
+
+	result := block.(*WebSearchToolResultBlock)
+	results := result.Results()
+	if len(results) != 1 {
+		t.Fatalf("len(results) = %d, want %d", len(results), 1)
+	}
+	if results[0].URL != "https://go.dev" || results[0].Title != "Go" {
+		t.Errorf("result = %+v", results[0])
+	}
+	if result.SearchError() != nil {
+		t.Errorf("SearchError() = %+v, want nil", result.SearchError())
+	}
+
+	failed, _ := DecodeBlock([]byte(`{"type":"web_search_tool_result","tool_use_id":"srvtoolu_2","content":{"type":"web_search_tool_result_error","error_code":"max_uses_exceeded"}}`))
+	if e := failed.(*WebSearchToolResultBlock).SearchError(); e == nil || e.ErrorCode != "max_uses_exceeded" {
+		t.Errorf("SearchError() = %+v", e)
+	}
+}
+
+func TestBlockContent_Formats(t *testing.T) {
+	var text BlockContent
+	if err := json.Unmarshal([]byte(`"Hello"`), &text); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if !text.IsText() || text.String() != "Hello" {
+		t.Errorf("text content = %+v", text)
+	}
+
+	var blocks BlockContent
+	if err := json.Unmarshal([]byte(`[{"type":"text","text":"a"},{"type":"text","text":"b"}]`), &blocks); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if blocks.IsText() || blocks.String() != "a\nb" {
+		t.Errorf("blocks content = %+v", blocks)
+	}
+
+	// 空配列は配列のまま再出力する
+	data, _ := json.Marshal(NewBlocksContent())
+	if string(data) != "[]" {
+		t.Errorf("empty blocks = %s, want []", data)
+	}
+}
+
+func TestAssistantMessage_Accessors(t *testing.T) {
+	data := `{"type":"assistant","message":{"role":"assistant","model":"claude-sonnet","content":[
+		{"type":"thinking","thinking":"まず読む","signature":"s"},
+		{"type":"text","text":"ファイルを読みます。"},
+		{"type":"tool_use","id":"toolu_1","name":"Read","input":{"file_path":"a.go"}},
+		{"type":"tool_use","id":"toolu_2","name":"Grep","input":{"pattern":"x"}}
+	]}}`
+
+	var msg AssistantMessage
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+
+	if msg.Text() != "ファイルを読みます。" {
+		t.Errorf("Text() = %q", msg.Text())
+	}
+	if msg.Thinking() != "まず読む" {
+		t.Errorf("Thinking() = %q", msg.Thinking())
+	}
+	toolUses := msg.ToolUses()
+	if len(toolUses) != 2 {
+		t.Fatalf("len(ToolUses()) = %d, want %d", len(toolUses), 2)
+	}
+	if toolUses[1].Name != "Grep" || toolUses[1].Input["pattern"] != "x" {
+		t.Errorf("ToolUses()[1] = %+v", toolUses[1])
+	}
+}
+
+func TestUserMessage_ToolResults(t *testing.T) {
+	data := `{"type":"user","message":{"role":"user","content":[
+		{"type":"tool_result","tool_use_id":"toolu_1","content":"package main"},
+		{"type":"tool_result","tool_use_id":"toolu_2","content":[{"type":"text","text":"no match"}],"is_error":true}
+	]},"session_id":"s1"}`
+
+	var msg UserMessage
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+
+	results := msg.ToolResults()
+	if len(results) != 2 {
+		t.Fatalf("len(ToolResults()) = %d, want %d", len(results), 2)
+	}
+	if results[0].ToolUseID != "toolu_1" || results[0].ResultText() != "package main" {
+		t.Errorf("ToolResults()[0] = %+v", results[0])
+	}
+	if !results[1].IsError || results[1].ResultText() != "no match" {
+		t.Errorf("ToolResults()[1] = %+v", results[1])
+	}
+
+	// 文字列形式のユーザーメッセージ
+	var plain UserMessage
+	json.Unmarshal([]byte(`{"type":"user","message":{"role":"user","content":"Hi"}}`), &plain)
+	if plain.Text() != "Hi" || plain.ToolResults() != nil {
+		t.Errorf("plain message = %+v", plain)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/y-oga-819/my-go-claude-agent/internal/logging"
 	"github.com/y-oga-819/my-go-claude-agent/internal/transport"
 )
 
@@ -28,11 +30,30 @@ type ProtocolHandler struct {
 	hookCallbacks      map[string][]HookCallback
 	mcpMessageCallback MCPMessageCallback
 
+	// 配信前にメッセージを観測するコールバック
+	messageObserver MessageObserver
+
+	// 制御リクエストのタイムアウト時に呼ばれるコールバック
+	controlTimeoutCallback ControlTimeoutCallback
+
+	logger *slog.Logger
+
 	mu sync.RWMutex
 
 	// メッセージ出力チャネル
 	msgChan chan Message
 	errChan chan error
+
+	// メッセージ配信の設定と統計
+	delivery DeliveryConfig
+	stats    deliveryState
+	spill    *spillQueue
+
+	// closeMu はチャネルのクローズと送信を排他する
+	closeMu   sync.RWMutex
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // CanUseToolCallback はツール使用許可確認のコールバック
@@ -44,6 +65,13 @@ type HookCallback func(ctx context.Context, req *HookCallbackRequest) (*HookCall
 // MCPMessageCallback はMCPメッセージのコールバック
 type MCPMessageCallback func(ctx context.Context, req *MCPMessageRequest) (*MCPMessageResponse, error)
 
+// MessageObserver は制御メッセージ以外の受信メッセージを配信前に観測するコールバック
+// 受信ループ内で呼ばれるため、ブロックしてはならない
+type MessageObserver func(msg Message)
+
+// ControlTimeoutCallback はSDKからの制御リクエストがタイムアウトした際に呼ばれるコールバック
+type ControlTimeoutCallback func(subtype string)
+
 // CanUseToolRequest はツール使用許可リクエスト
 type CanUseToolRequest struct {
 	ToolName              string                `json:"tool_name"`
@@ -76,17 +104,41 @@ type PermissionUpdate struct {
 
 // HookCallbackRequest はフックコールバックリクエスト
 type HookCallbackRequest struct {
-	HookType  string         `json:"hook_type"`
-	ToolName  string         `json:"tool_name,omitempty"`
-	Input     map[string]any `json:"input,omitempty"`
-	Output    map[string]any `json:"output,omitempty"`
-	SessionID string         `json:"session_id,omitempty"`
+	CallbackID string         `json:"callback_id,omitempty"` // initialize時に登録したコールバックID
+	HookType   string         `json:"hook_type,omitempty"`
+	ToolName   string         `json:"tool_name,omitempty"`
+	ToolUseID  string         `json:"tool_use_id,omitempty"`
+	Input      map[string]any `json:"input,omitempty"` // フック入力（hook_event_name, tool_input等）
+	Output     map[string]any `json:"output,omitempty"`
+	SessionID  string         `json:"session_id,omitempty"`
 }
 
 // HookCallbackResponse はフックコールバックレスポンス
 type HookCallbackResponse struct {
-	Continue bool   `json:"continue"`
-	Message  string `json:"message,omitempty"`
+	Continue           bool                `json:"continue"`
+	Message            string              `json:"message,omitempty"`
+	StopReason         string              `json:"stopReason,omitempty"`
+	SuppressOutput     bool                `json:"suppressOutput,omitempty"`
+	Decision           string              `json:"decision,omitempty"` // "block"
+	SystemMessage      string              `json:"systemMessage,omitempty"`
+	Reason             string              `json:"reason,omitempty"`
+	HookSpecificOutput *HookSpecificOutput `json:"hookSpecificOutput,omitempty"`
+}
+
+// HookSpecificOutput はフック固有の出力
+type HookSpecificOutput struct {
+	HookEventName            string         `json:"hookEventName"`
+	PermissionDecision       string         `json:"permissionDecision,omitempty"` // "allow", "deny", "ask"
+	PermissionDecisionReason string         `json:"permissionDecisionReason,omitempty"`
+	UpdatedInput             map[string]any `json:"updatedInput,omitempty"`
+	AdditionalContext        string         `json:"additionalContext,omitempty"`
+}
+
+// HookMatcher はinitialize時にCLIへ登録するフックマッチャー
+type HookMatcher struct {
+	Matcher         string   `json:"matcher,omitempty"` // ツール名パターン（空で全てにマッチ）
+	HookCallbackIDs []string `json:"hookCallbackIds"`
+	Timeout         float64  `json:"timeout,omitempty"` // 秒
 }
 
 // MCPMessageRequest はMCPメッセージリクエスト
@@ -97,7 +149,21 @@ type MCPMessageRequest struct {
 
 // MCPMessageResponse はMCPメッセージレスポンス
 type MCPMessageResponse struct {
-	Message map[string]any `json:"message"`
+	MCPResponse map[string]any `json:"mcp_response"` // JSON-RPC 2.0レスポンス
+}
+
+// OutputFormat は構造化出力の形式を表す
+type OutputFormat struct {
+	Type   string         `json:"type"` // "json_schema"
+	Schema map[string]any `json:"schema"`
+}
+
+// AgentDefinition はサブエージェントの定義
+type AgentDefinition struct {
+	Description string   `json:"description"`
+	Prompt      string   `json:"prompt"`
+	Tools       []string `json:"tools,omitempty"`
+	Model       string   `json:"model,omitempty"` // "sonnet", "opus", "haiku", "inherit"
 }
 
 // InitializeRequest は初期化リクエスト
@@ -111,9 +177,19 @@ type InitializeRequest struct {
 	PermissionMode     string            `json:"permission_mode,omitempty"`
 	Model              string            `json:"model,omitempty"`
 	MaxTurns           int               `json:"max_turns,omitempty"`
+	MaxThinkingTokens  int               `json:"max_thinking_tokens,omitempty"`
 	MaxBudgetUSD       float64           `json:"max_budget_usd,omitempty"`
 	Options            map[string]string `json:"options,omitempty"`
 
+	// フック設定（イベント名 → マッチャー）
+	Hooks map[string][]HookMatcher `json:"hooks,omitempty"`
+
+	// 構造化出力の形式
+	OutputFormat *OutputFormat `json:"output_format,omitempty"`
+
+	// サブエージェント定義（名前 → 定義）
+	Agents map[string]AgentDefinition `json:"agents,omitempty"`
+
 	// セッション設定
 	Resume                  string `json:"resume,omitempty"`                    // 再開するセッションID
 	ForkSession             bool   `json:"fork_session,omitempty"`              // trueで分岐
@@ -122,8 +198,50 @@ type InitializeRequest struct {
 }
 
 // InitializeResponse は初期化レスポンス
+// CLIが提供する機能のスナップショットを含む
 type InitializeResponse struct {
-	SessionID string `json:"session_id"`
+	SessionID             string         `json:"session_id"`
+	Commands              []SlashCommand `json:"commands,omitempty"`
+	Models                []ModelInfo    `json:"models,omitempty"`
+	Account               *AccountInfo   `json:"account,omitempty"`
+	OutputStyle           string         `json:"output_style,omitempty"`
+	AvailableOutputStyles []string       `json:"available_output_styles,omitempty"`
+}
+
+// SlashCommand はCLIで利用可能なスラッシュコマンド
+type SlashCommand struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	ArgumentHint string `json:"argumentHint,omitempty"`
+}
+
+// ModelInfo はCLIで利用可能なモデル
+type ModelInfo struct {
+	Value       string `json:"value"` // Modelオプションに指定する値
+	DisplayName string `json:"displayName"`
+	Description string `json:"description,omitempty"`
+}
+
+// AccountInfo はログイン中のアカウント情報
+type AccountInfo struct {
+	Email            string `json:"email,omitempty"`
+	Organization     string `json:"organization,omitempty"`
+	SubscriptionType string `json:"subscriptionType,omitempty"`
+	TokenSource      string `json:"tokenSource,omitempty"`
+	APIKeySource     string `json:"apiKeySource,omitempty"`
+}
+
+// MCPServerStatus はMCPサーバーの接続状態
+type MCPServerStatus struct {
+	Name       string         `json:"name"`
+	Status     string         `json:"status"` // "connected", "failed", "needs-auth", "pending"
+	ServerInfo *MCPServerInfo `json:"serverInfo,omitempty"`
+}
+
+// MCPServerInfo はMCPサーバーの情報
+type MCPServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
 }
 
 // InterruptRequest は中断リクエスト
@@ -137,15 +255,95 @@ type RewindFilesRequest struct {
 	UserMessageID string `json:"user_message_id"` // 巻き戻し先のユーザーメッセージID
 }
 
+// SupportedModelsRequest は利用可能モデル一覧の取得リクエスト
+type SupportedModelsRequest struct {
+	Subtype string `json:"subtype"` // "supported_models"
+}
+
+// SupportedModelsResponse は利用可能モデル一覧
+type SupportedModelsResponse struct {
+	Models []ModelInfo `json:"models"`
+}
+
+// SupportedCommandsRequest はスラッシュコマンド一覧の取得リクエスト
+type SupportedCommandsRequest struct {
+	Subtype string `json:"subtype"` // "supported_commands"
+}
+
+// SupportedCommandsResponse はスラッシュコマンド一覧
+type SupportedCommandsResponse struct {
+	Commands []SlashCommand `json:"commands"`
+}
+
+// AccountInfoRequest はアカウント情報の取得リクエスト
+type AccountInfoRequest struct {
+	Subtype string `json:"subtype"` // "account_info"
+}
+
+// AccountInfoResponse はアカウント情報
+type AccountInfoResponse struct {
+	Account *AccountInfo `json:"account"`
+}
+
+// MCPStatusRequest はMCPサーバー状態の取得リクエスト
+type MCPStatusRequest struct {
+	Subtype string `json:"subtype"` // "mcp_status"
+}
+
+// MCPStatusResponse はMCPサーバー状態の一覧
+type MCPStatusResponse struct {
+	MCPServers []MCPServerStatus `json:"mcpServers"`
+}
+
+// SetModelRequest はモデル変更リクエスト
+type SetModelRequest struct {
+	Subtype string `json:"subtype"`         // "set_model"
+	Model   string `json:"model,omitempty"` // 空の場合はデフォルトモデル
+}
+
+// SetPermissionModeRequest は権限モード変更リクエスト
+type SetPermissionModeRequest struct {
+	Subtype string `json:"subtype"` // "set_permission_mode"
+	Mode    string `json:"mode"`
+}
+
+// SetMaxThinkingTokensRequest は思考トークン上限変更リクエスト
+type SetMaxThinkingTokensRequest struct {
+	Subtype           string `json:"subtype"`             // "set_max_thinking_tokens"
+	MaxThinkingTokens int    `json:"max_thinking_tokens"` // 0で思考を無効化
+}
+
 // NewProtocolHandler は新しいProtocolHandlerを作成する
 func NewProtocolHandler(t transport.Transport) *ProtocolHandler {
-	return &ProtocolHandler{
+	return NewProtocolHandlerWithConfig(t, DeliveryConfig{})
+}
+
+// NewProtocolHandlerWithConfig はメッセージ配信の設定を指定してProtocolHandlerを作成する
+func NewProtocolHandlerWithConfig(t transport.Transport, cfg DeliveryConfig) *ProtocolHandler {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultMessageBufferSize
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = OverflowDropOldest
+	}
+
+	h := &ProtocolHandler{
 		transport:       t,
 		pendingRequests: make(map[string]chan *ControlResponse),
 		hookCallbacks:   make(map[string][]HookCallback),
-		msgChan:         make(chan Message, 100),
+		msgChan:         make(chan Message, cfg.BufferSize),
 		errChan:         make(chan error, 10),
+		delivery:        cfg,
+		logger:          logging.Discard(),
+		done:            make(chan struct{}),
 	}
+
+	if cfg.Policy == OverflowSpill {
+		h.spill = newSpillQueue(cfg.SpillDir)
+		go h.spill.pump(h)
+	}
+
+	return h
 }
 
 // SetCanUseToolCallback はツール使用許可コールバックを設定する
@@ -162,11 +360,60 @@ func (h *ProtocolHandler) SetMCPMessageCallback(cb MCPMessageCallback) {
 	h.mcpMessageCallback = cb
 }
 
+// SetMessageObserver は受信メッセージの観測コールバックを設定する
+func (h *ProtocolHandler) SetMessageObserver(observer MessageObserver) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messageObserver = observer
+}
+
+// SetControlTimeoutCallback は制御リクエストのタイムアウト時のコールバックを設定する
+func (h *ProtocolHandler) SetControlTimeoutCallback(cb ControlTimeoutCallback) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.controlTimeoutCallback = cb
+}
+
+// SetLogger はログの出力先を設定する
+func (h *ProtocolHandler) SetLogger(logger *slog.Logger) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.logger = logging.OrDiscard(logger)
+}
+
+// log は現在のログの出力先を返す
+func (h *ProtocolHandler) log() *slog.Logger {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.logger
+}
+
 // AddHookCallback はフックコールバックを追加する
-func (h *ProtocolHandler) AddHookCallback(hookType string, cb HookCallback) {
+// keyにはinitialize時に登録したコールバックID（またはhook_type）を指定する
+func (h *ProtocolHandler) AddHookCallback(key string, cb HookCallback) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hookCallbacks[key] = append(h.hookCallbacks[key], cb)
+}
+
+// SetTransport は通信先のTransportを差し替える（CLIプロセス再起動時に使用）
+// メッセージチャネルと登録済みコールバックはそのまま引き継ぐ
+func (h *ProtocolHandler) SetTransport(t transport.Transport) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.hookCallbacks[hookType] = append(h.hookCallbacks[hookType], cb)
+	h.transport = t
+}
+
+// currentTransport は現在のTransportを返す
+func (h *ProtocolHandler) currentTransport() transport.Transport {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.transport
+}
+
+// Emit はSDK内部で生成したメッセージをメッセージチャネルに配信する
+func (h *ProtocolHandler) Emit(msg Message) error {
+	return h.deliver(msg, nil)
 }
 
 // Messages はメッセージチャネルを返す
@@ -214,7 +461,12 @@ func (h *ProtocolHandler) SendControlRequestWithTimeout(ctx context.Context, req
 		return nil, fmt.Errorf("marshal control request: %w", err)
 	}
 
-	if err := h.transport.Write(data); err != nil {
+	subtype := requestSubtype(data)
+	logger := h.log().With("request_id", id, "subtype", subtype)
+	logger.Debug("sending control request")
+
+	if err := h.currentTransport().Write(data); err != nil {
+		logger.Error("failed to write control request", "error", err)
 		return nil, fmt.Errorf("write control request: %w", err)
 	}
 
@@ -224,8 +476,18 @@ func (h *ProtocolHandler) SendControlRequestWithTimeout(ctx context.Context, req
 
 	select {
 	case resp := <-respChan:
+		if resp.Response.Subtype == "error" {
+			logger.Warn("control request rejected by CLI", "error", resp.Response.Error)
+		}
 		return resp, nil
 	case <-timeoutCtx.Done():
+		logger.Warn("control request timed out", "timeout", timeout, "error", timeoutCtx.Err())
+		h.mu.RLock()
+		cb := h.controlTimeoutCallback
+		h.mu.RUnlock()
+		if cb != nil {
+			cb(subtype)
+		}
 		return nil, fmt.Errorf("control request timeout: %w", timeoutCtx.Err())
 	}
 }
@@ -234,6 +496,7 @@ func (h *ProtocolHandler) SendControlRequestWithTimeout(ctx context.Context, req
 func (h *ProtocolHandler) HandleIncoming(ctx context.Context, raw transport.RawMessage) error {
 	msg, err := ParseMessage(raw.Data)
 	if err != nil {
+		h.log().Warn("failed to parse message", "type", raw.Type, "error", err, logging.Payload("raw", json.RawMessage(raw.Raw)))
 		return fmt.Errorf("parse message: %w", err)
 	}
 
@@ -244,27 +507,17 @@ func (h *ProtocolHandler) HandleIncoming(ctx context.Context, raw transport.RawM
 	case *ControlResponse:
 		return h.handleControlResponse(m)
 
-	case *AssistantMessage, *SystemMessage, *UserMessage, *ResultMessage:
-		select {
-		case h.msgChan <- msg:
-		default:
-			// チャネルがいっぱいの場合は古いメッセージを破棄
-			select {
-			case <-h.msgChan:
-			default:
-			}
-			h.msgChan <- msg
-		}
-
 	default:
-		// 未知のメッセージ型はそのまま転送
-		select {
-		case h.msgChan <- msg:
-		default:
+		h.mu.RLock()
+		observer := h.messageObserver
+		h.mu.RUnlock()
+		if observer != nil {
+			observer(msg)
 		}
-	}
 
-	return nil
+		// コアメッセージ・未知のメッセージ型ともに同じポリシーで配信
+		return h.deliver(msg, raw.Raw)
+	}
 }
 
 func (h *ProtocolHandler) handleControlRequest(ctx context.Context, req *ControlRequest) error {
@@ -275,6 +528,7 @@ func (h *ProtocolHandler) handleControlRequest(ctx context.Context, req *Control
 	}
 
 	subtype, _ := reqData["subtype"].(string)
+	h.log().Debug("received control request", "request_id", req.RequestID, "subtype", subtype)
 
 	switch subtype {
 	case "can_use_tool":
@@ -288,6 +542,7 @@ func (h *ProtocolHandler) handleControlRequest(ctx context.Context, req *Control
 
 	default:
 		// 未知のリクエストは成功レスポンスを返す
+		h.log().Warn("unsupported control request", "request_id", req.RequestID, "subtype", subtype)
 		return h.sendControlSuccess(req.RequestID, nil)
 	}
 }
@@ -313,9 +568,12 @@ func (h *ProtocolHandler) handleCanUseTool(ctx context.Context, requestID string
 		return h.sendControlError(requestID, "unmarshal request: "+err.Error())
 	}
 
+	h.log().Debug("checking tool permission", "request_id", requestID, "tool", toolReq.ToolName, logging.Payload("input", toolReq.Input))
+
 	// コールバックを呼び出し
 	resp, err := cb(ctx, &toolReq)
 	if err != nil {
+		h.log().Warn("can_use_tool callback failed", "request_id", requestID, "tool", toolReq.ToolName, "error", err)
 		return h.sendControlError(requestID, err.Error())
 	}
 
@@ -323,10 +581,14 @@ func (h *ProtocolHandler) handleCanUseTool(ctx context.Context, requestID string
 }
 
 func (h *ProtocolHandler) handleHookCallback(ctx context.Context, requestID string, reqData map[string]any) error {
-	hookType, _ := reqData["hook_type"].(string)
+	// callback_idを優先し、なければhook_typeで検索する
+	key, _ := reqData["callback_id"].(string)
+	if key == "" {
+		key, _ = reqData["hook_type"].(string)
+	}
 
 	h.mu.RLock()
-	callbacks := h.hookCallbacks[hookType]
+	callbacks := h.hookCallbacks[key]
 	h.mu.RUnlock()
 
 	if len(callbacks) == 0 {
@@ -346,17 +608,24 @@ func (h *ProtocolHandler) handleHookCallback(ctx context.Context, requestID stri
 	}
 
 	// 全てのコールバックを呼び出し
+	result := &HookCallbackResponse{Continue: true}
 	for _, cb := range callbacks {
 		resp, err := cb(ctx, &hookReq)
 		if err != nil {
+			h.log().Warn("hook callback failed", "request_id", requestID, "callback_id", key, "error", err)
 			return h.sendControlError(requestID, err.Error())
 		}
+		if resp == nil {
+			continue
+		}
 		if !resp.Continue {
 			return h.sendControlSuccess(requestID, resp)
 		}
+		// 続行時もdecisionやhookSpecificOutputをCLIに返す
+		result = resp
 	}
 
-	return h.sendControlSuccess(requestID, &HookCallbackResponse{Continue: true})
+	return h.sendControlSuccess(requestID, result)
 }
 
 func (h *ProtocolHandler) handleMCPMessage(ctx context.Context, requestID string, reqData map[string]any) error {
@@ -382,6 +651,7 @@ func (h *ProtocolHandler) handleMCPMessage(ctx context.Context, requestID string
 	// コールバックを呼び出し
 	resp, err := cb(ctx, &mcpReq)
 	if err != nil {
+		h.log().Warn("mcp_message callback failed", "request_id", requestID, "server", mcpReq.ServerName, "error", err)
 		return h.sendControlError(requestID, err.Error())
 	}
 
@@ -395,6 +665,7 @@ func (h *ProtocolHandler) handleControlResponse(resp *ControlResponse) error {
 
 	if !ok {
 		// 対応するリクエストが見つからない（タイムアウト済みなど）
+		h.log().Debug("control response for unknown request", "request_id", resp.Response.RequestID)
 		return nil
 	}
 
@@ -422,7 +693,7 @@ func (h *ProtocolHandler) sendControlSuccess(requestID string, response any) err
 		return fmt.Errorf("marshal control response: %w", err)
 	}
 
-	return h.transport.Write(data)
+	return h.writeControlResponse(requestID, data)
 }
 
 func (h *ProtocolHandler) sendControlError(requestID string, errMsg string) error {
@@ -440,7 +711,27 @@ func (h *ProtocolHandler) sendControlError(requestID string, errMsg string) erro
 		return fmt.Errorf("marshal control response: %w", err)
 	}
 
-	return h.transport.Write(data)
+	return h.writeControlResponse(requestID, data)
+}
+
+// writeControlResponse は制御レスポンスをCLIに書き込む
+func (h *ProtocolHandler) writeControlResponse(requestID string, data []byte) error {
+	if err := h.currentTransport().Write(data); err != nil {
+		h.log().Error("failed to write control response", "request_id", requestID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// requestSubtype は制御リクエストのJSONからsubtypeを取り出す（ログ用）
+func requestSubtype(data []byte) string {
+	var req struct {
+		Request struct {
+			Subtype string `json:"subtype"`
+		} `json:"request"`
+	}
+	json.Unmarshal(data, &req)
+	return req.Request.Subtype
 }
 
 func (h *ProtocolHandler) generateRequestID() string {
@@ -450,6 +741,18 @@ func (h *ProtocolHandler) generateRequestID() string {
 
 // Close はハンドラをクローズする
 func (h *ProtocolHandler) Close() {
-	close(h.msgChan)
-	close(h.errChan)
+	h.closeOnce.Do(func() {
+		// ブロック中の送信を解除してからチャネルをクローズする
+		close(h.done)
+
+		h.closeMu.Lock()
+		h.closed = true
+		close(h.msgChan)
+		close(h.errChan)
+		h.closeMu.Unlock()
+
+		if h.spill != nil {
+			h.spill.close()
+		}
+	})
 }
@@ -118,6 +118,49 @@ func TestProtocolHandler_SetCallbacks(t *testing.T) {
 	})
 }
 
+func TestProtocolHandler_MessageObserver(t *testing.T) {
+	mt := newMockTransport()
+	h := NewProtocolHandler(mt)
+
+	var observed []string
+	h.SetMessageObserver(func(msg Message) {
+		observed = append(observed, msg.MessageType())
+	})
+
+	ctx := context.Background()
+	for _, data := range []map[string]any{
+		{"type": "result", "subtype": "success", "session_id": "s1"},
+		{"type": "control_response", "response": map[string]any{"subtype": "success", "request_id": "unknown"}},
+	} {
+		if err := h.HandleIncoming(ctx, transport.RawMessage{Type: data["type"].(string), Data: data}); err != nil {
+			t.Fatalf("HandleIncoming failed: %v", err)
+		}
+	}
+
+	// 制御メッセージは観測されない
+	if len(observed) != 1 || observed[0] != "result" {
+		t.Errorf("observed = %v, want [result]", observed)
+	}
+}
+
+func TestProtocolHandler_ControlTimeoutCallback(t *testing.T) {
+	mt := newMockTransport()
+	h := NewProtocolHandler(mt)
+
+	var timedOut []string
+	h.SetControlTimeoutCallback(func(subtype string) {
+		timedOut = append(timedOut, subtype)
+	})
+
+	_, err := h.SendControlRequestWithTimeout(context.Background(), map[string]any{"subtype": "interrupt"}, 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if len(timedOut) != 1 || timedOut[0] != "interrupt" {
+		t.Errorf("timedOut = %v, want [interrupt]", timedOut)
+	}
+}
+
 func TestProtocolHandler_HandleIncoming_AssistantMessage(t *testing.T) {
 	mt := newMockTransport()
 	h := NewProtocolHandler(mt)
@@ -634,3 +677,183 @@ func TestProtocolHandler_HandleIncoming_AskUserQuestion(t *testing.T) {
 
 	t.Logf("テスト成功: コールバック待機時間=%v, 全体処理時間=%v", callbackDuration, elapsed)
 }
+
+func TestProtocolHandler_HandleIncoming_MCPMessage(t *testing.T) {
+	mt := newMockTransport()
+	h := NewProtocolHandler(mt)
+
+	h.SetMCPMessageCallback(func(ctx context.Context, req *MCPMessageRequest) (*MCPMessageResponse, error) {
+		if req.ServerName != "calc" {
+			t.Errorf("ServerName = %q, want %q", req.ServerName, "calc")
+		}
+		return &MCPMessageResponse{
+			MCPResponse: map[string]any{"jsonrpc": "2.0", "id": req.Message["id"], "result": map[string]any{}},
+		}, nil
+	})
+
+	raw := transport.RawMessage{
+		Type: "control_request",
+		Data: map[string]any{
+			"type":       "control_request",
+			"request_id": "mcp-123",
+			"request": map[string]any{
+				"subtype":     "mcp_message",
+				"server_name": "calc",
+				"message": map[string]any{
+					"jsonrpc": "2.0",
+					"id":      float64(1),
+					"method":  "tools/list",
+				},
+			},
+		},
+	}
+
+	if err := h.HandleIncoming(context.Background(), raw); err != nil {
+		t.Fatalf("HandleIncoming failed: %v", err)
+	}
+
+	written := mt.getWrittenData()
+	if len(written) != 1 {
+		t.Fatalf("len(written) = %d, want 1", len(written))
+	}
+
+	var resp map[string]any
+	if err := json.Unmarshal(written[0], &resp); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	body := resp["response"].(map[string]any)
+	if body["subtype"] != "success" {
+		t.Errorf("subtype = %v, want success", body["subtype"])
+	}
+	payload := body["response"].(map[string]any)
+	mcpResp, ok := payload["mcp_response"].(map[string]any)
+	if !ok {
+		t.Fatalf("mcp_response missing: %v", payload)
+	}
+	if mcpResp["id"] != float64(1) {
+		t.Errorf("id = %v, want 1", mcpResp["id"])
+	}
+}
+
+func TestProtocolHandler_HandleIncoming_HookCallbackByID(t *testing.T) {
+	mt := newMockTransport()
+	h := NewProtocolHandler(mt)
+
+	h.AddHookCallback("hook_0", func(ctx context.Context, req *HookCallbackRequest) (*HookCallbackResponse, error) {
+		if req.Input["tool_name"] != "Bash" {
+			t.Errorf("input.tool_name = %v, want Bash", req.Input["tool_name"])
+		}
+		return &HookCallbackResponse{
+			Continue: true,
+			Decision: "block",
+			Reason:   "not allowed",
+		}, nil
+	})
+
+	raw := transport.RawMessage{
+		Type: "control_request",
+		Data: map[string]any{
+			"type":       "control_request",
+			"request_id": "hook-456",
+			"request": map[string]any{
+				"subtype":     "hook_callback",
+				"callback_id": "hook_0",
+				"input": map[string]any{
+					"hook_event_name": "PreToolUse",
+					"tool_name":       "Bash",
+				},
+			},
+		},
+	}
+
+	if err := h.HandleIncoming(context.Background(), raw); err != nil {
+		t.Fatalf("HandleIncoming failed: %v", err)
+	}
+
+	written := mt.getWrittenData()
+	if len(written) != 1 {
+		t.Fatalf("len(written) = %d, want 1", len(written))
+	}
+
+	var resp map[string]any
+	if err := json.Unmarshal(written[0], &resp); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	payload := resp["response"].(map[string]any)["response"].(map[string]any)
+	if payload["decision"] != "block" {
+		t.Errorf("decision = %v, want block", payload["decision"])
+	}
+	if payload["reason"] != "not allowed" {
+		t.Errorf("reason = %v, want %q", payload["reason"], "not allowed")
+	}
+}
+
+func TestInitializeRequest_Hooks(t *testing.T) {
+	req := InitializeRequest{
+		Subtype: "initialize",
+		Hooks: map[string][]HookMatcher{
+			"PreToolUse": {{Matcher: "Bash", HookCallbackIDs: []string{"hook_0"}, Timeout: 60}},
+		},
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	var parsed map[string]any
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	hooks := parsed["hooks"].(map[string]any)
+	pre := hooks["PreToolUse"].([]any)[0].(map[string]any)
+	if pre["matcher"] != "Bash" {
+		t.Errorf("matcher = %v, want Bash", pre["matcher"])
+	}
+	ids := pre["hookCallbackIds"].([]any)
+	if len(ids) != 1 || ids[0] != "hook_0" {
+		t.Errorf("hookCallbackIds = %v, want [hook_0]", ids)
+	}
+}
+
+func TestProtocolHandler_SetTransport(t *testing.T) {
+	oldTransport := newMockTransport()
+	h := NewProtocolHandler(oldTransport)
+	msgChan := h.Messages()
+
+	newTransport := newMockTransport()
+	h.SetTransport(newTransport)
+
+	// 差し替え後のTransportにレスポンスが書き込まれる
+	if err := h.sendControlSuccess("req-1", nil); err != nil {
+		t.Fatalf("sendControlSuccess failed: %v", err)
+	}
+	if len(oldTransport.getWrittenData()) != 0 {
+		t.Error("old transport should not receive writes")
+	}
+	if len(newTransport.getWrittenData()) != 1 {
+		t.Errorf("new transport writes = %d, want %d", len(newTransport.getWrittenData()), 1)
+	}
+
+	// メッセージチャネルは引き継がれる
+	if h.Messages() != msgChan {
+		t.Error("message channel should be preserved")
+	}
+}
+
+func TestProtocolHandler_Emit(t *testing.T) {
+	h := NewProtocolHandler(newMockTransport())
+
+	h.Emit(&SystemMessage{Type: "system", Subtype: "sdk_event"})
+
+	select {
+	case msg := <-h.Messages():
+		if msg.MessageType() != "system" {
+			t.Errorf("MessageType() = %q, want %q", msg.MessageType(), "system")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("emitted message not delivered")
+	}
+}
@@ -0,0 +1,326 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrMessageBufferFull はOverflowErrorポリシーでメッセージバッファが満杯の場合のエラー
+var ErrMessageBufferFull = errors.New("message buffer full")
+
+// DefaultMessageBufferSize はメッセージチャネルのデフォルト容量
+const DefaultMessageBufferSize = 100
+
+// OverflowPolicy はメッセージチャネルが満杯の場合の動作を表す
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest は最も古いメッセージを破棄して新しいメッセージを入れる（デフォルト）
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowBlock はコンシューマが読み出すまで受信を止める
+	// 受信ループ全体が止まるため、制御レスポンスも待たされる点に注意
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowSpill は溢れたメッセージをディスクに退避し、空きができ次第順番に配信する
+	OverflowSpill OverflowPolicy = "spill"
+	// OverflowError は新しいメッセージを破棄してErrMessageBufferFullを返す
+	OverflowError OverflowPolicy = "error"
+)
+
+// DeliveryConfig はメッセージ配信の設定
+type DeliveryConfig struct {
+	BufferSize int            // メッセージチャネルの容量（デフォルト: 100）
+	Policy     OverflowPolicy // 満杯時の動作（デフォルト: OverflowDropOldest）
+	SpillDir   string         // OverflowSpillの退避先ディレクトリ（デフォルト: os.TempDir()）
+	OnDrop     func(Message)  // メッセージを破棄した際のコールバック（任意）
+}
+
+// DeliveryStats はメッセージ配信の統計
+type DeliveryStats struct {
+	Delivered     uint64            // チャネルに送信したメッセージ数（後で破棄されたものを含む）
+	Dropped       uint64            // 破棄したメッセージ数
+	Spilled       uint64            // ディスクに退避したメッセージ数
+	Pending       int               // ディスクに退避中で未配信のメッセージ数
+	DroppedByType map[string]uint64 // メッセージ型ごとの破棄数
+}
+
+// deliveryState は配信の統計を保持する
+type deliveryState struct {
+	delivered atomic.Uint64
+	spilled   atomic.Uint64
+
+	mu            sync.Mutex
+	dropped       uint64
+	droppedByType map[string]uint64
+}
+
+func (s *deliveryState) recordDrop(msg Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropped++
+	if s.droppedByType == nil {
+		s.droppedByType = make(map[string]uint64)
+	}
+	s.droppedByType[msg.MessageType()]++
+}
+
+// DeliveryStats はメッセージ配信の統計を返す
+func (h *ProtocolHandler) DeliveryStats() DeliveryStats {
+	h.stats.mu.Lock()
+	byType := make(map[string]uint64, len(h.stats.droppedByType))
+	for k, v := range h.stats.droppedByType {
+		byType[k] = v
+	}
+	dropped := h.stats.dropped
+	h.stats.mu.Unlock()
+
+	stats := DeliveryStats{
+		Delivered:     h.stats.delivered.Load(),
+		Dropped:       dropped,
+		Spilled:       h.stats.spilled.Load(),
+		DroppedByType: byType,
+	}
+	if h.spill != nil {
+		stats.Pending = h.spill.len()
+	}
+	return stats
+}
+
+// deliver は設定されたポリシーに従ってメッセージをチャネルに送信する
+// rawはディスク退避用の元のJSON（SDK内部で生成したメッセージの場合はnil）
+func (h *ProtocolHandler) deliver(msg Message, raw []byte) error {
+	switch h.delivery.Policy {
+	case OverflowBlock:
+		h.send(msg, true)
+		return nil
+
+	case OverflowSpill:
+		return h.spill.deliver(h, msg, raw)
+
+	case OverflowError:
+		if h.send(msg, false) {
+			return nil
+		}
+		h.drop(msg)
+		return fmt.Errorf("%w: %s message dropped", ErrMessageBufferFull, msg.MessageType())
+
+	default:
+		if h.send(msg, false) {
+			return nil
+		}
+		// チャネルがいっぱいの場合は古いメッセージを破棄
+		h.closeMu.RLock()
+		if !h.closed {
+			select {
+			case old := <-h.msgChan:
+				h.drop(old)
+			default:
+			}
+		}
+		h.closeMu.RUnlock()
+		h.send(msg, true)
+		return nil
+	}
+}
+
+// send はメッセージをチャネルに送信する
+// blockがfalseの場合、チャネルが満杯なら送信せずにfalseを返す
+func (h *ProtocolHandler) send(msg Message, block bool) bool {
+	h.closeMu.RLock()
+	defer h.closeMu.RUnlock()
+
+	if h.closed {
+		return false
+	}
+
+	if block {
+		select {
+		case h.msgChan <- msg:
+		case <-h.done:
+			return false
+		}
+	} else {
+		select {
+		case h.msgChan <- msg:
+		default:
+			return false
+		}
+	}
+
+	h.stats.delivered.Add(1)
+	return true
+}
+
+func (h *ProtocolHandler) drop(msg Message) {
+	h.stats.recordDrop(msg)
+	h.log().Warn("message dropped", "type", msg.MessageType(), "policy", string(h.delivery.Policy))
+	if h.delivery.OnDrop != nil {
+		h.delivery.OnDrop(msg)
+	}
+}
+
+// spillQueue はチャネルから溢れたメッセージをディスクに退避するFIFOキュー
+// 本体はJSON Linesとしてファイルに書き込み、メモリにはオフセットのみ保持する
+type spillQueue struct {
+	dir string
+
+	mu      sync.Mutex
+	file    *os.File
+	offset  int64
+	entries []spillEntry
+	notify  chan struct{}
+}
+
+type spillEntry struct {
+	offset int64
+	size   int
+	msg    Message // rawがない（SDK内部で生成した）メッセージはメモリに保持
+}
+
+func newSpillQueue(dir string) *spillQueue {
+	return &spillQueue{
+		dir:    dir,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (q *spillQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// deliver は退避中のメッセージがなければ直接送信し、あれば順序を保つため退避する
+func (q *spillQueue) deliver(h *ProtocolHandler, msg Message, raw []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 && h.send(msg, false) {
+		return nil
+	}
+
+	if err := q.push(msg, raw); err != nil {
+		h.drop(msg)
+		return fmt.Errorf("spill message: %w", err)
+	}
+	h.stats.spilled.Add(1)
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// push はメッセージを末尾に追加する（q.muを保持して呼ぶ）
+func (q *spillQueue) push(msg Message, raw []byte) error {
+	if raw == nil {
+		q.entries = append(q.entries, spillEntry{msg: msg})
+		return nil
+	}
+
+	if q.file == nil {
+		f, err := os.CreateTemp(q.dir, "claude-spill-*.jsonl")
+		if err != nil {
+			return err
+		}
+		q.file = f
+	}
+
+	n, err := q.file.WriteAt(append(raw, '\n'), q.offset)
+	if err != nil {
+		return err
+	}
+	q.entries = append(q.entries, spillEntry{offset: q.offset, size: n - 1})
+	q.offset += int64(n)
+	return nil
+}
+
+// peek は先頭のメッセージを読み出す（キューからは削除しない）
+func (q *spillQueue) peek() (Message, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 {
+		return nil, false, nil
+	}
+
+	entry := q.entries[0]
+	if entry.msg != nil {
+		return entry.msg, true, nil
+	}
+
+	buf := make([]byte, entry.size)
+	if _, err := q.file.ReadAt(buf, entry.offset); err != nil {
+		return nil, true, err
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal(buf, &data); err != nil {
+		return nil, true, err
+	}
+	msg, err := ParseMessage(data)
+	return msg, true, err
+}
+
+// pop は先頭のメッセージを削除する
+func (q *spillQueue) pop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 {
+		return
+	}
+	q.entries = q.entries[1:]
+
+	// 空になったらファイルを先頭から再利用する
+	if len(q.entries) == 0 && q.file != nil {
+		q.file.Truncate(0)
+		q.offset = 0
+	}
+}
+
+// pump は退避したメッセージをチャネルに空きができ次第配信する
+func (q *spillQueue) pump(h *ProtocolHandler) {
+	for {
+		select {
+		case <-q.notify:
+		case <-h.done:
+			return
+		}
+
+		for {
+			msg, ok, err := q.peek()
+			if !ok {
+				break
+			}
+			if err != nil {
+				// 読み出せないメッセージは破棄して次へ
+				q.pop()
+				h.stats.recordDrop(&GenericMessage{Type: "spill_error"})
+				continue
+			}
+			if !h.send(msg, true) {
+				return
+			}
+			q.pop()
+		}
+	}
+}
+
+// close は退避ファイルを削除する
+func (q *spillQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file != nil {
+		name := q.file.Name()
+		q.file.Close()
+		os.Remove(name)
+		q.file = nil
+	}
+	q.entries = nil
+}
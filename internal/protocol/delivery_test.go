@@ -0,0 +1,226 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/transport"
+)
+
+func assistantRaw(text string) transport.RawMessage {
+	data := map[string]any{
+		"type": "assistant",
+		"message": map[string]any{
+			"role":    "assistant",
+			"model":   "claude",
+			"content": []any{map[string]any{"type": "text", "text": text}},
+		},
+	}
+	raw := []byte(fmt.Sprintf(`{"type":"assistant","message":{"role":"assistant","model":"claude","content":[{"type":"text","text":%q}]}}`, text))
+	return transport.RawMessage{Type: "assistant", Data: data, Raw: raw}
+}
+
+func messageText(t *testing.T, msg Message) string {
+	t.Helper()
+	am, ok := msg.(*AssistantMessage)
+	if !ok {
+		t.Fatalf("expected *AssistantMessage, got %T", msg)
+	}
+	return am.Text()
+}
+
+func TestDelivery_DropOldest(t *testing.T) {
+	var dropped []Message
+	h := NewProtocolHandlerWithConfig(newMockTransport(), DeliveryConfig{
+		BufferSize: 2,
+		OnDrop:     func(m Message) { dropped = append(dropped, m) },
+	})
+	defer h.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := h.HandleIncoming(context.Background(), assistantRaw(fmt.Sprint(i))); err != nil {
+			t.Fatalf("HandleIncoming failed: %v", err)
+		}
+	}
+
+	if got := messageText(t, <-h.Messages()); got != "1" {
+		t.Errorf("first message = %q, want %q", got, "1")
+	}
+
+	stats := h.DeliveryStats()
+	if stats.Dropped != 1 || stats.DroppedByType["assistant"] != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if len(dropped) != 1 || messageText(t, dropped[0]) != "0" {
+		t.Errorf("OnDrop called with %v", dropped)
+	}
+}
+
+func TestDelivery_Error(t *testing.T) {
+	h := NewProtocolHandlerWithConfig(newMockTransport(), DeliveryConfig{
+		BufferSize: 1,
+		Policy:     OverflowError,
+	})
+	defer h.Close()
+
+	if err := h.HandleIncoming(context.Background(), assistantRaw("0")); err != nil {
+		t.Fatalf("HandleIncoming failed: %v", err)
+	}
+	err := h.HandleIncoming(context.Background(), assistantRaw("1"))
+	if !errors.Is(err, ErrMessageBufferFull) {
+		t.Errorf("expected ErrMessageBufferFull, got %v", err)
+	}
+
+	// 既存のメッセージは残る
+	if got := messageText(t, <-h.Messages()); got != "0" {
+		t.Errorf("message = %q, want %q", got, "0")
+	}
+	if h.DeliveryStats().Dropped != 1 {
+		t.Errorf("Dropped = %d, want %d", h.DeliveryStats().Dropped, 1)
+	}
+}
+
+func TestDelivery_Block(t *testing.T) {
+	h := NewProtocolHandlerWithConfig(newMockTransport(), DeliveryConfig{
+		BufferSize: 1,
+		Policy:     OverflowBlock,
+	})
+	defer h.Close()
+
+	h.HandleIncoming(context.Background(), assistantRaw("0"))
+
+	done := make(chan struct{})
+	go func() {
+		h.HandleIncoming(context.Background(), assistantRaw("1"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("HandleIncoming should block while buffer is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got := messageText(t, <-h.Messages()); got != "0" {
+		t.Errorf("message = %q, want %q", got, "0")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("HandleIncoming did not unblock")
+	}
+	if got := messageText(t, <-h.Messages()); got != "1" {
+		t.Errorf("message = %q, want %q", got, "1")
+	}
+	if h.DeliveryStats().Dropped != 0 {
+		t.Errorf("Dropped = %d, want 0", h.DeliveryStats().Dropped)
+	}
+}
+
+func TestDelivery_BlockUnblocksOnClose(t *testing.T) {
+	h := NewProtocolHandlerWithConfig(newMockTransport(), DeliveryConfig{
+		BufferSize: 1,
+		Policy:     OverflowBlock,
+	})
+
+	h.HandleIncoming(context.Background(), assistantRaw("0"))
+
+	done := make(chan struct{})
+	go func() {
+		h.HandleIncoming(context.Background(), assistantRaw("1"))
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	h.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked delivery should be released by Close")
+	}
+}
+
+func TestDelivery_Spill(t *testing.T) {
+	dir := t.TempDir()
+	h := NewProtocolHandlerWithConfig(newMockTransport(), DeliveryConfig{
+		BufferSize: 2,
+		Policy:     OverflowSpill,
+		SpillDir:   dir,
+	})
+
+	const total = 20
+	for i := 0; i < total; i++ {
+		if err := h.HandleIncoming(context.Background(), assistantRaw(fmt.Sprint(i))); err != nil {
+			t.Fatalf("HandleIncoming failed: %v", err)
+		}
+	}
+	// SDK内部で生成したメッセージも順番を保つ
+	h.Emit(&SystemMessage{Type: "system", Subtype: "sdk_event"})
+
+	stats := h.DeliveryStats()
+	if stats.Spilled == 0 {
+		t.Error("messages should be spilled to disk")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("spill files = %d, want 1", len(entries))
+	}
+
+	for i := 0; i < total; i++ {
+		select {
+		case msg := <-h.Messages():
+			if got := messageText(t, msg); got != fmt.Sprint(i) {
+				t.Fatalf("message %d = %q, want %q", i, got, fmt.Sprint(i))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for message %d", i)
+		}
+	}
+	select {
+	case msg := <-h.Messages():
+		if msg.MessageType() != "system" {
+			t.Errorf("last message = %T, want *SystemMessage", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for emitted message")
+	}
+
+	// 退避キューからの削除は配信の直後に行われる
+	deadline := time.Now().Add(time.Second)
+	for h.DeliveryStats().Pending != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := h.DeliveryStats(); stats.Dropped != 0 || stats.Pending != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Closeで退避ファイルを削除する
+	h.Close()
+	entries, _ = os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("spill files after Close = %d, want 0", len(entries))
+	}
+}
+
+func TestDelivery_UnknownMessageUsesPolicy(t *testing.T) {
+	h := NewProtocolHandlerWithConfig(newMockTransport(), DeliveryConfig{BufferSize: 1})
+	defer h.Close()
+
+	for i := 0; i < 2; i++ {
+		h.HandleIncoming(context.Background(), transport.RawMessage{
+			Type: "custom",
+			Data: map[string]any{"type": "custom", "n": float64(i)},
+		})
+	}
+
+	// 未知のメッセージも破棄時にカウントされる
+	if h.DeliveryStats().DroppedByType["custom"] != 1 {
+		t.Errorf("DroppedByType = %v", h.DeliveryStats().DroppedByType)
+	}
+}
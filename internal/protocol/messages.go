@@ -1,5 +1,10 @@
 package protocol
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // Message はCLIとやり取りするメッセージの共通インターフェース
 type Message interface {
 	MessageType() string
@@ -18,8 +23,8 @@ func (m *UserMessage) MessageType() string { return m.Type }
 
 // UserContent はユーザーメッセージの内容
 type UserContent struct {
-	Role    string `json:"role"` // "user"
-	Content any    `json:"content"` // string or []ContentBlock
+	Role    string       `json:"role"`    // "user"
+	Content BlockContent `json:"content"` // 文字列またはコンテンツブロックの配列
 }
 
 // AssistantMessage はアシスタントからのメッセージ
@@ -27,28 +32,20 @@ type AssistantMessage struct {
 	Type            string        `json:"type"` // "assistant"
 	Message         AssistantBody `json:"message"`
 	ParentToolUseID *string       `json:"parent_tool_use_id,omitempty"`
+	SessionID       string        `json:"session_id,omitempty"`
 }
 
 func (m *AssistantMessage) MessageType() string { return m.Type }
 
 // AssistantBody はアシスタントメッセージの本体
 type AssistantBody struct {
-	Role    string         `json:"role"` // "assistant"
-	Model   string         `json:"model"`
-	Content []ContentBlock `json:"content"`
-	Error   *string        `json:"error,omitempty"`
-}
-
-// ContentBlock はメッセージ内のコンテンツブロック
-type ContentBlock struct {
-	Type string `json:"type"`
-	// type別のフィールド
-	Text      string         `json:"text,omitempty"`      // type: "text"
-	Thinking  string         `json:"thinking,omitempty"`  // type: "thinking"
-	Signature string         `json:"signature,omitempty"` // type: "thinking"
-	ID        string         `json:"id,omitempty"`        // type: "tool_use"
-	Name      string         `json:"name,omitempty"`      // type: "tool_use"
-	Input     map[string]any `json:"input,omitempty"`     // type: "tool_use"
+	ID         string  `json:"id,omitempty"`
+	Role       string  `json:"role"` // "assistant"
+	Model      string  `json:"model"`
+	Content    Blocks  `json:"content"`
+	StopReason string  `json:"stop_reason,omitempty"`
+	Usage      *Usage  `json:"usage,omitempty"`
+	Error      *string `json:"error,omitempty"`
 }
 
 // SystemMessage はシステムメッセージ
@@ -74,6 +71,9 @@ type ResultMessage struct {
 	Result           string         `json:"result,omitempty"`
 	StructuredOutput map[string]any `json:"structured_output,omitempty"`
 
+	// ModelUsage はモデルごとの使用量とコスト（CLIプロセス内の累計）
+	ModelUsage map[string]ModelUsage `json:"modelUsage,omitempty"`
+
 	// エラー関連フィールド
 	ErrorCode    string `json:"error_code,omitempty"`
 	ErrorMessage string `json:"error_message,omitempty"`
@@ -140,6 +140,17 @@ type Usage struct {
 	CacheReadTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
+// ModelUsage はresultに含まれるモデルごとの使用量
+type ModelUsage struct {
+	InputTokens              int     `json:"inputTokens"`
+	OutputTokens             int     `json:"outputTokens"`
+	CacheReadInputTokens     int     `json:"cacheReadInputTokens"`
+	CacheCreationInputTokens int     `json:"cacheCreationInputTokens"`
+	WebSearchRequests        int     `json:"webSearchRequests,omitempty"`
+	CostUSD                  float64 `json:"costUSD"`
+	ContextWindow            int     `json:"contextWindow,omitempty"`
+}
+
 // ControlRequest は制御リクエスト
 type ControlRequest struct {
 	Type      string `json:"type"` // "control_request"
@@ -157,6 +168,21 @@ type ControlResponse struct {
 
 func (m *ControlResponse) MessageType() string { return m.Type }
 
+// Decode はレスポンス本体をvにデコードする
+func (m *ControlResponse) Decode(v any) error {
+	if m.Response.Response == nil {
+		return nil
+	}
+	data, err := json.Marshal(m.Response.Response)
+	if err != nil {
+		return fmt.Errorf("marshal control response: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode control response: %w", err)
+	}
+	return nil
+}
+
 // ControlResponseBody は制御レスポンスの本体
 type ControlResponseBody struct {
 	Subtype   string `json:"subtype"` // "success" or "error"
@@ -11,7 +11,7 @@ func TestUserMessage_JSON(t *testing.T) {
 		Type: "user",
 		Message: UserContent{
 			Role:    "user",
-			Content: "Hello, Claude!",
+			Content: NewTextContent("Hello, Claude!"),
 		},
 		SessionID: sessionID,
 	}
@@ -45,8 +45,8 @@ func TestAssistantMessage_JSON(t *testing.T) {
 		Message: AssistantBody{
 			Role:  "assistant",
 			Model: "claude-3-opus",
-			Content: []ContentBlock{
-				{Type: "text", Text: "Hello!"},
+			Content: Blocks{
+				&TextBlock{Text: "Hello!"},
 			},
 		},
 	}
@@ -70,14 +70,14 @@ func TestAssistantMessage_JSON(t *testing.T) {
 	if len(decoded.Message.Content) != 1 {
 		t.Fatalf("len(Content) = %d, want %d", len(decoded.Message.Content), 1)
 	}
-	if decoded.Message.Content[0].Text != "Hello!" {
-		t.Errorf("Content[0].Text = %q, want %q", decoded.Message.Content[0].Text, "Hello!")
+	text, ok := decoded.Message.Content[0].(*TextBlock)
+	if !ok || text.Text != "Hello!" {
+		t.Errorf("Content[0] = %+v, want text %q", decoded.Message.Content[0], "Hello!")
 	}
 }
 
 func TestContentBlock_ToolUse_JSON(t *testing.T) {
-	block := ContentBlock{
-		Type: "tool_use",
+	block := &ToolUseBlock{
 		ID:   "toolu_123",
 		Name: "Read",
 		Input: map[string]any{
@@ -90,13 +90,14 @@ func TestContentBlock_ToolUse_JSON(t *testing.T) {
 		t.Fatalf("json.Marshal failed: %v", err)
 	}
 
-	var decoded ContentBlock
-	if err := json.Unmarshal(data, &decoded); err != nil {
-		t.Fatalf("json.Unmarshal failed: %v", err)
+	decodedBlock, err := DecodeBlock(data)
+	if err != nil {
+		t.Fatalf("DecodeBlock failed: %v", err)
 	}
 
-	if decoded.Type != "tool_use" {
-		t.Errorf("Type = %q, want %q", decoded.Type, "tool_use")
+	decoded, ok := decodedBlock.(*ToolUseBlock)
+	if !ok {
+		t.Fatalf("decoded block = %T, want *ToolUseBlock", decodedBlock)
 	}
 	if decoded.ID != "toolu_123" {
 		t.Errorf("ID = %q, want %q", decoded.ID, "toolu_123")
@@ -239,7 +240,7 @@ func TestAssistantMessage_WithError(t *testing.T) {
 		Message: AssistantBody{
 			Role:    "assistant",
 			Model:   "claude-3-opus",
-			Content: []ContentBlock{},
+			Content: Blocks{},
 			Error:   &errMsg,
 		},
 	}
@@ -268,7 +269,7 @@ func TestUserMessage_WithParentToolUseID(t *testing.T) {
 		Type: "user",
 		Message: UserContent{
 			Role:    "user",
-			Content: "Tool result",
+			Content: NewTextContent("Tool result"),
 		},
 		ParentToolUseID: &parentID,
 		SessionID:       "session-123",
@@ -297,7 +298,7 @@ func TestUserMessage_WithUUID(t *testing.T) {
 		Type: "user",
 		Message: UserContent{
 			Role:    "user",
-			Content: "Hello",
+			Content: NewTextContent("Hello"),
 		},
 		SessionID: "session-123",
 		UUID:      "uuid-456-789",
@@ -323,7 +324,7 @@ func TestUserMessage_UUIDOmitEmpty(t *testing.T) {
 		Type: "user",
 		Message: UserContent{
 			Role:    "user",
-			Content: "Hello",
+			Content: NewTextContent("Hello"),
 		},
 		SessionID: "session-123",
 		// UUID is empty
@@ -344,3 +345,33 @@ func TestUserMessage_UUIDOmitEmpty(t *testing.T) {
 		t.Error("uuid should not be present when empty")
 	}
 }
+
+func TestControlResponse_Decode(t *testing.T) {
+	resp := &ControlResponse{
+		Type: "control_response",
+		Response: ControlResponseBody{
+			Subtype: "success",
+			Response: map[string]any{
+				"session_id": "session-1",
+				"models":     []any{map[string]any{"value": "opus", "displayName": "Opus"}},
+			},
+		},
+	}
+
+	var init InitializeResponse
+	if err := resp.Decode(&init); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if init.SessionID != "session-1" {
+		t.Errorf("SessionID = %q, want %q", init.SessionID, "session-1")
+	}
+	if len(init.Models) != 1 || init.Models[0].DisplayName != "Opus" {
+		t.Errorf("Models = %+v", init.Models)
+	}
+
+	// レスポンス本体がない場合は何もしない
+	empty := &ControlResponse{}
+	if err := empty.Decode(&init); err != nil {
+		t.Errorf("Decode of empty response failed: %v", err)
+	}
+}
@@ -46,6 +46,13 @@ func ParseMessage(data map[string]any) (Message, error) {
 		}
 		return &msg, nil
 
+	case "stream_event":
+		var msg StreamEvent
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return nil, fmt.Errorf("parse stream event: %w", err)
+		}
+		return &msg, nil
+
 	case "control_request":
 		var msg ControlRequest
 		if err := json.Unmarshal(raw, &msg); err != nil {
@@ -38,8 +38,8 @@ func TestParseMessage_AssistantMessage(t *testing.T) {
 	if len(am.Message.Content) != 1 {
 		t.Fatalf("len(Content) = %d, want %d", len(am.Message.Content), 1)
 	}
-	if am.Message.Content[0].Text != "Hello!" {
-		t.Errorf("Content[0].Text = %q, want %q", am.Message.Content[0].Text, "Hello!")
+	if am.Text() != "Hello!" {
+		t.Errorf("Text() = %q, want %q", am.Text(), "Hello!")
 	}
 }
 
@@ -0,0 +1,248 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ストリームイベントの種類
+const (
+	StreamEventMessageStart      = "message_start"
+	StreamEventContentBlockStart = "content_block_start"
+	StreamEventContentBlockDelta = "content_block_delta"
+	StreamEventContentBlockStop  = "content_block_stop"
+	StreamEventMessageDelta      = "message_delta"
+	StreamEventMessageStop       = "message_stop"
+)
+
+// デルタの種類
+const (
+	DeltaText      = "text_delta"
+	DeltaInputJSON = "input_json_delta"
+	DeltaThinking  = "thinking_delta"
+	DeltaSignature = "signature_delta"
+)
+
+// StreamEvent は部分メッセージ（IncludePartialMessages有効時）
+type StreamEvent struct {
+	Type            string          `json:"type"` // "stream_event"
+	UUID            string          `json:"uuid,omitempty"`
+	SessionID       string          `json:"session_id,omitempty"`
+	Event           StreamEventData `json:"event"`
+	ParentToolUseID *string         `json:"parent_tool_use_id,omitempty"`
+}
+
+func (m *StreamEvent) MessageType() string { return m.Type }
+
+// StreamEventData はAPIのストリーミングイベント本体
+type StreamEventData struct {
+	Type         string         `json:"type"`
+	Message      *AssistantBody `json:"message,omitempty"`       // message_start
+	Index        int            `json:"index"`                   // content_block_*
+	ContentBlock Block          `json:"content_block,omitempty"` // content_block_start
+	Delta        *Delta         `json:"delta,omitempty"`         // content_block_delta, message_delta
+	Usage        *Usage         `json:"usage,omitempty"`         // message_delta
+}
+
+// UnmarshalJSON はcontent_blockを種類に応じたブロック型でデコードする
+func (d *StreamEventData) UnmarshalJSON(data []byte) error {
+	type alias StreamEventData
+	var a struct {
+		alias
+		ContentBlock json.RawMessage `json:"content_block,omitempty"`
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	*d = StreamEventData(a.alias)
+	if len(a.ContentBlock) > 0 && string(a.ContentBlock) != "null" {
+		block, err := DecodeBlock(a.ContentBlock)
+		if err != nil {
+			return err
+		}
+		d.ContentBlock = block
+	}
+	return nil
+}
+
+// Delta はストリーミングの差分
+type Delta struct {
+	Type        string `json:"type,omitempty"`
+	Text        string `json:"text,omitempty"`         // text_delta
+	PartialJSON string `json:"partial_json,omitempty"` // input_json_delta
+	Thinking    string `json:"thinking,omitempty"`     // thinking_delta
+	Signature   string `json:"signature,omitempty"`    // signature_delta
+	StopReason  string `json:"stop_reason,omitempty"`  // message_delta
+}
+
+// StreamAccumulator はStreamEventのデルタから最終的なAssistantBodyを組み立てる
+type StreamAccumulator struct {
+	message     *AssistantBody
+	partialJSON map[int]*strings.Builder
+	done        bool
+}
+
+// NewStreamAccumulator は新しいStreamAccumulatorを作成する
+func NewStreamAccumulator() *StreamAccumulator {
+	return &StreamAccumulator{
+		partialJSON: make(map[int]*strings.Builder),
+	}
+}
+
+// Add はストリームイベントを適用する
+// message_startを受け取ると前のメッセージの状態はリセットされる
+func (a *StreamAccumulator) Add(ev *StreamEvent) error {
+	data := ev.Event
+
+	switch data.Type {
+	case StreamEventMessageStart:
+		a.message = &AssistantBody{Role: "assistant"}
+		if data.Message != nil {
+			*a.message = *data.Message
+			a.message.Content = append(Blocks(nil), data.Message.Content...)
+		}
+		a.partialJSON = make(map[int]*strings.Builder)
+		a.done = false
+
+	case StreamEventContentBlockStart:
+		if data.Index < 0 {
+			return fmt.Errorf("invalid content block index %d", data.Index)
+		}
+		msg := a.current()
+		for len(msg.Content) <= data.Index {
+			msg.Content = append(msg.Content, nil)
+		}
+		if data.ContentBlock != nil {
+			msg.Content[data.Index] = data.ContentBlock
+		}
+
+	case StreamEventContentBlockDelta:
+		if data.Delta == nil {
+			return nil
+		}
+		block, err := a.block(data.Index)
+		if err != nil {
+			return err
+		}
+		switch data.Delta.Type {
+		case DeltaText:
+			if b, ok := block.(*TextBlock); ok {
+				b.Text += data.Delta.Text
+			}
+		case DeltaThinking:
+			if b, ok := block.(*ThinkingBlock); ok {
+				b.Thinking += data.Delta.Thinking
+			}
+		case DeltaSignature:
+			if b, ok := block.(*ThinkingBlock); ok {
+				b.Signature += data.Delta.Signature
+			}
+		case DeltaInputJSON:
+			buf, ok := a.partialJSON[data.Index]
+			if !ok {
+				buf = &strings.Builder{}
+				a.partialJSON[data.Index] = buf
+			}
+			buf.WriteString(data.Delta.PartialJSON)
+		}
+
+	case StreamEventContentBlockStop:
+		buf, ok := a.partialJSON[data.Index]
+		if !ok {
+			return nil
+		}
+		delete(a.partialJSON, data.Index)
+		block, err := a.block(data.Index)
+		if err != nil {
+			return err
+		}
+		if buf.Len() == 0 {
+			return nil
+		}
+		var input map[string]any
+		if err := json.Unmarshal([]byte(buf.String()), &input); err != nil {
+			return fmt.Errorf("parse tool input of block %d: %w", data.Index, err)
+		}
+		switch b := block.(type) {
+		case *ToolUseBlock:
+			b.Input = input
+		case *ServerToolUseBlock:
+			b.Input = input
+		}
+
+	case StreamEventMessageDelta:
+		msg := a.current()
+		if data.Delta != nil && data.Delta.StopReason != "" {
+			msg.StopReason = data.Delta.StopReason
+		}
+		if data.Usage != nil {
+			msg.Usage = mergeUsage(msg.Usage, data.Usage)
+		}
+
+	case StreamEventMessageStop:
+		a.current()
+		a.done = true
+	}
+
+	return nil
+}
+
+// Message は現在までに組み立てたメッセージを返す（message_start前はnil）
+func (a *StreamAccumulator) Message() *AssistantBody {
+	return a.message
+}
+
+// Text は現在までに受信したテキストを連結して返す
+func (a *StreamAccumulator) Text() string {
+	if a.message == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, block := range blocksOf[*TextBlock](a.message.Content) {
+		sb.WriteString(block.Text)
+	}
+	return sb.String()
+}
+
+// Done はmessage_stopを受信したかを返す
+func (a *StreamAccumulator) Done() bool {
+	return a.done
+}
+
+// mergeUsage はmessage_deltaの使用量をmessage_startの使用量に反映する
+// message_deltaは主にoutput_tokensのみを含むため、0でない項目だけを上書きする
+func mergeUsage(base, delta *Usage) *Usage {
+	merged := Usage{}
+	if base != nil {
+		merged = *base
+	}
+	if delta.InputTokens != 0 {
+		merged.InputTokens = delta.InputTokens
+	}
+	if delta.OutputTokens != 0 {
+		merged.OutputTokens = delta.OutputTokens
+	}
+	if delta.CacheCreationTokens != 0 {
+		merged.CacheCreationTokens = delta.CacheCreationTokens
+	}
+	if delta.CacheReadTokens != 0 {
+		merged.CacheReadTokens = delta.CacheReadTokens
+	}
+	return &merged
+}
+
+func (a *StreamAccumulator) current() *AssistantBody {
+	if a.message == nil {
+		a.message = &AssistantBody{Role: "assistant"}
+	}
+	return a.message
+}
+
+func (a *StreamAccumulator) block(index int) (Block, error) {
+	msg := a.current()
+	if index < 0 || index >= len(msg.Content) || msg.Content[index] == nil {
+		return nil, fmt.Errorf("delta for unknown content block %d", index)
+	}
+	return msg.Content[index], nil
+}
@@ -0,0 +1,207 @@
+package protocol
+
+import (
+	"testing"
+)
+
+// streamEvents はテスト用のstream_eventシーケンス（テキスト、思考、ツール使用）
+func streamEvents() []map[string]any {
+	event := func(ev map[string]any) map[string]any {
+		return map[string]any{
+			"type":       "stream_event",
+			"uuid":       "uuid-1",
+			"session_id": "session-1",
+			"event":      ev,
+		}
+	}
+
+	return []map[string]any{
+		event(map[string]any{"type": "message_start", "message": map[string]any{
+			"id": "msg_01", "role": "assistant", "model": "claude-sonnet-4-5", "content": []any{},
+		}}),
+		event(map[string]any{"type": "content_block_start", "index": 0, "content_block": map[string]any{"type": "thinking", "thinking": ""}}),
+		event(map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "thinking_delta", "thinking": "Let me "}}),
+		event(map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "thinking_delta", "thinking": "think."}}),
+		event(map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "signature_delta", "signature": "sig"}}),
+		event(map[string]any{"type": "content_block_stop", "index": 0}),
+		event(map[string]any{"type": "content_block_start", "index": 1, "content_block": map[string]any{"type": "text", "text": ""}}),
+		event(map[string]any{"type": "content_block_delta", "index": 1, "delta": map[string]any{"type": "text_delta", "text": "Hello, "}}),
+		event(map[string]any{"type": "content_block_delta", "index": 1, "delta": map[string]any{"type": "text_delta", "text": "world!"}}),
+		event(map[string]any{"type": "content_block_stop", "index": 1}),
+		event(map[string]any{"type": "content_block_start", "index": 2, "content_block": map[string]any{"type": "tool_use", "id": "toolu_01", "name": "Read", "input": map[string]any{}}}),
+		event(map[string]any{"type": "content_block_delta", "index": 2, "delta": map[string]any{"type": "input_json_delta", "partial_json": `{"file_`}}),
+		event(map[string]any{"type": "content_block_delta", "index": 2, "delta": map[string]any{"type": "input_json_delta", "partial_json": `path":"/tmp/a.txt"}`}}),
+		event(map[string]any{"type": "content_block_stop", "index": 2}),
+		event(map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": "tool_use"}, "usage": map[string]any{"input_tokens": 10, "output_tokens": 25}}),
+		event(map[string]any{"type": "message_stop"}),
+	}
+}
+
+func TestParseMessage_StreamEvent(t *testing.T) {
+	msg, err := ParseMessage(streamEvents()[7])
+	if err != nil {
+		t.Fatalf("ParseMessage failed: %v", err)
+	}
+
+	ev, ok := msg.(*StreamEvent)
+	if !ok {
+		t.Fatalf("expected *StreamEvent, got %T", msg)
+	}
+	if ev.MessageType() != "stream_event" {
+		t.Errorf("MessageType() = %q, want %q", ev.MessageType(), "stream_event")
+	}
+	if ev.SessionID != "session-1" {
+		t.Errorf("SessionID = %q, want %q", ev.SessionID, "session-1")
+	}
+	if ev.Event.Type != StreamEventContentBlockDelta {
+		t.Errorf("Event.Type = %q, want %q", ev.Event.Type, StreamEventContentBlockDelta)
+	}
+	if ev.Event.Index != 1 {
+		t.Errorf("Event.Index = %d, want 1", ev.Event.Index)
+	}
+	if ev.Event.Delta == nil || ev.Event.Delta.Text != "Hello, " {
+		t.Errorf("Event.Delta = %+v", ev.Event.Delta)
+	}
+}
+
+func TestStreamAccumulator(t *testing.T) {
+	acc := NewStreamAccumulator()
+
+	for i, data := range streamEvents() {
+		msg, err := ParseMessage(data)
+		if err != nil {
+			t.Fatalf("ParseMessage failed: %v", err)
+		}
+		if err := acc.Add(msg.(*StreamEvent)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+
+		// テキストは途中経過でも取得できる
+		if i == 8 && acc.Text() != "Hello, world!" {
+			t.Errorf("Text() = %q, want %q", acc.Text(), "Hello, world!")
+		}
+	}
+
+	if !acc.Done() {
+		t.Error("Done() should be true after message_stop")
+	}
+
+	body := acc.Message()
+	if body.ID != "msg_01" || body.Model != "claude-sonnet-4-5" {
+		t.Errorf("unexpected message header: %+v", body)
+	}
+	if len(body.Content) != 3 {
+		t.Fatalf("len(Content) = %d, want 3", len(body.Content))
+	}
+	if b, ok := body.Content[0].(*ThinkingBlock); !ok || b.Thinking != "Let me think." || b.Signature != "sig" {
+		t.Errorf("thinking block = %+v", body.Content[0])
+	}
+	if b, ok := body.Content[1].(*TextBlock); !ok || b.Text != "Hello, world!" {
+		t.Errorf("text block = %+v", body.Content[1])
+	}
+	if b, ok := body.Content[2].(*ToolUseBlock); !ok || b.Name != "Read" || b.Input["file_path"] != "/tmp/a.txt" {
+		t.Errorf("tool_use block = %+v", body.Content[2])
+	}
+	if body.StopReason != "tool_use" {
+		t.Errorf("StopReason = %q, want %q", body.StopReason, "tool_use")
+	}
+	if body.Usage == nil || body.Usage.OutputTokens != 25 {
+		t.Errorf("Usage = %+v", body.Usage)
+	}
+}
+
+func TestStreamAccumulator_ResetOnMessageStart(t *testing.T) {
+	acc := NewStreamAccumulator()
+	events := streamEvents()
+
+	for _, data := range events {
+		msg, _ := ParseMessage(data)
+		acc.Add(msg.(*StreamEvent))
+	}
+
+	// 2つ目のメッセージ開始で状態がリセットされる
+	msg, _ := ParseMessage(events[0])
+	if err := acc.Add(msg.(*StreamEvent)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if acc.Done() {
+		t.Error("Done() should be false after new message_start")
+	}
+	if len(acc.Message().Content) != 0 {
+		t.Errorf("len(Content) = %d, want 0", len(acc.Message().Content))
+	}
+}
+
+func TestStreamAccumulator_UnknownBlock(t *testing.T) {
+	acc := NewStreamAccumulator()
+
+	err := acc.Add(&StreamEvent{
+		Type: "stream_event",
+		Event: StreamEventData{
+			Type:  StreamEventContentBlockDelta,
+			Index: 3,
+			Delta: &Delta{Type: DeltaText, Text: "orphan"},
+		},
+	})
+	if err == nil {
+		t.Error("expected error for delta without content_block_start")
+	}
+}
+
+func TestStreamAccumulator_InvalidInputJSON(t *testing.T) {
+	acc := NewStreamAccumulator()
+
+	events := []StreamEventData{
+		{Type: StreamEventContentBlockStart, Index: 0, ContentBlock: &ToolUseBlock{ID: "toolu_01", Name: "Bash"}},
+		{Type: StreamEventContentBlockDelta, Index: 0, Delta: &Delta{Type: DeltaInputJSON, PartialJSON: `{"command":`}},
+	}
+	for _, ev := range events {
+		if err := acc.Add(&StreamEvent{Type: "stream_event", Event: ev}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	err := acc.Add(&StreamEvent{Type: "stream_event", Event: StreamEventData{Type: StreamEventContentBlockStop, Index: 0}})
+	if err == nil {
+		t.Error("expected error for incomplete tool input JSON")
+	}
+}
+
+func TestStreamAccumulator_NegativeIndex(t *testing.T) {
+	acc := NewStreamAccumulator()
+
+	err := acc.Add(&StreamEvent{
+		Type:  "stream_event",
+		Event: StreamEventData{Type: StreamEventContentBlockStart, Index: -1, ContentBlock: &TextBlock{}},
+	})
+	if err == nil {
+		t.Error("expected error for negative content block index")
+	}
+}
+
+func TestStreamAccumulator_MergesUsage(t *testing.T) {
+	acc := NewStreamAccumulator()
+
+	events := []StreamEventData{
+		{Type: StreamEventMessageStart, Message: &AssistantBody{
+			ID:    "msg_01",
+			Usage: &Usage{InputTokens: 100, OutputTokens: 1, CacheCreationTokens: 20, CacheReadTokens: 300},
+		}},
+		// message_deltaはoutput_tokensのみを含む
+		{Type: StreamEventMessageDelta, Usage: &Usage{OutputTokens: 42}},
+	}
+	for _, ev := range events {
+		if err := acc.Add(&StreamEvent{Type: "stream_event", Event: ev}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	want := Usage{InputTokens: 100, OutputTokens: 42, CacheCreationTokens: 20, CacheReadTokens: 300}
+	if got := acc.Message().Usage; got == nil || *got != want {
+		t.Errorf("Usage = %+v, want %+v", got, want)
+	}
+	// message_startのイベントは変更しない
+	if events[0].Message.Usage.OutputTokens != 1 {
+		t.Error("usage of message_start event should not be modified")
+	}
+}
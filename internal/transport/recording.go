@@ -0,0 +1,239 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 記録の方向
+const (
+	DirectionSend = "send" // SDK → CLI（stdinへの書き込み）
+	DirectionRecv = "recv" // CLI → SDK（stdoutからの受信）
+)
+
+// errRecorderClosed はクローズ済みのRecorderへの記録で返すエラー
+var errRecorderClosed = errors.New("recorder is closed")
+
+// Record はCLIとやり取りした1行分の記録
+type Record struct {
+	Seq       int64           `json:"seq"`                  // 記録順の連番（1始まり）
+	Direction string          `json:"direction"`            // "send" または "recv"
+	Elapsed   time.Duration   `json:"elapsed_ns"`           // 記録開始からの経過時間（単調時計）
+	Time      time.Time       `json:"time"`                 // 記録時刻（壁時計）
+	SessionID string          `json:"session_id,omitempty"` // 記録時点のセッションID
+	Type      string          `json:"type,omitempty"`       // メッセージのtype
+	Data      json.RawMessage `json:"data"`                 // やり取りした行（JSON）
+	Error     string          `json:"error,omitempty"`      // 書き込みに失敗した場合のエラー
+}
+
+// Recorder はRecordをJSON Lines形式で書き出す
+// 複数のTransport（自動復旧による再接続など）で共有できる
+type Recorder struct {
+	mu        sync.Mutex
+	w         *bufio.Writer
+	closer    io.Closer
+	start     time.Time
+	seq       int64
+	sessionID string
+	err       error
+}
+
+// NewRecorder はwriterに記録するRecorderを作成する（writerはCloseでクローズしない）
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		w:     bufio.NewWriter(w),
+		start: time.Now(),
+	}
+}
+
+// CreateRecorder はファイルに記録するRecorderを作成する（既存のファイルは上書きする）
+func CreateRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	r := NewRecorder(f)
+	r.closer = f
+	return r, nil
+}
+
+// Record は1行分のやり取りを記録する
+func (r *Recorder) Record(direction string, line []byte, writeErr error) error {
+	line = []byte(strings.TrimSpace(string(line)))
+
+	var fields struct {
+		Type      string `json:"type"`
+		SessionID string `json:"session_id"`
+	}
+	data := json.RawMessage(line)
+	if err := json.Unmarshal(line, &fields); err != nil {
+		// JSONでない行は文字列として記録する
+		data, _ = json.Marshal(string(line))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	// セッションIDはメッセージに含まれていれば更新し、含まれていなければ直前の値を引き継ぐ
+	if fields.SessionID != "" {
+		r.sessionID = fields.SessionID
+	}
+
+	r.seq++
+	now := time.Now()
+	rec := Record{
+		Seq:       r.seq,
+		Direction: direction,
+		Elapsed:   now.Sub(r.start),
+		Time:      now,
+		SessionID: r.sessionID,
+		Type:      fields.Type,
+		Data:      data,
+	}
+	if writeErr != nil {
+		rec.Error = writeErr.Error()
+	}
+
+	encoded, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	encoded = append(encoded, '\n')
+	if _, err := r.w.Write(encoded); err != nil {
+		r.err = err
+		return err
+	}
+	// クラッシュ時にも記録が残るよう1行ごとにフラッシュする
+	if err := r.w.Flush(); err != nil {
+		r.err = err
+		return err
+	}
+	return nil
+}
+
+// Close は記録を終了する
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == errRecorderClosed {
+		return nil
+	}
+
+	var err error
+	if r.err == nil {
+		err = r.w.Flush()
+	}
+	// クローズ後の記録は破棄する
+	r.err = errRecorderClosed
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); err == nil {
+			err = closeErr
+		}
+		r.closer = nil
+	}
+	return err
+}
+
+// ReadRecords は記録ファイルを読み込む
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), DefaultMaxBufferSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return records, fmt.Errorf("record %d: %w", len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// RecordingTransport はやり取りをRecorderに記録するTransportのラッパー
+type RecordingTransport struct {
+	inner    Transport
+	recorder *Recorder
+
+	once    sync.Once
+	msgChan chan RawMessage
+}
+
+// NewRecordingTransport はinnerのやり取りを記録するTransportを作成する
+func NewRecordingTransport(inner Transport, recorder *Recorder) *RecordingTransport {
+	return &RecordingTransport{
+		inner:    inner,
+		recorder: recorder,
+	}
+}
+
+// Connect はCLIプロセスを起動して接続する
+func (t *RecordingTransport) Connect(ctx context.Context) error {
+	return t.inner.Connect(ctx)
+}
+
+// Write はCLIのstdinにデータを書き込み、記録する
+func (t *RecordingTransport) Write(data []byte) error {
+	err := t.inner.Write(data)
+	t.recorder.Record(DirectionSend, data, err)
+	return err
+}
+
+// Messages は受信メッセージのチャネルを返す（受信したメッセージは記録してから転送する）
+func (t *RecordingTransport) Messages() <-chan RawMessage {
+	t.once.Do(func() {
+		in := t.inner.Messages()
+		t.msgChan = make(chan RawMessage, cap(in))
+		go t.forward(in)
+	})
+	return t.msgChan
+}
+
+func (t *RecordingTransport) forward(in <-chan RawMessage) {
+	defer close(t.msgChan)
+	for msg := range in {
+		t.recorder.Record(DirectionRecv, msg.Raw, nil)
+		t.msgChan <- msg
+	}
+}
+
+// Errors はエラーのチャネルを返す
+func (t *RecordingTransport) Errors() <-chan error {
+	return t.inner.Errors()
+}
+
+// EndInput はstdinをクローズする
+func (t *RecordingTransport) EndInput() error {
+	return t.inner.EndInput()
+}
+
+// Close はプロセスを終了する（Recorderは共有されるためクローズしない）
+func (t *RecordingTransport) Close() error {
+	return t.inner.Close()
+}
+
+// IsConnected は接続状態を返す
+func (t *RecordingTransport) IsConnected() bool {
+	return t.inner.IsConnected()
+}
+
+// GetProcessStatus はプロセスの終了状態を取得する
+func (t *RecordingTransport) GetProcessStatus() *ProcessStatus {
+	return t.inner.GetProcessStatus()
+}
@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// stubTransport はテスト用の最小Transport実装
+type stubTransport struct {
+	msgChan  chan RawMessage
+	errChan  chan error
+	written  [][]byte
+	writeErr error
+}
+
+func newStubTransport() *stubTransport {
+	return &stubTransport{
+		msgChan: make(chan RawMessage, 10),
+		errChan: make(chan error, 1),
+	}
+}
+
+func (s *stubTransport) Connect(ctx context.Context) error { return nil }
+func (s *stubTransport) Write(data []byte) error {
+	s.written = append(s.written, data)
+	return s.writeErr
+}
+func (s *stubTransport) Messages() <-chan RawMessage      { return s.msgChan }
+func (s *stubTransport) Errors() <-chan error             { return s.errChan }
+func (s *stubTransport) EndInput() error                  { return nil }
+func (s *stubTransport) Close() error                     { return nil }
+func (s *stubTransport) IsConnected() bool                { return true }
+func (s *stubTransport) GetProcessStatus() *ProcessStatus { return nil }
+
+func TestRecordingTransport_RecordsBothDirections(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	inner := newStubTransport()
+	tr := NewRecordingTransport(inner, recorder)
+
+	msgs := tr.Messages()
+
+	tr.Write([]byte(`{"type":"control_request","request_id":"req_1","request":{"subtype":"initialize"}}`))
+	inner.msgChan <- RawMessage{Type: "system", Raw: []byte(`{"type":"system","subtype":"init","session_id":"sess-1"}`)}
+	<-msgs
+	tr.Write([]byte(`{"type":"user","message":{"role":"user","content":"Hi"},"session_id":""}` + "\n"))
+	inner.msgChan <- RawMessage{Type: "result", Raw: []byte(`{"type":"result","subtype":"success","session_id":"sess-1"}`)}
+	<-msgs
+
+	inner.writeErr = errors.New("broken pipe")
+	tr.Write([]byte(`{"type":"control_request","request_id":"req_2","request":{"subtype":"interrupt"}}`))
+
+	close(inner.msgChan)
+	if _, ok := <-msgs; ok {
+		t.Error("Messages() should be closed after inner channel is closed")
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// 転送されたデータはinnerにそのまま書き込まれる
+	if len(inner.written) != 3 {
+		t.Fatalf("len(written) = %d, want %d", len(inner.written), 3)
+	}
+
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords failed: %v", err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("len(records) = %d, want %d", len(records), 5)
+	}
+
+	wantDirections := []string{DirectionSend, DirectionRecv, DirectionSend, DirectionRecv, DirectionSend}
+	wantSessions := []string{"", "sess-1", "sess-1", "sess-1", "sess-1"}
+	for i, rec := range records {
+		if rec.Seq != int64(i+1) {
+			t.Errorf("records[%d].Seq = %d, want %d", i, rec.Seq, i+1)
+		}
+		if rec.Direction != wantDirections[i] {
+			t.Errorf("records[%d].Direction = %q, want %q", i, rec.Direction, wantDirections[i])
+		}
+		if rec.SessionID != wantSessions[i] {
+			t.Errorf("records[%d].SessionID = %q, want %q", i, rec.SessionID, wantSessions[i])
+		}
+		if i > 0 && rec.Elapsed < records[i-1].Elapsed {
+			t.Errorf("records[%d].Elapsed is not monotonic", i)
+		}
+	}
+	if records[2].Type != "user" || strings.HasSuffix(string(records[2].Data), "\n") {
+		t.Errorf("records[2] = %+v", records[2])
+	}
+	if records[4].Error != "broken pipe" {
+		t.Errorf("records[4].Error = %q, want %q", records[4].Error, "broken pipe")
+	}
+}
+
+func TestRecorder_NonJSONLine(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	recorder.Record(DirectionRecv, []byte("not json"), nil)
+	recorder.Close()
+
+	// クローズ後の記録は破棄される
+	recorder.Record(DirectionRecv, []byte(`{"type":"system"}`), nil)
+
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords failed: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("len(records) = %d, want %d", len(records), 1)
+	}
+	if string(records[0].Data) != `"not json"` {
+		t.Errorf("Data = %s, want %q", records[0].Data, "not json")
+	}
+}
+
+func TestWriteTimeline(t *testing.T) {
+	var rec bytes.Buffer
+	recorder := NewRecorder(&rec)
+	recorder.Record(DirectionSend, []byte(`{"type":"control_request","request_id":"req_1","request":{"subtype":"initialize"}}`), nil)
+	recorder.Record(DirectionRecv, []byte(`{"type":"control_response","response":{"subtype":"success","request_id":"req_1"}}`), nil)
+	recorder.Record(DirectionRecv, []byte(`{"type":"system","subtype":"init","session_id":"sess-1","model":"claude-sonnet"}`), nil)
+	recorder.Record(DirectionRecv, []byte(`{"type":"assistant","message":{"content":[{"type":"text","text":"読みます"},{"type":"tool_use","id":"toolu_1","name":"Read","input":{}}]},"session_id":"sess-1"}`), nil)
+	recorder.Record(DirectionRecv, []byte(`{"type":"control_request","request_id":"cli_1","request":{"subtype":"can_use_tool","tool_name":"Read"}}`), nil)
+	recorder.Record(DirectionRecv, []byte(`{"type":"result","subtype":"success","num_turns":1,"total_cost_usd":0.01,"session_id":"sess-1"}`), nil)
+	recorder.Close()
+
+	var out bytes.Buffer
+	if err := WriteTimeline(&out, &rec); err != nil {
+		t.Fatalf("WriteTimeline failed: %v", err)
+	}
+	got := out.String()
+
+	for _, want := range []string{
+		"→  control_request   initialize (req_1)",
+		"←  control_response  success (req_1)",
+		"== session sess-1 ==",
+		"init model=claude-sonnet",
+		`"読みます" | tool_use Read(toolu_1)`,
+		"can_use_tool (cli_1) tool=Read",
+		"success turns=1 cost=$0.01",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("timeline missing %q\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Matcher は記録された値と実際に書き込まれた値の比較方法をJSONパスごとに指定する
+// Pathはドット区切り（例: "request.hooks.PreToolUse.0.hookCallbackIds"）で、"*"は任意の1要素に一致する
+type Matcher struct {
+	Path  string
+	Match func(expected, actual any) bool
+}
+
+// IgnoreField は指定したパスの値を比較しないMatcherを返す
+func IgnoreField(path string) Matcher {
+	return Matcher{
+		Path:  path,
+		Match: func(expected, actual any) bool { return true },
+	}
+}
+
+// MatchPattern は実際の値が正規表現に一致すればよいMatcherを返す
+func MatchPattern(path string, pattern string) Matcher {
+	re := regexp.MustCompile(pattern)
+	return Matcher{
+		Path: path,
+		Match: func(expected, actual any) bool {
+			s, ok := actual.(string)
+			return ok && re.MatchString(s)
+		},
+	}
+}
+
+// DefaultReplayMatchers は実行ごとに変わるフィールドを無視するMatcherを返す
+func DefaultReplayMatchers() []Matcher {
+	return []Matcher{
+		IgnoreField("request_id"),
+		IgnoreField("uuid"),
+	}
+}
+
+// ReplayConfig はReplayTransportの設定
+type ReplayConfig struct {
+	// Matchers は書き込みの比較に使用するMatcher（nilの場合はDefaultReplayMatchers）
+	Matchers []Matcher
+	// KeepOpen がtrueの場合、記録の再生が終わってもMessages()をクローズしない
+	// falseの場合はCLIプロセスが終了したときと同様にチャネルをクローズする
+	KeepOpen bool
+}
+
+// ReplayDivergenceError は書き込みが記録と一致しなかった場合のエラー
+type ReplayDivergenceError struct {
+	Seq      int64           // 期待していた記録の連番（記録の終端を超えた場合は0）
+	Expected json.RawMessage // 記録された書き込み
+	Actual   json.RawMessage // 実際の書き込み
+	Diffs    []string        // 差分（パスごと）
+}
+
+func (e *ReplayDivergenceError) Error() string {
+	var sb strings.Builder
+	if e.Seq == 0 {
+		sb.WriteString("replay diverged: unexpected write after end of recording")
+	} else {
+		fmt.Fprintf(&sb, "replay diverged at record #%d", e.Seq)
+	}
+	for _, d := range e.Diffs {
+		sb.WriteString("\n  ")
+		sb.WriteString(d)
+	}
+	if e.Expected != nil {
+		fmt.Fprintf(&sb, "\n- expected: %s", e.Expected)
+	}
+	fmt.Fprintf(&sb, "\n+ actual:   %s", e.Actual)
+	return sb.String()
+}
+
+// replayWrite はWriteから再生ループへ渡す書き込み
+type replayWrite struct {
+	data   []byte
+	result chan error
+}
+
+// ReplayTransport は記録したセッションを再生するTransport実装
+// 記録の順序どおりにCLIの出力を返し、SDKからの書き込みを記録と照合する
+type ReplayTransport struct {
+	records  []Record
+	matchers []Matcher
+	keepOpen bool
+
+	msgChan   chan RawMessage
+	errChan   chan error
+	writes    chan replayWrite
+	closeChan chan struct{}
+	playDone  chan struct{} // 再生ループの終了時にクローズされる
+	closeOnce sync.Once
+
+	mu         sync.Mutex
+	connected  bool
+	closed     bool
+	finished   bool
+	err        error
+	position   int               // 次に再生する記録のインデックス
+	requestIDs map[string]string // 記録されたrequest_id → 実際のrequest_id
+	status     *ProcessStatus
+}
+
+// NewReplayTransport は記録から再生用のTransportを作成する
+func NewReplayTransport(records []Record, cfg ReplayConfig) *ReplayTransport {
+	matchers := cfg.Matchers
+	if matchers == nil {
+		matchers = DefaultReplayMatchers()
+	}
+	return &ReplayTransport{
+		records:    records,
+		matchers:   matchers,
+		keepOpen:   cfg.KeepOpen,
+		msgChan:    make(chan RawMessage, DefaultMessageBufferSize),
+		errChan:    make(chan error, 10),
+		writes:     make(chan replayWrite),
+		closeChan:  make(chan struct{}),
+		playDone:   make(chan struct{}),
+		requestIDs: make(map[string]string),
+	}
+}
+
+// LoadReplayTransport は記録ファイルから再生用のTransportを作成する
+func LoadReplayTransport(path string, cfg ReplayConfig) (*ReplayTransport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadReplayTransport(f, cfg)
+}
+
+// ReadReplayTransport は記録を読み込んで再生用のTransportを作成する
+func ReadReplayTransport(r io.Reader, cfg ReplayConfig) (*ReplayTransport, error) {
+	records, err := ReadRecords(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayTransport(records, cfg), nil
+}
+
+// Connect は再生を開始する
+func (t *ReplayTransport) Connect(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return fmt.Errorf("transport is closed")
+	}
+	if t.connected {
+		return nil
+	}
+	t.connected = true
+
+	go t.play()
+	return nil
+}
+
+// play は記録を順に再生する
+func (t *ReplayTransport) play() {
+	defer close(t.playDone)
+
+	for i, rec := range t.records {
+		t.mu.Lock()
+		t.position = i
+		t.mu.Unlock()
+
+		switch rec.Direction {
+		case DirectionRecv:
+			msg, err := t.rawMessage(rec)
+			if err != nil {
+				t.fail(fmt.Errorf("replay record #%d: %w", rec.Seq, err))
+				return
+			}
+			select {
+			case t.msgChan <- msg:
+			case <-t.closeChan:
+				t.closeChannels()
+				return
+			}
+
+		case DirectionSend:
+			select {
+			case w := <-t.writes:
+				err := t.compare(rec, w.data)
+				w.result <- err
+				if err != nil {
+					t.fail(err)
+					return
+				}
+			case <-t.closeChan:
+				t.closeChannels()
+				return
+			}
+		}
+	}
+
+	t.mu.Lock()
+	t.position = len(t.records)
+	t.finished = true
+	t.status = &ProcessStatus{ExitCode: 0}
+	t.mu.Unlock()
+
+	if !t.keepOpen {
+		t.closeChannels()
+	}
+}
+
+// rawMessage は記録された受信行をRawMessageに変換する（request_idは実際の値に置き換える）
+func (t *ReplayTransport) rawMessage(rec Record) (RawMessage, error) {
+	var data map[string]any
+	if err := json.Unmarshal(rec.Data, &data); err != nil {
+		return RawMessage{}, err
+	}
+
+	raw := []byte(rec.Data)
+	if response, ok := data["response"].(map[string]any); ok && rec.Type == "control_response" {
+		recorded, _ := response["request_id"].(string)
+		t.mu.Lock()
+		actual, mapped := t.requestIDs[recorded]
+		t.mu.Unlock()
+		if mapped && actual != recorded {
+			response["request_id"] = actual
+			encoded, err := json.Marshal(data)
+			if err != nil {
+				return RawMessage{}, err
+			}
+			raw = encoded
+		}
+	}
+
+	msgType, _ := data["type"].(string)
+	return RawMessage{Type: msgType, Data: data, Raw: raw}, nil
+}
+
+// compare は書き込みを記録と照合する
+func (t *ReplayTransport) compare(rec Record, data []byte) error {
+	var expected, actual any
+	if err := json.Unmarshal(rec.Data, &expected); err != nil {
+		return fmt.Errorf("replay record #%d: %w", rec.Seq, err)
+	}
+	if err := json.Unmarshal(data, &actual); err != nil {
+		return &ReplayDivergenceError{
+			Seq:      rec.Seq,
+			Expected: rec.Data,
+			Actual:   json.RawMessage(strconv.Quote(string(data))),
+			Diffs:    []string{"actual write is not valid JSON"},
+		}
+	}
+
+	diffs := t.diff("", expected, actual, nil)
+	if len(diffs) > 0 {
+		return &ReplayDivergenceError{Seq: rec.Seq, Expected: rec.Data, Actual: data, Diffs: diffs}
+	}
+
+	// 制御リクエストのrequest_idを対応付け、記録された応答を実際のIDで返す
+	if rec.Type == "control_request" {
+		e, _ := expected.(map[string]any)
+		a, _ := actual.(map[string]any)
+		recorded, _ := e["request_id"].(string)
+		current, _ := a["request_id"].(string)
+		if recorded != "" && current != "" {
+			t.mu.Lock()
+			t.requestIDs[recorded] = current
+			t.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// diff は2つの値の差分をパスごとに返す
+func (t *ReplayTransport) diff(path string, expected, actual any, diffs []string) []string {
+	for _, m := range t.matchers {
+		if matchPath(m.Path, path) {
+			if !m.Match(expected, actual) {
+				diffs = append(diffs, fmt.Sprintf("%s: expected %s, got %s", displayPath(path), compactJSON(expected), compactJSON(actual)))
+			}
+			return diffs
+		}
+	}
+
+	switch e := expected.(type) {
+	case map[string]any:
+		a, ok := actual.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range e {
+			keys[k] = true
+		}
+		for k := range a {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			child := joinPath(path, k)
+			ev, eok := e[k]
+			av, aok := a[k]
+			switch {
+			case !eok:
+				if !t.ignored(child) {
+					diffs = append(diffs, fmt.Sprintf("%s: unexpected field %s", child, compactJSON(av)))
+				}
+			case !aok:
+				if !t.ignored(child) {
+					diffs = append(diffs, fmt.Sprintf("%s: missing field (expected %s)", child, compactJSON(ev)))
+				}
+			default:
+				diffs = t.diff(child, ev, av, diffs)
+			}
+		}
+		return diffs
+
+	case []any:
+		a, ok := actual.([]any)
+		if !ok {
+			break
+		}
+		if len(e) != len(a) {
+			return append(diffs, fmt.Sprintf("%s: expected %d elements, got %d", displayPath(path), len(e), len(a)))
+		}
+		for i := range e {
+			diffs = t.diff(joinPath(path, strconv.Itoa(i)), e[i], a[i], diffs)
+		}
+		return diffs
+
+	default:
+		if expected == actual {
+			return diffs
+		}
+	}
+
+	return append(diffs, fmt.Sprintf("%s: expected %s, got %s", displayPath(path), compactJSON(expected), compactJSON(actual)))
+}
+
+// ignored はパスに一致するMatcherが任意の値を許容するかを返す（フィールドの有無の比較用）
+func (t *ReplayTransport) ignored(path string) bool {
+	for _, m := range t.matchers {
+		if matchPath(m.Path, path) {
+			return m.Match(nil, nil)
+		}
+	}
+	return false
+}
+
+// fail は再生を中断し、CLIプロセスが異常終了したときと同様にチャネルをクローズする
+func (t *ReplayTransport) fail(err error) {
+	t.mu.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+	t.status = &ProcessStatus{ExitCode: 1, Stderr: err.Error()}
+	t.mu.Unlock()
+
+	select {
+	case t.errChan <- err:
+	default:
+	}
+	t.closeChannels()
+}
+
+func (t *ReplayTransport) closeChannels() {
+	t.closeOnce.Do(func() {
+		close(t.msgChan)
+		close(t.errChan)
+	})
+}
+
+// Write はSDKからの書き込みを記録と照合する（一致しない場合はReplayDivergenceErrorを返す）
+func (t *ReplayTransport) Write(data []byte) error {
+	data = []byte(strings.TrimSpace(string(data)))
+
+	t.mu.Lock()
+	if !t.connected || t.closed {
+		t.mu.Unlock()
+		return fmt.Errorf("not connected")
+	}
+	if t.err != nil {
+		err := t.err
+		t.mu.Unlock()
+		return err
+	}
+	if t.finished {
+		err := &ReplayDivergenceError{Actual: data}
+		t.err = err
+		t.mu.Unlock()
+		return err
+	}
+	t.mu.Unlock()
+
+	w := replayWrite{data: data, result: make(chan error, 1)}
+	select {
+	case t.writes <- w:
+		return <-w.result
+	case <-t.playDone:
+		// 書き込みを待たずに再生が終了した
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.err == nil {
+			t.err = &ReplayDivergenceError{Actual: data}
+		}
+		return t.err
+	case <-t.closeChan:
+		return fmt.Errorf("transport is closed")
+	}
+}
+
+// Messages は再生されるメッセージのチャネルを返す
+func (t *ReplayTransport) Messages() <-chan RawMessage {
+	return t.msgChan
+}
+
+// Errors はエラーのチャネルを返す
+func (t *ReplayTransport) Errors() <-chan error {
+	return t.errChan
+}
+
+// EndInput は何もしない
+func (t *ReplayTransport) EndInput() error {
+	return nil
+}
+
+// Close は再生を終了する
+func (t *ReplayTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	close(t.closeChan)
+
+	// 再生中の場合は再生ループがチャネルをクローズする
+	if !t.connected || t.finished {
+		t.closeChannels()
+	}
+	return nil
+}
+
+// IsConnected は接続状態を返す
+func (t *ReplayTransport) IsConnected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.connected && !t.closed && !t.finished && t.err == nil
+}
+
+// GetProcessStatus は再生の終了状態を返す（再生中はnil）
+func (t *ReplayTransport) GetProcessStatus() *ProcessStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// Err は最初に検出した不一致を返す
+func (t *ReplayTransport) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Verify は記録がすべて再生され、不一致がなかったことを確認する
+func (t *ReplayTransport) Verify() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+	if !t.finished {
+		rec := t.records[t.position]
+		return fmt.Errorf("replay incomplete: stopped at record #%d (%s %s), %d of %d records remaining",
+			rec.Seq, rec.Direction, rec.Type, len(t.records)-t.position, len(t.records))
+	}
+	return nil
+}
+
+func matchPath(pattern, path string) bool {
+	ps := strings.Split(pattern, ".")
+	xs := strings.Split(path, ".")
+	if len(ps) != len(xs) {
+		return false
+	}
+	for i := range ps {
+		if ps[i] != "*" && ps[i] != xs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
+
+func compactJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,182 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func replayRecords(t *testing.T, lines ...string) []Record {
+	t.Helper()
+	records := make([]Record, 0, len(lines))
+	for i, line := range lines {
+		direction, data, _ := strings.Cut(line, " ")
+		var fields struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(data), &fields); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		records = append(records, Record{
+			Seq:       int64(i + 1),
+			Direction: direction,
+			Type:      fields.Type,
+			Data:      json.RawMessage(data),
+		})
+	}
+	return records
+}
+
+func receive(t *testing.T, ch <-chan RawMessage) RawMessage {
+	t.Helper()
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			t.Fatal("Messages() closed unexpectedly")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+	return RawMessage{}
+}
+
+func TestReplayTransport_Session(t *testing.T) {
+	tr := NewReplayTransport(replayRecords(t,
+		`send {"type":"control_request","request_id":"sdk-1","request":{"subtype":"initialize"}}`,
+		`recv {"type":"control_response","response":{"subtype":"success","request_id":"sdk-1","response":{}}}`,
+		`send {"type":"user","message":{"role":"user","content":"Hi"},"session_id":""}`,
+		`recv {"type":"control_request","request_id":"cli-1","request":{"subtype":"can_use_tool","tool_name":"Read"}}`,
+		`send {"type":"control_response","response":{"subtype":"success","request_id":"cli-1","response":{"behavior":"allow"}}}`,
+		`recv {"type":"result","subtype":"success","session_id":"s1"}`,
+	), ReplayConfig{})
+
+	if err := tr.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	msgs := tr.Messages()
+
+	// 実行ごとに異なるrequest_idは無視され、記録された応答は実際のIDで返る
+	if err := tr.Write([]byte(`{"type":"control_request","request_id":"sdk-42","request":{"subtype":"initialize"}}`)); err != nil {
+		t.Fatalf("Write initialize failed: %v", err)
+	}
+	resp := receive(t, msgs)
+	if id := resp.Data["response"].(map[string]any)["request_id"]; id != "sdk-42" {
+		t.Errorf("response request_id = %v, want sdk-42", id)
+	}
+	if !strings.Contains(string(resp.Raw), `"sdk-42"`) {
+		t.Errorf("Raw should contain rewritten request_id: %s", resp.Raw)
+	}
+
+	if err := tr.Write([]byte(`{"type":"user","message":{"role":"user","content":"Hi"},"session_id":""}` + "\n")); err != nil {
+		t.Fatalf("Write user failed: %v", err)
+	}
+	if req := receive(t, msgs); req.Type != "control_request" {
+		t.Errorf("Type = %q, want control_request", req.Type)
+	}
+	if err := tr.Write([]byte(`{"type":"control_response","response":{"subtype":"success","request_id":"cli-1","response":{"behavior":"allow"}}}`)); err != nil {
+		t.Fatalf("Write control_response failed: %v", err)
+	}
+	if result := receive(t, msgs); result.Type != "result" {
+		t.Errorf("Type = %q, want result", result.Type)
+	}
+
+	// 再生が終わるとチャネルはクローズされる
+	if _, ok := <-msgs; ok {
+		t.Error("Messages() should be closed at end of recording")
+	}
+	if err := tr.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	// 記録にない書き込みは不一致
+	var divErr *ReplayDivergenceError
+	if err := tr.Write([]byte(`{"type":"user"}`)); !errors.As(err, &divErr) || divErr.Seq != 0 {
+		t.Errorf("expected divergence after end, got %v", err)
+	}
+}
+
+func TestReplayTransport_Divergence(t *testing.T) {
+	tr := NewReplayTransport(replayRecords(t,
+		`send {"type":"user","message":{"role":"user","content":"Hello"},"session_id":"s1"}`,
+		`recv {"type":"result","subtype":"success"}`,
+	), ReplayConfig{})
+	tr.Connect(context.Background())
+
+	err := tr.Write([]byte(`{"type":"user","message":{"role":"user","content":"Goodbye"},"session_id":"s1","extra":true}`))
+	var divErr *ReplayDivergenceError
+	if !errors.As(err, &divErr) {
+		t.Fatalf("expected ReplayDivergenceError, got %v", err)
+	}
+	if divErr.Seq != 1 {
+		t.Errorf("Seq = %d, want 1", divErr.Seq)
+	}
+	want := []string{
+		`extra: unexpected field true`,
+		`message.content: expected "Hello", got "Goodbye"`,
+	}
+	if strings.Join(divErr.Diffs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Diffs = %q, want %q", divErr.Diffs, want)
+	}
+	if !strings.Contains(err.Error(), "- expected:") || !strings.Contains(err.Error(), "+ actual:") {
+		t.Errorf("error should contain a diff: %s", err)
+	}
+
+	// 不一致はErrors()にも通知され、再生は中断される
+	select {
+	case e := <-tr.Errors():
+		if !errors.As(e, &divErr) {
+			t.Errorf("Errors() = %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for error")
+	}
+	if _, ok := <-tr.Messages(); ok {
+		t.Error("Messages() should be closed after divergence")
+	}
+	if status := tr.GetProcessStatus(); status == nil || status.ExitCode != 1 {
+		t.Errorf("status = %+v", status)
+	}
+	if tr.Verify() != err {
+		t.Error("Verify should return the divergence")
+	}
+}
+
+func TestReplayTransport_Matchers(t *testing.T) {
+	records := replayRecords(t,
+		`send {"type":"user","message":{"role":"user","content":"run at 2026-01-01"},"session_id":"old"}`,
+	)
+	tr := NewReplayTransport(records, ReplayConfig{
+		Matchers: []Matcher{
+			IgnoreField("session_id"),
+			MatchPattern("message.content", `^run at \d{4}-\d{2}-\d{2}$`),
+		},
+	})
+	tr.Connect(context.Background())
+
+	if err := tr.Write([]byte(`{"type":"user","message":{"role":"user","content":"run at 2026-10-16"},"session_id":"new"}`)); err != nil {
+		t.Errorf("Write failed: %v", err)
+	}
+}
+
+func TestReplayTransport_VerifyIncomplete(t *testing.T) {
+	tr := NewReplayTransport(replayRecords(t,
+		`recv {"type":"system","subtype":"init"}`,
+		`send {"type":"user","message":{"role":"user","content":"Hi"}}`,
+	), ReplayConfig{})
+	tr.Connect(context.Background())
+	receive(t, tr.Messages())
+
+	// 送信待ちの状態で閉じる
+	time.Sleep(10 * time.Millisecond)
+	if err := tr.Verify(); err == nil || !strings.Contains(err.Error(), "record #2") {
+		t.Errorf("Verify = %v, want incomplete at record #2", err)
+	}
+	tr.Close()
+	if _, ok := <-tr.Messages(); ok {
+		t.Error("Messages() should be closed after Close")
+	}
+}
@@ -4,22 +4,28 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+
+	"github.com/y-oga-819/my-go-claude-agent/internal/logging"
 )
 
 const (
-	DefaultMaxBufferSize = 10 * 1024 * 1024 // 10MB
-	DefaultCLIPath       = "claude"
+	DefaultMaxBufferSize     = 10 * 1024 * 1024 // 10MB
+	DefaultMessageBufferSize = 100
+	DefaultCLIPath           = "claude"
 )
 
 // SubprocessTransport はCLIをサブプロセスとして起動するTransport実装
 type SubprocessTransport struct {
 	config Config
+	logger *slog.Logger
 
 	cmd    *exec.Cmd
 	stdin  io.WriteCloser
@@ -35,6 +41,10 @@ type SubprocessTransport struct {
 	closed        bool
 	processStatus *ProcessStatus
 	stderrBuf     strings.Builder
+
+	// readWG はstdout/stderrの読み取り完了を待つ
+	// cmd.Wait()はパイプをクローズするため、読み取り完了後に呼び出す必要がある
+	readWG sync.WaitGroup
 }
 
 // NewSubprocessTransport は新しいSubprocessTransportを作成する
@@ -45,10 +55,14 @@ func NewSubprocessTransport(config Config) *SubprocessTransport {
 	if config.MaxBufferSize == 0 {
 		config.MaxBufferSize = DefaultMaxBufferSize
 	}
+	if config.MessageBufferSize <= 0 {
+		config.MessageBufferSize = DefaultMessageBufferSize
+	}
 
 	return &SubprocessTransport{
 		config:    config,
-		msgChan:   make(chan RawMessage, 100),
+		logger:    logging.OrDiscard(config.Logger),
+		msgChan:   make(chan RawMessage, config.MessageBufferSize),
 		errChan:   make(chan error, 10),
 		closeChan: make(chan struct{}),
 	}
@@ -90,12 +104,21 @@ func (t *SubprocessTransport) Connect(ctx context.Context) error {
 
 	// プロセス起動
 	if err := t.cmd.Start(); err != nil {
+		t.logger.Error("failed to start CLI", "cli_path", t.config.CLIPath, "error", err)
 		return fmt.Errorf("start CLI: %w", err)
 	}
 
 	t.connected = true
+	// 引数にはプロンプトやシステムプロンプトが含まれる
+	t.logger.Info("CLI process started",
+		"pid", t.cmd.Process.Pid,
+		"cli_path", t.config.CLIPath,
+		"streaming", t.config.StreamingMode,
+		logging.Payload("args", args),
+	)
 
 	// 読み取りgoroutine開始
+	t.readWG.Add(2)
 	go t.readLoop()
 	go t.readStderr()
 	go t.waitProcess()
@@ -142,6 +165,7 @@ func (t *SubprocessTransport) buildEnv() []string {
 }
 
 func (t *SubprocessTransport) readLoop() {
+	defer t.readWG.Done()
 	defer close(t.msgChan)
 
 	scanner := bufio.NewScanner(t.stdout)
@@ -169,28 +193,62 @@ func (t *SubprocessTransport) readLoop() {
 		if err := json.Unmarshal([]byte(raw), &data); err == nil {
 			// 完全なJSONを取得
 			msgType, _ := data["type"].(string)
-			t.msgChan <- RawMessage{
-				Type: msgType,
-				Data: data,
-				Raw:  []byte(raw),
+			t.logger.Debug("received message from CLI", "type", msgType, "bytes", len(raw))
+			if !t.sendMessage(RawMessage{Type: msgType, Data: data, Raw: []byte(raw)}) {
+				return
 			}
 			jsonBuffer.Reset()
 		} else if jsonBuffer.Len() > t.config.MaxBufferSize {
-			t.errChan <- fmt.Errorf("JSON buffer overflow: %d bytes", jsonBuffer.Len())
+			t.logger.Error("JSON buffer overflow, discarding buffered output", "bytes", jsonBuffer.Len())
+			if !t.sendError(fmt.Errorf("JSON buffer overflow: %d bytes", jsonBuffer.Len())) {
+				return
+			}
 			jsonBuffer.Reset()
+		} else {
+			// 不完全な場合は次の行を待つ
+			t.logger.Warn("failed to parse CLI output, waiting for next line",
+				"bytes", jsonBuffer.Len(),
+				"error", err,
+				logging.Payload("line", line),
+			)
 		}
-		// 不完全な場合は次の行を待つ
 	}
 
 	if err := scanner.Err(); err != nil {
-		t.errChan <- fmt.Errorf("stdout read: %w", err)
+		t.logger.Error("failed to read CLI stdout", "error", err)
+		t.sendError(fmt.Errorf("stdout read: %w", err))
+	}
+}
+
+// sendMessage はメッセージを送信する
+// Close後は受信側がいなくなるため、ブロックせずに破棄してfalseを返す
+func (t *SubprocessTransport) sendMessage(msg RawMessage) bool {
+	select {
+	case t.msgChan <- msg:
+		return true
+	case <-t.closeChan:
+		return false
+	}
+}
+
+// sendError はエラーを送信する（Close後は破棄してfalseを返す）
+func (t *SubprocessTransport) sendError(err error) bool {
+	select {
+	case t.errChan <- err:
+		return true
+	case <-t.closeChan:
+		return false
 	}
 }
 
 func (t *SubprocessTransport) readStderr() {
+	defer t.readWG.Done()
+
 	scanner := bufio.NewScanner(t.stderr)
 	for scanner.Scan() {
 		line := scanner.Text()
+		// stderrはプロンプトやツールの出力を含みうる（異常終了時はエラーログに含める）
+		t.logger.Debug("CLI stderr", logging.Payload("line", line))
 		t.mu.Lock()
 		t.stderrBuf.WriteString(line)
 		t.stderrBuf.WriteString("\n")
@@ -199,6 +257,9 @@ func (t *SubprocessTransport) readStderr() {
 }
 
 func (t *SubprocessTransport) waitProcess() {
+	// 読み取りが完了するまで待つ（errChanのクローズ後に送信しないため）
+	t.readWG.Wait()
+
 	err := t.cmd.Wait()
 	t.mu.Lock()
 	t.connected = false
@@ -216,10 +277,22 @@ func (t *SubprocessTransport) waitProcess() {
 	}
 
 	t.processStatus = status
+	closed := t.closed
 	t.mu.Unlock()
 
+	// Closeによる終了はエラーとして扱わない
+	if err != nil && !closed {
+		t.logger.Error("CLI process exited with error",
+			"exit_code", status.ExitCode,
+			"error", err,
+			logging.Payload("stderr", status.Stderr),
+		)
+	} else {
+		t.logger.Info("CLI process exited", "exit_code", status.ExitCode)
+	}
+
 	if err != nil {
-		t.errChan <- fmt.Errorf("CLI process exited: %w", err)
+		t.sendError(fmt.Errorf("CLI process exited: %w", err))
 	}
 	close(t.errChan)
 }
@@ -238,7 +311,11 @@ func (t *SubprocessTransport) Write(data []byte) error {
 		data = append(data, '\n')
 	}
 
+	t.logger.Debug("writing to CLI", "bytes", len(data), logging.Payload("data", json.RawMessage(data)))
 	_, err := t.stdin.Write(data)
+	if err != nil {
+		t.logger.Error("failed to write to CLI", "error", err)
+	}
 	return err
 }
 
@@ -258,7 +335,10 @@ func (t *SubprocessTransport) EndInput() error {
 	defer t.mu.Unlock()
 
 	if t.stdin != nil {
-		return t.stdin.Close()
+		// プロセスが先に終了した場合はcmd.Wait()がstdinをクローズ済み
+		if err := t.stdin.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			return err
+		}
 	}
 	return nil
 }
@@ -272,6 +352,7 @@ func (t *SubprocessTransport) Close() error {
 		return nil
 	}
 	t.closed = true
+	t.logger.Debug("closing CLI process")
 
 	close(t.closeChan)
 
@@ -225,6 +225,50 @@ func TestSubprocessTransport_CloseIdempotent(t *testing.T) {
 	}
 }
 
+func TestSubprocessTransport_CloseWithUndrainedMessages(t *testing.T) {
+	// 受信バッファを超える出力をしたまま終了しないCLI
+	tmpFile, err := os.CreateTemp("", "mock-cli-*.sh")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	script := `#!/bin/sh
+i=0
+while [ $i -lt 500 ]; do
+  echo '{"type":"assistant"}'
+  i=$((i+1))
+done
+exec sleep 30
+`
+	if _, err := tmpFile.WriteString(script); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	tmpFile.Close()
+	if err := os.Chmod(tmpFile.Name(), 0755); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+
+	tr := NewSubprocessTransport(Config{CLIPath: tmpFile.Name(), MessageBufferSize: 1})
+	if err := tr.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	// 読み取りがバッファ満杯でブロックするまで待つ
+	time.Sleep(200 * time.Millisecond)
+
+	if err := tr.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// メッセージを読まなくてもプロセスは回収される
+	deadline := time.Now().Add(5 * time.Second)
+	for tr.GetProcessStatus() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("CLI process was not reaped after Close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestRawMessage_JSON(t *testing.T) {
 	// RawMessageの基本的な動作確認
 	raw := []byte(`{"type":"assistant","message":{"role":"assistant"}}`)
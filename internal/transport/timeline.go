@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// タイムライン表示で省略するテキストの長さ
+const timelineTextLimit = 80
+
+// WriteTimeline は記録ファイルを読みやすいタイムラインとして出力する
+//
+// 出力例:
+//
+//	#1    +0.000s  →  control_request   initialize (req_1)
+//	#2    +0.412s  ←  control_response  success (req_1)
+//	#3    +0.415s  ←  system            init [sess-1234]
+func WriteTimeline(w io.Writer, r io.Reader) error {
+	records, err := ReadRecords(r)
+	if err != nil {
+		return err
+	}
+
+	var session string
+	for _, rec := range records {
+		// セッションが切り替わった行で区切りを入れる
+		if rec.SessionID != "" && rec.SessionID != session {
+			if session != "" {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "== session %s ==\n", rec.SessionID)
+			session = rec.SessionID
+		}
+
+		arrow := "←"
+		if rec.Direction == DirectionSend {
+			arrow = "→"
+		}
+		msgType := rec.Type
+		if msgType == "" {
+			msgType = "-"
+		}
+
+		line := fmt.Sprintf("#%-4d %+9.3fs  %s  %-17s %s", rec.Seq, rec.Elapsed.Seconds(), arrow, msgType, summarizeRecord(rec))
+		if rec.Error != "" {
+			line += "  !! " + rec.Error
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summarizeRecord はメッセージの種類ごとに要点を1行にまとめる
+func summarizeRecord(rec Record) string {
+	var data map[string]any
+	if err := json.Unmarshal(rec.Data, &data); err != nil {
+		var text string
+		if json.Unmarshal(rec.Data, &text) == nil {
+			return truncate(text)
+		}
+		return truncate(string(rec.Data))
+	}
+
+	switch rec.Type {
+	case "control_request":
+		request, _ := data["request"].(map[string]any)
+		summary := fmt.Sprintf("%s (%s)", str(request, "subtype"), str(data, "request_id"))
+		if name := str(request, "tool_name"); name != "" {
+			summary += " tool=" + name
+		}
+		if id := str(request, "callback_id"); id != "" {
+			summary += " callback=" + id
+		}
+		if server := str(request, "server_name"); server != "" {
+			summary += " server=" + server
+		}
+		return summary
+
+	case "control_response":
+		response, _ := data["response"].(map[string]any)
+		summary := fmt.Sprintf("%s (%s)", str(response, "subtype"), str(response, "request_id"))
+		if errMsg := str(response, "error"); errMsg != "" {
+			summary += " error=" + truncate(errMsg)
+		}
+		return summary
+
+	case "control_cancel_request":
+		return fmt.Sprintf("(%s)", str(data, "request_id"))
+
+	case "system":
+		summary := str(data, "subtype")
+		if model := str(data, "model"); model != "" {
+			summary += " model=" + model
+		}
+		return summary
+
+	case "assistant", "user":
+		message, _ := data["message"].(map[string]any)
+		return summarizeContent(message["content"])
+
+	case "result":
+		return fmt.Sprintf("%s turns=%v cost=$%v", str(data, "subtype"), data["num_turns"], data["total_cost_usd"])
+
+	case "stream_event":
+		event, _ := data["event"].(map[string]any)
+		return str(event, "type")
+	}
+
+	return truncate(string(rec.Data))
+}
+
+// summarizeContent はcontent（文字列またはブロック配列）を要約する
+func summarizeContent(content any) string {
+	switch c := content.(type) {
+	case string:
+		return truncate(c)
+	case []any:
+		var parts []string
+		for _, b := range c {
+			block, _ := b.(map[string]any)
+			switch blockType := str(block, "type"); blockType {
+			case "text":
+				parts = append(parts, fmt.Sprintf("%q", truncate(str(block, "text"))))
+			case "tool_use", "server_tool_use":
+				parts = append(parts, fmt.Sprintf("%s %s(%s)", blockType, str(block, "name"), str(block, "id")))
+			case "tool_result":
+				result := fmt.Sprintf("tool_result(%s)", str(block, "tool_use_id"))
+				if isErr, _ := block["is_error"].(bool); isErr {
+					result += " error"
+				}
+				parts = append(parts, result)
+			default:
+				parts = append(parts, blockType)
+			}
+		}
+		return strings.Join(parts, " | ")
+	}
+	return ""
+}
+
+func str(m map[string]any, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+func truncate(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) > timelineTextLimit {
+		return string(runes[:timelineTextLimit]) + "…"
+	}
+	return s
+}
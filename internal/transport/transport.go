@@ -1,6 +1,9 @@
 package transport
 
-import "context"
+import (
+	"context"
+	"log/slog"
+)
 
 // RawMessage はCLIから受信した生のJSONメッセージ
 type RawMessage struct {
@@ -51,5 +54,7 @@ type Config struct {
 	CWD                      string            // 作業ディレクトリ
 	StreamingMode            bool              // 双方向ストリーミングモード
 	MaxBufferSize            int               // JSONバッファの最大サイズ
+	MessageBufferSize        int               // 受信メッセージチャネルの容量
 	PermissionPromptToolName string            // 権限プロンプトツール名（"stdio"でSDKに権限確認を委譲）
+	Logger                   *slog.Logger      // ログの出力先（nilの場合は出力しない）
 }